@@ -0,0 +1,763 @@
+// Package main is the entry point for the remote shell admin tool.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/format"
+)
+
+func main() {
+	// Parse command line flags
+	host := flag.String("host", "localhost", "Server host, or unix:/path for a Unix socket")
+	port := flag.Int("port", 50051, "Server port")
+	token := flag.String("token", os.Getenv("RSH_ADMIN_TOKEN"), "Admin token (defaults to $RSH_ADMIN_TOKEN)")
+	timeout := flag.Duration("timeout", 10*time.Second, "Request timeout")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	// A host of the form unix:/path names a Unix socket
+	address := fmt.Sprintf("%s:%d", *host, *port)
+	if strings.HasPrefix(*host, "unix:") {
+		address = *host
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+*token)
+
+	admin := pb.NewAdminServiceClient(conn)
+
+	var cmdErr error
+	switch flag.Arg(0) {
+	case "audit":
+		cmdErr = runAudit(ctx, admin, flag.Args()[1:])
+	case "sessions":
+		cmdErr = runSessions(ctx, admin)
+	case "health":
+		cmdErr = runHealth(ctx, admin)
+	case "approvals":
+		cmdErr = runApprovals(ctx, admin)
+	case "approve":
+		cmdErr = runDecide(ctx, admin, true, flag.Args()[1:])
+	case "deny":
+		cmdErr = runDecide(ctx, admin, false, flag.Args()[1:])
+	case "drain":
+		cmdErr = runDrain(ctx, admin, *token, flag.Args()[1:])
+	case "undrain":
+		cmdErr = runUndrain(ctx, admin)
+	case "maintenance":
+		cmdErr = runMaintenance(ctx, admin, flag.Args()[1:])
+	case "banner":
+		cmdErr = runBanner(ctx, admin, flag.Args()[1:])
+	case "priority":
+		cmdErr = runPriority(ctx, admin, flag.Args()[1:])
+	case "bans":
+		cmdErr = runBans(ctx, admin)
+	case "unban":
+		cmdErr = runUnban(ctx, admin, flag.Args()[1:])
+	case "killswitch":
+		cmdErr = runKillSwitch(ctx, admin, flag.Args()[1:])
+	case "capacity":
+		cmdErr = runCapacity(ctx, admin, flag.Args()[1:])
+	case "watch":
+		// Watching lasts until interrupted, so the request timeout does
+		// not apply
+		watchCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		watchCtx = metadata.AppendToOutgoingContext(watchCtx, "authorization", "Bearer "+*token)
+		cmdErr = runWatch(watchCtx, admin, flag.Args()[1:])
+	case "export":
+		// Exports can be large, so the request timeout does not apply
+		exportCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		exportCtx = metadata.AppendToOutgoingContext(exportCtx, "authorization", "Bearer "+*token)
+		cmdErr = runExport(exportCtx, admin, flag.Args()[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if cmdErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", cmdErr)
+		os.Exit(1)
+	}
+}
+
+// usage prints the command usage
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> [args]\n\n", os.Args[0])
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  audit                  Query recorded commands")
+	fmt.Fprintln(os.Stderr, "  export                 Export recorded commands and policy decisions as JSONL or CSV")
+	fmt.Fprintln(os.Stderr, "  sessions               List active sessions and their resource usage")
+	fmt.Fprintln(os.Stderr, "  health                 Show server resource usage (fails when over limits)")
+	fmt.Fprintln(os.Stderr, "  approvals              List commands waiting for approval")
+	fmt.Fprintln(os.Stderr, "  approve <id>           Approve a pending command")
+	fmt.Fprintln(os.Stderr, "  deny <id> [reason]     Deny a pending command")
+	fmt.Fprintln(os.Stderr, "  drain <host:port>      Move all sessions to another server (over TLS unless -target-plaintext)")
+	fmt.Fprintln(os.Stderr, "  undrain                Accept new sessions again after a drain")
+	fmt.Fprintln(os.Stderr, "  maintenance on|off     Refuse new sessions (-read-only also stops commands)")
+	fmt.Fprintln(os.Stderr, "  banner [text]          Set the login banner (-file, or no text to clear)")
+	fmt.Fprintln(os.Stderr, "  priority <session>     Set a session's nice level and IO class (-nice, -io, -io-level)")
+	fmt.Fprintln(os.Stderr, "  bans                   List hosts and identities banned for failed authentication")
+	fmt.Fprintln(os.Stderr, "  unban <peer>|-all      Lift the ban of a host or identity, or all bans")
+	fmt.Fprintln(os.Stderr, "  killswitch             Kill all commands and close all sessions (-lock refuses new ones)")
+	fmt.Fprintln(os.Stderr, "  capacity [n|reset]     Show session capacity, or change the limit (-for to revert later)")
+	fmt.Fprintln(os.Stderr, "  watch <session>        Follow a session's commands and output live (the user is told)")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+}
+
+// runAudit queries the audit log and prints matching records
+func runAudit(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	sessionID := fs.String("session", "", "Filter by session ID")
+	clientID := fs.String("client", "", "Filter by client ID")
+	since := fs.Duration("since", 0, "Only show commands started within this duration (e.g. 1h)")
+	until := fs.Duration("until", 0, "Only show commands started before this duration ago")
+	severity := fs.String("severity", "", "Filter by severity (normal or high)")
+	limit := fs.Int("limit", 100, "Maximum number of records")
+	fs.Parse(args)
+
+	req := &pb.QueryAuditRequest{
+		SessionId: *sessionID,
+		ClientId:  *clientID,
+		Severity:  *severity,
+		Limit:     int32(*limit),
+	}
+	now := time.Now()
+	if *since > 0 {
+		req.SinceUnixMs = now.Add(-*since).UnixMilli()
+	}
+	if *until > 0 {
+		req.UntilUnixMs = now.Add(-*until).UnixMilli()
+	}
+
+	resp, err := admin.QueryAudit(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tSESSION\tCLIENT\tEXIT\tTIME\tSEVERITY\tDECISION\tCOMMAND")
+	for _, rec := range resp.Records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			time.UnixMilli(rec.StartedAtUnixMs).Format(time.RFC3339),
+			rec.SessionId,
+			rec.ClientId,
+			rec.ExitCode,
+			format.Millis(rec.ExecutionTimeMs),
+			rec.Severity,
+			rec.Decision,
+			rec.Command,
+		)
+	}
+	return w.Flush()
+}
+
+// runExport writes the audit records matching the filters to a file or
+// standard output
+func runExport(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	since := fs.String("since", "", "Only export commands started after this time (RFC 3339, or a duration ago such as 24h)")
+	until := fs.String("until", "", "Only export commands started before this time (RFC 3339, or a duration ago)")
+	sessionID := fs.String("session", "", "Filter by session ID")
+	clientID := fs.String("client", "", "Filter by client ID")
+	exitCode := fs.String("exit-code", "", "Filter by exit code (-1 for refused commands)")
+	decision := fs.String("decision", "", "Filter by policy decision (allowed, audited, confirmed, approved, blocked, rejected or denied)")
+	format := fs.String("format", "jsonl", "Output format (jsonl or csv)")
+	output := fs.String("o", "", "Write to this file instead of standard output")
+	fs.Parse(args)
+
+	req := &pb.ExportAuditRequest{
+		SessionId: *sessionID,
+		ClientId:  *clientID,
+		Decision:  *decision,
+		Format:    *format,
+	}
+	now := time.Now()
+	var err error
+	if req.SinceUnixMs, err = parseTimeFlag("since", *since, now); err != nil {
+		return err
+	}
+	if req.UntilUnixMs, err = parseTimeFlag("until", *until, now); err != nil {
+		return err
+	}
+	if *exitCode != "" {
+		code, err := strconv.Atoi(*exitCode)
+		if err != nil {
+			return fmt.Errorf("invalid -exit-code %q", *exitCode)
+		}
+		req.HasExitCode = true
+		req.ExitCode = int32(code)
+	}
+
+	stream, err := admin.ExportAudit(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(chunk.Data); err != nil {
+			return err
+		}
+	}
+	if *output != "" {
+		return out.Close()
+	}
+	return nil
+}
+
+// parseTimeFlag parses a time given as RFC 3339 or as a duration before
+// now into Unix milliseconds; empty is 0, meaning no limit
+func parseTimeFlag(name, value string, now time.Time) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixMilli(), nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d).UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid -%s %q: expected an RFC 3339 time or a duration", name, value)
+}
+
+// runSessions lists the active sessions with their resource usage
+func runSessions(ctx context.Context, admin pb.AdminServiceClient) error {
+	resp, err := admin.ListSessions(ctx, &pb.ListSessionsRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tCLIENT\tFROM\tCREATED\tIDLE\tCOMMANDS\tWALL\tCPU\tBYTES\tPRIORITY\tDIR")
+	now := time.Now()
+	for _, sess := range resp.Sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			sess.SessionId,
+			sess.ClientId,
+			sessionOrigin(sess.ClientInfo),
+			time.UnixMilli(sess.CreatedAtUnixMs).Format(time.RFC3339),
+			format.Duration(now.Sub(time.UnixMilli(sess.LastActivityUnixMs))),
+			sess.Commands,
+			format.Millis(sess.WallTimeMs),
+			format.Millis(sess.CpuTimeMs),
+			format.Bytes(sess.BytesStreamed),
+			sessionPriority(sess),
+			sess.WorkingDir,
+		)
+	}
+	return w.Flush()
+}
+
+// runHealth prints the server's resource usage and fails when any of its
+// limits are exceeded, so it can be used as a health check
+func runHealth(ctx context.Context, admin pb.AdminServiceClient) error {
+	resp, err := admin.GetServerHealth(ctx, &pb.GetServerHealthRequest{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Goroutines:     %d\n", resp.Goroutines)
+	fmt.Printf("Heap:           %s\n", format.Bytes(int64(resp.HeapBytes)))
+	if resp.OpenFiles >= 0 {
+		fmt.Printf("Open files:     %d\n", resp.OpenFiles)
+	}
+	fmt.Printf("Sessions:       %d of %d\n", resp.Sessions, resp.MaxSessions)
+	fmt.Printf("Rejected:       %d\n", resp.SessionsRejected)
+	fmt.Printf("Active streams: %d\n", resp.ActiveStreams)
+	fmt.Printf("Failed auth:    %d\n", resp.FailedAuthAttempts)
+	fmt.Printf("Bans issued:    %d\n", resp.BansIssued)
+	fmt.Printf("Banned hosts:   %d\n", resp.BannedPeers)
+	fmt.Printf("Panics:         %d\n", resp.Panics)
+	if len(resp.Methods) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "METHOD\tCALLS\tFAILED\tAVG TIME")
+		for _, m := range resp.Methods {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\n", m.Method, m.Calls, m.Failures, format.Millis(m.TotalTimeMs/m.Calls))
+		}
+		w.Flush()
+	}
+
+	if len(resp.Exceeded) == 0 {
+		return nil
+	}
+	for _, e := range resp.Exceeded {
+		fmt.Printf("  over limit: %s\n", e)
+	}
+	if resp.RefusingCommands {
+		fmt.Println("New commands are being refused")
+	}
+	return fmt.Errorf("resource limits exceeded")
+}
+
+// runApprovals lists commands waiting for approval
+func runApprovals(ctx context.Context, admin pb.AdminServiceClient) error {
+	resp, err := admin.ListApprovals(ctx, &pb.ListApprovalsRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tREQUESTED\tSESSION\tCLIENT\tCOMMAND")
+	for _, a := range resp.Approvals {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			a.Id,
+			time.UnixMilli(a.RequestedAtUnixMs).Format(time.RFC3339),
+			a.SessionId,
+			a.ClientId,
+			a.Command,
+		)
+	}
+	return w.Flush()
+}
+
+// runDecide approves or denies a pending command
+func runDecide(ctx context.Context, admin pb.AdminServiceClient, approve bool, args []string) error {
+	name := "deny"
+	if approve {
+		name = "approve"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	approver := fs.String("as", os.Getenv("USER"), "Name noted next to the identity recorded as the approver")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return fmt.Errorf("usage: %s <id> [reason]", name)
+	}
+
+	resp, err := admin.DecideApproval(ctx, &pb.DecideApprovalRequest{
+		Id:       fs.Arg(0),
+		Approve:  approve,
+		Approver: *approver,
+		Reason:   strings.Join(fs.Args()[1:], " "),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(resp.Message)
+	return nil
+}
+
+// runDrain moves all sessions to another server
+func runDrain(ctx context.Context, admin pb.AdminServiceClient, token string, args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	targetToken := fs.String("target-token", "", "Admin token of the target server (defaults to -token)")
+	targetCA := fs.String("target-ca", "", "CA certificate file that verifies the target (defaults to the system roots)")
+	targetName := fs.String("target-server-name", "", "Name expected in the target's certificate (defaults to its host)")
+	plaintext := fs.Bool("target-plaintext", false, "Connect to the target without TLS, sending its token in the clear")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: drain [-target-token token] [-target-ca file] [-target-server-name name] [-target-plaintext] <host:port>")
+	}
+
+	if *targetToken == "" {
+		*targetToken = token
+	}
+	var ca []byte
+	if *targetCA != "" {
+		var err error
+		if ca, err = os.ReadFile(*targetCA); err != nil {
+			return err
+		}
+	}
+
+	resp, err := admin.DrainNode(ctx, &pb.DrainNodeRequest{
+		TargetAddress:    fs.Arg(0),
+		TargetToken:      *targetToken,
+		TargetCa:         ca,
+		TargetServerName: *targetName,
+		TargetPlaintext:  *plaintext,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Migrated %d sessions to %s\n", resp.Migrated, fs.Arg(0))
+	for _, f := range resp.Failed {
+		fmt.Printf("  failed: %s\n", f)
+	}
+	if len(resp.Failed) > 0 {
+		fmt.Println("The server accepts new sessions again")
+		return fmt.Errorf("%d sessions could not be migrated", len(resp.Failed))
+	}
+	return nil
+}
+
+// runUndrain makes a drained server accept new sessions again
+func runUndrain(ctx context.Context, admin pb.AdminServiceClient) error {
+	resp, err := admin.UndrainNode(ctx, &pb.UndrainNodeRequest{})
+	if err != nil {
+		return err
+	}
+
+	if resp.PreviousTarget == "" {
+		fmt.Println("The server was not draining")
+	} else {
+		fmt.Printf("Stopped draining to %s; new sessions are accepted again\n", resp.PreviousTarget)
+	}
+	return nil
+}
+
+// runMaintenance turns maintenance mode on or off
+func runMaintenance(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	readOnly := fs.Bool("read-only", false, "Also stop existing sessions from running commands and uploading files")
+	message := fs.String("message", "", "Message shown to refused clients (defaults to the server's configured message)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (fs.Arg(0) != "on" && fs.Arg(0) != "off") {
+		return fmt.Errorf("usage: maintenance [-read-only] [-message text] on|off")
+	}
+
+	resp, err := admin.SetMaintenance(ctx, &pb.SetMaintenanceRequest{
+		Enabled:  fs.Arg(0) == "on",
+		ReadOnly: *readOnly,
+		Message:  *message,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !resp.Enabled:
+		fmt.Println("Maintenance mode off")
+	case resp.ReadOnly:
+		fmt.Printf("Maintenance mode on (read-only): %s\n", resp.Message)
+	default:
+		fmt.Printf("Maintenance mode on: %s\n", resp.Message)
+	}
+	return nil
+}
+
+// runBanner replaces the login banner
+func runBanner(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("banner", flag.ExitOnError)
+	file := fs.String("file", "", "Read the banner from a file")
+	fs.Parse(args)
+
+	banner := strings.Join(fs.Args(), " ")
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		banner = string(data)
+	}
+
+	if _, err := admin.SetBanner(ctx, &pb.SetBannerRequest{Banner: banner}); err != nil {
+		return err
+	}
+
+	if banner == "" {
+		fmt.Println("Banner cleared")
+	} else {
+		fmt.Println("Banner updated")
+	}
+	return nil
+}
+
+// runPriority changes the scheduling and IO priority of a session
+func runPriority(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("priority", flag.ExitOnError)
+	nice := fs.Int("nice", 0, "Niceness added to commands (0-19)")
+	ioClass := fs.String("io", "", "IO class: best-effort or idle (empty leaves IO priority alone)")
+	ioLevel := fs.Int("io-level", 4, "IO priority within best-effort (0-7)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: priority [-nice n] [-io best-effort|idle] [-io-level n] <session>")
+	}
+
+	resp, err := admin.SetSessionPriority(ctx, &pb.SetSessionPriorityRequest{
+		SessionId: fs.Arg(0),
+		Nice:      int32(*nice),
+		IoClass:   *ioClass,
+		IoLevel:   int32(*ioLevel),
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Session %s now runs commands at %s\n", resp.Session.SessionId, sessionPriority(resp.Session))
+	return nil
+}
+
+// runBans lists the hosts banned for failed authentication attempts
+func runBans(ctx context.Context, admin pb.AdminServiceClient) error {
+	resp, err := admin.ListBans(ctx, &pb.ListBansRequest{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tUNTIL\tREMAINING\tBANS")
+	now := time.Now()
+	for _, b := range resp.Bans {
+		until := time.UnixMilli(b.UntilUnixMs)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n",
+			b.Peer,
+			until.Format(time.RFC3339),
+			until.Sub(now).Truncate(time.Second),
+			b.Count,
+		)
+	}
+	return w.Flush()
+}
+
+// runUnban lifts the ban of a host, or of all hosts
+func runUnban(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("unban", flag.ExitOnError)
+	all := fs.Bool("all", false, "Lift every ban")
+	fs.Parse(args)
+
+	if (fs.NArg() != 1) == !*all {
+		return fmt.Errorf("usage: unban <peer> | unban -all")
+	}
+
+	resp, err := admin.ClearBan(ctx, &pb.ClearBanRequest{
+		Peer: fs.Arg(0),
+		All:  *all,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Lifted %d ban(s)\n", resp.Cleared)
+	return nil
+}
+
+// runKillSwitch closes every session on the server at once
+func runKillSwitch(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("killswitch", flag.ExitOnError)
+	lock := fs.Bool("lock", false, "Also enter maintenance mode so that no new sessions can be created")
+	reason := fs.String("reason", "", "Reason recorded in the server log")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: killswitch [-lock] [-reason text]")
+	}
+
+	resp, err := admin.TerminateAllSessions(ctx, &pb.TerminateAllSessionsRequest{
+		Reason: *reason,
+		Lock:   *lock,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Terminated %d session(s)\n", resp.Terminated)
+	if *lock {
+		fmt.Println("Server locked; run 'maintenance off' to accept sessions again")
+	}
+	return nil
+}
+
+// runCapacity shows how many sessions the server allows and who holds
+// them, or changes the limit
+func runCapacity(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("capacity", flag.ExitOnError)
+	duration := fs.Duration("for", 0, "Restore the configured limit after this long (default: until the server restarts)")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: capacity [-for duration] [n|reset]")
+	}
+	if fs.NArg() == 1 {
+		var n int
+		if fs.Arg(0) != "reset" {
+			var err error
+			if n, err = strconv.Atoi(fs.Arg(0)); err != nil || n <= 0 {
+				return fmt.Errorf("invalid session limit %q", fs.Arg(0))
+			}
+		}
+		resp, err := admin.SetMaxSessions(ctx, &pb.SetMaxSessionsRequest{
+			MaxSessions: int32(n),
+			DurationMs:  duration.Milliseconds(),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Session limit %d (was %d)", resp.MaxSessions, resp.Previous)
+		if resp.UntilUnixMs > 0 {
+			fmt.Printf(" until %s", time.UnixMilli(resp.UntilUnixMs).Format(time.RFC3339))
+		}
+		fmt.Println()
+		return nil
+	}
+
+	resp, err := admin.GetServerHealth(ctx, &pb.GetServerHealthRequest{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sessions:   %d of %d\n", resp.Sessions, resp.MaxSessions)
+	if resp.MaxSessions != resp.ConfiguredMaxSessions {
+		fmt.Printf("Configured: %d", resp.ConfiguredMaxSessions)
+		if resp.MaxSessionsUntilUnixMs > 0 {
+			fmt.Printf(" (restored at %s)", time.UnixMilli(resp.MaxSessionsUntilUnixMs).Format(time.RFC3339))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Rejected:   %d\n", resp.SessionsRejected)
+	if len(resp.Clients) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tSESSIONS")
+	for _, c := range resp.Clients {
+		client := c.Client
+		if client == "" {
+			client = "(none)"
+		}
+		fmt.Fprintf(w, "%s\t%d\n", client, c.Sessions)
+	}
+	return w.Flush()
+}
+
+// runWatch prints the commands run in a session and their output until
+// interrupted or the session ends
+func runWatch(ctx context.Context, admin pb.AdminServiceClient, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	name := fs.String("name", "", "Name shown to the session's user (default \"an administrator\")")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: watch [-name text] <session>")
+	}
+
+	stream, err := admin.WatchSession(ctx, &pb.WatchSessionRequest{
+		SessionId: fs.Arg(0),
+		Name:      *name,
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return err
+		}
+
+		at := time.UnixMilli(event.TimeUnixMs).Format("15:04:05")
+		switch event.Type {
+		case pb.SessionEvent_ATTACHED:
+			fmt.Printf("Watching session %s in %s, press Ctrl-C to stop\n", event.SessionId, event.WorkingDir)
+		case pb.SessionEvent_COMMAND_STARTED:
+			fmt.Printf("[%s %s] $ %s\n", at, event.Participant, event.Command)
+		case pb.SessionEvent_OUTPUT:
+			switch {
+			case event.Binary:
+				fmt.Printf("[%s of binary output]\n", format.Bytes(int64(len(event.Data))))
+			case event.Stderr:
+				os.Stderr.Write(event.Data)
+			default:
+				os.Stdout.Write(event.Data)
+			}
+		case pb.SessionEvent_COMMAND_FINISHED:
+			if len(event.Data) > 0 {
+				fmt.Printf("[%s %s: %s]\n", at, event.Participant, strings.TrimSpace(string(event.Data)))
+			} else {
+				fmt.Printf("[%s %s: exit code %d]\n", at, event.Participant, event.ExitCode)
+			}
+		case pb.SessionEvent_JOINED:
+			fmt.Printf("[%s %s joined]\n", at, event.Participant)
+		case pb.SessionEvent_LEFT:
+			fmt.Printf("[%s %s left]\n", at, event.Participant)
+		case pb.SessionEvent_NOTICE:
+			fmt.Printf("[%s %s]\n", at, event.Data)
+		}
+	}
+}
+
+// sessionOrigin describes the machine a session was created from, as
+// reported by its client
+func sessionOrigin(info *pb.ClientInfo) string {
+	if info == nil {
+		return "-"
+	}
+	origin := info.Hostname
+	if info.Username != "" {
+		origin = info.Username + "@" + origin
+	}
+	var details []string
+	for _, d := range []string{info.Os, info.ClientVersion} {
+		if d != "" {
+			details = append(details, d)
+		}
+	}
+	if len(details) > 0 {
+		origin += " (" + strings.Join(details, ", ") + ")"
+	}
+	return origin
+}
+
+// sessionPriority describes the priority of a session's commands
+func sessionPriority(sess *pb.SessionInfo) string {
+	return executor.Priority{
+		Nice:    int(sess.Nice),
+		IOClass: sess.IoClass,
+		IOLevel: int(sess.IoLevel),
+	}.String()
+}
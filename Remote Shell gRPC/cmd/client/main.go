@@ -11,15 +11,34 @@ import (
 	"time"
 	"gopkg.in/yaml.v3"
 	"remote-shell-rpc/internal/client"
+	"remote-shell-rpc/pkg/executor"
 	"remote-shell-rpc/pkg/logger"
 )
 
 func main() {
 	// Parse command line flags
 	configPath := flag.String("config", "", "Path to configuration file")
-	host := flag.String("host", "localhost", "Server host")
+	host := flag.String("host", "localhost", "Server host, unix:/path for a Unix socket, or several as host1,host2 or dns:///name")
 	port := flag.Int("port", 50051, "Server port")
 	clientID := flag.String("client-id", "", "Client ID (auto-generated if empty)")
+	profile := flag.String("profile", "", "Server profile from the config file")
+	shellName := flag.String("shell", "", "Remote shell to use (must be allowed by the server)")
+	loginShell := flag.Bool("login", false, "Run remote commands through a login shell")
+	initScript := flag.Bool("init", false, "Source the server's init script before each command")
+	umask := flag.String("umask", "", "File mode creation mask (octal) for remote commands and uploads")
+	glob := flag.String("glob", "", "How patterns such as *.log in commands are expanded: shell, noglob or expand")
+	rawOutput := flag.Bool("raw", false, "Write binary command output to the terminal instead of suppressing it")
+	verbose := flag.Bool("verbose", false, "Print server time, round trip and bytes received after each command")
+	keepAlive := flag.Bool("keep-alive", false, "Let commands run past the server's timeout while the client keeps answering its pings")
+	quiet := flag.Bool("quiet", false, "Leave out the welcome banner, session ID and other decorations")
+	events := flag.String("events", "", "Write session and command events to stderr in this format (json)")
+	controlSocket := flag.String("control-socket", "", "Let local tools run commands in the session over JSON-RPC on this Unix socket")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Log out after this long without input (0 = never)")
+	notifyAfter := flag.Duration("notify-after", 0, "Ring the bell when a command that ran at least this long finishes (0 = never)")
+	relayAddr := flag.String("relay", "", "Reach the server through this relay; -host is then the server's relay name")
+	sshKey := flag.String("ssh-key", "", "Log in with this SSH private key, or \"agent\" for the keys in ssh-agent")
+	stateFile := flag.String("state-file", "", "State file used to reattach to the previous session across restarts")
+	attach := flag.String("attach", "", "Join the session shared with this invitation instead of creating one")
 	logLevel := flag.String("log-level", "warn", "Log level (debug, info, warn, error)")
 	flag.Parse()
 
@@ -33,14 +52,29 @@ func main() {
 
 	// Load configuration
 	cfg := client.DefaultConfig()
+	shellCfg := client.DefaultShellConfig()
 
 	if *configPath != "" {
-		loadedCfg, err := loadConfig(*configPath)
+		loadedCfg, loadedShellCfg, err := loadConfig(*configPath)
 		if err != nil {
 			log.Error("Failed to load config", "error", err.Error())
 			os.Exit(1)
 		}
 		cfg = loadedCfg
+		shellCfg = loadedShellCfg
+	}
+
+	// Select a server profile
+	if *profile != "" {
+		cfg.Profile = *profile
+	}
+	if cfg.Profile != "" {
+		profileCfg, err := cfg.WithProfile(cfg.Profile)
+		if err != nil {
+			log.Error("Failed to select profile", "error", err.Error())
+			os.Exit(1)
+		}
+		cfg = profileCfg
 	}
 
 	// Override with command line flags
@@ -50,9 +84,83 @@ func main() {
 	if *port != 50051 {
 		cfg.Port = *port
 	}
+	if *relayAddr != "" {
+		cfg.Relay = *relayAddr
+	}
+	if *sshKey != "" {
+		cfg.SSHKey = *sshKey
+	}
+	if *stateFile != "" {
+		cfg.StateFile = *stateFile
+	}
+	if *shellName != "" {
+		cfg.Shell = *shellName
+	}
+	if *loginShell {
+		cfg.LoginShell = true
+	}
+	if *initScript {
+		cfg.InitScript = true
+	}
+	if *umask != "" {
+		mask, err := executor.ParseUmask(*umask)
+		if err != nil {
+			log.Error("Invalid -umask", "error", err.Error())
+			os.Exit(1)
+		}
+		cfg.Umask = mask
+	}
+	if *glob != "" {
+		if err := client.ValidGlob(*glob); err != nil {
+			log.Error("Invalid -glob", "error", err.Error())
+			os.Exit(1)
+		}
+		cfg.Glob = *glob
+	}
+	if *verbose {
+		shellCfg.Verbose = true
+	}
+	if *rawOutput {
+		shellCfg.RawOutput = true
+	}
+	if *keepAlive {
+		shellCfg.KeepAlive = true
+	}
+	if *quiet {
+		shellCfg.Quiet = true
+	}
+	if *controlSocket != "" {
+		shellCfg.ControlSocket = *controlSocket
+	}
+	if *events != "" {
+		if err := client.ValidEvents(*events); err != nil {
+			log.Error("Invalid -events", "error", err.Error())
+			os.Exit(1)
+		}
+		cfg.Events = *events
+	}
+	if *idleTimeout > 0 {
+		shellCfg.IdleTimeout = *idleTimeout
+	}
+	if *notifyAfter > 0 {
+		shellCfg.NotifyAfter = *notifyAfter
+	}
+
+	// Load persisted state so that the previous session can be resumed
+	var state client.State
+	if cfg.StateFile != "" {
+		loadedState, err := client.LoadState(cfg.StateFile)
+		if err != nil {
+			log.Warn("Ignoring client state", "error", err.Error())
+		}
+		state = loadedState
+	}
 
 	// Generate client ID if not provided
 	cID := *clientID
+	if cID == "" {
+		cID = state.ClientID
+	}
 	if cID == "" {
 		cID = fmt.Sprintf("client-%d", time.Now().UnixNano())
 	}
@@ -74,21 +182,50 @@ func main() {
 	}()
 
 	// Connect to server
-	fmt.Printf("Connecting to %s:%d...\n", cfg.Host, cfg.Port)
+	if !shellCfg.Quiet {
+		fmt.Printf("Connecting to %s...\n", c.Address())
+	}
 	if err := c.Connect(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to connect: %v\n", err)
 		os.Exit(1)
 	}
-	defer c.Disconnect()
+	// With a state file the session outlives the client process and is
+	// only closed by the logout builtin
+	if cfg.StateFile != "" {
+		defer c.Detach()
+	} else {
+		defer c.Disconnect()
+	}
+
+	// A guest joins someone else's session instead of creating its own
+	if *attach != "" {
+		shell := client.NewShell(c, shellCfg)
+		if err := shell.RunAttached(ctx, *attach); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Session ended: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Create session
-	if err := c.CreateSession(ctx, cID); err != nil {
+	// Create session, following the redirect of a draining server
+	err := c.CreateSession(ctx, cID)
+	if address, moved := client.MovedTo(err); moved {
+		fmt.Printf("Server is draining, connecting to %s...\n", address)
+		err = c.Follow(ctx, address)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to create session: %v\n", err)
 		os.Exit(1)
 	}
 
+	if cfg.StateFile != "" {
+		if state.SessionID != "" && state.SessionID == c.GetSessionID() && !shellCfg.Quiet {
+			fmt.Printf("Reattached to session %s\n", state.SessionID)
+		}
+		saveState(log, cfg.StateFile, cID, c.GetSessionID())
+	}
+
 	// Create and run interactive shell
-	shellCfg := client.DefaultShellConfig()
 	shell := client.NewShell(c, shellCfg)
 
 	if err := shell.Run(ctx); err != nil {
@@ -97,31 +234,83 @@ func main() {
 			os.Exit(1)
 		}
 	}
+
+	// Forget the session if it was closed with logout
+	if cfg.StateFile != "" && !c.HasSession() {
+		saveState(log, cfg.StateFile, cID, "")
+	}
+}
+
+// saveState persists the client and session IDs, logging failures
+func saveState(log *logger.Logger, path, clientID, sessionID string) {
+	err := client.SaveState(path, client.State{
+		ClientID:  clientID,
+		SessionID: sessionID,
+	})
+	if err != nil {
+		log.Warn("Failed to save client state", "error", err.Error())
+	}
 }
 
 // loadConfig loads configuration from a YAML file
-func loadConfig(path string) (client.Config, error) {
+func loadConfig(path string) (client.Config, client.ShellConfig, error) {
 	cfg := client.DefaultConfig()
+	shellCfg := client.DefaultShellConfig()
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return cfg, err
+		return cfg, shellCfg, err
 	}
 
 	var fileCfg struct {
 		Server struct {
-			Host    string `yaml:"host"`
-			Port    int    `yaml:"port"`
-			Timeout string `yaml:"timeout"`
+			Host    string            `yaml:"host"`
+			Port    int               `yaml:"port"`
+			Timeout string            `yaml:"timeout"`
+			TLS     client.TLSConfig  `yaml:"tls"`
+			Token   string            `yaml:"token"`
+			SSHKey  string            `yaml:"ssh_key"`
+			Relay   string            `yaml:"relay"`
+			OIDC    client.OIDCConfig `yaml:"oidc"`
+
+			MaxRecvMsgSize int    `yaml:"max_recv_msg_size"`
+			MaxSendMsgSize int    `yaml:"max_send_msg_size"`
+			LoadBalancing  string `yaml:"load_balancing"`
+
+			RelayTLS client.TLSConfig `yaml:"relay_tls"`
 		} `yaml:"server"`
+		DefaultProfile string                    `yaml:"default_profile"`
+		Profiles       map[string]client.Profile `yaml:"profiles"`
+		Session        struct {
+			StateFile  string `yaml:"state_file"`
+			Shell      string `yaml:"shell"`
+			LoginShell bool   `yaml:"login_shell"`
+			InitScript bool   `yaml:"init_script"`
+			Umask      string `yaml:"umask"`
+			Glob       string `yaml:"glob"`
+		} `yaml:"session"`
 		Shell struct {
-			Prompt      string `yaml:"prompt"`
-			HistorySize int    `yaml:"history_size"`
+			Prompt          string    `yaml:"prompt"`
+			HistorySize     int       `yaml:"history_size"`
+			ConfirmPatterns *[]string `yaml:"confirm_patterns"`
+			HistoryIgnore   *[]string `yaml:"history_ignore"`
+			RawOutput       bool      `yaml:"raw_output"`
+			Verbose         bool      `yaml:"verbose"`
+			Quiet           bool      `yaml:"quiet"`
+			KeepAlive       bool      `yaml:"keep_alive"`
+			Events          string    `yaml:"events"`
+			ControlSocket   string    `yaml:"control_socket"`
+			SnippetsFile    string    `yaml:"snippets_file"`
+			IdleTimeout     string    `yaml:"idle_timeout"`
+			IdleWarning     string    `yaml:"idle_warning"`
+			ExitColor       *string   `yaml:"exit_color"`
+			NotifyAfter     string    `yaml:"notify_after"`
+			Notify          string    `yaml:"notify"`
 		} `yaml:"shell"`
 	}
 
 	if err := yaml.Unmarshal(data, &fileCfg); err != nil {
-		return cfg, err
+		return cfg, shellCfg, err
 	}
 
 	if fileCfg.Server.Host != "" {
@@ -135,6 +324,106 @@ func loadConfig(path string) (client.Config, error) {
 			cfg.Timeout = timeout
 		}
 	}
+	cfg.StateFile = fileCfg.Session.StateFile
+	cfg.Shell = fileCfg.Session.Shell
+	cfg.LoginShell = fileCfg.Session.LoginShell
+	cfg.InitScript = fileCfg.Session.InitScript
+	if fileCfg.Session.Umask != "" {
+		mask, err := executor.ParseUmask(fileCfg.Session.Umask)
+		if err != nil {
+			return cfg, shellCfg, fmt.Errorf("session.umask: %w", err)
+		}
+		cfg.Umask = mask
+	}
+	if err := client.ValidGlob(fileCfg.Session.Glob); err != nil {
+		return cfg, shellCfg, fmt.Errorf("session.glob: %w", err)
+	}
+	cfg.Glob = fileCfg.Session.Glob
+	cfg.TLS = fileCfg.Server.TLS
+	cfg.Token = fileCfg.Server.Token
+	cfg.SSHKey = fileCfg.Server.SSHKey
+	cfg.OIDC = fileCfg.Server.OIDC
+	cfg.Relay = fileCfg.Server.Relay
+	cfg.RelayTLS = fileCfg.Server.RelayTLS
+	if fileCfg.Server.MaxRecvMsgSize < 0 || fileCfg.Server.MaxSendMsgSize < 0 {
+		return cfg, shellCfg, fmt.Errorf("server.max_recv_msg_size and server.max_send_msg_size must not be negative")
+	}
+	cfg.MaxRecvMsgSize = fileCfg.Server.MaxRecvMsgSize
+	cfg.MaxSendMsgSize = fileCfg.Server.MaxSendMsgSize
+	if err := client.ValidLoadBalancing(fileCfg.Server.LoadBalancing); err != nil {
+		return cfg, shellCfg, fmt.Errorf("server.load_balancing: %w", err)
+	}
+	cfg.LoadBalancing = fileCfg.Server.LoadBalancing
+	cfg.Profile = fileCfg.DefaultProfile
+	cfg.Profiles = fileCfg.Profiles
+
+	if fileCfg.Shell.Prompt != "" {
+		shellCfg.Prompt = fileCfg.Shell.Prompt
+	}
+	// An explicit empty color turns coloring off
+	if fileCfg.Shell.ExitColor != nil {
+		if _, err := client.ColorCode(*fileCfg.Shell.ExitColor); err != nil {
+			return cfg, shellCfg, fmt.Errorf("shell.exit_color: %w", err)
+		}
+		shellCfg.ExitColor = *fileCfg.Shell.ExitColor
+	}
+	if fileCfg.Shell.HistorySize > 0 {
+		shellCfg.HistorySize = fileCfg.Shell.HistorySize
+	}
+	// An explicit empty list disables confirmations
+	if fileCfg.Shell.ConfirmPatterns != nil {
+		shellCfg.ConfirmPatterns = *fileCfg.Shell.ConfirmPatterns
+	}
+	if _, err := client.CompilePatterns(shellCfg.ConfirmPatterns); err != nil {
+		return cfg, shellCfg, fmt.Errorf("shell.confirm_patterns: %w", err)
+	}
+	// An explicit empty list keeps every command in the history
+	if fileCfg.Shell.HistoryIgnore != nil {
+		shellCfg.HistoryIgnore = *fileCfg.Shell.HistoryIgnore
+	}
+	if _, err := client.CompilePatterns(shellCfg.HistoryIgnore); err != nil {
+		return cfg, shellCfg, fmt.Errorf("shell.history_ignore: %w", err)
+	}
+	shellCfg.RawOutput = fileCfg.Shell.RawOutput
+	shellCfg.Verbose = fileCfg.Shell.Verbose
+	shellCfg.Quiet = fileCfg.Shell.Quiet
+	shellCfg.KeepAlive = fileCfg.Shell.KeepAlive
+	if err := client.ValidEvents(fileCfg.Shell.Events); err != nil {
+		return cfg, shellCfg, fmt.Errorf("shell.events: %w", err)
+	}
+	cfg.Events = fileCfg.Shell.Events
+	shellCfg.ControlSocket = fileCfg.Shell.ControlSocket
+	if fileCfg.Shell.SnippetsFile != "" {
+		shellCfg.SnippetsFile = fileCfg.Shell.SnippetsFile
+	}
+	if fileCfg.Shell.IdleTimeout != "" {
+		timeout, err := time.ParseDuration(fileCfg.Shell.IdleTimeout)
+		if err != nil {
+			return cfg, shellCfg, fmt.Errorf("shell.idle_timeout: %w", err)
+		}
+		shellCfg.IdleTimeout = timeout
+	}
+	if fileCfg.Shell.IdleWarning != "" {
+		warning, err := time.ParseDuration(fileCfg.Shell.IdleWarning)
+		if err != nil {
+			return cfg, shellCfg, fmt.Errorf("shell.idle_warning: %w", err)
+		}
+		shellCfg.IdleWarning = warning
+	}
+	if fileCfg.Shell.NotifyAfter != "" {
+		after, err := time.ParseDuration(fileCfg.Shell.NotifyAfter)
+		if err != nil {
+			return cfg, shellCfg, fmt.Errorf("shell.notify_after: %w", err)
+		}
+		shellCfg.NotifyAfter = after
+	}
+	switch fileCfg.Shell.Notify {
+	case "":
+	case client.NotifyBell, client.NotifyDesktop:
+		shellCfg.Notify = fileCfg.Shell.Notify
+	default:
+		return cfg, shellCfg, fmt.Errorf("shell.notify: unknown method %q (use %q or %q)", fileCfg.Shell.Notify, client.NotifyBell, client.NotifyDesktop)
+	}
 
-	return cfg, nil
+	return cfg, shellCfg, nil
 }
@@ -0,0 +1,223 @@
+// Package main is the entry point for the fan-out client, which runs one
+// command on many servers at once.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"remote-shell-rpc/internal/client"
+	"remote-shell-rpc/pkg/logger"
+)
+
+func main() {
+	// Parse command line flags
+	configPath := flag.String("config", "", "Path to configuration file")
+	hosts := flag.String("hosts", "", "Comma-separated profile names, host:port addresses or relay names (defaults to fanout.hosts, then all profiles)")
+	relayAddr := flag.String("relay", "", "Relay address; without -hosts, runs on every server registered with it")
+	list := flag.Bool("list", false, "Print the target servers and exit")
+	parallel := flag.Int("parallel", 0, "Maximum number of servers contacted at once (0 = all)")
+	timeout := flag.Int("timeout", 30, "Command timeout in seconds")
+	clientID := flag.String("client-id", "", "Client ID (auto-generated if empty)")
+	grep := flag.String("grep", "", "Only show output lines matching this regular expression")
+	logLevel := flag.String("log-level", "warn", "Log level (debug, info, warn, error)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command>\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 && !*list {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command := strings.Join(flag.Args(), " ")
+
+	// Create logger
+	log := logger.New(logger.Config{
+		Level:  logger.Level(*logLevel),
+		Format: "text",
+		Output: os.Stderr,
+	})
+
+	// Load configuration
+	cfg := client.DefaultConfig()
+	var targets []string
+	if *configPath != "" {
+		loadedCfg, loadedTargets, loadedParallel, err := loadConfig(*configPath)
+		if err != nil {
+			log.Error("Failed to load config", "error", err.Error())
+			os.Exit(1)
+		}
+		cfg = loadedCfg
+		targets = loadedTargets
+		if *parallel == 0 {
+			*parallel = loadedParallel
+		}
+	}
+
+	if *relayAddr != "" {
+		cfg.Relay = *relayAddr
+	}
+	if *hosts != "" {
+		targets = nil
+		for _, h := range strings.Split(*hosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				targets = append(targets, h)
+			}
+		}
+	}
+	if len(targets) == 0 && cfg.Relay != "" {
+		names, err := listRelayServers(cfg)
+		if err != nil {
+			log.Error("Failed to list relay servers", "error", err.Error())
+			os.Exit(1)
+		}
+		targets = names
+	}
+	if len(targets) == 0 {
+		targets = cfg.ProfileNames()
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "No servers given; use -hosts or configure fanout.hosts or profiles")
+		os.Exit(2)
+	}
+
+	if *list {
+		for _, target := range targets {
+			fmt.Println(target)
+		}
+		return
+	}
+
+	cID := *clientID
+	if cID == "" {
+		cID = fmt.Sprintf("fanout-%d", time.Now().UnixNano())
+	}
+
+	var pattern *regexp.Regexp
+	if *grep != "" {
+		var err error
+		if pattern, err = regexp.Compile(*grep); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -grep pattern: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	// Setup context with cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle interrupt signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	results := client.Fanout(ctx, cfg, command, client.FanoutOptions{
+		Targets:  targets,
+		Parallel: *parallel,
+		Timeout:  *timeout,
+		ClientID: cID,
+		Stdout:   os.Stdout,
+		Stderr:   os.Stderr,
+		Grep:     pattern,
+	}, log)
+
+	// Print the aggregate summary
+	failed := 0
+	for _, r := range results {
+		if r.Failed() {
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\n%d/%d succeeded, %d failed\n", len(results)-failed, len(results), failed)
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(os.Stderr, "  %s: %v\n", r.Target, r.Err)
+		case r.ExitCode != 0:
+			fmt.Fprintf(os.Stderr, "  %s: exit code %d\n", r.Target, r.ExitCode)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// listRelayServers returns the servers registered with the relay
+func listRelayServers(cfg client.Config) ([]string, error) {
+	dialer, err := cfg.RelayDialer()
+	if err != nil {
+		return nil, err
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+	return dialer.ListServers(ctx)
+}
+
+// loadConfig loads the server defaults, profiles and fan-out settings
+// from a client YAML configuration file
+func loadConfig(path string) (client.Config, []string, int, error) {
+	cfg := client.DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, nil, 0, err
+	}
+
+	var fileCfg struct {
+		Server struct {
+			Host    string           `yaml:"host"`
+			Port    int              `yaml:"port"`
+			Timeout string           `yaml:"timeout"`
+			TLS     client.TLSConfig `yaml:"tls"`
+			Token   string           `yaml:"token"`
+			Relay   string           `yaml:"relay"`
+
+			RelayTLS client.TLSConfig `yaml:"relay_tls"`
+		} `yaml:"server"`
+		Profiles map[string]client.Profile `yaml:"profiles"`
+		Fanout   struct {
+			Hosts    []string `yaml:"hosts"`
+			Parallel int      `yaml:"parallel"`
+		} `yaml:"fanout"`
+	}
+
+	if err := yaml.Unmarshal(data, &fileCfg); err != nil {
+		return cfg, nil, 0, err
+	}
+
+	if fileCfg.Server.Host != "" {
+		cfg.Host = fileCfg.Server.Host
+	}
+	if fileCfg.Server.Port != 0 {
+		cfg.Port = fileCfg.Server.Port
+	}
+	if fileCfg.Server.Timeout != "" {
+		if timeout, err := time.ParseDuration(fileCfg.Server.Timeout); err == nil {
+			cfg.Timeout = timeout
+		}
+	}
+	cfg.TLS = fileCfg.Server.TLS
+	cfg.Token = fileCfg.Server.Token
+	cfg.Relay = fileCfg.Server.Relay
+	cfg.RelayTLS = fileCfg.Server.RelayTLS
+	cfg.Profiles = fileCfg.Profiles
+
+	return cfg, fileCfg.Fanout.Hosts, fileCfg.Fanout.Parallel, nil
+}
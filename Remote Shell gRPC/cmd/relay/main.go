@@ -0,0 +1,215 @@
+// Package main is the entry point for the relay, which lets clients reach
+// shell servers behind NAT.
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"gopkg.in/yaml.v3"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/logger"
+	"remote-shell-rpc/pkg/relay"
+	"remote-shell-rpc/pkg/tlsreload"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes
+const certReloadInterval = 10 * time.Second
+
+// listenConfig holds where and how the relay listens
+type listenConfig struct {
+	host      string
+	port      int
+	certFile  string
+	keyFile   string
+	plaintext bool
+}
+
+func main() {
+	// Parse command line flags
+	configPath := flag.String("config", "", "Path to configuration file")
+	host := flag.String("host", "0.0.0.0", "Relay host")
+	port := flag.Int("port", 50052, "Relay port")
+	token := flag.String("token", os.Getenv("RSH_RELAY_TOKEN"), "Token servers must present to register (defaults to $RSH_RELAY_TOKEN)")
+	insecureMode := flag.Bool("insecure", false, "Let servers register without a token")
+	certFile := flag.String("tls-cert", "", "TLS certificate file")
+	keyFile := flag.String("tls-key", "", "TLS key file")
+	plaintext := flag.Bool("plaintext", false, "Serve without TLS")
+	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	flag.Parse()
+
+	// Create logger
+	log := logger.New(logger.Config{
+		Level:  logger.Level(*logLevel),
+		Format: "text",
+		Output: os.Stdout,
+	})
+
+	// Load configuration
+	cfg := relay.DefaultConfig()
+	lc := listenConfig{host: "0.0.0.0", port: 50052}
+	if *configPath != "" {
+		var err error
+		cfg, lc, err = loadConfig(*configPath)
+		if err != nil {
+			log.Error("Failed to load config", "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Override with command line flags
+	if *host != "0.0.0.0" {
+		lc.host = *host
+	}
+	if *port != 50052 {
+		lc.port = *port
+	}
+	if *certFile != "" {
+		lc.certFile = *certFile
+	}
+	if *keyFile != "" {
+		lc.keyFile = *keyFile
+	}
+	if *plaintext {
+		lc.plaintext = true
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+	if *insecureMode {
+		cfg.Insecure = true
+	}
+	if cfg.Token == "" && len(cfg.Servers) == 0 {
+		if !cfg.Insecure {
+			log.Error("No relay token configured; set relay.token, or relay.insecure to let any server register")
+			os.Exit(1)
+		}
+		log.Warn("No relay token configured; any server can register")
+	}
+
+	// Servers send their token to the relay, so it is not served in
+	// plaintext by accident
+	var certs *tlsreload.Reloader
+	if lc.certFile != "" || lc.keyFile != "" {
+		var err error
+		certs, err = tlsreload.New(lc.certFile, lc.keyFile)
+		if err != nil {
+			log.Error("Failed to load TLS certificate", "error", err.Error())
+			os.Exit(1)
+		}
+	} else if !lc.plaintext {
+		log.Error("No TLS certificate configured; set relay.tls, or relay.plaintext to serve without TLS")
+		os.Exit(1)
+	} else {
+		log.Warn("Serving without TLS; relay tokens and tunnels can be read on the network")
+	}
+
+	address := fmt.Sprintf("%s:%d", lc.host, lc.port)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Error("Failed to listen", "address", address, "error", err.Error())
+		os.Exit(1)
+	}
+
+	// Keepalives drop the registrations of servers that vanished, so
+	// that their names are free again when they come back
+	opts := []grpc.ServerOption{grpc.KeepaliveParams(keepalive.ServerParameters{
+		Time:    30 * time.Second,
+		Timeout: 10 * time.Second,
+	})}
+	if certs != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			GetCertificate: certs.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		})))
+		stop := make(chan struct{})
+		defer close(stop)
+		go certs.Watch(certReloadInterval, stop, func(err error) {
+			if err != nil {
+				log.Warn("Failed to reload TLS certificate", "cert_file", certs.CertFile(), "error", err.Error())
+				return
+			}
+			log.Info("TLS certificate reloaded", "cert_file", certs.CertFile(), "expires", certs.Expires())
+		})
+	}
+	grpcServer := grpc.NewServer(opts...)
+	pb.RegisterRelayServiceServer(grpcServer, relay.NewServer(cfg, log))
+
+	// Handle graceful shutdown
+	go func() {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		<-sigCh
+		log.Info("Shutting down relay")
+		grpcServer.Stop()
+	}()
+
+	log.Info("Relay starting", "address", address, "tls", certs != nil)
+	if err := grpcServer.Serve(listener); err != nil {
+		log.Error("Relay failed", "error", err.Error())
+		os.Exit(1)
+	}
+}
+
+// loadConfig loads relay configuration from a YAML file
+func loadConfig(path string) (relay.Config, listenConfig, error) {
+	cfg := relay.DefaultConfig()
+	lc := listenConfig{host: "0.0.0.0", port: 50052}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, lc, err
+	}
+
+	var fileCfg struct {
+		Relay struct {
+			Host          string            `yaml:"host"`
+			Port          int               `yaml:"port"`
+			Token         string            `yaml:"token"`
+			Servers       map[string]string `yaml:"servers"`
+			Insecure      bool              `yaml:"insecure"`
+			TunnelTimeout string            `yaml:"tunnel_timeout"`
+			TLS           struct {
+				CertFile string `yaml:"cert_file"`
+				KeyFile  string `yaml:"key_file"`
+			} `yaml:"tls"`
+			Plaintext bool `yaml:"plaintext"`
+		} `yaml:"relay"`
+	}
+
+	if err := yaml.Unmarshal(data, &fileCfg); err != nil {
+		return cfg, lc, err
+	}
+
+	if fileCfg.Relay.Host != "" {
+		lc.host = fileCfg.Relay.Host
+	}
+	if fileCfg.Relay.Port != 0 {
+		lc.port = fileCfg.Relay.Port
+	}
+	lc.certFile = fileCfg.Relay.TLS.CertFile
+	lc.keyFile = fileCfg.Relay.TLS.KeyFile
+	lc.plaintext = fileCfg.Relay.Plaintext
+	cfg.Token = fileCfg.Relay.Token
+	cfg.Servers = fileCfg.Relay.Servers
+	cfg.Insecure = fileCfg.Relay.Insecure
+	if fileCfg.Relay.TunnelTimeout != "" {
+		if timeout, err := time.ParseDuration(fileCfg.Relay.TunnelTimeout); err == nil {
+			cfg.TunnelTimeout = timeout
+		}
+	}
+
+	return cfg, lc, nil
+}
@@ -3,12 +3,18 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"strings"
 	"time"
+	"golang.org/x/text/encoding/htmlindex"
 	"gopkg.in/yaml.v3"
 	"remote-shell-rpc/internal/server"
+	"remote-shell-rpc/pkg/executor"
 	"remote-shell-rpc/pkg/logger"
+	"remote-shell-rpc/pkg/netfilter"
 )
 
 func main() {
@@ -17,6 +23,7 @@ func main() {
 	host := flag.String("host", "0.0.0.0", "Server host")
 	port := flag.Int("port", 50051, "Server port")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	validate := flag.Bool("validate-config", false, "Check the configuration, print the effective settings and exit")
 	flag.Parse()
 
 	// Create logger
@@ -47,13 +54,22 @@ func main() {
 		cfg.Port = *port
 	}
 
+	if *validate {
+		os.Exit(validateConfig(cfg))
+	}
+
 	// Create and start server
-	srv := server.New(cfg, log)
+	srv, err := server.New(cfg, log)
+	if err != nil {
+		log.Error("Failed to create server", "error", err.Error())
+		os.Exit(1)
+	}
 
 	log.Info("Starting Remote Shell RPC Server",
 		"host", cfg.Host,
 		"port", cfg.Port,
 		"max_connections", cfg.MaxConnections,
+		"max_streams_per_client", cfg.MaxStreamsPerClient,
 	)
 
 	if err := srv.Start(); err != nil {
@@ -62,6 +78,39 @@ func main() {
 	}
 }
 
+// validateConfig prints the effective configuration and every problem
+// that would keep the server from starting or serving commands. It
+// returns the process exit code.
+func validateConfig(cfg server.Config) int {
+	out, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print config: %v\n", err)
+		return 1
+	}
+	fmt.Print(string(out))
+
+	errs := server.Check(cfg)
+	for _, err := range errs {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "Configuration has %d problem(s)\n", len(errs))
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Configuration OK")
+	return 0
+}
+
+// macroName matches valid macro names
+var macroName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// Bounds for executor.chunk_size_bytes; chunks must also fit in
+// server.max_send_msg_size
+const (
+	minChunkSize = 256
+	maxChunkSize = 1 << 20
+)
+
 // loadConfig loads configuration from a YAML file
 func loadConfig(path string) (server.Config, error) {
 	cfg := server.DefaultConfig()
@@ -73,20 +122,127 @@ func loadConfig(path string) (server.Config, error) {
 
 	var fileCfg struct {
 		Server struct {
-			Host           string `yaml:"host"`
-			Port           int    `yaml:"port"`
-			MaxConnections int    `yaml:"max_connections"`
+			Host                string   `yaml:"host"`
+			Port                int      `yaml:"port"`
+			MaxConnections      int      `yaml:"max_connections"`
+			MaxStreamsPerClient *int     `yaml:"max_streams_per_client"`
+			MaxRecvMsgSize      int      `yaml:"max_recv_msg_size"`
+			MaxSendMsgSize      int      `yaml:"max_send_msg_size"`
+			UploadResumeTTL     string   `yaml:"upload_resume_ttl"`
+			Name                string   `yaml:"name"`
+			AllowedNetworks     []string `yaml:"allowed_networks"`
+			DeniedNetworks      []string `yaml:"denied_networks"`
+
+			TLS          server.TLSConfig         `yaml:"tls"`
+			Listeners    []server.ListenerConfig  `yaml:"listeners"`
+			Interceptors server.InterceptorConfig `yaml:"interceptors"`
 		} `yaml:"server"`
+		ACME struct {
+			Domains      []string `yaml:"domains"`
+			Email        string   `yaml:"email"`
+			CacheDir     string   `yaml:"cache_dir"`
+			DirectoryURL string   `yaml:"directory_url"`
+			HTTPAddress  string   `yaml:"http_address"`
+		} `yaml:"acme"`
 		Executor struct {
-			Timeout string `yaml:"timeout"`
-			Shell   string `yaml:"shell"`
+			Timeout        string   `yaml:"timeout"`
+			SoftTimeout    string   `yaml:"soft_timeout"`
+			KeepAlive      string   `yaml:"keep_alive_interval"`
+			KeepAliveMax   string   `yaml:"keep_alive_max"`
+			Shell          string   `yaml:"shell"`
+			AllowedShells  []string `yaml:"allowed_shells"`
+			InitScript     string   `yaml:"init_script"`
+			Umask          string   `yaml:"umask"`
+			ChunkSizeBytes int      `yaml:"chunk_size_bytes"`
+			FlushInterval  string   `yaml:"flush_interval"`
+			IsolateNetwork bool     `yaml:"isolate_network"`
+			MaxCommands    int      `yaml:"max_commands_per_session"`
+			MaxQueued      *int     `yaml:"max_queued_per_session"`
+			IdempotencyTTL string   `yaml:"idempotency_ttl"`
+			IdempotencyMax *int     `yaml:"idempotency_keys"`
+			Limits         struct {
+				OpenFiles  uint64 `yaml:"open_files"`
+				Processes  uint64 `yaml:"processes"`
+				CPUSeconds uint64 `yaml:"cpu_seconds"`
+			} `yaml:"limits"`
+			Priority struct {
+				Nice    int    `yaml:"nice"`
+				IOClass string `yaml:"io_class"`
+				IOLevel int    `yaml:"io_level"`
+			} `yaml:"priority"`
+			InheritEnv   executor.EnvPolicy   `yaml:"inherit_env"`
+			TimeoutRules []server.TimeoutRule `yaml:"timeout_rules"`
 		} `yaml:"executor"`
+		Audit struct {
+			Driver string `yaml:"driver"`
+			DSN    string `yaml:"dsn"`
+		} `yaml:"audit"`
+		Admin struct {
+			Token string `yaml:"token"`
+		} `yaml:"admin"`
+		Auth struct {
+			AuthorizedKeys  string `yaml:"authorized_keys"`
+			TokenTTL        string `yaml:"token_ttl"`
+			SessionTokenTTL string `yaml:"session_token_ttl"`
+			OIDC            struct {
+				Issuer   string `yaml:"issuer"`
+				Audience string `yaml:"audience"`
+			} `yaml:"oidc"`
+		} `yaml:"auth"`
+		Policy struct {
+			DangerousAction string                 `yaml:"dangerous_action"`
+			ConfirmTimeout  string                 `yaml:"confirm_timeout"`
+			DangerousRules  []server.DangerousRule `yaml:"dangerous_rules"`
+			StrictMode      bool                   `yaml:"strict_mode"`
+			StrictAllow     []string               `yaml:"strict_allow"`
+		} `yaml:"policy"`
+		Approval struct {
+			Patterns []string `yaml:"patterns"`
+			Timeout  string   `yaml:"timeout"`
+		} `yaml:"approval"`
+		Relay struct {
+			Address    string `yaml:"address"`
+			Name       string `yaml:"name"`
+			Token      string `yaml:"token"`
+			AgentMode  bool   `yaml:"agent_mode"`
+			CAFile     string `yaml:"ca_file"`
+			ServerName string `yaml:"server_name"`
+			Plaintext  bool   `yaml:"plaintext"`
+		} `yaml:"relay"`
+		Output struct {
+			Encoding    string `yaml:"encoding"`
+			InvalidUTF8 string `yaml:"invalid_utf8"`
+		} `yaml:"output"`
+		Macros      map[string]string `yaml:"macros"`
+		Banner      string            `yaml:"banner"`
+		Maintenance struct {
+			Message string `yaml:"message"`
+		} `yaml:"maintenance"`
+		Monitor struct {
+			Interval       string `yaml:"interval"`
+			MaxGoroutines  *int   `yaml:"max_goroutines"`
+			MaxHeapMB      int    `yaml:"max_heap_mb"`
+			MaxOpenFiles   int    `yaml:"max_open_files"`
+			RefuseCommands bool   `yaml:"refuse_commands"`
+		} `yaml:"monitor"`
+		Sharing struct {
+			Enabled *bool `yaml:"enabled"`
+		} `yaml:"sharing"`
+		Ban struct {
+			MaxFailures *int   `yaml:"max_failures"`
+			Window      string `yaml:"window"`
+			Duration    string `yaml:"duration"`
+			MaxDuration string `yaml:"max_duration"`
+		} `yaml:"ban"`
 		Logging struct {
 			Level  string `yaml:"level"`
 			Format string `yaml:"format"`
 		} `yaml:"logging"`
 	}
 
+	// Interceptors missing from the file keep their defaults
+	fileCfg.Server.Interceptors = cfg.Interceptors
+	fileCfg.Executor.InheritEnv = cfg.InheritEnv
 	if err := yaml.Unmarshal(data, &fileCfg); err != nil {
 		return cfg, err
 	}
@@ -97,17 +253,299 @@ func loadConfig(path string) (server.Config, error) {
 	if fileCfg.Server.Port != 0 {
 		cfg.Port = fileCfg.Server.Port
 	}
+	cfg.Name = fileCfg.Server.Name
 	if fileCfg.Server.MaxConnections != 0 {
 		cfg.MaxConnections = fileCfg.Server.MaxConnections
 	}
+	if fileCfg.Server.MaxStreamsPerClient != nil {
+		cfg.MaxStreamsPerClient = *fileCfg.Server.MaxStreamsPerClient
+	}
+	if size := fileCfg.Server.MaxRecvMsgSize; size != 0 {
+		if size < server.MinMsgSize {
+			return cfg, fmt.Errorf("server.max_recv_msg_size must be at least %d", server.MinMsgSize)
+		}
+		cfg.MaxRecvMsgSize = size
+	}
+	if size := fileCfg.Server.MaxSendMsgSize; size != 0 {
+		if size < server.MinMsgSize {
+			return cfg, fmt.Errorf("server.max_send_msg_size must be at least %d", server.MinMsgSize)
+		}
+		cfg.MaxSendMsgSize = size
+	}
+	if fileCfg.Server.UploadResumeTTL != "" {
+		ttl, err := time.ParseDuration(fileCfg.Server.UploadResumeTTL)
+		if err != nil || ttl < 0 {
+			return cfg, fmt.Errorf("invalid server.upload_resume_ttl %q", fileCfg.Server.UploadResumeTTL)
+		}
+		cfg.UploadResumeTTL = ttl
+	}
+	if _, err := netfilter.New(fileCfg.Server.AllowedNetworks, fileCfg.Server.DeniedNetworks); err != nil {
+		return cfg, fmt.Errorf("invalid server network lists: %w", err)
+	}
+	cfg.AllowedNetworks = fileCfg.Server.AllowedNetworks
+	cfg.DeniedNetworks = fileCfg.Server.DeniedNetworks
+	cfg.TLS = fileCfg.Server.TLS
+	for i, l := range fileCfg.Server.Listeners {
+		switch l.Network {
+		case "":
+			// Paths are Unix sockets, anything else a TCP address
+			if strings.Contains(l.Address, "/") {
+				fileCfg.Server.Listeners[i].Network = "unix"
+			} else {
+				fileCfg.Server.Listeners[i].Network = "tcp"
+			}
+		case "tcp", "tcp4", "tcp6", "unix":
+		default:
+			return cfg, fmt.Errorf("invalid network %q in server.listeners[%d]", l.Network, i)
+		}
+		if l.Address == "" {
+			return cfg, fmt.Errorf("server.listeners[%d] requires an address", i)
+		}
+	}
+	cfg.Listeners = fileCfg.Server.Listeners
+	cfg.Interceptors = fileCfg.Server.Interceptors
+	cfg.ACMEDomains = fileCfg.ACME.Domains
+	cfg.ACMEEmail = fileCfg.ACME.Email
+	cfg.ACMECacheDir = fileCfg.ACME.CacheDir
+	cfg.ACMEDirectoryURL = fileCfg.ACME.DirectoryURL
+	cfg.ACMEHTTPAddress = fileCfg.ACME.HTTPAddress
 	if fileCfg.Executor.Timeout != "" {
 		if timeout, err := time.ParseDuration(fileCfg.Executor.Timeout); err == nil {
 			cfg.CommandTimeout = timeout
 		}
 	}
+	for i, rule := range fileCfg.Executor.TimeoutRules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return cfg, fmt.Errorf("invalid pattern %q in executor.timeout_rules[%d]: %w", rule.Pattern, i, err)
+		}
+		if rule.Timeout <= 0 {
+			return cfg, fmt.Errorf("executor.timeout_rules[%d] requires a positive timeout", i)
+		}
+	}
+	cfg.TimeoutRules = fileCfg.Executor.TimeoutRules
+	if fileCfg.Executor.SoftTimeout != "" {
+		soft, err := time.ParseDuration(fileCfg.Executor.SoftTimeout)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid executor.soft_timeout %q: %w", fileCfg.Executor.SoftTimeout, err)
+		}
+		cfg.SoftTimeout = soft
+	}
+	if fileCfg.Executor.KeepAlive != "" {
+		interval, err := time.ParseDuration(fileCfg.Executor.KeepAlive)
+		if err != nil || interval < 0 {
+			return cfg, fmt.Errorf("invalid executor.keep_alive_interval %q", fileCfg.Executor.KeepAlive)
+		}
+		cfg.KeepAliveInterval = interval
+	}
+	if fileCfg.Executor.KeepAliveMax != "" {
+		limit, err := time.ParseDuration(fileCfg.Executor.KeepAliveMax)
+		if err != nil || limit < 0 {
+			return cfg, fmt.Errorf("invalid executor.keep_alive_max %q", fileCfg.Executor.KeepAliveMax)
+		}
+		cfg.KeepAliveMax = limit
+	}
 	if fileCfg.Executor.Shell != "" {
 		cfg.Shell = fileCfg.Executor.Shell
 	}
+	cfg.AllowedShells = fileCfg.Executor.AllowedShells
+	cfg.InitScript = fileCfg.Executor.InitScript
+	if size := fileCfg.Executor.ChunkSizeBytes; size != 0 {
+		if size < minChunkSize || size > maxChunkSize {
+			return cfg, fmt.Errorf("executor.chunk_size_bytes must be between %d and %d", minChunkSize, maxChunkSize)
+		}
+		cfg.ChunkSizeBytes = size
+	}
+	if cfg.ChunkSizeBytes > server.ChunkLimit(cfg.MaxSendMsgSize) {
+		return cfg, fmt.Errorf("executor.chunk_size_bytes must be at most %d to fit in server.max_send_msg_size", server.ChunkLimit(cfg.MaxSendMsgSize))
+	}
+	if fileCfg.Executor.FlushInterval != "" {
+		interval, err := time.ParseDuration(fileCfg.Executor.FlushInterval)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid executor.flush_interval %q: %w", fileCfg.Executor.FlushInterval, err)
+		}
+		cfg.FlushInterval = interval
+	}
+	cfg.IsolateNetwork = fileCfg.Executor.IsolateNetwork
+	if fileCfg.Executor.MaxCommands < 0 {
+		return cfg, fmt.Errorf("executor.max_commands_per_session must not be negative")
+	}
+	cfg.MaxCommandsPerSession = fileCfg.Executor.MaxCommands
+	if fileCfg.Executor.MaxQueued != nil {
+		if *fileCfg.Executor.MaxQueued < 0 {
+			return cfg, fmt.Errorf("executor.max_queued_per_session must not be negative")
+		}
+		cfg.MaxQueuedPerSession = *fileCfg.Executor.MaxQueued
+	}
+	if fileCfg.Executor.IdempotencyTTL != "" {
+		ttl, err := time.ParseDuration(fileCfg.Executor.IdempotencyTTL)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid executor.idempotency_ttl %q: %w", fileCfg.Executor.IdempotencyTTL, err)
+		}
+		cfg.IdempotencyTTL = ttl
+	}
+	if fileCfg.Executor.IdempotencyMax != nil {
+		if *fileCfg.Executor.IdempotencyMax < 0 {
+			return cfg, fmt.Errorf("executor.idempotency_keys must not be negative")
+		}
+		cfg.IdempotencyKeys = *fileCfg.Executor.IdempotencyMax
+	}
+	cfg.Limits.OpenFiles = fileCfg.Executor.Limits.OpenFiles
+	cfg.Limits.Processes = fileCfg.Executor.Limits.Processes
+	cfg.Limits.CPUSeconds = fileCfg.Executor.Limits.CPUSeconds
+	cfg.Priority.Nice = fileCfg.Executor.Priority.Nice
+	cfg.Priority.IOClass = fileCfg.Executor.Priority.IOClass
+	cfg.Priority.IOLevel = fileCfg.Executor.Priority.IOLevel
+	if err := cfg.Priority.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid executor.priority: %w", err)
+	}
+	if err := fileCfg.Executor.InheritEnv.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid executor.inherit_env: %w", err)
+	}
+	cfg.InheritEnv = fileCfg.Executor.InheritEnv
+	if fileCfg.Executor.Umask != "" {
+		umask, err := executor.ParseUmask(fileCfg.Executor.Umask)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid executor.umask: %w", err)
+		}
+		cfg.Umask = umask
+	}
+	cfg.AuditDriver = fileCfg.Audit.Driver
+	cfg.AuditDSN = fileCfg.Audit.DSN
+	cfg.AdminToken = fileCfg.Admin.Token
+	cfg.AuthorizedKeysFile = fileCfg.Auth.AuthorizedKeys
+	if fileCfg.Auth.TokenTTL != "" {
+		ttl, err := time.ParseDuration(fileCfg.Auth.TokenTTL)
+		if err != nil || ttl <= 0 {
+			return cfg, fmt.Errorf("invalid auth.token_ttl %q", fileCfg.Auth.TokenTTL)
+		}
+		cfg.LoginTokenTTL = ttl
+	}
+	if fileCfg.Auth.SessionTokenTTL != "" {
+		ttl, err := time.ParseDuration(fileCfg.Auth.SessionTokenTTL)
+		if err != nil || ttl < 0 {
+			return cfg, fmt.Errorf("invalid auth.session_token_ttl %q", fileCfg.Auth.SessionTokenTTL)
+		}
+		cfg.SessionTokenTTL = ttl
+	}
+	cfg.OIDCIssuer = fileCfg.Auth.OIDC.Issuer
+	cfg.OIDCAudience = fileCfg.Auth.OIDC.Audience
+	switch fileCfg.Policy.DangerousAction {
+	case "":
+	case server.ActionBlock, server.ActionConfirm, server.ActionAudit:
+		cfg.DangerousAction = fileCfg.Policy.DangerousAction
+	default:
+		return cfg, fmt.Errorf("invalid policy.dangerous_action %q", fileCfg.Policy.DangerousAction)
+	}
+	for i, rule := range fileCfg.Policy.DangerousRules {
+		switch rule.Action {
+		case server.ActionBlock, server.ActionConfirm, server.ActionAudit:
+		default:
+			return cfg, fmt.Errorf("invalid action %q in policy.dangerous_rules[%d]", rule.Action, i)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return cfg, fmt.Errorf("invalid pattern %q in policy.dangerous_rules[%d]: %w", rule.Pattern, i, err)
+		}
+	}
+	cfg.DangerousRules = fileCfg.Policy.DangerousRules
+	if err := server.ValidStrictAllow(fileCfg.Policy.StrictAllow); err != nil {
+		return cfg, fmt.Errorf("invalid policy.strict_allow: %w", err)
+	}
+	cfg.StrictMode = fileCfg.Policy.StrictMode
+	cfg.StrictAllow = fileCfg.Policy.StrictAllow
+	if fileCfg.Policy.ConfirmTimeout != "" {
+		if timeout, err := time.ParseDuration(fileCfg.Policy.ConfirmTimeout); err == nil {
+			cfg.ConfirmTimeout = timeout
+		}
+	}
+	for _, pattern := range fileCfg.Approval.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return cfg, fmt.Errorf("invalid approval pattern %q: %w", pattern, err)
+		}
+	}
+	cfg.ApprovalPatterns = fileCfg.Approval.Patterns
+	if fileCfg.Approval.Timeout != "" {
+		if timeout, err := time.ParseDuration(fileCfg.Approval.Timeout); err == nil {
+			cfg.ApprovalTimeout = timeout
+		}
+	}
+
+	for name := range fileCfg.Macros {
+		if !macroName.MatchString(name) {
+			return cfg, fmt.Errorf("invalid macro name %q", name)
+		}
+	}
+	cfg.Macros = fileCfg.Macros
+	cfg.MaintenanceMessage = fileCfg.Maintenance.Message
+	if fileCfg.Monitor.Interval != "" {
+		interval, err := time.ParseDuration(fileCfg.Monitor.Interval)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid monitor.interval %q: %w", fileCfg.Monitor.Interval, err)
+		}
+		cfg.MonitorInterval = interval
+	}
+	if fileCfg.Monitor.MaxGoroutines != nil {
+		cfg.MaxGoroutines = *fileCfg.Monitor.MaxGoroutines
+	}
+	cfg.MaxHeapMB = fileCfg.Monitor.MaxHeapMB
+	cfg.MaxOpenFiles = fileCfg.Monitor.MaxOpenFiles
+	cfg.RefuseWhenOverloaded = fileCfg.Monitor.RefuseCommands
+	if fileCfg.Sharing.Enabled != nil {
+		cfg.AllowSharing = *fileCfg.Sharing.Enabled
+	}
+	if fileCfg.Ban.MaxFailures != nil {
+		if *fileCfg.Ban.MaxFailures < 0 {
+			return cfg, fmt.Errorf("ban.max_failures must not be negative")
+		}
+		cfg.BanMaxFailures = *fileCfg.Ban.MaxFailures
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"window", fileCfg.Ban.Window, &cfg.BanWindow},
+		{"duration", fileCfg.Ban.Duration, &cfg.BanDuration},
+		{"max_duration", fileCfg.Ban.MaxDuration, &cfg.BanMaxDuration},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil || duration <= 0 {
+			return cfg, fmt.Errorf("invalid ban.%s %q", d.name, d.value)
+		}
+		*d.dst = duration
+	}
+	if cfg.BanMaxDuration < cfg.BanDuration {
+		return cfg, fmt.Errorf("ban.max_duration must not be shorter than ban.duration")
+	}
+	cfg.Banner = fileCfg.Banner
+	if fileCfg.Relay.Address != "" && fileCfg.Relay.Name == "" {
+		return cfg, fmt.Errorf("relay.name is required when relay.address is set")
+	}
+	if fileCfg.Relay.AgentMode && fileCfg.Relay.Address == "" {
+		return cfg, fmt.Errorf("relay.agent_mode requires relay.address")
+	}
+	cfg.AgentMode = fileCfg.Relay.AgentMode
+	cfg.RelayAddress = fileCfg.Relay.Address
+	cfg.RelayName = fileCfg.Relay.Name
+	cfg.RelayToken = fileCfg.Relay.Token
+	cfg.RelayCAFile = fileCfg.Relay.CAFile
+	cfg.RelayServerName = fileCfg.Relay.ServerName
+	cfg.RelayPlaintext = fileCfg.Relay.Plaintext
+	if fileCfg.Output.Encoding != "" {
+		if _, err := htmlindex.Get(fileCfg.Output.Encoding); err != nil {
+			return cfg, fmt.Errorf("invalid output.encoding %q: %w", fileCfg.Output.Encoding, err)
+		}
+	}
+	cfg.OutputEncoding = fileCfg.Output.Encoding
+	switch fileCfg.Output.InvalidUTF8 {
+	case "":
+	case server.InvalidUTF8Replace, server.InvalidUTF8Binary:
+		cfg.InvalidUTF8 = fileCfg.Output.InvalidUTF8
+	default:
+		return cfg, fmt.Errorf("invalid output.invalid_utf8 %q", fileCfg.Output.InvalidUTF8)
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// Load balancing policies for a server given as a list of addresses or a
+// dns:/// target that resolves to several
+const (
+	// PickFirst connects to the addresses in order and uses the first one
+	// that answers, so the others are fallbacks
+	PickFirst = "pick_first"
+	// RoundRobin spreads clients over the addresses by trying them in a
+	// random order. A session lives on one server, so the requests of a
+	// connection are not spread.
+	RoundRobin = "round_robin"
+)
+
+// staticScheme is the resolver scheme of a server list
+const staticScheme = "static"
+
+// ValidLoadBalancing reports an error for an unknown policy; empty selects
+// PickFirst
+func ValidLoadBalancing(policy string) error {
+	switch policy {
+	case "", PickFirst, RoundRobin:
+		return nil
+	}
+	return fmt.Errorf("unknown load balancing policy %q (use %q or %q)", policy, PickFirst, RoundRobin)
+}
+
+// serverList reports whether the host is a comma-separated list of
+// servers
+func (c Config) serverList() bool {
+	return strings.Contains(c.Host, ",")
+}
+
+// dnsTarget reports whether the host is a dns:/// target, which may
+// resolve to several servers
+func (c Config) dnsTarget() bool {
+	return strings.HasPrefix(c.Host, "dns://")
+}
+
+// withPort adds the configured port to an address that has none
+func (c Config) withPort(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(c.Port))
+}
+
+// serverAddresses returns the addresses of a server list, each with a
+// port
+func (c Config) serverAddresses() []string {
+	var addresses []string
+	for _, address := range strings.Split(c.Host, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, c.withPort(address))
+		}
+	}
+	return addresses
+}
+
+// balancingOptions returns the dial options that resolve a server list and
+// select the load balancing policy
+func (c Config) balancingOptions() ([]grpc.DialOption, error) {
+	if err := ValidLoadBalancing(c.LoadBalancing); err != nil {
+		return nil, err
+	}
+	var opts []grpc.DialOption
+	if c.serverList() {
+		addresses := c.serverAddresses()
+		if len(addresses) == 0 {
+			return nil, fmt.Errorf("no server addresses in %q", c.Host)
+		}
+		state := resolver.State{}
+		for _, address := range addresses {
+			// Each server's certificate is checked against its own name
+			host, _, _ := net.SplitHostPort(address)
+			state.Addresses = append(state.Addresses, resolver.Address{Addr: address, ServerName: host})
+		}
+		r := manual.NewBuilderWithScheme(staticScheme)
+		r.InitialState(state)
+		opts = append(opts, grpc.WithResolvers(r))
+	}
+	if c.LoadBalancing == RoundRobin {
+		opts = append(opts, grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"pick_first": {"shuffleAddressList": true}}]}`))
+	}
+	return opts, nil
+}
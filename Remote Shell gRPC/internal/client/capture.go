@@ -0,0 +1,44 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// handleCapture saves command output to a file on the server and shows
+// only its last lines. "capture <file> <command>" does this for a single
+// command, "capture -dir <dir>" for every command until "capture -off".
+func (s *Shell) handleCapture(ctx context.Context, input string, args []string) error {
+	if len(args) == 0 {
+		if s.captureDir == "" {
+			fmt.Println("Output capture is off")
+		} else {
+			fmt.Printf("Saving command output in %s\n", s.captureDir)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "-off":
+		s.captureDir = ""
+		fmt.Println("Output capture is off")
+		return nil
+	case "-dir":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: capture -dir <remote-dir>")
+		}
+		// A trailing slash asks the server for a new file per command
+		s.captureDir = strings.TrimSuffix(args[1], "/") + "/"
+		fmt.Printf("Saving command output in %s\n", s.captureDir)
+		return nil
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf("usage: capture <remote-file> <command>")
+	}
+	// Keep the command exactly as typed
+	rest := strings.TrimSpace(strings.TrimPrefix(input, "capture"))
+	command := strings.TrimSpace(rest[len(args[0]):])
+	return s.runRemoteCommand(ctx, command, args[0], nil)
+}
@@ -2,23 +2,74 @@ package client
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"golang.org/x/crypto/ssh"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	pb "remote-shell-rpc/proto"
 
 	"remote-shell-rpc/pkg/logger"
+	"remote-shell-rpc/pkg/oidc"
+	"remote-shell-rpc/pkg/relay"
+	"remote-shell-rpc/pkg/streamcheck"
 )
 
 // Config holds client configuration
 type Config struct {
-	Host    string        `yaml:"host"`
-	Port    int           `yaml:"port"`
-	Timeout time.Duration `yaml:"timeout"`
+	Host      string        `yaml:"host"`
+	Port      int           `yaml:"port"`
+	Timeout   time.Duration `yaml:"timeout"`
+	StateFile string        `yaml:"state_file"`
+	Shell     string        `yaml:"shell"`
+	// LoginShell and InitScript request a login shell and the server's
+	// init script for new sessions
+	LoginShell bool               `yaml:"login_shell"`
+	InitScript bool               `yaml:"init_script"`
+	TLS        TLSConfig          `yaml:"tls"`
+	Token      string             `yaml:"token"`
+	Profile    string             `yaml:"-"`
+	Profiles   map[string]Profile `yaml:"profiles"`
+	// Relay is the address of a relay to reach the server through; Host
+	// is then the name the server registered under and Port is unused.
+	// TLS still applies end to end to the server inside the tunnel, while
+	// RelayTLS secures the connection to the relay itself.
+	Relay    string    `yaml:"relay"`
+	RelayTLS TLSConfig `yaml:"relay_tls"`
+	// SSHKey logs in with this SSH private key, or with the keys held by
+	// ssh-agent when it is "agent", instead of sending Token
+	SSHKey string `yaml:"ssh_key"`
+	// OIDC logs in with single sign-on at an identity provider instead
+	// of sending Token
+	OIDC OIDCConfig `yaml:"oidc"`
+	// MaxRecvMsgSize and MaxSendMsgSize cap the size of one gRPC message
+	// in bytes; zero keeps gRPC's defaults. The receive limit is reported
+	// to the server, which chunks streamed output to fit it.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize int `yaml:"max_send_msg_size"`
+	// LoadBalancing picks among the servers when Host lists several,
+	// separated by commas, or is a dns:/// target: PickFirst (the
+	// default) or RoundRobin
+	LoadBalancing string `yaml:"load_balancing"`
+	// Events writes connection, session and command events to stderr in
+	// this format, for wrappers and editors; only EventsJSON is supported
+	Events string `yaml:"events"`
+	// Umask is the octal file mode creation mask asked for new sessions;
+	// empty leaves it to the server
+	Umask string `yaml:"umask"`
+	// Glob is how the pathname patterns of commands are expanded: one of
+	// the glob modes, or empty for GlobShell
+	Glob string `yaml:"glob"`
 }
 
 // DefaultConfig returns the default client configuration
@@ -34,9 +85,40 @@ func DefaultConfig() Config {
 type Client struct {
 	config    Config
 	conn      *grpc.ClientConn
+	relay     *relay.Dialer
 	client    pb.ShellServiceClient
 	sessionID string
-	logger    *logger.Logger
+	clientID  string
+	banner    string
+	// serverID identifies the server process that created the session
+	serverID string
+	// guest is set while attached to another client's session
+	guest bool
+	// workingDir is the session's working directory as last reported by
+	// the server; events of a shared session update it concurrently
+	workingDir string
+	cwdMu      sync.Mutex
+	// login is the token from logging in with an SSH key, and signers
+	// the keys loaded from signersFrom
+	login       string
+	loginMu     sync.Mutex
+	signers     []ssh.Signer
+	signersFrom string
+	// ssoCurrent is the single sign-on login with the provider and client
+	// ID named by ssoKey
+	ssoCurrent  *oidc.Token
+	ssoProvider *oidc.Provider
+	ssoKey      string
+	ssoMu       sync.Mutex
+	// sessionToken is sent with every request for the session and renewed
+	// after tokenRenewAt
+	sessionToken string
+	tokenRenewAt time.Time
+	tokenMu      sync.Mutex
+	logger       *logger.Logger
+	// glob is the pb.CommandRequest_Glob sent with commands, which the
+	// glob builtin changes while control requests may be running
+	glob atomic.Int32
 }
 
 // New creates a new Client with the given configuration
@@ -44,97 +126,370 @@ func New(cfg Config, log *logger.Logger) *Client {
 	if log == nil {
 		log = logger.Default()
 	}
-	return &Client{
+	c := &Client{
 		config: cfg,
 		logger: log.WithComponent("client"),
 	}
+	c.glob.Store(int32(globModes[cfg.Glob]))
+	return c
 }
 
 // Connect establishes a connection to the server
 func (c *Client) Connect(ctx context.Context) error {
-	address := fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+	address := c.target()
+
+	// A device login waits for the user, so it happens before the
+	// connection timeout starts
+	sso := c.config.SSHKey == "" && c.config.OIDC.Issuer != ""
+	if sso {
+		if err := c.ssoLogin(ctx); err != nil {
+			return err
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
 	defer cancel()
 
-	c.logger.Info("Connecting to server", "address", address)
+	c.logger.Info("Connecting to server", "address", c.Address(), "profile", c.config.Profile)
+
+	creds, err := c.config.transportCredentials()
+	if err != nil {
+		return err
+	}
 
-	conn, err := grpc.DialContext(ctx, address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
 		grpc.WithBlock(),
-	)
+		grpc.WithPerRPCCredentials(sessionCredentials{client: c}),
+	}
+	balancing, err := c.config.balancingOptions()
 	if err != nil {
-		return fmt.Errorf("failed to connect to %s: %w", address, err)
+		return err
+	}
+	opts = append(opts, balancing...)
+	if c.config.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(c.config.MaxRecvMsgSize)))
+	}
+	if c.config.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(c.config.MaxSendMsgSize)))
+	}
+	// With an SSH key the client logs in once connected and sends the
+	// token it gets
+	var signers []ssh.Signer
+	if c.config.SSHKey != "" {
+		signers, err = c.sshSigners()
+		if err != nil {
+			return err
+		}
+		c.loginMu.Lock()
+		c.login = ""
+		c.loginMu.Unlock()
+		opts = append(opts, grpc.WithPerRPCCredentials(loginCredentials{client: c}))
+	} else if sso {
+		if !c.config.TLS.Enabled && !c.config.unixSocket() {
+			c.logger.Warn("Sending single sign-on token over an unencrypted connection")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(ssoCredentials{client: c}))
+	} else if c.config.Token != "" {
+		if !c.config.TLS.Enabled && !c.config.unixSocket() {
+			c.logger.Warn("Sending auth token over an unencrypted connection")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{
+			token:  c.config.Token,
+			secure: c.config.TLS.Enabled,
+		}))
+	}
+
+	// Through a relay the server is addressed by name and reached over a
+	// tunnel instead of a TCP connection
+	var dialer *relay.Dialer
+	if c.config.Relay != "" {
+		dialer, err = c.config.RelayDialer()
+		if err != nil {
+			return err
+		}
+		address = "passthrough:///" + c.config.Host
+		opts = append(opts, grpc.WithContextDialer(dialer.DialContext))
+	}
+
+	conn, err := grpc.DialContext(ctx, address, opts...)
+	if err != nil {
+		if dialer != nil {
+			dialer.Close()
+		}
+		return fmt.Errorf("failed to connect to %s: %w", c.Address(), err)
 	}
 
 	c.conn = conn
+	c.relay = dialer
 	c.client = pb.NewShellServiceClient(conn)
 
+	if signers != nil {
+		if err := c.loginWithKey(ctx, signers); err != nil {
+			c.Detach()
+			return err
+		}
+	}
+
 	c.logger.Info("Connected to server", "address", address)
+	c.emit(event{Event: eventConnected, Address: c.Address()})
 	return nil
 }
 
-// Disconnect closes the connection to the server
+// Disconnect closes the session and the connection to the server
 func (c *Client) Disconnect() error {
-	if c.sessionID != "" {
+	// A guest leaves the session to its owner
+	if c.sessionID != "" && !c.guest {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		_, err := c.client.CloseSession(ctx, &pb.CloseSessionRequest{
-			SessionId: c.sessionID,
-		})
-		if err != nil {
+		if err := c.CloseSession(ctx); err != nil {
 			c.logger.Warn("Failed to close session", "error", err.Error())
 		}
-		c.sessionID = ""
 	}
 
+	return c.Detach()
+}
+
+// Detach closes the connection to the server but leaves the session
+// running so that it can be resumed by a later CreateSession
+func (c *Client) Detach() error {
 	if c.conn != nil {
 		c.logger.Info("Disconnecting from server")
-		return c.conn.Close()
+		c.emit(event{Event: eventDisconnected, Address: c.Address()})
+		err := c.conn.Close()
+		c.conn = nil
+		if c.relay != nil {
+			c.relay.Close()
+			c.relay = nil
+		}
+		return err
 	}
 	return nil
 }
 
+// Release drops the connection to the server. The session is closed
+// unless a state file is configured, in which case it is kept so that it
+// can be resumed later.
+func (c *Client) Release() error {
+	if c.config.StateFile != "" {
+		c.sessionID = ""
+		return c.Detach()
+	}
+	return c.Disconnect()
+}
+
+// Reconnect re-establishes the connection to the configured server and
+// creates a session with the same client ID. The server hands back the
+// previous session if it is still alive.
+func (c *Client) Reconnect(ctx context.Context) error {
+	if c.clientID == "" {
+		return fmt.Errorf("no client ID to reconnect with")
+	}
+
+	c.Detach()
+	c.sessionID = ""
+
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	return c.CreateSession(ctx, c.clientID)
+}
+
+// Switch moves the client to another server: the current session is
+// released and a session is created on the new server with the same
+// client ID
+func (c *Client) Switch(ctx context.Context, cfg Config) error {
+	c.Release()
+	c.config = cfg
+	return c.Reconnect(ctx)
+}
+
+// CloseSession terminates the current session on the server
+func (c *Client) CloseSession(ctx context.Context) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+	if c.guest {
+		return ErrGuest
+	}
+
+	_, err := c.client.CloseSession(ctx, &pb.CloseSessionRequest{
+		SessionId: c.sessionID,
+	})
+	if err != nil {
+		c.sessionID = ""
+		return fmt.Errorf("failed to close session: %w", err)
+	}
+	c.emit(event{Event: eventSessionClosed})
+	c.sessionID = ""
+	return nil
+}
+
 // CreateSession creates a new shell session
 func (c *Client) CreateSession(ctx context.Context, clientID string) error {
 	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
 	defer cancel()
 
+	rows, cols := terminalSize()
 	resp, err := c.client.CreateSession(ctx, &pb.CreateSessionRequest{
-		ClientId: clientID,
+		ClientId:         clientID,
+		Shell:            c.config.Shell,
+		LoginShell:       c.config.LoginShell,
+		SourceInitScript: c.config.InitScript,
+		Rows:             rows,
+		Cols:             cols,
+		ClientInfo:       c.clientInfo(),
+		Umask:            c.config.Umask,
 	})
 	if err != nil {
+		if m := maintenanceMessage(err); m != "" {
+			return &MaintenanceError{Message: m, err: err}
+		}
 		return fmt.Errorf("failed to create session: %w", err)
 	}
 
 	c.sessionID = resp.SessionId
+	c.clientID = clientID
+	c.guest = false
+	c.banner = resp.Banner
+	c.serverID = resp.ServerId
+	c.setSessionToken(resp.SessionToken, resp.SessionTokenExpiresAtUnixMs)
+	c.setWorkingDir(resp.WorkingDirectory)
 	c.logger.Info("Session created",
 		"session_id", c.sessionID,
+		"server_id", c.serverID,
 		"working_dir", resp.WorkingDirectory,
+		"shell", resp.Shell,
 	)
+	c.emit(event{Event: eventSessionCreated, ServerID: c.serverID})
 
 	return nil
 }
 
+// serverIDHeader is the response header servers report their ID in
+const serverIDHeader = "x-server-id"
+
+// ServerID returns the ID of the server that created the session, or ""
+// for servers that do not report one
+func (c *Client) ServerID() string {
+	return c.serverID
+}
+
+// Banner returns the banner the server sent when the session was created
+func (c *Client) Banner() string {
+	return c.banner
+}
+
 // GetSessionID returns the current session ID
 func (c *Client) GetSessionID() string {
 	return c.sessionID
 }
 
-// ExecuteCommand executes a command and returns the result
+// ExecuteCommand executes a command and returns the result. When the
+// command timed out or was killed, the output it produced until then is
+// returned along with the error.
 func (c *Client) ExecuteCommand(ctx context.Context, command string, timeout int) (*pb.CommandResponse, error) {
 	if c.sessionID == "" {
 		return nil, fmt.Errorf("no active session")
 	}
 
-	resp, err := c.client.ExecuteCommand(ctx, &pb.CommandRequest{
+	req := &pb.CommandRequest{
 		SessionId:      c.sessionID,
 		Command:        command,
 		TimeoutSeconds: int32(timeout),
+		IdempotencyKey: newIdempotencyKey(),
+		Glob:           c.requestGlob(),
+	}
+	resp, err := c.client.ExecuteCommand(ctx, req)
+	for attempt := 1; attempt < executeAttempts && status.Code(err) == codes.Unavailable; attempt++ {
+		// The command may have run before the connection dropped; the key
+		// makes the server return that result instead of running it again
+		select {
+		case <-time.After(time.Duration(attempt) * executeRetryDelay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("command execution failed: %w", err)
+		}
+		resp, err = c.client.ExecuteCommand(ctx, req)
+	}
+	if err != nil {
+		return partialResponse(err), fmt.Errorf("command execution failed: %w", err)
+	}
+	c.setWorkingDir(resp.WorkingDir)
+
+	return resp, nil
+}
+
+// ExecuteCommand tries a command this many times while the server is
+// unavailable, waiting executeRetryDelay longer before each retry
+const (
+	executeAttempts   = 3
+	executeRetryDelay = 500 * time.Millisecond
+)
+
+// newIdempotencyKey returns a random key that lets the server recognise a
+// retried command
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// partialResponse returns the output of a failed command attached to err,
+// or nil
+func partialResponse(err error) *pb.CommandResponse {
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil
+	}
+	for _, detail := range st.Details() {
+		if resp, ok := detail.(*pb.CommandResponse); ok {
+			return resp
+		}
+	}
+	return nil
+}
+
+// ExecutePipeline runs commands on the server with the output of each one
+// piped into the next, returning the last stage's output and every
+// stage's exit code, error output and timing
+func (c *Client) ExecutePipeline(ctx context.Context, commands []string, timeout int) (*pb.PipelineResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	resp, err := c.client.ExecutePipeline(ctx, &pb.PipelineRequest{
+		SessionId:      c.sessionID,
+		Commands:       commands,
+		TimeoutSeconds: int32(timeout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pipeline execution failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+// ExecuteBatch runs commands on the server one after another in a single
+// call. With stopOnFailure the batch ends at the first command that fails.
+func (c *Client) ExecuteBatch(ctx context.Context, commands []string, stopOnFailure bool, timeout int) (*pb.BatchResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	policy := pb.BatchRequest_CONTINUE_ON_ERROR
+	if stopOnFailure {
+		policy = pb.BatchRequest_STOP_ON_FAILURE
+	}
+
+	resp, err := c.client.ExecuteBatch(ctx, &pb.BatchRequest{
+		SessionId:      c.sessionID,
+		Commands:       commands,
+		Policy:         policy,
+		TimeoutSeconds: int32(timeout),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("command execution failed: %w", err)
+		return nil, fmt.Errorf("batch execution failed: %w", err)
 	}
 
 	return resp, nil
@@ -150,28 +505,323 @@ func (c *Client) ExecuteCommandStream(ctx context.Context, command string, timeo
 		SessionId:      c.sessionID,
 		Command:        command,
 		TimeoutSeconds: int32(timeout),
+		Glob:           c.requestGlob(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start command stream: %w", err)
+	}
+
+	return c.receiveOutput(stream, outputHandler)
+}
+
+// ExecuteArgvStream runs a program with its arguments and streams the
+// output. The server starts it without a shell, so the arguments need no
+// quoting and nothing in them is interpreted.
+func (c *Client) ExecuteArgvStream(ctx context.Context, argv []string, timeout int, outputHandler func(output *pb.CommandOutput)) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.ExecuteCommandStream(ctx, &pb.CommandRequest{
+		SessionId:      c.sessionID,
+		Argv:           argv,
+		TimeoutSeconds: int32(timeout),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start command stream: %w", err)
+	}
+
+	return c.receiveOutput(stream, outputHandler)
+}
+
+// ExecuteCommandWatched executes a command like ExecuteCommandStream,
+// answering the server's keep-alive pings so that the command may run past
+// its timeout while the client is there. Servers without watched commands
+// run it as a plain stream.
+func (c *Client) ExecuteCommandWatched(ctx context.Context, command string, timeout int, outputHandler func(output *pb.CommandOutput)) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.ExecuteCommandWatched(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start command stream: %w", err)
+	}
+	defer stream.CloseSend()
+	err = stream.Send(&pb.WatchedCommandRequest{Command: &pb.CommandRequest{
+		SessionId:      c.sessionID,
+		Command:        command,
+		TimeoutSeconds: int32(timeout),
+		Glob:           c.requestGlob(),
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to start command stream: %w", err)
+	}
+
+	received := false
+	err = c.receiveOutput(stream, func(output *pb.CommandOutput) {
+		received = true
+		if ping := output.KeepAlive; ping != nil {
+			if err := stream.Send(&pb.WatchedCommandRequest{Ack: ping.Id}); err != nil {
+				c.logger.Debug("Failed to acknowledge keep-alive ping", "error", err.Error())
+			}
+			return
+		}
+		if outputHandler != nil {
+			outputHandler(output)
+		}
+	})
+	if !received && status.Code(err) == codes.Unimplemented {
+		// The command never started, so it can be sent again
+		return c.ExecuteCommandStream(ctx, command, timeout, outputHandler)
+	}
+	return err
+}
+
+// ExecuteCommandToFile runs a command with its output saved to a file on
+// the server. Only the last tailLines lines are streamed to the handler;
+// the completion message carries the file's path and size.
+func (c *Client) ExecuteCommandToFile(ctx context.Context, command, outputFile string, tailLines, timeout int, outputHandler func(output *pb.CommandOutput)) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.ExecuteCommandStream(ctx, &pb.CommandRequest{
+		SessionId:      c.sessionID,
+		Command:        command,
+		TimeoutSeconds: int32(timeout),
+		OutputFile:     outputFile,
+		TailLines:      int32(tailLines),
+		Glob:           c.requestGlob(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to start command stream: %w", err)
 	}
 
+	return c.receiveOutput(stream, outputHandler)
+}
+
+// ConfirmCommand answers a confirmation challenge from the server. When
+// approved, the held command runs and its output is streamed to the handler.
+func (c *Client) ConfirmCommand(ctx context.Context, token string, approve bool, outputHandler func(output *pb.CommandOutput)) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.ConfirmCommand(ctx, &pb.ConfirmCommandRequest{
+		SessionId: c.sessionID,
+		Token:     token,
+		Approve:   approve,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to confirm command: %w", err)
+	}
+
+	return c.receiveOutput(stream, outputHandler)
+}
+
+// receiveOutput passes every message of an output stream to the handler,
+// noting the working directory reported on completion. It fails when
+// messages were lost or arrived out of order; servers that do not number
+// their messages are trusted.
+func (c *Client) receiveOutput(stream interface {
+	Recv() (*pb.CommandOutput, error)
+	Header() (metadata.MD, error)
+}, outputHandler func(output *pb.CommandOutput)) error {
+	var check streamcheck.Receiver
+	first := true
 	for {
 		output, err := stream.Recv()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
 			return fmt.Errorf("stream error: %w", err)
 		}
+		if first {
+			// The header has arrived with the first message
+			first = false
+			if md, err := stream.Header(); err == nil {
+				c.logger.Debug("Command stream opened", "server_id", strings.Join(md.Get(serverIDHeader), ","))
+			}
+		}
+		if err := checkOutput(&check, output); err != nil {
+			return fmt.Errorf("output stream damaged: %w", err)
+		}
 
+		if output.IsComplete {
+			c.setWorkingDir(output.WorkingDir)
+		}
 		if outputHandler != nil {
 			outputHandler(output)
 		}
 	}
+}
 
+// checkOutput checks a message's sequence number and, on the final
+// message, the summary of the stream
+func checkOutput(check *streamcheck.Receiver, output *pb.CommandOutput) error {
+	if output.Sequence == 0 {
+		return nil
+	}
+	if err := check.Receive(output.Sequence, output.Data); err != nil {
+		return err
+	}
+	if sum := output.Summary; sum != nil {
+		return check.Verify(streamcheck.Summary{
+			Chunks:   sum.Chunks,
+			Bytes:    sum.Bytes,
+			Checksum: sum.Checksum,
+		})
+	}
 	return nil
 }
 
+// TailFile follows a remote file, passing the last lines and everything
+// appended to it to the handler until ctx is cancelled
+func (c *Client) TailFile(ctx context.Context, path string, lines int, outputHandler func(output *pb.TailFileOutput)) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.TailFile(ctx, &pb.TailFileRequest{
+		SessionId: c.sessionID,
+		Path:      path,
+		Lines:     uint32(lines),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tail file: %w", err)
+	}
+
+	for {
+		output, err := stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stream error: %w", err)
+		}
+		outputHandler(output)
+	}
+}
+
+// ListDirectory returns the entries of a remote directory; an empty path
+// lists the session directory
+func (c *Client) ListDirectory(ctx context.Context, path string) (*pb.ListDirectoryResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.ListDirectory(ctx, &pb.ListDirectoryRequest{
+		SessionId: c.sessionID,
+		Path:      path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+	return resp, nil
+}
+
+// StatFile returns information about a remote file, including its SHA-256
+// checksum when checksum is set
+func (c *Client) StatFile(ctx context.Context, path string, checksum bool) (*pb.StatFileResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.StatFile(ctx, &pb.StatFileRequest{
+		SessionId: c.sessionID,
+		Path:      path,
+		Checksum:  checksum,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return resp, nil
+}
+
+// GetSessionInfo returns the state and resource usage of the session
+func (c *Client) GetSessionInfo(ctx context.Context) (*pb.SessionInfo, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.GetSessionInfo(ctx, &pb.GetSessionInfoRequest{SessionId: c.sessionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session info: %w", err)
+	}
+	c.setWorkingDir(resp.Session.WorkingDir)
+	return resp.Session, nil
+}
+
+// ListMacros returns the macros defined on the server
+func (c *Client) ListMacros(ctx context.Context) ([]*pb.Macro, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.ListMacros(ctx, &pb.ListMacrosRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list macros: %w", err)
+	}
+	return resp.Macros, nil
+}
+
+// Config returns the client configuration
+func (c *Client) Config() Config {
+	return c.config
+}
+
+// Address returns the server address the client is configured for
+func (c *Client) Address() string {
+	if c.config.Relay != "" {
+		return fmt.Sprintf("%s via relay %s", c.config.Host, c.config.Relay)
+	}
+	if c.config.serverList() {
+		return strings.Join(c.config.serverAddresses(), ",")
+	}
+	return c.target()
+}
+
+// target returns the gRPC target of the server. A host of the form
+// unix:/path names a Unix socket, and the port is ignored. A dns:///
+// target and the entries of a comma-separated list get the port unless
+// they have one.
+func (c *Client) target() string {
+	switch {
+	case c.config.unixSocket():
+		return c.config.Host
+	case c.config.dnsTarget():
+		i := strings.LastIndex(c.config.Host, "/")
+		return c.config.Host[:i+1] + c.config.withPort(c.config.Host[i+1:])
+	case c.config.serverList():
+		return staticScheme + ":///" + strings.Join(c.config.serverAddresses(), ",")
+	}
+	return fmt.Sprintf("%s:%d", c.config.Host, c.config.Port)
+}
+
+// unixSocket reports whether the server is reached through a Unix socket
+func (c Config) unixSocket() bool {
+	return strings.HasPrefix(c.Host, "unix:")
+}
+
+// RelayDialer connects to the configured relay with the RelayTLS settings
+func (c Config) RelayDialer() (*relay.Dialer, error) {
+	creds, err := c.RelayTLS.transportCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("relay: %w", err)
+	}
+	return relay.NewDialer(c.Relay, creds)
+}
+
 // IsConnected returns true if the client is connected
 func (c *Client) IsConnected() bool {
 	return c.conn != nil
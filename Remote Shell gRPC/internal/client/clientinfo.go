@@ -0,0 +1,30 @@
+package client
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// Version is the client version reported to servers at login. Release
+// builds set it with -ldflags "-X remote-shell-rpc/internal/client.Version=...".
+var Version = "dev"
+
+// clientInfo describes this machine, and the messages the client accepts,
+// to the server at login
+func (c *Client) clientInfo() *pb.ClientInfo {
+	info := &pb.ClientInfo{
+		Os:             runtime.GOOS + "/" + runtime.GOARCH,
+		ClientVersion:  Version,
+		MaxRecvMsgSize: int32(c.config.MaxRecvMsgSize),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		info.Hostname = hostname
+	}
+	if u, err := user.Current(); err == nil {
+		info.Username = u.Username
+	}
+	return info
+}
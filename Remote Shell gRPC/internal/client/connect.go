@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// handleConnect switches the shell to a server profile or a host:port address
+func (s *Shell) handleConnect(ctx context.Context, args []string) error {
+	cfg := s.client.Config()
+
+	if len(args) != 1 {
+		names := cfg.ProfileNames()
+		if len(names) == 0 {
+			return fmt.Errorf("usage: connect <profile|host:port>")
+		}
+		return fmt.Errorf("usage: connect <profile|host:port> (profiles: %s)", strings.Join(names, ", "))
+	}
+
+	target, err := connectTarget(cfg, args[0])
+	if err != nil {
+		return err
+	}
+
+	if target.Profile != "" {
+		fmt.Printf("Connecting to %s (%s:%d)...\n", target.Profile, target.Host, target.Port)
+	} else {
+		fmt.Printf("Connecting to %s:%d...\n", target.Host, target.Port)
+	}
+	if err := s.client.Switch(ctx, target); err != nil {
+		return err
+	}
+
+	s.printBanner()
+	fmt.Printf("Session ID: %s\n", s.client.GetSessionID())
+	return nil
+}
+
+// handleDisconnect drops the current server connection without leaving the shell
+func (s *Shell) handleDisconnect() error {
+	if !s.client.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	if err := s.client.Release(); err != nil {
+		return err
+	}
+	fmt.Printf("Disconnected from %s\n", s.client.Address())
+	return nil
+}
+
+// handleReconnect re-establishes the connection to the current server
+func (s *Shell) handleReconnect(ctx context.Context) error {
+	fmt.Printf("Reconnecting to %s...\n", s.client.Address())
+	if err := s.client.Reconnect(ctx); err != nil {
+		return err
+	}
+	fmt.Printf("Session ID: %s\n", s.client.GetSessionID())
+	return nil
+}
+
+// connectTarget resolves a connect argument to a client configuration.
+// Profile names take precedence over host:port addresses. Addresses keep
+// the current TLS settings but never reuse the current auth token. When a
+// relay is configured, other names are servers registered with the relay.
+func connectTarget(cfg Config, arg string) (Config, error) {
+	if _, ok := cfg.Profiles[arg]; ok {
+		return cfg.WithProfile(arg)
+	}
+	if cfg.Relay != "" && !strings.Contains(arg, ":") {
+		cfg.Host = arg
+		cfg.Profile = ""
+		return cfg, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(arg)
+	if err != nil {
+		return cfg, fmt.Errorf("unknown profile or invalid address %q", arg)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return cfg, fmt.Errorf("invalid port in %q", arg)
+	}
+
+	cfg.Host = host
+	cfg.Port = port
+	cfg.Token = ""
+	cfg.Profile = ""
+	cfg.Relay = ""
+	return cfg, nil
+}
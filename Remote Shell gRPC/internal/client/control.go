@@ -0,0 +1,357 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"remote-shell-rpc/pkg/executor"
+	pb "remote-shell-rpc/proto"
+)
+
+// controlOutputLimit is how much of each output stream a run request
+// returns; the rest is dropped and the result marked truncated
+const controlOutputLimit = 1 << 20
+
+// controlRequestLimit is the longest request line accepted
+const controlRequestLimit = 1 << 20
+
+// controlTimeout is the command timeout in seconds when a run request
+// does not give one, the same as for commands typed at the prompt
+const controlTimeout = serverTimeout
+
+// JSON-RPC 2.0 error codes, with rpcRequestCanceled taken from LSP
+const (
+	rpcParseError      = -32700
+	rpcInvalidRequest  = -32600
+	rpcMethodNotFound  = -32601
+	rpcInvalidParams   = -32602
+	rpcCommandFailed   = -32000
+	rpcRequestCanceled = -32800
+)
+
+// controlRequest is a JSON-RPC 2.0 request; requests without an ID are
+// notifications and get no response
+type controlRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// controlResponse is a JSON-RPC 2.0 response holding either a result or
+// an error
+type controlResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *controlError   `json:"error,omitempty"`
+}
+
+// controlError is the error of a failed request
+type controlError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// runParams are the parameters of the run method: a command line for the
+// session shell, or an argument vector the server runs without one
+type runParams struct {
+	Command string   `json:"command"`
+	Argv    []string `json:"argv"`
+	// Timeout is in seconds
+	Timeout int `json:"timeout"`
+}
+
+// runResult is the result of the run method
+type runResult struct {
+	ExitCode        int32  `json:"exit_code"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	ExecutionTimeMs int64  `json:"execution_time_ms"`
+	WorkingDir      string `json:"working_dir"`
+	Truncated       bool   `json:"truncated,omitempty"`
+}
+
+// cancelParams are the parameters of the cancel method: the ID of the
+// run request to cancel, or none to cancel every running command
+type cancelParams struct {
+	ID json.RawMessage `json:"id"`
+}
+
+// statusResult is the result of the status method
+type statusResult struct {
+	Connected  bool   `json:"connected"`
+	Address    string `json:"address"`
+	SessionID  string `json:"session_id,omitempty"`
+	ServerID   string `json:"server_id,omitempty"`
+	WorkingDir string `json:"working_dir,omitempty"`
+	// Running lists the IDs of the run requests in progress
+	Running []json.RawMessage `json:"running"`
+}
+
+// controlServer answers JSON-RPC requests on a local socket, running
+// commands in the shell's session. Each connection carries one JSON
+// object per line in both directions.
+type controlServer struct {
+	client   *Client
+	listener net.Listener
+	path     string
+	// running holds the cancel functions of run requests in progress,
+	// keyed by request ID
+	running   map[string]context.CancelFunc
+	runningMu sync.Mutex
+}
+
+// serveControl starts the control socket at path, answering requests
+// until ctx is done. Only the current user may connect to it.
+func (s *Shell) serveControl(ctx context.Context, path string) error {
+	// A socket left behind by a client that crashed is replaced
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("cannot listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("cannot restrict control socket: %w", err)
+	}
+
+	srv := &controlServer{
+		client:   s.client,
+		listener: listener,
+		path:     path,
+		running:  make(map[string]context.CancelFunc),
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		os.Remove(path)
+	}()
+	go srv.serve(ctx)
+	s.client.logger.Info("Control socket listening", "path", path)
+	return nil
+}
+
+// serve accepts connections until the listener is closed
+func (c *controlServer) serve(ctx context.Context) {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			return
+		}
+		go c.handleConn(ctx, conn)
+	}
+}
+
+// handleConn answers the requests of one connection. Requests are handled
+// concurrently, so that a long run can be cancelled over the same
+// connection; responses may therefore come out of order.
+func (c *controlServer) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	encoder := json.NewEncoder(conn)
+	reply := func(resp controlResponse) {
+		resp.JSONRPC = "2.0"
+		if resp.ID == nil {
+			resp.ID = json.RawMessage("null")
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		encoder.Encode(resp)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), controlRequestLimit)
+	for scanner.Scan() {
+		var req controlRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			reply(controlResponse{Error: &controlError{Code: rpcParseError, Message: err.Error()}})
+			continue
+		}
+		if req.JSONRPC != "2.0" || req.Method == "" {
+			reply(controlResponse{ID: req.ID, Error: &controlError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}})
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, rpcErr := c.call(ctx, req)
+			if req.ID == nil {
+				return
+			}
+			if rpcErr != nil {
+				reply(controlResponse{ID: req.ID, Error: rpcErr})
+				return
+			}
+			reply(controlResponse{ID: req.ID, Result: result})
+		}()
+	}
+}
+
+// call runs one request
+func (c *controlServer) call(ctx context.Context, req controlRequest) (any, *controlError) {
+	switch req.Method {
+	case "run":
+		var params runParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || (params.Command == "") == (len(params.Argv) == 0) {
+			return nil, &controlError{Code: rpcInvalidParams, Message: "run needs either a command or an argv"}
+		}
+		return c.run(ctx, string(req.ID), params)
+
+	case "status":
+		return c.status(), nil
+
+	case "cancel":
+		var params cancelParams
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &params); err != nil {
+				return nil, &controlError{Code: rpcInvalidParams, Message: err.Error()}
+			}
+		}
+		id := string(params.ID)
+		if id == "null" {
+			id = ""
+		}
+		return map[string]int{"cancelled": c.cancel(id)}, nil
+	}
+	return nil, &controlError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+}
+
+// run executes a command in the session and collects its output
+func (c *controlServer) run(ctx context.Context, id string, params runParams) (any, *controlError) {
+	timeout := params.Timeout
+	if timeout <= 0 {
+		timeout = controlTimeout
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if id != "" {
+		c.runningMu.Lock()
+		if _, dup := c.running[id]; dup {
+			c.runningMu.Unlock()
+			return nil, &controlError{Code: rpcInvalidRequest, Message: fmt.Sprintf("request %s is already running", id)}
+		}
+		c.running[id] = cancel
+		c.runningMu.Unlock()
+		defer func() {
+			c.runningMu.Lock()
+			delete(c.running, id)
+			c.runningMu.Unlock()
+		}()
+	}
+
+	command := params.Command
+	if len(params.Argv) > 0 {
+		command = executor.QuoteArgv(params.Argv)
+	}
+	c.client.emit(event{Event: eventCommandStart, Command: command})
+	result := runResult{ExitCode: -1}
+	var stdout, stderr []byte
+	var challenge *pb.ConfirmationChallenge
+	handler := func(output *pb.CommandOutput) {
+		if output.Confirmation != nil {
+			challenge = output.Confirmation
+			return
+		}
+		if output.IsComplete {
+			result.ExitCode = output.ExitCode
+			result.ExecutionTimeMs = output.ExecutionTimeMs
+		}
+		// Builtins such as pwd send their output with the completion
+		if output.Type == pb.CommandOutput_STDERR {
+			stderr = appendLimited(stderr, output.Data, &result.Truncated)
+		} else {
+			stdout = appendLimited(stdout, output.Data, &result.Truncated)
+		}
+	}
+	var err error
+	if len(params.Argv) > 0 {
+		err = c.client.ExecuteArgvStream(ctx, params.Argv, timeout, handler)
+	} else {
+		err = c.client.ExecuteCommandStream(ctx, params.Command, timeout, handler)
+	}
+	code := int(result.ExitCode)
+	end := event{Event: eventCommandEnd, Command: command, ExitCode: &code, DurationMs: result.ExecutionTimeMs}
+	if err != nil {
+		end.Error = err.Error()
+	}
+	c.client.emit(end)
+
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		return nil, &controlError{Code: rpcRequestCanceled, Message: "command cancelled"}
+	case err != nil:
+		return nil, &controlError{Code: rpcCommandFailed, Message: err.Error()}
+	case challenge != nil:
+		// Confirmations need a person at the prompt
+		return nil, &controlError{Code: rpcCommandFailed, Message: fmt.Sprintf("server requires confirmation (%s); run the command at the prompt", challenge.Reason)}
+	}
+	result.Stdout = string(stdout)
+	result.Stderr = string(stderr)
+	result.WorkingDir = c.client.WorkingDir()
+	return result, nil
+}
+
+// appendLimited appends data to buf up to controlOutputLimit, setting
+// truncated when some of it had to be dropped
+func appendLimited(buf, data []byte, truncated *bool) []byte {
+	if room := controlOutputLimit - len(buf); len(data) > room {
+		*truncated = true
+		data = data[:max(room, 0)]
+	}
+	return append(buf, data...)
+}
+
+// status describes the connection and the commands in progress
+func (c *controlServer) status() statusResult {
+	result := statusResult{
+		Connected:  c.client.IsConnected(),
+		Address:    c.client.Address(),
+		SessionID:  c.client.GetSessionID(),
+		ServerID:   c.client.ServerID(),
+		WorkingDir: c.client.WorkingDir(),
+		Running:    []json.RawMessage{},
+	}
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+	for id := range c.running {
+		result.Running = append(result.Running, json.RawMessage(id))
+	}
+	return result
+}
+
+// cancel stops the run request with the given ID, or all of them when id
+// is empty, returning how many were cancelled
+func (c *controlServer) cancel(id string) int {
+	c.runningMu.Lock()
+	defer c.runningMu.Unlock()
+	if id != "" {
+		cancel, ok := c.running[id]
+		if !ok {
+			return 0
+		}
+		cancel()
+		return 1
+	}
+	for _, cancel := range c.running {
+		cancel()
+	}
+	return len(c.running)
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// WorkingDir returns the session's working directory as last reported by
+// the server, or "" before it reported one
+func (c *Client) WorkingDir() string {
+	c.cwdMu.Lock()
+	defer c.cwdMu.Unlock()
+	return c.workingDir
+}
+
+// setWorkingDir records a working directory reported by the server; empty
+// values, sent by servers that do not report it, are ignored
+func (c *Client) setWorkingDir(dir string) {
+	if dir == "" {
+		return
+	}
+	c.cwdMu.Lock()
+	defer c.cwdMu.Unlock()
+	c.workingDir = dir
+}
+
+// handlePwd prints the remote working directory without a round trip,
+// asking the server only when it has not reported one yet
+func (s *Shell) handlePwd(ctx context.Context) error {
+	cwd := s.client.WorkingDir()
+	if cwd == "" {
+		info, err := s.client.GetSessionInfo(ctx)
+		if err != nil {
+			return err
+		}
+		cwd = info.WorkingDir
+	}
+	fmt.Println(cwd)
+	s.lastExit = 0
+	return nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"remote-shell-rpc/pkg/files"
+	"remote-shell-rpc/pkg/format"
+)
+
+// defaultEditor is used when neither VISUAL nor EDITOR is set
+const defaultEditor = "vi"
+
+// handleEdit implements "edit <remote-path>": the file is downloaded to a
+// temporary directory, opened in the local editor and uploaded again if it
+// was changed. When the remote file changed in the meantime the user is
+// asked before it is overwritten; otherwise the edited copy is kept.
+func (s *Shell) handleEdit(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: edit <remote-path>")
+	}
+	remote := args[0]
+
+	// A missing file is created, as editors do
+	before, err := s.client.remoteChecksum(ctx, remote)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "rsh-edit-")
+	if err != nil {
+		return err
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// The editor sees the remote name, for syntax highlighting
+	local := filepath.Join(dir, path.Base(remote))
+	if before != "" {
+		if _, err := s.client.Download(ctx, remote, local); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(local, nil, writeMode); err != nil {
+		return err
+	}
+	original, err := files.Checksum(local)
+	if err != nil {
+		return err
+	}
+
+	if err := runEditor(local); err != nil {
+		return err
+	}
+
+	edited, err := files.Checksum(local)
+	if err != nil {
+		return err
+	}
+	if edited == original {
+		fmt.Println("No changes")
+		return nil
+	}
+
+	current, err := s.client.remoteChecksum(ctx, remote)
+	if err != nil {
+		keep = true
+		return fmt.Errorf("%w; your changes are in %s", err, local)
+	}
+	if current != before && !s.confirmPrompt(fmt.Sprintf("%s changed on the server while you were editing. Overwrite it? [y/N] ", remote)) {
+		keep = true
+		fmt.Printf("Not saved; your changes are in %s\n", local)
+		return nil
+	}
+
+	size, err := s.client.Upload(ctx, local, remote)
+	if err != nil {
+		keep = true
+		return fmt.Errorf("%w; your changes are in %s", err, local)
+	}
+	fmt.Printf("Saved %s (%s)\n", remote, format.Bytes(size))
+	return nil
+}
+
+// runEditor opens a file in the editor named by VISUAL or EDITOR, which may
+// include arguments, e.g. "code --wait"
+func runEditor(file string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = defaultEditor
+	}
+	fields := strings.Fields(editor)
+
+	cmd := exec.Command(fields[0], append(fields[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s failed: %w", fields[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// SaveEnv stores the session environment and working directory on the
+// server under a name
+func (c *Client) SaveEnv(ctx context.Context, name string) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	_, err := c.client.SaveEnv(ctx, &pb.SaveEnvRequest{
+		SessionId: c.sessionID,
+		Name:      name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save environment: %w", err)
+	}
+	return nil
+}
+
+// LoadEnv restores a saved environment and working directory
+func (c *Client) LoadEnv(ctx context.Context, name string) (*pb.LoadEnvResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.LoadEnv(ctx, &pb.LoadEnvRequest{
+		SessionId: c.sessionID,
+		Name:      name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load environment: %w", err)
+	}
+	c.setWorkingDir(resp.WorkingDir)
+	return resp, nil
+}
+
+// ListEnvs returns the environment snapshots saved in the session
+func (c *Client) ListEnvs(ctx context.Context) ([]*pb.EnvSnapshot, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.ListEnvs(ctx, &pb.ListEnvsRequest{SessionId: c.sessionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments: %w", err)
+	}
+	return resp.Snapshots, nil
+}
+
+// handleEnvSave implements "envsave <name>"
+func (s *Shell) handleEnvSave(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: envsave <name>")
+	}
+	if err := s.client.SaveEnv(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Environment saved as %s\n", args[0])
+	return nil
+}
+
+// handleEnvLoad implements "envload <name>"; without a name it lists the
+// saved snapshots
+func (s *Shell) handleEnvLoad(ctx context.Context, args []string) error {
+	switch len(args) {
+	case 0:
+		return s.printEnvs(ctx)
+	case 1:
+	default:
+		return fmt.Errorf("usage: envload [name]")
+	}
+
+	resp, err := s.client.LoadEnv(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if resp.Message != "" {
+		fmt.Printf("Warning: %s\n", resp.Message)
+	}
+	fmt.Printf("Environment %s loaded, working directory %s\n", args[0], resp.WorkingDir)
+	return nil
+}
+
+// printEnvs lists the saved environment snapshots
+func (s *Shell) printEnvs(ctx context.Context) error {
+	snaps, err := s.client.ListEnvs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		fmt.Println("No saved environments; use envsave <name>")
+		return nil
+	}
+	for _, snap := range snaps {
+		fmt.Printf("  %-16s %3d vars  %s  (saved %s)\n",
+			snap.Name,
+			snap.Variables,
+			snap.WorkingDir,
+			time.UnixMilli(snap.SavedAtUnixMs).Format("15:04:05"),
+		)
+	}
+	return nil
+}
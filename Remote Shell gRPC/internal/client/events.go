@@ -0,0 +1,79 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventsJSON writes session events to stderr as JSON lines
+const EventsJSON = "json"
+
+// Session events, in the order they usually happen
+const (
+	eventConnected      = "connected"
+	eventSessionCreated = "session-created"
+	eventCommandStart   = "command-start"
+	eventCommandEnd     = "command-end"
+	eventSessionClosed  = "session-closed"
+	eventDisconnected   = "disconnected"
+)
+
+// ValidEvents checks an events setting: "" (no events) or EventsJSON
+func ValidEvents(format string) error {
+	switch format {
+	case "", EventsJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown events format %q (want %q)", format, EventsJSON)
+}
+
+// event is one line of machine-readable output. Fields that do not apply
+// to an event are left out.
+type event struct {
+	Event     string `json:"event"`
+	Time      string `json:"time"`
+	Address   string `json:"address,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	ServerID  string `json:"server_id,omitempty"`
+	Command   string `json:"command,omitempty"`
+	// ExitCode is set for command-end; -1 means the command did not
+	// complete
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// eventsMu keeps events written from several goroutines on lines of
+// their own
+var eventsMu sync.Mutex
+
+// emit writes an event when events are enabled, filling in its time and
+// the current session
+func (c *Client) emit(e event) {
+	if c.config.Events != EventsJSON {
+		return
+	}
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if e.SessionID == "" {
+		e.SessionID = c.sessionID
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	os.Stderr.Write(append(line, '\n'))
+}
+
+// emitCommandEnd reports that a command finished with the last exit code
+func (s *Shell) emitCommandEnd(command string, elapsed time.Duration, err error) {
+	e := event{Event: eventCommandEnd, Command: command, ExitCode: &s.lastExit, DurationMs: elapsed.Milliseconds()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.client.emit(e)
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"remote-shell-rpc/pkg/logger"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// FanoutResult is the outcome of a fan-out command on one server
+type FanoutResult struct {
+	Target   string
+	ExitCode int
+	Err      error
+}
+
+// Failed reports whether the command failed on this server
+func (r FanoutResult) Failed() bool {
+	return r.Err != nil || r.ExitCode != 0
+}
+
+// FanoutOptions controls a fan-out run
+type FanoutOptions struct {
+	// Targets are profile names or host:port addresses
+	Targets []string
+	// Parallel limits how many servers are contacted at once; zero means
+	// all of them
+	Parallel int
+	// Timeout is the per-command timeout in seconds sent to the servers
+	Timeout  int
+	ClientID string
+	Stdout   io.Writer
+	Stderr   io.Writer
+	// Grep, when set, shows only the output lines matching it; error
+	// output is always shown
+	Grep *regexp.Regexp
+}
+
+// Fanout runs a command on every target concurrently. Output lines are
+// prefixed with the target name; results are returned in target order.
+func Fanout(ctx context.Context, cfg Config, command string, opts FanoutOptions, log *logger.Logger) []FanoutResult {
+	if log == nil {
+		log = logger.Default()
+	}
+
+	parallel := opts.Parallel
+	if parallel <= 0 || parallel > len(opts.Targets) {
+		parallel = len(opts.Targets)
+	}
+
+	var mu sync.Mutex
+	results := make([]FanoutResult, len(opts.Targets))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, target := range opts.Targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			stdout := &prefixWriter{mu: &mu, w: opts.Stdout, prefix: "[" + target + "] "}
+			stderr := &prefixWriter{mu: &mu, w: opts.Stderr, prefix: "[" + target + "] "}
+			var out io.Writer = stdout
+			var filter *lineFilter
+			if opts.Grep != nil {
+				filter = &lineFilter{w: stdout, pattern: opts.Grep}
+				out = filter
+			}
+			exitCode, err := runOn(ctx, cfg, target, command, opts, out, stderr, log)
+			if filter != nil {
+				filter.Flush()
+			}
+			stdout.Flush()
+			stderr.Flush()
+
+			results[i] = FanoutResult{Target: target, ExitCode: exitCode, Err: err}
+		}(i, target)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// runOn runs a command in a fresh session on a single target
+func runOn(ctx context.Context, cfg Config, target, command string, opts FanoutOptions, stdout io.Writer, stderr *prefixWriter, log *logger.Logger) (int, error) {
+	targetCfg, err := connectTarget(cfg, target)
+	if err != nil {
+		return -1, err
+	}
+
+	c := New(targetCfg, log)
+	if err := c.Connect(ctx); err != nil {
+		return -1, err
+	}
+	defer c.Disconnect()
+
+	if err := c.CreateSession(ctx, opts.ClientID); err != nil {
+		return -1, err
+	}
+
+	exitCode := -1
+	suppressed := false
+	var held error
+	err = c.ExecuteCommandStream(ctx, command, opts.Timeout, func(output *pb.CommandOutput) {
+		switch {
+		case output.Confirmation != nil:
+			held = fmt.Errorf("command needs confirmation: %s", output.Confirmation.Reason)
+			return
+		case output.Approval != nil:
+			fmt.Fprintf(stderr, "[Waiting for administrator approval, request %s]\n", output.Approval.ApprovalId)
+			return
+		case output.State != nil:
+			if msg := commandStateMessage(output.State); msg != "" {
+				fmt.Fprintf(stderr, "[%s]\n", msg)
+			}
+			return
+		case output.IsComplete:
+			exitCode = int(output.ExitCode)
+			return
+		case suppressed:
+			return
+		case isBinary(output):
+			suppressed = true
+			fmt.Fprintln(stderr, "[binary output suppressed]")
+			return
+		}
+
+		if output.Type == pb.CommandOutput_STDERR {
+			stderr.Write(output.Data)
+		} else {
+			stdout.Write(output.Data)
+		}
+	})
+	if err != nil {
+		return -1, err
+	}
+	if held != nil {
+		return -1, held
+	}
+	return exitCode, nil
+}
+
+// prefixWriter writes complete lines with a prefix, serialising writes
+// from concurrent targets through a shared mutex
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+// Write buffers data and writes out every complete line
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			return len(data), nil
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+}
+
+// Flush writes out a trailing partial line
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+// writeLine writes one prefixed line
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"remote-shell-rpc/pkg/shellparse"
+)
+
+// trailingFilter matches a "| filter [-v] <regex>" at the end of a command
+// line, with the pattern possibly quoted
+var trailingFilter = regexp.MustCompile(`\|\s*filter(\s+-v)?\s+('[^']*'|"[^"]*"|\S+)\s*$`)
+
+// maxFilterLine is the longest line the filter holds back waiting for its
+// end; longer output is matched in pieces of this size
+const maxFilterLine = 1 << 20
+
+// lineFilter passes on the lines of output that match a pattern, or with
+// invert those that do not
+type lineFilter struct {
+	w       io.Writer
+	pattern *regexp.Regexp
+	invert  bool
+	buf     []byte
+}
+
+// splitFilter separates a trailing "| filter [-v] <regex>" from a command
+// line, returning the command to run on the server and a filter without a
+// writer. A command line without a filter is returned unchanged.
+func splitFilter(line string) (string, *lineFilter, error) {
+	m := trailingFilter.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line, nil, nil
+	}
+	invert := m[3] > m[2]
+	expr := line[m[4]:m[5]]
+	if len(expr) >= 2 && (expr[0] == '\'' || expr[0] == '"') && expr[len(expr)-1] == expr[0] {
+		expr = expr[1 : len(expr)-1]
+	}
+
+	// The filter must be the last command of a pipeline rather than part
+	// of a quoted argument
+	commands, err := shellparse.Parse(line)
+	if err != nil || len(commands) == 0 {
+		return line, nil, nil
+	}
+	want := []string{"filter", expr}
+	if invert {
+		want = []string{"filter", "-v", expr}
+	}
+	last := commands[len(commands)-1]
+	if !last.Piped || strings.Join(last.Args, "\x00") != strings.Join(want, "\x00") || len(last.Redirects) > 0 {
+		return line, nil, nil
+	}
+
+	command := strings.TrimSpace(line[:m[0]])
+	if command == "" {
+		return line, nil, nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid filter pattern: %w", err)
+	}
+	return command, &lineFilter{pattern: pattern, invert: invert}, nil
+}
+
+// Write buffers data and passes on every complete line that matches
+func (f *lineFilter) Write(data []byte) (int, error) {
+	f.buf = append(f.buf, data...)
+	for {
+		i := bytes.IndexByte(f.buf, '\n')
+		if i < 0 && len(f.buf) < maxFilterLine {
+			return len(data), nil
+		}
+		if i < 0 {
+			i = maxFilterLine - 1
+		}
+		if err := f.writeLine(f.buf[:i+1]); err != nil {
+			return len(data), err
+		}
+		f.buf = f.buf[i+1:]
+	}
+}
+
+// Flush passes on a trailing partial line that matches, ending it with a
+// line break
+func (f *lineFilter) Flush() error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+	line := append(f.buf, '\n')
+	f.buf = nil
+	return f.writeLine(line)
+}
+
+// writeLine writes one line when it matches
+func (f *lineFilter) writeLine(line []byte) error {
+	if f.pattern.Match(bytes.TrimSuffix(line, []byte("\n"))) == f.invert {
+		return nil
+	}
+	_, err := f.w.Write(line)
+	return err
+}
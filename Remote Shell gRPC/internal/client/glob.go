@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// Glob modes: how the pathname patterns of remote commands, such as *.log,
+// are expanded
+const (
+	// GlobShell leaves patterns to the session shell
+	GlobShell = "shell"
+	// GlobNoGlob passes patterns to commands as written
+	GlobNoGlob = "noglob"
+	// GlobExpand has the server expand patterns against the session
+	// directory and fail commands whose patterns match nothing
+	GlobExpand = "expand"
+)
+
+// globModes maps the glob modes to their request values
+var globModes = map[string]pb.CommandRequest_Glob{
+	"":         pb.CommandRequest_SHELL,
+	GlobShell:  pb.CommandRequest_SHELL,
+	GlobNoGlob: pb.CommandRequest_NOGLOB,
+	GlobExpand: pb.CommandRequest_EXPAND,
+}
+
+// ValidGlob checks a glob setting: "" (the same as GlobShell) or one of the
+// glob modes
+func ValidGlob(mode string) error {
+	if _, ok := globModes[mode]; ok {
+		return nil
+	}
+	return fmt.Errorf("unknown glob mode %q (want %s, %s or %s)", mode, GlobShell, GlobNoGlob, GlobExpand)
+}
+
+// SetGlob changes how the patterns of later commands are expanded
+func (c *Client) SetGlob(mode string) error {
+	glob, ok := globModes[mode]
+	if !ok {
+		return ValidGlob(mode)
+	}
+	c.glob.Store(int32(glob))
+	return nil
+}
+
+// GlobMode returns how the patterns of commands are expanded
+func (c *Client) GlobMode() string {
+	switch pb.CommandRequest_Glob(c.glob.Load()) {
+	case pb.CommandRequest_NOGLOB:
+		return GlobNoGlob
+	case pb.CommandRequest_EXPAND:
+		return GlobExpand
+	}
+	return GlobShell
+}
+
+// handleGlob sets how the patterns of remote commands are expanded:
+// "glob [shell|noglob|expand]"; without an argument it reports the
+// current mode
+func (s *Shell) handleGlob(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: glob [%s|%s|%s]", GlobShell, GlobNoGlob, GlobExpand)
+	}
+	if len(args) == 1 {
+		if err := s.client.SetGlob(args[0]); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Glob mode is %s\n", s.client.GlobMode())
+	return nil
+}
+
+// printGlobs shows the paths the server expanded the patterns of a
+// command to
+func printGlobs(globs []*pb.GlobExpansion) {
+	for _, glob := range globs {
+		fmt.Fprintf(os.Stderr, "[%s: %s]\n", glob.Pattern, strings.Join(glob.Matches, " "))
+	}
+}
+
+// requestGlob returns the glob mode to send with a command
+func (c *Client) requestGlob() pb.CommandRequest_Glob {
+	return pb.CommandRequest_Glob(c.glob.Load())
+}
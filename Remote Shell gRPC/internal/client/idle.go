@@ -0,0 +1,42 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// armIdle starts the idle timers while the shell waits at the prompt and
+// returns a function that stops them
+func (s *Shell) armIdle() func() {
+	timeout := s.config.IdleTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	var warn *time.Timer
+	if before := s.config.IdleWarning; before > 0 && before < timeout {
+		warn = time.AfterFunc(timeout-before, func() {
+			fmt.Printf("\n[No input for %s, logging out in %s]\n%s", timeout-before, before, s.prompt())
+		})
+	}
+	logout := time.AfterFunc(timeout, s.idleLogout)
+
+	return func() {
+		if warn != nil {
+			warn.Stop()
+		}
+		logout.Stop()
+	}
+}
+
+// idleLogout closes the session and exits the client
+func (s *Shell) idleLogout() {
+	fmt.Printf("\nLogged out after %s without input\n", s.config.IdleTimeout)
+	if err := s.client.Disconnect(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	// The prompt is blocked reading stdin, which cannot be interrupted,
+	// so exit from here
+	os.Exit(0)
+}
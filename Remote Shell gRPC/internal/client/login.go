@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/sshauth"
+	pb "remote-shell-rpc/proto"
+)
+
+// sshAgentKey is the ssh_key setting that logs in with the keys held by
+// ssh-agent
+const sshAgentKey = "agent"
+
+// loginCredentials sends the login token obtained with an SSH key
+type loginCredentials struct {
+	client *Client
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (l loginCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token := l.client.loginToken()
+	if token == "" {
+		return nil, nil
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (l loginCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// loginToken returns the token of the current login
+func (c *Client) loginToken() string {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	return c.login
+}
+
+// sshSigners returns the keys to log in with, loading them on first use.
+// A passphrase for an encrypted key file is asked on the terminal.
+func (c *Client) sshSigners() ([]ssh.Signer, error) {
+	if c.signers != nil && c.signersFrom == c.config.SSHKey {
+		return c.signers, nil
+	}
+
+	var signers []ssh.Signer
+	if c.config.SSHKey == sshAgentKey {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("ssh_key is %q but SSH_AUTH_SOCK is not set", sshAgentKey)
+		}
+		// The connection stays open, the agent signs every login
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("failed to reach ssh-agent: %w", err)
+		}
+		signers, err = agent.NewClient(conn).Signers()
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+		}
+		if len(signers) == 0 {
+			conn.Close()
+			return nil, fmt.Errorf("ssh-agent holds no keys")
+		}
+	} else {
+		path := expandHome(c.config.SSHKey)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			var passphrase []byte
+			passphrase, err = readPassphrase(path)
+			if err == nil {
+				signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH key %s: %w", path, err)
+		}
+		signers = []ssh.Signer{signer}
+	}
+
+	c.signers = signers
+	c.signersFrom = c.config.SSHKey
+	return signers, nil
+}
+
+// readPassphrase asks for the passphrase of a key on the terminal
+func readPassphrase(path string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("the key is encrypted and there is no terminal to ask for its passphrase; use ssh-agent")
+	}
+	fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
+}
+
+// loginWithKey logs in with the first SSH key the server accepts
+func (c *Client) loginWithKey(ctx context.Context, signers []ssh.Signer) error {
+	var refused error
+	for _, signer := range signers {
+		challenge, err := c.client.GetLoginChallenge(ctx, &pb.GetLoginChallengeRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to log in: %w", err)
+		}
+		sig, err := signChallenge(signer, challenge.Challenge)
+		if err != nil {
+			return fmt.Errorf("failed to sign login challenge: %w", err)
+		}
+
+		resp, err := c.client.Login(ctx, &pb.LoginRequest{
+			Challenge:       challenge.Challenge,
+			PublicKey:       signer.PublicKey().Marshal(),
+			SignatureFormat: sig.Format,
+			Signature:       sig.Blob,
+		})
+		if status.Code(err) == codes.PermissionDenied {
+			refused = err
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to log in: %w", err)
+		}
+
+		c.loginMu.Lock()
+		c.login = resp.Token
+		c.loginMu.Unlock()
+		c.logger.Info("Logged in with SSH key", "fingerprint", resp.Fingerprint)
+		return nil
+	}
+	return fmt.Errorf("no SSH key was accepted: %s", status.Convert(refused).Message())
+}
+
+// signChallenge signs a login challenge, with SHA-256 for RSA keys since
+// the server refuses SHA-1 signatures
+func signChallenge(signer ssh.Signer, challenge []byte) (*ssh.Signature, error) {
+	data := sshauth.SignedData(challenge)
+	if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		if as, ok := signer.(ssh.AlgorithmSigner); ok {
+			return as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA256)
+		}
+	}
+	return signer.Sign(rand.Reader, data)
+}
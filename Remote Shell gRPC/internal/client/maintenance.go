@@ -0,0 +1,37 @@
+package client
+
+import (
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// MaintenanceError is returned when a server in maintenance mode refuses
+// a new session
+type MaintenanceError struct {
+	// Message is the notice set by the server administrator
+	Message string
+	err     error
+}
+
+func (e *MaintenanceError) Error() string {
+	return "server is in maintenance: " + e.Message
+}
+
+func (e *MaintenanceError) Unwrap() error {
+	return e.err
+}
+
+// maintenanceMessage returns the maintenance notice attached to err, or ""
+func maintenanceMessage(err error) string {
+	st, ok := status.FromError(err)
+	if !ok {
+		return ""
+	}
+	for _, detail := range st.Details() {
+		if notice, ok := detail.(*pb.MaintenanceNotice); ok {
+			return notice.Message
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// MovedTo reports the address the session was moved to when err is a
+// redirect from a draining server
+func MovedTo(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return "", false
+	}
+	for _, detail := range st.Details() {
+		if moved, ok := detail.(*pb.SessionMoved); ok && moved.Address != "" {
+			return moved.Address, true
+		}
+	}
+	return "", false
+}
+
+// Follow connects to the server the session was moved to and reattaches
+// to it. TLS settings and the auth token are kept, since the new server
+// belongs to the same deployment.
+func (c *Client) Follow(ctx context.Context, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid redirect address %q", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port in redirect address %q", address)
+	}
+
+	c.config.Host = host
+	c.config.Port = port
+	c.config.Profile = ""
+	c.config.Relay = ""
+	return c.Reconnect(ctx)
+}
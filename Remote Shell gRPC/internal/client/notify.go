@@ -0,0 +1,53 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Ways of telling that a long command has finished
+const (
+	// NotifyBell rings the terminal bell
+	NotifyBell = "bell"
+	// NotifyDesktop shows a desktop notification, ringing the bell where
+	// that is not possible
+	NotifyDesktop = "desktop"
+)
+
+// notifyTitleMax is how much of the command a notification shows
+const notifyTitleMax = 60
+
+// notifyDone tells the user that a command which ran for elapsed has
+// finished, when that is longer than the configured threshold
+func (s *Shell) notifyDone(command string, elapsed time.Duration) {
+	if s.config.NotifyAfter <= 0 || elapsed < s.config.NotifyAfter {
+		return
+	}
+	if s.config.Notify == NotifyDesktop {
+		if len(command) > notifyTitleMax {
+			command = command[:notifyTitleMax] + "..."
+		}
+		message := fmt.Sprintf("Finished after %s with exit code %d", elapsed.Round(time.Second), s.lastExit)
+		if err := desktopNotification(command, message); err == nil {
+			return
+		}
+	}
+	fmt.Fprint(os.Stderr, "\a")
+}
+
+// desktopNotification shows a notification with notify-send, or with
+// osascript on macOS
+func desktopNotification(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	default:
+		cmd = exec.Command("notify-send", "--app-name=remote-shell", title, message)
+	}
+	return cmd.Run()
+}
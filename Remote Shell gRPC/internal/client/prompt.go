@@ -0,0 +1,66 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Placeholders in the prompt: the remote working directory and the exit
+// code of the last command
+const (
+	cwdPlaceholder  = "{cwd}"
+	exitPlaceholder = "{exit}"
+)
+
+// colorReset ends a colored part of the prompt
+const colorReset = "\033[0m"
+
+// promptColors are the colors a failed exit code can be shown in
+var promptColors = map[string]string{
+	"black":   "\033[30m",
+	"red":     "\033[31m",
+	"green":   "\033[32m",
+	"yellow":  "\033[33m",
+	"blue":    "\033[34m",
+	"magenta": "\033[35m",
+	"cyan":    "\033[36m",
+	"white":   "\033[37m",
+}
+
+// ColorCode returns the terminal escape sequence for a color name, or ""
+// for the empty name, which leaves text uncolored
+func ColorCode(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	code, ok := promptColors[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown color %q", name)
+	}
+	return code, nil
+}
+
+// prompt returns the prompt with its placeholders filled in. A non-zero
+// exit code is shown in the configured color when the output is a terminal.
+func (s *Shell) prompt() string {
+	prompt := s.config.Prompt
+	if strings.Contains(prompt, cwdPlaceholder) {
+		cwd := s.client.WorkingDir()
+		if cwd == "" {
+			cwd = "?"
+		}
+		prompt = strings.ReplaceAll(prompt, cwdPlaceholder, cwd)
+	}
+	if strings.Contains(prompt, exitPlaceholder) {
+		exit := strconv.Itoa(s.lastExit)
+		if code, _ := ColorCode(s.config.ExitColor); s.lastExit != 0 && code != "" && term.IsTerminal(int(os.Stdout.Fd())) {
+			exit = code + exit + colorReset
+		}
+		prompt = strings.ReplaceAll(prompt, exitPlaceholder, exit)
+	}
+	return prompt
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"remote-shell-rpc/pkg/format"
+	"remote-shell-rpc/pkg/shellparse"
+)
+
+// localPrefix marks a file on this machine as the target of a
+// redirection, e.g. "dmesg > local:dmesg.txt"; plain targets are files on
+// the server
+const localPrefix = "local:"
+
+// trailingRedirect matches a redirection of standard output at the end of
+// a command line, with the target possibly quoted
+var trailingRedirect = regexp.MustCompile(`(?:^|\s)(>>?)\s*('[^']*'|"[^"]*"|\S+)\s*$`)
+
+// progressInterval is how often the progress of redirected output is shown
+const progressInterval = 500 * time.Millisecond
+
+// splitLocalRedirect separates a trailing "> local:<file>" or
+// ">> local:<file>" from a command line, returning the command to run on
+// the server, the local file and whether to append to it
+func splitLocalRedirect(line string) (command, path string, appendTo, ok bool) {
+	m := trailingRedirect.FindStringSubmatchIndex(line)
+	if m == nil {
+		return "", "", false, false
+	}
+	op, target := line[m[2]:m[3]], line[m[4]:m[5]]
+	if len(target) >= 2 && (target[0] == '\'' || target[0] == '"') && target[len(target)-1] == target[0] {
+		target = target[1 : len(target)-1]
+	}
+	path, ok = strings.CutPrefix(target, localPrefix)
+	if !ok || path == "" {
+		return "", "", false, false
+	}
+
+	// The operator must be a redirection of the last command rather than
+	// part of a quoted argument
+	commands, err := shellparse.Parse(line)
+	if err != nil || len(commands) == 0 {
+		return "", "", false, false
+	}
+	last := commands[len(commands)-1].Redirects
+	if len(last) == 0 || last[len(last)-1] != (shellparse.Redirect{Op: op, Target: target}) {
+		return "", "", false, false
+	}
+
+	command = strings.TrimSpace(line[:m[2]])
+	if command == "" {
+		return "", "", false, false
+	}
+	return command, path, op == ">>", true
+}
+
+// runRedirected runs a command on the server with its standard output
+// written to a local file, through filter when that is not nil, showing how
+// much was written while it runs. Error output still goes to the terminal.
+func (s *Shell) runRedirected(ctx context.Context, command, path string, appendTo bool, filter *lineFilter) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", path, err)
+	}
+
+	out := &progressWriter{w: f, path: path, last: time.Now()}
+	if filter == nil {
+		err = s.runRemoteCommand(ctx, command, "", out)
+	} else {
+		filter.w = out
+		err = s.runRemoteCommand(ctx, command, "", filter)
+		if flushErr := filter.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	out.done()
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to write %s: %w", path, closeErr)
+	}
+	return err
+}
+
+// progressWriter writes redirected output to a file, reporting the bytes
+// written so far on the terminal
+type progressWriter struct {
+	w       io.Writer
+	path    string
+	written int64
+	last    time.Time
+	shown   bool
+}
+
+// Write writes p and refreshes the progress line now and then
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("failed to write %s: %w", p.path, err)
+	}
+	if time.Since(p.last) >= progressInterval {
+		p.last = time.Now()
+		p.shown = true
+		fmt.Fprintf(os.Stderr, "\r\033[K[Writing %s: %s]", p.path, format.Bytes(p.written))
+	}
+	return n, nil
+}
+
+// done replaces the progress line with the total
+func (p *progressWriter) done() {
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fmt.Fprintf(os.Stderr, "[Wrote %s to %s]\n", format.Bytes(p.written), p.path)
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package client
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchResize forwards terminal size changes (SIGWINCH) to the server
+// until the context is cancelled
+func (c *Client) watchResize(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigCh:
+			if !c.IsConnected() || !c.HasSession() {
+				continue
+			}
+			if err := c.Resize(ctx); err != nil {
+				c.logger.Debug("Failed to send terminal size", "error", err)
+			}
+		}
+	}
+}
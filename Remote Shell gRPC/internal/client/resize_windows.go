@@ -0,0 +1,9 @@
+//go:build windows
+
+package client
+
+import "context"
+
+// watchResize is a no-op on Windows, which has no SIGWINCH; the size is
+// only sent when the session is created
+func (c *Client) watchResize(ctx context.Context) {}
@@ -0,0 +1,290 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxScriptDepth stops scripts that run themselves from recursing forever
+const maxScriptDepth = 8
+
+var (
+	varName      = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+	forLoop      = regexp.MustCompile(`^for\s+([A-Za-z_]\w*)\s+in\s+(.+)$`)
+	commandSubst = regexp.MustCompile(`^\$\((.*)\)$`)
+)
+
+// scriptNodeKind tells the statements of a script apart
+type scriptNodeKind int
+
+const (
+	nodeLine scriptNodeKind = iota
+	nodeIf
+	nodeFor
+)
+
+// scriptNode is a statement of a parsed script. Plain lines are handled
+// like input at the prompt.
+type scriptNode struct {
+	kind scriptNodeKind
+	line int
+	// text is the line itself, the condition of an if or the command
+	// whose output a for loop iterates over
+	text string
+	// name is the loop variable of a for
+	name   string
+	body   []scriptNode
+	orElse []scriptNode
+}
+
+// scriptParser turns script lines into nodes
+type scriptParser struct {
+	lines []string
+	pos   int
+}
+
+// parseScript parses the lines of a script. "if <command>" and
+// "for <name> in <command>" open blocks closed by "end"; lines ending in
+// "fi" or "done" are left alone as they are shell syntax for the server.
+func parseScript(lines []string) ([]scriptNode, error) {
+	p := &scriptParser{lines: lines}
+	nodes, end, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if end != "" {
+		return nil, fmt.Errorf("line %d: %s without if or for", p.pos, end)
+	}
+	return nodes, nil
+}
+
+// block parses statements up to an "else" or "end", returning which of
+// the two ended it, or "" at the end of the script
+func (p *scriptParser) block() ([]scriptNode, string, error) {
+	var nodes []scriptNode
+	for p.pos < len(p.lines) {
+		text := strings.TrimSpace(p.lines[p.pos])
+		p.pos++
+		line := p.pos
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if text == "else" || text == "end" {
+			return nodes, text, nil
+		}
+
+		if cond, ok := ifCondition(text); ok {
+			node := scriptNode{kind: nodeIf, line: line, text: cond}
+			body, end, err := p.block()
+			if err != nil {
+				return nil, "", err
+			}
+			node.body = body
+			if end == "else" {
+				if node.orElse, end, err = p.block(); err != nil {
+					return nil, "", err
+				}
+			}
+			if end != "end" {
+				return nil, "", fmt.Errorf("line %d: if without end", line)
+			}
+			nodes = append(nodes, node)
+			continue
+		}
+
+		if m := forLoop.FindStringSubmatch(text); m != nil && !strings.HasSuffix(text, "done") {
+			node := scriptNode{kind: nodeFor, line: line, name: m[1], text: m[2]}
+			body, end, err := p.block()
+			if err != nil {
+				return nil, "", err
+			}
+			if end != "end" {
+				return nil, "", fmt.Errorf("line %d: for without end", line)
+			}
+			node.body = body
+			nodes = append(nodes, node)
+			continue
+		}
+
+		nodes = append(nodes, scriptNode{kind: nodeLine, line: line, text: text})
+	}
+	return nodes, "", nil
+}
+
+// ifCondition returns the command of a local "if <command>" line
+func ifCondition(text string) (string, bool) {
+	if !strings.HasPrefix(text, "if ") || strings.HasSuffix(text, "fi") {
+		return "", false
+	}
+	return strings.TrimSpace(text[len("if "):]), true
+}
+
+// handleScript runs a local script file in the current session. key=value
+// arguments set variables before it starts.
+func (s *Shell) handleScript(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: script <file> [key=value...]")
+	}
+	if s.scriptDepth >= maxScriptDepth {
+		return fmt.Errorf("scripts nested more than %d deep", maxScriptDepth)
+	}
+
+	data, err := os.ReadFile(expandHome(args[0]))
+	if err != nil {
+		return fmt.Errorf("failed to read script: %w", err)
+	}
+	nodes, err := parseScript(strings.Split(string(data), "\n"))
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || !varName.MatchString(key) {
+			return fmt.Errorf("invalid parameter %q, expected key=value", arg)
+		}
+		s.vars[key] = value
+	}
+
+	s.scriptDepth++
+	defer func() { s.scriptDepth-- }()
+
+	if err := s.runScript(ctx, nodes); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+// runScript executes parsed statements, stopping at the first error
+func (s *Shell) runScript(ctx context.Context, nodes []scriptNode) error {
+	for _, node := range nodes {
+		// exit or logout inside a script ends it too
+		if !s.running || ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		switch node.kind {
+		case nodeIf:
+			// The condition runs like any other command, output included
+			if err := s.executeRemoteCommand(ctx, s.expandVars(node.text)); err != nil {
+				return fmt.Errorf("line %d: %w", node.line, err)
+			}
+			branch := node.orElse
+			if s.lastExit == 0 {
+				branch = node.body
+			}
+			if err := s.runScript(ctx, branch); err != nil {
+				return err
+			}
+
+		case nodeFor:
+			output, err := s.commandOutput(ctx, s.expandVars(node.text))
+			if err != nil {
+				return fmt.Errorf("line %d: %w", node.line, err)
+			}
+			for _, item := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+				if item == "" {
+					continue
+				}
+				s.vars[node.name] = item
+				if err := s.runScript(ctx, node.body); err != nil {
+					return err
+				}
+			}
+
+		default:
+			if err := s.handleCommand(ctx, node.text); err != nil {
+				return fmt.Errorf("line %d: %w", node.line, err)
+			}
+		}
+	}
+	return nil
+}
+
+// handleLet sets, deletes or lists the shell's variables. "let name = $(cmd)"
+// stores the output of a remote command, without its trailing newlines.
+func (s *Shell) handleLet(ctx context.Context, input string) error {
+	rest := strings.TrimSpace(strings.TrimPrefix(input, "let"))
+	if rest == "" {
+		s.printVars()
+		return nil
+	}
+
+	name, value, assign := strings.Cut(rest, "=")
+	name = strings.TrimSpace(name)
+	if !varName.MatchString(name) {
+		return fmt.Errorf("usage: let <name> [= value | = $(command)]")
+	}
+	if !assign {
+		delete(s.vars, name)
+		return nil
+	}
+
+	value = strings.TrimSpace(value)
+	if m := commandSubst.FindStringSubmatch(value); m != nil {
+		output, err := s.commandOutput(ctx, m[1])
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(output, "\n")
+	}
+	s.vars[name] = value
+	return nil
+}
+
+// commandOutput runs a remote command and returns its standard output,
+// recording its exit code
+func (s *Shell) commandOutput(ctx context.Context, command string) (string, error) {
+	resp, err := s.client.ExecuteCommand(ctx, command, serverTimeout)
+	if err != nil {
+		// Show what the command printed before it failed
+		if resp != nil {
+			fmt.Fprint(os.Stderr, resp.Output, resp.Error)
+		}
+		return "", err
+	}
+	if resp.Confirmation != nil {
+		return "", fmt.Errorf("%q needs confirmation and cannot be used for a value", command)
+	}
+	if resp.Error != "" {
+		fmt.Fprint(os.Stderr, resp.Error)
+	}
+	printNotices(resp.Notices)
+	s.lastExit = int(resp.ExitCode)
+	return resp.Output, nil
+}
+
+// expandVars substitutes {{name}} with the value of defined variables.
+// Other placeholders are left for snippets to fill in.
+func (s *Shell) expandVars(input string) string {
+	if len(s.vars) == 0 {
+		return input
+	}
+	return snippetParam.ReplaceAllStringFunc(input, func(m string) string {
+		if value, ok := s.vars[snippetParam.FindStringSubmatch(m)[1]]; ok {
+			return value
+		}
+		return m
+	})
+}
+
+// printVars lists the shell's variables
+func (s *Shell) printVars() {
+	if len(s.vars) == 0 {
+		fmt.Println("No variables set")
+		return
+	}
+	names := make([]string, 0, len(s.vars))
+	for name := range s.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("  %s = %s\n", name, s.vars[name])
+	}
+}
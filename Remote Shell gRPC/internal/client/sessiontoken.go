@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// sessionTokenCheckInterval is how often the session token is checked for
+// renewal
+const sessionTokenCheckInterval = 10 * time.Second
+
+// sessionCredentials sends the token of the current session
+type sessionCredentials struct {
+	client *Client
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (s sessionCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, _ := s.client.currentSessionToken()
+	if token == "" {
+		return nil, nil
+	}
+	return map[string]string{"x-session-token": token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (s sessionCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// setSessionToken keeps the token the server issued for the session. It
+// is renewed once half of its lifetime has passed.
+func (c *Client) setSessionToken(token string, expiresUnixMs int64) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+
+	c.sessionToken = token
+	if token == "" {
+		return
+	}
+	expires := time.UnixMilli(expiresUnixMs)
+	c.tokenRenewAt = time.Now().Add(time.Until(expires) / 2)
+}
+
+// currentSessionToken returns the session token and when to renew it
+func (c *Client) currentSessionToken() (string, time.Time) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.sessionToken, c.tokenRenewAt
+}
+
+// renewSessionToken replaces the session token with a new one
+func (c *Client) renewSessionToken(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.RenewToken(ctx, &pb.RenewTokenRequest{SessionId: c.sessionID})
+	if err != nil {
+		return err
+	}
+	c.setSessionToken(resp.SessionToken, resp.ExpiresAtUnixMs)
+	return nil
+}
+
+// keepSessionToken renews the session token before it expires until the
+// context is cancelled, so that it stays valid while the shell is idle
+func (c *Client) keepSessionToken(ctx context.Context) {
+	ticker := time.NewTicker(sessionTokenCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			token, renewAt := c.currentSessionToken()
+			if token == "" || time.Now().Before(renewAt) || !c.IsConnected() || !c.HasSession() {
+				continue
+			}
+			if err := c.renewSessionToken(ctx); err != nil {
+				c.logger.Debug("Failed to renew session token", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// ErrGuest is returned for operations only a session's owner may perform
+var ErrGuest = errors.New("only the session owner can do this")
+
+// Attachment streams the events of a shared session
+type Attachment struct {
+	// SessionID is the session attached to
+	SessionID string
+	// Name is how the other participants see this client
+	Name string
+	// ReadWrite is set when this client may run commands in the session
+	ReadWrite bool
+
+	workingDir string
+	stream     pb.ShellService_AttachSessionClient
+}
+
+// Share creates an invitation for another client to watch the session, or
+// with readWrite also run commands in it; a zero ttl uses the server's
+// default
+func (c *Client) Share(ctx context.Context, readWrite bool, ttl time.Duration) (string, time.Time, error) {
+	if c.sessionID == "" {
+		return "", time.Time{}, fmt.Errorf("no active session")
+	}
+	if c.guest {
+		return "", time.Time{}, ErrGuest
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.ShareSession(ctx, &pb.ShareSessionRequest{
+		SessionId:  c.sessionID,
+		ReadWrite:  readWrite,
+		TtlSeconds: uint32(ttl / time.Second),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to share session: %w", err)
+	}
+	return resp.Invitation, time.UnixMilli(resp.ExpiresAtUnixMs), nil
+}
+
+// Unshare removes every guest from the session and returns how many
+// there were
+func (c *Client) Unshare(ctx context.Context) (int, error) {
+	if c.sessionID == "" {
+		return 0, fmt.Errorf("no active session")
+	}
+	if c.guest {
+		return 0, ErrGuest
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	resp, err := c.client.ShareSession(ctx, &pb.ShareSessionRequest{
+		SessionId: c.sessionID,
+		Revoke:    true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to unshare session: %w", err)
+	}
+	return int(resp.Revoked), nil
+}
+
+// Attach joins another client's session with an invitation. Commands run
+// through the client afterwards run in that session, if the invitation
+// allows it. The session is left running when the client disconnects.
+func (c *Client) Attach(ctx context.Context, invitation string) (*Attachment, error) {
+	a, err := c.attach(ctx, &pb.AttachSessionRequest{
+		Invitation: invitation,
+		ClientInfo: c.clientInfo(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.sessionID = a.SessionID
+	c.guest = true
+	c.setWorkingDir(a.workingDir)
+	return a, nil
+}
+
+// FollowSession streams what the guests of the current session do
+func (c *Client) FollowSession(ctx context.Context) (*Attachment, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+	return c.attach(ctx, &pb.AttachSessionRequest{SessionId: c.sessionID})
+}
+
+// attach opens an attachment and waits for the server to accept it
+func (c *Client) attach(ctx context.Context, req *pb.AttachSessionRequest) (*Attachment, error) {
+	stream, err := c.client.AttachSession(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach: %w", err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach: %w", err)
+	}
+	if first.Type != pb.SessionEvent_ATTACHED {
+		return nil, fmt.Errorf("failed to attach: unexpected %s event", first.Type)
+	}
+	c.setSessionToken(first.SessionToken, first.SessionTokenExpiresAtUnixMs)
+	return &Attachment{
+		SessionID:  first.SessionId,
+		Name:       first.Participant,
+		ReadWrite:  first.ReadWrite,
+		workingDir: first.WorkingDir,
+		stream:     stream,
+	}, nil
+}
+
+// IsGuest reports whether the client joined another client's session
+func (c *Client) IsGuest() bool {
+	return c.guest
+}
+
+// Run passes the session's events to handler until the attachment ends.
+// It returns nil when the context is cancelled.
+func (a *Attachment) Run(ctx context.Context, handler func(*pb.SessionEvent)) error {
+	for {
+		event, err := a.stream.Recv()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return errors.New(status.Convert(err).Message())
+		}
+		handler(event)
+	}
+}
+
+// handleShare creates an invitation to the session: "share [-rw] [ttl]"
+func (s *Shell) handleShare(ctx context.Context, args []string) error {
+	readWrite := false
+	var ttl time.Duration
+	for _, arg := range args {
+		if arg == "-rw" {
+			readWrite = true
+			continue
+		}
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("usage: share [-rw] [ttl, e.g. 30m]")
+		}
+		ttl = d
+	}
+
+	invitation, expires, err := s.client.Share(ctx, readWrite, ttl)
+	if err != nil {
+		return err
+	}
+
+	mode := "read-only"
+	if readWrite {
+		mode = "read-write"
+	}
+	fmt.Printf("Invitation (%s, single use, valid until %s):\n  %s\n", mode, expires.Format("15:04"), invitation)
+	fmt.Printf("The guest joins with: client -host %s -port %d -attach %s\n", s.client.config.Host, s.client.config.Port, invitation)
+
+	// Show what guests run from now on
+	if !s.following {
+		a, err := s.client.FollowSession(ctx)
+		if err != nil {
+			return err
+		}
+		s.following = true
+		go s.followSession(ctx, a)
+	}
+	return nil
+}
+
+// handleUnshare removes every guest from the session
+func (s *Shell) handleUnshare(ctx context.Context) error {
+	n, err := s.client.Unshare(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d guest(s); unused invitations are void\n", n)
+	return nil
+}
+
+// RunAttached joins a shared session and, with a read-write invitation,
+// runs the interactive shell in it. Read-only guests watch until the
+// context is cancelled or the session ends.
+func (s *Shell) RunAttached(ctx context.Context, invitation string) error {
+	a, err := s.client.Attach(ctx, invitation)
+	if err != nil {
+		return err
+	}
+	s.following = true
+
+	if a.ReadWrite {
+		fmt.Printf("Joined session %s as %s (read-write)\n", a.SessionID, a.Name)
+		go s.followSession(ctx, a)
+		return s.Run(ctx)
+	}
+
+	fmt.Printf("Watching session %s as %s (read-only), press Ctrl-C to leave\n\n", a.SessionID, a.Name)
+	return a.Run(ctx, func(e *pb.SessionEvent) {
+		s.printSessionEvent(e, a.Name, false)
+	})
+}
+
+// followSession prints what the other participants of the session do
+// until the attachment ends
+func (s *Shell) followSession(ctx context.Context, a *Attachment) {
+	err := a.Run(ctx, func(e *pb.SessionEvent) {
+		s.printSessionEvent(e, a.Name, true)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\r\n[%v]\n", err)
+	}
+	s.following = false
+}
+
+// printSessionEvent shows an event of a shared session. Commands run by
+// this client are already shown as they run, so their events are skipped.
+// With prompt set the prompt is printed again after the event.
+func (s *Shell) printSessionEvent(e *pb.SessionEvent, self string, prompt bool) {
+	reprompt := func() {
+		if prompt {
+			fmt.Print(s.prompt())
+		}
+	}
+
+	if e.Participant == self && e.Type != pb.SessionEvent_NOTICE {
+		return
+	}
+
+	switch e.Type {
+	case pb.SessionEvent_COMMAND_STARTED:
+		fmt.Printf("\r\n[%s] $ %s\n", e.Participant, e.Command)
+	case pb.SessionEvent_OUTPUT:
+		if e.Binary && !s.config.RawOutput {
+			fmt.Fprintf(os.Stderr, "[%s: binary output suppressed]\n", e.Participant)
+			return
+		}
+		if e.Stderr {
+			os.Stderr.Write(e.Data)
+		} else {
+			os.Stdout.Write(e.Data)
+		}
+	case pb.SessionEvent_COMMAND_FINISHED:
+		// Other participants move the session's working directory too
+		s.client.setWorkingDir(e.WorkingDir)
+		if len(e.Data) > 0 {
+			fmt.Fprintf(os.Stderr, "[%s: %s]\n", e.Participant, strings.TrimSpace(string(e.Data)))
+		} else if e.ExitCode != 0 {
+			fmt.Fprintf(os.Stderr, "[%s: exit code %d]\n", e.Participant, e.ExitCode)
+		}
+		reprompt()
+	case pb.SessionEvent_JOINED:
+		fmt.Printf("\r\n[%s joined the session]\n", e.Participant)
+		reprompt()
+	case pb.SessionEvent_LEFT:
+		fmt.Printf("\r\n[%s left the session]\n", e.Participant)
+		reprompt()
+	case pb.SessionEvent_NOTICE:
+		fmt.Printf("\r\n[%s]\n", e.Data)
+	}
+}
+
+// commandStateMessage describes why a command has not started yet, or
+// returns "" once it runs
+func commandStateMessage(state *pb.CommandState) string {
+	switch state.State {
+	case pb.CommandState_QUEUED:
+		return fmt.Sprintf("Session busy: queued at position %d, %d command(s) running", state.Position, state.Running)
+	case pb.CommandState_PENDING_APPROVAL:
+		return "Waiting for administrator approval"
+	case pb.CommandState_BUSY:
+		return "Session busy"
+	}
+	return ""
+}
+
+// printNotices shows messages the server queued for the session's user
+func printNotices(notices []string) {
+	for _, notice := range notices {
+		fmt.Fprintf(os.Stderr, "*** %s ***\n", notice)
+	}
+}
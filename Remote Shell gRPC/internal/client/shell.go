@@ -2,67 +2,197 @@ package client
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/format"
 	pb "remote-shell-rpc/proto"
 )
 
 // ShellConfig holds configuration for the interactive shell
 type ShellConfig struct {
+	// Prompt is printed before every line read; {cwd} and {exit} are
+	// replaced with the remote working directory and the last exit code
 	Prompt      string
 	HistorySize int
+	// ExitColor is the color {exit} is shown in when the last command
+	// failed, e.g. "red"; empty leaves it uncolored
+	ExitColor string
+	// ConfirmPatterns are regular expressions for destructive commands
+	// that require a local confirmation before being sent to the server
+	ConfirmPatterns []string
+	// HistoryIgnore are regular expressions for commands that are kept
+	// out of the history, such as ones holding secrets. Commands typed
+	// with a leading space are always left out.
+	HistoryIgnore []string
+	// RawOutput writes binary command output to the terminal instead of
+	// suppressing it
+	RawOutput bool
+	// Verbose prints the server and round-trip time of every command and
+	// the bytes received for it
+	Verbose bool
+	// KeepAlive runs commands so that they may outlast the server's
+	// timeout for as long as the client keeps answering its pings
+	KeepAlive bool
+	// Quiet leaves out the welcome box, banner, session ID and farewells,
+	// for running the client from scripts or in small panes
+	Quiet bool
+	// SnippetsFile stores the snippets managed with save, run and snippets
+	SnippetsFile string
+	// IdleTimeout closes the session and exits after this long without
+	// input at the prompt; zero disables it. A warning is printed
+	// IdleWarning before the logout.
+	IdleTimeout time.Duration
+	IdleWarning time.Duration
+	// NotifyAfter rings the bell or shows a desktop notification, as
+	// Notify says, when a command that ran at least this long finishes;
+	// zero disables it
+	NotifyAfter time.Duration
+	Notify      string
+	// ControlSocket is the path of a Unix socket on which local tools can
+	// run commands in the session over JSON-RPC; empty disables it
+	ControlSocket string
 }
 
 // DefaultShellConfig returns the default shell configuration
 func DefaultShellConfig() ShellConfig {
 	return ShellConfig{
-		Prompt:      "remote> ",
-		HistorySize: 100,
+		Prompt:       "remote:{cwd}> ",
+		HistorySize:  100,
+		ExitColor:    "red",
+		SnippetsFile: "~/.remote-shell/snippets.yaml",
+		IdleWarning:  time.Minute,
+		Notify:       NotifyBell,
+		ConfirmPatterns: []string{
+			`\brm\s+(-\w*[rR]\w*f|-\w*f\w*[rR])\b`,
+			`(?i)\bdrop\s+(table|database|schema)\b`,
+			`(?i)\btruncate\s+table\b`,
+			`\b(mkfs|shutdown|reboot)\b`,
+		},
+		HistoryIgnore: []string{
+			`(?i)(password|passwd|secret|token|api_?key)\w*=`,
+		},
 	}
 }
 
+// serverTimeout leaves the timeout of a command to the server, which may
+// set it per command
+const serverTimeout = 0
+
 // Shell represents an interactive shell interface
 type Shell struct {
-	client  *Client
-	config  ShellConfig
-	history []string
-	running bool
+	client   *Client
+	config   ShellConfig
+	history  []string
+	running  bool
+	reader   *bufio.Reader
+	confirm  []*regexp.Regexp
+	snippets *Snippets
+	// historyIgnore are the compiled HistoryIgnore patterns
+	historyIgnore []*regexp.Regexp
+	// captureDir, when set, saves the output of every command to a new
+	// file in this server directory (see capture -dir)
+	captureDir string
+	// vars holds the variables set with let and filled in as {{name}}
+	vars        map[string]string
+	lastExit    int
+	scriptDepth int
+	// skewWarned is set once the user was told about the server's clock
+	skewWarned bool
+	// following is set while the events of a shared session are shown
+	following bool
 }
 
-// NewShell creates a new interactive shell
+// NewShell creates a new interactive shell. Invalid confirmation and
+// history patterns are skipped; use CompilePatterns to validate them
+// beforehand.
 func NewShell(client *Client, cfg ShellConfig) *Shell {
 	return &Shell{
-		client:  client,
-		config:  cfg,
-		history: make([]string, 0, cfg.HistorySize),
-		running: false,
+		client:        client,
+		config:        cfg,
+		history:       make([]string, 0, cfg.HistorySize),
+		running:       false,
+		reader:        bufio.NewReader(os.Stdin),
+		confirm:       compileValid(cfg.ConfirmPatterns),
+		historyIgnore: compileValid(cfg.HistoryIgnore),
+		vars:          make(map[string]string),
+	}
+}
+
+// compileValid compiles the valid patterns of a list, skipping the others
+func compileValid(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			compiled = append(compiled, re)
+		}
+	}
+	return compiled
+}
+
+// CompilePatterns compiles a list of regular expressions, reporting the
+// first invalid one
+func CompilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
 	}
+	return compiled, nil
 }
 
 // Run starts the interactive shell loop
 func (s *Shell) Run(ctx context.Context) error {
-	reader := bufio.NewReader(os.Stdin)
+	reader := s.reader
 	s.running = true
 
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	go s.client.watchResize(watchCtx)
+	go s.client.keepSessionToken(watchCtx)
+
 	s.printWelcome()
+	if s.config.ControlSocket != "" {
+		if err := s.serveControl(watchCtx, expandHome(s.config.ControlSocket)); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
 
 	for s.running {
 		// Print prompt
-		fmt.Print(s.config.Prompt)
+		fmt.Print(s.prompt())
 
 		// Read input
+		stopIdle := s.armIdle()
 		input, err := reader.ReadString('\n')
+		stopIdle()
 		if err != nil {
 			if err.Error() == "EOF" {
-				fmt.Println("\nGoodbye!")
+				if !s.config.Quiet {
+					fmt.Println("\nGoodbye!")
+				}
 				break
 			}
 			return fmt.Errorf("failed to read input: %w", err)
 		}
 
+		// A leading space keeps the command out of the history
+		private := strings.HasPrefix(input, " ")
+
 		// Trim whitespace
 		input = strings.TrimSpace(input)
 
@@ -72,7 +202,9 @@ func (s *Shell) Run(ctx context.Context) error {
 		}
 
 		// Add to history
-		s.addToHistory(input)
+		if !private && !s.historyIgnored(input) {
+			s.addToHistory(input)
+		}
 
 		// Handle command
 		if err := s.handleCommand(ctx, input); err != nil {
@@ -90,10 +222,27 @@ func (s *Shell) Stop() {
 
 // handleCommand processes a command
 func (s *Shell) handleCommand(ctx context.Context, input string) error {
+	// Snippets being saved keep their placeholders
+	if !strings.HasPrefix(input, "save ") {
+		input = s.expandVars(input)
+	}
+
 	// Handle local commands
 	switch strings.ToLower(input) {
 	case "exit", "quit":
-		fmt.Println("Goodbye!")
+		if !s.config.Quiet {
+			fmt.Println("Goodbye!")
+		}
+		s.running = false
+		return nil
+
+	case "logout":
+		if err := s.client.CloseSession(ctx); err != nil {
+			return err
+		}
+		if !s.config.Quiet {
+			fmt.Println("Session closed. Goodbye!")
+		}
 		s.running = false
 		return nil
 
@@ -111,34 +260,307 @@ func (s *Shell) handleCommand(ctx context.Context, input string) error {
 		return nil
 
 	case "status":
-		s.printStatus()
+		s.printStatus(ctx)
 		return nil
+
+	case "disconnect":
+		return s.handleDisconnect()
+
+	case "reconnect":
+		return s.handleReconnect(ctx)
+
+	case "macros":
+		return s.printMacros(ctx)
+
+	case "unshare":
+		return s.handleUnshare(ctx)
+
+	case "pwd":
+		return s.handlePwd(ctx)
+	}
+
+	// Handle local commands with arguments
+	fields := strings.Fields(input)
+	switch fields[0] {
+	case "connect":
+		return s.handleConnect(ctx, fields[1:])
+	case "save":
+		return s.handleSave(input)
+	case "run":
+		return s.handleRun(ctx, fields[1:])
+	case "snippets":
+		return s.handleSnippets(fields[1:])
+	case "watch":
+		return s.handleWatch(ctx, input)
+	case "tail":
+		return s.handleTail(ctx, input, fields[1:])
+	case "upload":
+		return s.handleUpload(ctx, fields[1:])
+	case "download":
+		return s.handleDownload(ctx, fields[1:])
+	case "write":
+		return s.handleWrite(ctx, input, fields[1:])
+	case "edit":
+		return s.handleEdit(ctx, fields[1:])
+	case "sync":
+		return s.handleSync(ctx, input, fields[1:])
+	case "envsave":
+		return s.handleEnvSave(ctx, fields[1:])
+	case "envload":
+		return s.handleEnvLoad(ctx, fields[1:])
+	case "capture":
+		return s.handleCapture(ctx, input, fields[1:])
+	case "let":
+		return s.handleLet(ctx, input)
+	case "script":
+		return s.handleScript(ctx, fields[1:])
+	case "share":
+		return s.handleShare(ctx, fields[1:])
+	case "verbose":
+		return s.handleVerbose(fields[1:])
+	case "glob":
+		return s.handleGlob(fields[1:])
 	}
 
 	// Execute remote command with streaming
 	return s.executeRemoteCommand(ctx, input)
 }
 
-// executeRemoteCommand executes a command on the remote server
+// executeRemoteCommand executes a command on the remote server. A trailing
+// "| filter <regex>" filters its output here, and "> local:<file>" writes
+// it to a local file.
 func (s *Shell) executeRemoteCommand(ctx context.Context, command string) error {
+	remote, path, appendTo, redirected := splitLocalRedirect(command)
+	if !redirected {
+		remote = command
+	}
+	remote, filter, err := splitFilter(remote)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case redirected:
+		return s.runRedirected(ctx, remote, path, appendTo, filter)
+	case filter != nil:
+		filter.w = os.Stdout
+		err := s.runRemoteCommand(ctx, remote, "", filter)
+		if flushErr := filter.Flush(); err == nil {
+			err = flushErr
+		}
+		return err
+	}
+	return s.runRemoteCommand(ctx, command, s.captureDir, nil)
+}
+
+// runRemoteCommand executes a command on the remote server, saving its
+// output to outputFile on the server when that is set. Standard output is
+// written to stdout instead of the terminal when that is not nil.
+func (s *Shell) runRemoteCommand(ctx context.Context, command, outputFile string, stdout io.Writer) error {
+	if re := s.destructiveMatch(command); re != nil {
+		if !s.confirmPrompt(fmt.Sprintf("Command matches destructive pattern `%s`. Are you sure? [y/N] ", re)) {
+			fmt.Println("Command cancelled")
+			return nil
+		}
+	}
+
+	// A command that never completes counts as failed
+	s.lastExit = -1
+	began := time.Now()
+	s.client.emit(event{Event: eventCommandStart, Command: command})
+
+	var challenge *pb.ConfirmationChallenge
+	suppressed := false
+	// Output is passed through unchanged, so a last line without a line
+	// break is finished here to keep the prompt on a line of its own
+	midLine := false
+	// Timing for verbose mode, restarted for every request sent
+	var start time.Time
+	received := 0
+	var writeErr error
 	outputHandler := func(output *pb.CommandOutput) {
+		received += len(output.Data)
+		if output.Confirmation != nil {
+			challenge = output.Confirmation
+			return
+		}
+		if output.Approval != nil {
+			fmt.Fprintf(os.Stderr, "[Waiting for administrator approval, request %s]\n", output.Approval.ApprovalId)
+			return
+		}
+		if output.State != nil {
+			if msg := commandStateMessage(output.State); msg != "" {
+				fmt.Fprintf(os.Stderr, "[%s]\n", msg)
+			}
+			return
+		}
+
 		if output.IsComplete {
 			// Command completed
+			if midLine {
+				fmt.Println()
+				midLine = false
+			}
+			s.lastExit = int(output.ExitCode)
+			if output.OutputFile != "" {
+				fmt.Fprintf(os.Stderr, "[Output saved to %s (%s)]\n", output.OutputFile, format.Bytes(output.OutputBytes))
+			}
+			if output.CpuLimitExceeded {
+				fmt.Fprintln(os.Stderr, "[Killed: CPU time limit exceeded]")
+			}
 			if output.ExitCode != 0 {
 				fmt.Fprintf(os.Stderr, "[Exit code: %d]\n", output.ExitCode)
 			}
+			printNotices(output.Notices)
+			skew, uncertainty, skewKnown := s.checkClockSkew(start, output)
+			if s.config.Verbose {
+				printGlobs(output.Globs)
+				printTiming(time.Since(start), time.Duration(output.ExecutionTimeMs)*time.Millisecond, received)
+				if skewKnown {
+					fmt.Fprintf(os.Stderr, "[server clock %s ±%s]\n", describeSkew(skew), format.Duration(uncertainty))
+				}
+			}
+			return
+		}
+
+		// Binary data would corrupt the terminal, so drop the rest of
+		// the output unless raw output was requested
+		if suppressed {
+			return
+		}
+		if stdout == nil && !s.config.RawOutput && isBinary(output) {
+			suppressed = true
+			fmt.Fprintln(os.Stderr, "[binary output suppressed, use download or --raw]")
 			return
 		}
 
 		// Print output
+		if stdout != nil && output.Type == pb.CommandOutput_STDOUT {
+			if _, err := stdout.Write(output.Data); err != nil && writeErr == nil {
+				writeErr = err
+			}
+			return
+		}
 		if output.Type == pb.CommandOutput_STDERR {
-			fmt.Fprint(os.Stderr, string(output.Data))
+			os.Stderr.Write(output.Data)
 		} else {
-			fmt.Print(string(output.Data))
+			os.Stdout.Write(output.Data)
+		}
+		if len(output.Data) > 0 {
+			midLine = output.Data[len(output.Data)-1] != '\n'
+		}
+	}
+
+	execute := func() error {
+		start, received = time.Now(), 0
+		if outputFile != "" {
+			return s.client.ExecuteCommandToFile(ctx, command, outputFile, 0, serverTimeout, outputHandler)
+		}
+		if s.config.KeepAlive {
+			return s.client.ExecuteCommandWatched(ctx, command, serverTimeout, outputHandler)
 		}
+		return s.client.ExecuteCommandStream(ctx, command, serverTimeout, outputHandler)
 	}
 
-	return s.client.ExecuteCommandStream(ctx, command, 30, outputHandler)
+	err := execute()
+	if address, moved := MovedTo(err); moved {
+		// The command was rejected before it ran, so it is safe to retry
+		fmt.Printf("Session moved to %s, reconnecting...\n", address)
+		if err := s.client.Follow(ctx, address); err != nil {
+			return err
+		}
+		err = execute()
+	}
+	if err == nil && challenge != nil {
+		// The server holds the command until we answer its challenge
+		approve := s.confirmPrompt(fmt.Sprintf("Server requires confirmation (%s). Run it anyway? [y/N] ", challenge.Reason))
+		start, received, began = time.Now(), 0, time.Now()
+		err = s.client.ConfirmCommand(ctx, challenge.Token, approve, outputHandler)
+		if err == nil && !approve {
+			fmt.Println("Command cancelled")
+		}
+	}
+	s.notifyDone(command, time.Since(began))
+	s.emitCommandEnd(command, time.Since(began), err)
+	if status.Code(err) == codes.Unavailable {
+		return fmt.Errorf("%w (use 'reconnect' to restore the connection)", err)
+	}
+	if err == nil {
+		err = writeErr
+	}
+	return err
+}
+
+// handleVerbose turns the timing shown after each command on or off:
+// "verbose [on|off]"; without an argument it reports the current setting
+func (s *Shell) handleVerbose(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: verbose [on|off]")
+	}
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			s.config.Verbose = true
+		case "off":
+			s.config.Verbose = false
+		default:
+			return fmt.Errorf("usage: verbose [on|off]")
+		}
+	}
+	if s.config.Verbose {
+		fmt.Println("Verbose timing is on")
+	} else {
+		fmt.Println("Verbose timing is off")
+	}
+	return nil
+}
+
+// printTiming shows how much of a command's round trip was spent running
+// it on the server, and how much output came back
+func printTiming(roundTrip, server time.Duration, received int) {
+	overhead := roundTrip - server
+	if overhead < 0 {
+		overhead = 0
+	}
+	fmt.Fprintf(os.Stderr, "[server %s, round trip %s, overhead %s, %s received]\n",
+		format.Duration(server), format.Duration(roundTrip), format.Duration(overhead), format.Bytes(int64(received)))
+}
+
+// isBinary reports whether an output chunk holds binary data
+func isBinary(output *pb.CommandOutput) bool {
+	return output.Binary || bytes.IndexByte(output.Data, 0) >= 0
+}
+
+// destructiveMatch returns the first confirmation pattern matching the command
+func (s *Shell) destructiveMatch(command string) *regexp.Regexp {
+	for _, re := range s.confirm {
+		if re.MatchString(command) {
+			return re
+		}
+	}
+	return nil
+}
+
+// confirmPrompt asks the user a yes/no question, defaulting to no
+func (s *Shell) confirmPrompt(question string) bool {
+	fmt.Print(question)
+	answer, err := s.reader.ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// historyIgnored reports whether a command matches a HistoryIgnore pattern
+func (s *Shell) historyIgnored(command string) bool {
+	for _, re := range s.historyIgnore {
+		if re.MatchString(command) {
+			return true
+		}
+	}
+	return false
 }
 
 // addToHistory adds a command to the history
@@ -149,8 +571,11 @@ func (s *Shell) addToHistory(cmd string) {
 	s.history = append(s.history, cmd)
 }
 
-// printWelcome prints the welcome message
+// printWelcome prints the welcome message, unless the shell is quiet
 func (s *Shell) printWelcome() {
+	if s.config.Quiet {
+		return
+	}
 	fmt.Println("╔════════════════════════════════════════════════════╗")
 	fmt.Println("║       Remote Shell RPC Client - Group 15           ║")
 	fmt.Println("║────────────────────────────────────────────────────║")
@@ -158,7 +583,21 @@ func (s *Shell) printWelcome() {
 	fmt.Println("║  Type 'exit' or 'quit' to disconnect               ║")
 	fmt.Println("╚════════════════════════════════════════════════════╝")
 	fmt.Println()
+	s.printBanner()
 	fmt.Printf("Session ID: %s\n", s.client.GetSessionID())
+	if id := s.client.ServerID(); id != "" {
+		fmt.Printf("Server:     %s\n", id)
+	}
+	fmt.Println()
+}
+
+// printBanner prints the server's banner, if it sent one
+func (s *Shell) printBanner() {
+	banner := strings.TrimRight(s.client.Banner(), "\n")
+	if banner == "" {
+		return
+	}
+	fmt.Println(banner)
 	fmt.Println()
 }
 
@@ -169,15 +608,61 @@ func (s *Shell) printHelp() {
 	fmt.Println("  help     - Show this help message")
 	fmt.Println("  exit     - Disconnect and exit")
 	fmt.Println("  quit     - Same as exit")
+	fmt.Println("  logout   - Close the remote session and exit")
 	fmt.Println("  clear    - Clear the screen")
 	fmt.Println("  history  - Show command history")
-	fmt.Println("  status   - Show connection status")
+	fmt.Println("  status   - Show connection status and session details")
+	fmt.Println("  pwd      - Show the remote working directory (answered locally)")
+	fmt.Println("  verbose [on|off]  - Show server time, round trip and bytes after each command")
+	fmt.Println("  glob [shell|noglob|expand]  - Choose how *, ? and [...] in commands are expanded")
+	fmt.Println("  connect <profile|host:port>  - Switch to another server")
+	fmt.Println("  disconnect  - Drop the server connection")
+	fmt.Println("  reconnect   - Reconnect to the current server")
+	fmt.Println("  save <name> \"<command>\"  - Save a command as a snippet")
+	fmt.Println("  run <name> [key=value...]  - Run a snippet, filling in {{key}}")
+	fmt.Println("  snippets [list|delete <name>]  - Manage snippets")
+	fmt.Println("  macros      - List server macros; run one with @<name>")
+	fmt.Println("  watch <interval> <command>  - Rerun a command until a key is pressed")
+	fmt.Println("  tail [-n lines] <path>      - Follow a remote file until a key is pressed")
+	fmt.Println("  upload <local> [remote]     - Copy a file to the server")
+	fmt.Println("  upload -conflict fail|rename <local> [remote]  - Keep an existing remote file")
+	fmt.Println("  download <remote> [local]   - Copy a file from the server")
+	fmt.Println("  write <remote> <<EOF        - Write the following lines, up to EOF, to a file")
+	fmt.Println("  edit <remote>               - Edit a remote file in the local $EDITOR")
+	fmt.Println("  sync push <local> remote:<dir>  - Copy changed files to the server")
+	fmt.Println("  sync pull remote:<dir> <local>  - Copy changed files from the server")
+	fmt.Println("  envsave <name>              - Save the environment and directory")
+	fmt.Println("  envload [name]              - Restore a saved environment, or list them")
+	fmt.Println("  capture <file> <command>    - Save output on the server, show only the tail")
+	fmt.Println("  capture -dir <dir> | -off   - Save the output of every command in <dir>")
+	fmt.Println("  <command> > local:<file>    - Write the output to a local file (>> appends)")
+	fmt.Println("  <command> | filter [-v] <regex>  - Show only the output lines matching <regex>")
+	fmt.Println("  let [name [= value | = $(command)]]  - Set, delete or list {{name}} variables")
+	fmt.Println("  script <file> [key=value...]  - Run a local script with if/for/end")
+	fmt.Println("  share [-rw] [ttl]           - Invite another client to watch (or use) this session")
+	fmt.Println("  unshare                     - Remove every guest from this session")
 	fmt.Println()
 	fmt.Println("All other commands are executed on the remote server.")
 	fmt.Println("───────────────────────────────────────────────────")
 	fmt.Println()
 }
 
+// printMacros lists the macros defined on the server
+func (s *Shell) printMacros(ctx context.Context) error {
+	macros, err := s.client.ListMacros(ctx)
+	if err != nil {
+		return err
+	}
+	if len(macros) == 0 {
+		fmt.Println("The server defines no macros")
+		return nil
+	}
+	for _, m := range macros {
+		fmt.Printf("  @%-16s %s\n", m.Name, m.Command)
+	}
+	return nil
+}
+
 // printHistory prints the command history
 func (s *Shell) printHistory() {
 	fmt.Println("\nCommand History:")
@@ -190,7 +675,8 @@ func (s *Shell) printHistory() {
 }
 
 // printStatus prints the connection status
-func (s *Shell) printStatus() {
+func (s *Shell) printStatus(ctx context.Context) {
+	// Connection first, then what the server reports about the session
 	fmt.Println("\nConnection Status:")
 	fmt.Println("───────────────────────────────────────────────────")
 	if s.client.IsConnected() {
@@ -198,11 +684,53 @@ func (s *Shell) printStatus() {
 	} else {
 		fmt.Println("  Connected: No")
 	}
+	fmt.Printf("  Server: %s\n", s.client.Address())
+	if profile := s.client.Config().Profile; profile != "" {
+		fmt.Printf("  Profile: %s\n", profile)
+	}
 	if s.client.HasSession() {
 		fmt.Printf("  Session ID: %s\n", s.client.GetSessionID())
+		if info, err := s.client.GetSessionInfo(ctx); err == nil {
+			printSessionInfo(info)
+		} else {
+			fmt.Printf("  Session details unavailable: %v\n", err)
+		}
 	} else {
 		fmt.Println("  Session ID: None")
 	}
 	fmt.Println("───────────────────────────────────────────────────")
 	fmt.Println()
 }
+
+// printSessionInfo prints the state and resource usage of the session as
+// reported by the server
+func printSessionInfo(info *pb.SessionInfo) {
+	now := time.Now()
+	created := time.UnixMilli(info.CreatedAtUnixMs)
+	lastActivity := time.UnixMilli(info.LastActivityUnixMs)
+
+	fmt.Printf("  Client ID: %s\n", info.ClientId)
+	fmt.Printf("  Shell: %s\n", info.Shell)
+	fmt.Printf("  Working directory: %s\n", info.WorkingDir)
+	fmt.Printf("  Created: %s (%s ago)\n", created.Format(time.RFC3339), format.Duration(now.Sub(created)))
+	fmt.Printf("  Last activity: %s (%s ago)\n", lastActivity.Format(time.RFC3339), format.Duration(now.Sub(lastActivity)))
+	fmt.Printf("  Commands: %d (%s wall, %s CPU)\n", info.Commands, format.Millis(info.WallTimeMs), format.Millis(info.CpuTimeMs))
+	fmt.Printf("  Output received: %s\n", format.Bytes(info.BytesStreamed))
+	if info.Nice != 0 || info.IoClass != "" {
+		priority := executor.Priority{Nice: int(info.Nice), IOClass: info.IoClass, IOLevel: int(info.IoLevel)}
+		fmt.Printf("  Priority: %s\n", priority)
+	}
+
+	if len(info.Env) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(info.Env))
+	for k := range info.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Println("  Environment:")
+	for _, k := range keys {
+		fmt.Printf("    %s=%s\n", k, info.Env[k])
+	}
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"remote-shell-rpc/pkg/format"
+	pb "remote-shell-rpc/proto"
+)
+
+// skewWarning is how far the server's clock must be off, beyond the
+// uncertainty of the estimate, before the user is told
+const skewWarning = time.Second
+
+// clockSkew estimates how far the server's clock is ahead of this one,
+// from a command sent at sent whose final message arrived at received, and
+// that ran from started to finished by the server's clock. The server
+// cannot have started before the request was sent nor finished after the
+// reply arrived, which bounds the skew; the middle of the bounds is
+// returned along with half their distance.
+func clockSkew(sent, received, started, finished time.Time) (skew, uncertainty time.Duration) {
+	lower := finished.Sub(received)
+	upper := started.Sub(sent)
+	if upper < lower {
+		lower, upper = upper, lower
+	}
+	return (lower + upper) / 2, (upper - lower) / 2
+}
+
+// checkClockSkew estimates the server's clock skew from a command's final
+// message, warning once per shell when it is clearly off. It returns false
+// for servers that do not send timestamps.
+func (s *Shell) checkClockSkew(sent time.Time, output *pb.CommandOutput) (skew, uncertainty time.Duration, ok bool) {
+	if output.StartedAtUnixMs == 0 || output.FinishedAtUnixMs == 0 {
+		return 0, 0, false
+	}
+	skew, uncertainty = clockSkew(sent, time.Now(),
+		time.UnixMilli(output.StartedAtUnixMs), time.UnixMilli(output.FinishedAtUnixMs))
+	// The timestamps are in whole milliseconds
+	uncertainty += time.Millisecond
+
+	off := skew
+	if off < 0 {
+		off = -off
+	}
+	if !s.skewWarned && off-uncertainty > skewWarning {
+		s.skewWarned = true
+		fmt.Fprintf(os.Stderr, "[The server's clock is %s; its timestamps, e.g. in the audit log, are off by that much]\n", describeSkew(skew))
+	}
+	return skew, uncertainty, true
+}
+
+// describeSkew says how far and which way the server's clock is off
+func describeSkew(skew time.Duration) string {
+	skew = skew.Round(time.Millisecond)
+	if skew == 0 {
+		return "in step"
+	}
+	if skew < 0 {
+		return format.Duration(-skew) + " behind"
+	}
+	return format.Duration(skew) + " ahead"
+}
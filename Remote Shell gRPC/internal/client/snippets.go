@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// snippetParam matches a {{name}} placeholder in a snippet
+var snippetParam = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Snippets is a set of named command templates persisted in a YAML file
+type Snippets struct {
+	path  string
+	items map[string]string
+}
+
+// LoadSnippets reads snippets from a file. A missing file yields an empty set.
+func LoadSnippets(path string) (*Snippets, error) {
+	s := &Snippets{
+		path:  expandHome(path),
+		items: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read snippets: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &s.items); err != nil {
+		return nil, fmt.Errorf("failed to parse snippets: %w", err)
+	}
+	if s.items == nil {
+		s.items = make(map[string]string)
+	}
+	return s, nil
+}
+
+// Get returns the command saved under a name
+func (s *Snippets) Get(name string) (string, bool) {
+	command, ok := s.items[name]
+	return command, ok
+}
+
+// Names returns the snippet names in sorted order
+func (s *Snippets) Names() []string {
+	names := make([]string, 0, len(s.items))
+	for name := range s.items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save stores a snippet and writes the file
+func (s *Snippets) Save(name, command string) error {
+	s.items[name] = command
+	return s.write()
+}
+
+// Delete removes a snippet and writes the file
+func (s *Snippets) Delete(name string) error {
+	if _, ok := s.items[name]; !ok {
+		return fmt.Errorf("no snippet named %q", name)
+	}
+	delete(s.items, name)
+	return s.write()
+}
+
+// write persists the snippets
+func (s *Snippets) write() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create snippets directory: %w", err)
+	}
+
+	data, err := yaml.Marshal(s.items)
+	if err != nil {
+		return fmt.Errorf("failed to encode snippets: %w", err)
+	}
+
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write snippets: %w", err)
+	}
+	return nil
+}
+
+// ExpandSnippet substitutes {{name}} placeholders with parameters given as
+// key=value pairs, reporting any placeholder left without a value
+func ExpandSnippet(template string, args []string) (string, error) {
+	params := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return "", fmt.Errorf("invalid parameter %q, expected key=value", arg)
+		}
+		params[key] = value
+	}
+
+	var missing []string
+	expanded := snippetParam.ReplaceAllStringFunc(template, func(m string) string {
+		key := snippetParam.FindStringSubmatch(m)[1]
+		value, ok := params[key]
+		if !ok {
+			if !slices.Contains(missing, key) {
+				missing = append(missing, key)
+			}
+			return m
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing parameters: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// loadSnippets loads the snippets file on first use
+func (s *Shell) loadSnippets() (*Snippets, error) {
+	if s.snippets == nil {
+		snippets, err := LoadSnippets(s.config.SnippetsFile)
+		if err != nil {
+			return nil, err
+		}
+		s.snippets = snippets
+	}
+	return s.snippets, nil
+}
+
+// handleSave saves the rest of the input line as a snippet
+func (s *Shell) handleSave(input string) error {
+	rest := strings.TrimSpace(strings.TrimPrefix(input, "save"))
+	name, command, _ := strings.Cut(rest, " ")
+	command = strings.TrimSpace(command)
+	if name == "" || command == "" {
+		return fmt.Errorf("usage: save <name> \"<command>\"")
+	}
+
+	// Quotes around the whole command are only there to group it
+	if len(command) >= 2 && (command[0] == '"' || command[0] == '\'') && command[len(command)-1] == command[0] {
+		command = command[1 : len(command)-1]
+	}
+
+	snippets, err := s.loadSnippets()
+	if err != nil {
+		return err
+	}
+	if err := snippets.Save(name, command); err != nil {
+		return err
+	}
+	fmt.Printf("Saved snippet %s\n", name)
+	return nil
+}
+
+// handleRun expands a snippet and runs it on the server
+func (s *Shell) handleRun(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: run <name> [key=value...]")
+	}
+
+	snippets, err := s.loadSnippets()
+	if err != nil {
+		return err
+	}
+	template, ok := snippets.Get(args[0])
+	if !ok {
+		return fmt.Errorf("no snippet named %q", args[0])
+	}
+
+	command, err := ExpandSnippet(template, args[1:])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("> %s\n", command)
+	return s.executeRemoteCommand(ctx, command)
+}
+
+// handleSnippets lists or deletes snippets
+func (s *Shell) handleSnippets(args []string) error {
+	snippets, err := s.loadSnippets()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 || args[0] == "list" {
+		names := snippets.Names()
+		if len(names) == 0 {
+			fmt.Println("No snippets saved")
+			return nil
+		}
+		for _, name := range names {
+			command, _ := snippets.Get(name)
+			fmt.Printf("  %-16s %s\n", name, command)
+		}
+		return nil
+	}
+
+	if args[0] == "delete" && len(args) == 2 {
+		if err := snippets.Delete(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted snippet %s\n", args[1])
+		return nil
+	}
+
+	return fmt.Errorf("usage: snippets [list|delete <name>]")
+}
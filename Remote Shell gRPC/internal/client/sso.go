@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"remote-shell-rpc/pkg/oidc"
+)
+
+// defaultTokenCache keeps single sign-on tokens between runs
+const defaultTokenCache = "~/.remote-shell/oidc-tokens.json"
+
+// tokenRefreshMargin is how long before it expires an ID token is renewed
+const tokenRefreshMargin = 30 * time.Second
+
+// defaultOIDCScopes are requested when the configuration names none;
+// offline_access asks for a refresh token so that logins last longer
+// than an ID token
+var defaultOIDCScopes = []string{"openid", "profile", "email", "offline_access"}
+
+// ssoCredentials sends the ID token of a single sign-on login
+type ssoCredentials struct {
+	client *Client
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials
+func (s ssoCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := s.client.ssoToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials
+func (s ssoCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// oidcApp returns how the client is registered with the identity provider
+func (c *Client) oidcApp() oidc.App {
+	scopes := c.config.OIDC.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOIDCScopes
+	}
+	return oidc.App{
+		ClientID:     c.config.OIDC.ClientID,
+		ClientSecret: c.config.OIDC.ClientSecret,
+		Scopes:       scopes,
+	}
+}
+
+// ssoLogin makes sure the client holds a valid ID token, taken from the
+// token cache, renewed with a refresh token, or else obtained with a
+// device login that the user completes in a browser
+func (c *Client) ssoLogin(ctx context.Context) error {
+	c.ssoMu.Lock()
+	defer c.ssoMu.Unlock()
+
+	cfg := c.config.OIDC
+	if cfg.ClientID == "" {
+		return fmt.Errorf("oidc.client_id is required for single sign-on")
+	}
+	// Another profile may use another provider
+	key := cfg.Issuer + " " + cfg.ClientID
+	if c.ssoKey != key {
+		c.ssoKey = key
+		c.ssoProvider = nil
+		c.ssoCurrent = loadTokenCache(c.tokenCachePath())[key]
+	}
+	if c.ssoCurrent.Valid(tokenRefreshMargin) {
+		return nil
+	}
+
+	if c.ssoProvider == nil {
+		provider, err := oidc.Discover(ctx, cfg.Issuer)
+		if err != nil {
+			return err
+		}
+		c.ssoProvider = provider
+	}
+	if err := c.refreshSSO(ctx); err == nil {
+		return nil
+	}
+
+	app := c.oidcApp()
+	code, err := c.ssoProvider.DeviceAuth(ctx, app)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("To log in, open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	if code.VerificationURIComplete != "" {
+		fmt.Printf("or open %s\n", code.VerificationURIComplete)
+	}
+	fmt.Println("Waiting for the login to complete...")
+
+	token, err := c.ssoProvider.WaitForToken(ctx, app, code)
+	if err != nil {
+		return fmt.Errorf("single sign-on failed: %w", err)
+	}
+	c.setSSOToken(token)
+	c.logger.Info("Logged in with single sign-on", "issuer", cfg.Issuer)
+	return nil
+}
+
+// ssoToken returns the current ID token, renewing it when it is about to
+// expire
+func (c *Client) ssoToken(ctx context.Context) (string, error) {
+	c.ssoMu.Lock()
+	defer c.ssoMu.Unlock()
+
+	if !c.ssoCurrent.Valid(tokenRefreshMargin) {
+		if err := c.refreshSSO(ctx); err != nil {
+			return "", fmt.Errorf("single sign-on login expired (use 'reconnect' to log in again): %w", err)
+		}
+	}
+	return c.ssoCurrent.IDToken, nil
+}
+
+// refreshSSO renews the ID token with the login's refresh token. The
+// caller holds ssoMu.
+func (c *Client) refreshSSO(ctx context.Context) error {
+	if c.ssoCurrent == nil || c.ssoCurrent.RefreshToken == "" || c.ssoProvider == nil {
+		return fmt.Errorf("no refresh token")
+	}
+	token, err := c.ssoProvider.Refresh(ctx, c.oidcApp(), c.ssoCurrent.RefreshToken)
+	if err != nil {
+		c.logger.Debug("Failed to refresh single sign-on token", "error", err.Error())
+		return err
+	}
+	c.setSSOToken(token)
+	return nil
+}
+
+// setSSOToken makes token current and saves it in the token cache. The
+// caller holds ssoMu.
+func (c *Client) setSSOToken(token *oidc.Token) {
+	c.ssoCurrent = token
+	if err := saveTokenCache(c.tokenCachePath(), c.ssoKey, token); err != nil {
+		c.logger.Warn("Failed to save single sign-on token", "error", err.Error())
+	}
+}
+
+// tokenCachePath returns the path of the token cache
+func (c *Client) tokenCachePath() string {
+	if c.config.OIDC.TokenCache != "" {
+		return expandHome(c.config.OIDC.TokenCache)
+	}
+	return expandHome(defaultTokenCache)
+}
+
+// loadTokenCache reads the cached tokens by provider and client ID. A
+// missing or unreadable cache is empty.
+func loadTokenCache(path string) map[string]*oidc.Token {
+	tokens := make(map[string]*oidc.Token)
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &tokens)
+	}
+	return tokens
+}
+
+// saveTokenCache stores the token for key in the cache, which only its
+// owner can read
+func saveTokenCache(path, key string, token *oidc.Token) error {
+	tokens := loadTokenCache(path)
+	tokens[key] = token
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
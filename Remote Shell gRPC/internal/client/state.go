@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// State is the client state persisted between runs so that a relaunched
+// client can reattach to its previous server session
+type State struct {
+	ClientID  string `yaml:"client_id"`
+	SessionID string `yaml:"session_id"`
+}
+
+// LoadState reads the state file. A missing file yields an empty state.
+func LoadState(path string) (State, error) {
+	var st State
+
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return st, nil
+		}
+		return st, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return st, nil
+}
+
+// SaveState writes the state file, creating its directory if needed
+func SaveState(path string, st State) error {
+	path = expandHome(path)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/files"
+	"remote-shell-rpc/pkg/format"
+)
+
+// remotePrefix marks the remote side of a sync, e.g. "remote:/srv/app"
+const remotePrefix = "remote:"
+
+// SyncOptions controls a directory sync
+type SyncOptions struct {
+	// Include limits the sync to files matching one of these patterns
+	Include []string
+	// Exclude skips files and directories matching one of these patterns
+	Exclude []string
+	// DryRun reports what would be transferred without transferring it
+	DryRun bool
+	// Progress is called with the relative path of every changed file
+	// before it is transferred
+	Progress func(rel string)
+}
+
+// SyncResult summarises a directory sync
+type SyncResult struct {
+	Transferred int
+	Unchanged   int
+	Bytes       int64
+}
+
+// selected reports whether a relative path takes part in the sync.
+// Patterns are matched against the whole relative path and the base name.
+func (o SyncOptions) selected(rel string, dir bool) bool {
+	if matchAny(o.Exclude, rel) {
+		return false
+	}
+	return dir || len(o.Include) == 0 || matchAny(o.Include, rel)
+}
+
+// matchAny reports whether a slash-separated path or its base name matches
+// one of the glob patterns
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncPush copies the files of a local directory tree that are missing or
+// different on the server, comparing SHA-256 checksums
+func (c *Client) SyncPush(ctx context.Context, localDir, remoteDir string, opts SyncOptions) (SyncResult, error) {
+	var result SyncResult
+
+	err := filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !opts.selected(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		local, err := files.Checksum(p)
+		if err != nil {
+			return err
+		}
+		remote, err := c.remoteChecksum(ctx, path.Join(remoteDir, rel))
+		if err != nil {
+			return err
+		}
+		if local == remote {
+			result.Unchanged++
+			return nil
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(rel)
+		}
+		result.Transferred++
+		if opts.DryRun {
+			return nil
+		}
+		size, err := c.Upload(ctx, p, path.Join(remoteDir, rel))
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		result.Bytes += size
+		return nil
+	})
+	return result, err
+}
+
+// SyncPull copies the files of a remote directory tree that are missing or
+// different locally, comparing SHA-256 checksums
+func (c *Client) SyncPull(ctx context.Context, remoteDir, localDir string, opts SyncOptions) (SyncResult, error) {
+	var result SyncResult
+
+	err := c.walkRemote(ctx, remoteDir, "", opts, func(rel string) error {
+		remote, err := c.remoteChecksum(ctx, path.Join(remoteDir, rel))
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(localDir, filepath.FromSlash(rel))
+		local, err := files.Checksum(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if local == remote {
+			result.Unchanged++
+			return nil
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(rel)
+		}
+		result.Transferred++
+		if opts.DryRun {
+			return nil
+		}
+		size, err := c.Download(ctx, path.Join(remoteDir, rel), p)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		result.Bytes += size
+		return nil
+	})
+	return result, err
+}
+
+// walkRemote calls fn with the relative path of every selected regular
+// file below a remote directory
+func (c *Client) walkRemote(ctx context.Context, root, rel string, opts SyncOptions, fn func(rel string) error) error {
+	resp, err := c.ListDirectory(ctx, path.Join(root, rel))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range resp.Entries {
+		entryRel := path.Join(rel, entry.Name)
+		switch entry.Type {
+		case pb.DirectoryEntry_DIRECTORY:
+			if opts.selected(entryRel, true) {
+				if err := c.walkRemote(ctx, root, entryRel, opts, fn); err != nil {
+					return err
+				}
+			}
+		case pb.DirectoryEntry_FILE:
+			if opts.selected(entryRel, false) {
+				if err := fn(entryRel); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// remoteChecksum returns the checksum of a remote file, or "" when it does
+// not exist
+func (c *Client) remoteChecksum(ctx context.Context, remotePath string) (string, error) {
+	info, err := c.StatFile(ctx, remotePath, true)
+	if status.Code(err) == codes.NotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return info.Sha256, nil
+}
+
+// patternList collects a repeatable flag
+type patternList []string
+
+func (p *patternList) String() string     { return strings.Join(*p, ",") }
+func (p *patternList) Set(v string) error { *p = append(*p, v); return nil }
+
+// handleSync implements "sync push <local> remote:<dir>" and
+// "sync pull remote:<dir> <local>"
+func (s *Shell) handleSync(ctx context.Context, input string, args []string) error {
+	// Plain sync(1) still runs on the server
+	if len(args) == 0 || (args[0] != "push" && args[0] != "pull") {
+		return s.executeRemoteCommand(ctx, input)
+	}
+	usage := fmt.Errorf("usage: sync push|pull [-n] [-include pattern] [-exclude pattern] <source> <destination>")
+	direction := args[0]
+
+	var opts SyncOptions
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	flags.BoolVar(&opts.DryRun, "n", false, "Show what would be transferred")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "Show what would be transferred")
+	flags.Var((*patternList)(&opts.Include), "include", "Only sync files matching this pattern (repeatable)")
+	flags.Var((*patternList)(&opts.Exclude), "exclude", "Skip files and directories matching this pattern (repeatable)")
+	if err := flags.Parse(args[1:]); err != nil {
+		return usage
+	}
+	if flags.NArg() != 2 {
+		return usage
+	}
+
+	prefix := "  "
+	if opts.DryRun {
+		prefix = "  would " + direction + " "
+	}
+	opts.Progress = func(rel string) {
+		fmt.Println(prefix + rel)
+	}
+
+	var result SyncResult
+	var err error
+	if direction == "push" {
+		remote, ok := strings.CutPrefix(flags.Arg(1), remotePrefix)
+		if !ok {
+			return fmt.Errorf("destination must be %s<dir>", remotePrefix)
+		}
+		result, err = s.client.SyncPush(ctx, flags.Arg(0), remote, opts)
+	} else {
+		remote, ok := strings.CutPrefix(flags.Arg(0), remotePrefix)
+		if !ok {
+			return fmt.Errorf("source must be %s<dir>", remotePrefix)
+		}
+		result, err = s.client.SyncPull(ctx, remote, flags.Arg(1), opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		fmt.Printf("%d files would be transferred, %d unchanged\n", result.Transferred, result.Unchanged)
+	} else {
+		fmt.Printf("%d files transferred (%s), %d unchanged\n", result.Transferred, format.Bytes(result.Bytes), result.Unchanged)
+	}
+	return nil
+}
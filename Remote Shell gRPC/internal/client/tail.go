@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// handleTail follows a remote file until a key is pressed. Forms of tail
+// the builtin does not understand run as a remote command.
+func (s *Shell) handleTail(ctx context.Context, input string, args []string) error {
+	path, lines, ok := parseTailArgs(args)
+	if !ok {
+		return s.executeRemoteCommand(ctx, input)
+	}
+
+	ctx, stop, err := untilKeypress(ctx)
+	if err != nil {
+		return fmt.Errorf("tail needs an interactive terminal: %w", err)
+	}
+	defer stop()
+
+	suppressed := false
+	return s.client.TailFile(ctx, path, lines, func(output *pb.TailFileOutput) {
+		if output.Rotated {
+			fmt.Print("\r\n[file rotated or truncated, following from the start]\r\n")
+			suppressed = false
+		}
+		if suppressed {
+			return
+		}
+		if output.Binary && !s.config.RawOutput {
+			suppressed = true
+			fmt.Print("[binary data suppressed]\r\n")
+			return
+		}
+		// Raw mode disables output post-processing
+		fmt.Print(strings.ReplaceAll(string(output.Data), "\n", "\r\n"))
+	})
+}
+
+// parseTailArgs accepts "[-f] [-n lines] <path>"; -f is implied
+func parseTailArgs(args []string) (path string, lines int, ok bool) {
+	for len(args) > 0 {
+		switch {
+		case args[0] == "-f" || args[0] == "-F":
+			args = args[1:]
+		case args[0] == "-n" && len(args) >= 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return "", 0, false
+			}
+			lines = n
+			args = args[2:]
+		case len(args) == 1 && !strings.HasPrefix(args[0], "-"):
+			return args[0], lines, true
+		default:
+			return "", 0, false
+		}
+	}
+	return "", 0, false
+}
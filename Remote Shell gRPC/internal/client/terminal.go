@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// terminalSize returns the size of the terminal attached to stdout, or
+// zeros when the client is not running on a terminal
+func terminalSize() (rows, cols uint32) {
+	fd := int(os.Stdout.Fd())
+	if !term.IsTerminal(fd) {
+		return 0, 0
+	}
+	w, h, err := term.GetSize(fd)
+	if err != nil || w <= 0 || h <= 0 {
+		return 0, 0
+	}
+	return uint32(h), uint32(w)
+}
+
+// untilKeypress returns a context that is cancelled when a key is pressed.
+// The terminal is in raw mode until stop is called, so output needs "\r\n"
+// line endings.
+func untilKeypress(ctx context.Context) (context.Context, func(), error) {
+	// Read the terminal unbuffered; unlike stdin, a freshly opened /dev/tty
+	// supports read deadlines, which lets stop end the reader
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// tty.Fd would switch the file to blocking mode and disable deadlines
+	var fd int
+	rc, err := tty.SyscallConn()
+	if err == nil {
+		err = rc.Control(func(f uintptr) { fd = int(f) })
+	}
+	var oldState *term.State
+	if err == nil {
+		oldState, err = term.MakeRaw(fd)
+	}
+	if err != nil {
+		tty.Close()
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1)
+		if n, _ := tty.Read(buf); n > 0 {
+			cancel()
+		}
+	}()
+
+	stop := func() {
+		cancel()
+		tty.SetReadDeadline(time.Now())
+		<-done
+		term.Restore(fd, oldState)
+		tty.Close()
+		fmt.Print("\r\n")
+	}
+	return ctx, stop, nil
+}
+
+// Resize sends the current terminal size to the server
+func (c *Client) Resize(ctx context.Context) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	rows, cols := terminalSize()
+	if rows == 0 || cols == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	_, err := c.client.Resize(ctx, &pb.ResizeRequest{
+		SessionId: c.sessionID,
+		Rows:      rows,
+		Cols:      cols,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to resize: %w", err)
+	}
+	return nil
+}
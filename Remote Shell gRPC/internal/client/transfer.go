@@ -0,0 +1,392 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/files"
+	"remote-shell-rpc/pkg/format"
+)
+
+// An upload or download cut off by the connection dropping is resumed up
+// to transferRetries times, each after reconnecting. Reconnecting is tried
+// as often, waiting transferRetryDelay longer before each try.
+const (
+	transferRetries    = 3
+	transferRetryDelay = time.Second
+)
+
+// uploadSpec describes an upload
+type uploadSpec struct {
+	remotePath string
+	mode       os.FileMode
+	conflict   pb.UploadRequest_Conflict
+	// id lets the server keep the upload when it is interrupted, and
+	// offset is where it goes on
+	id     string
+	offset int64
+	// sum is the checksum of the whole file; when it is empty it is
+	// computed while sending, which needs the upload to start at zero
+	sum string
+}
+
+// Upload copies a local file to the server, keeping its permission bits
+// and replacing any file at remotePath, and returns the size of the file
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string) (int64, error) {
+	resp, err := c.UploadFile(ctx, localPath, remotePath, pb.UploadRequest_OVERWRITE)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Size, nil
+}
+
+// UploadFile copies a local file to the server, keeping its permission
+// bits; conflict decides what happens when remotePath exists, and the
+// response tells where the file was placed. The server checks the file
+// against its checksum before placing it. An interrupted upload goes on
+// from where the server stopped receiving, also when the same upload is
+// run again after a reconnect.
+func (c *Client) UploadFile(ctx context.Context, localPath, remotePath string, conflict pb.UploadRequest_Conflict) (*pb.UploadResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", localPath)
+	}
+
+	spec := uploadSpec{
+		remotePath: remotePath,
+		mode:       info.Mode().Perm(),
+		conflict:   conflict,
+		id:         transferID(localPath, remotePath, info),
+	}
+	for retries := 0; ; retries++ {
+		spec.offset = c.uploadOffset(ctx, spec.id, remotePath)
+		if spec.offset > 0 {
+			c.logger.Info("Resuming upload", "path", remotePath, "offset", spec.offset)
+			if spec.sum == "" {
+				if spec.sum, err = files.Checksum(localPath); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if _, err := f.Seek(spec.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		resp, err := c.upload(ctx, f, spec)
+		if err == nil {
+			return resp, nil
+		}
+		if status.Code(err) != codes.Unavailable || retries == transferRetries || c.reconnectForTransfer(ctx, err) != nil {
+			return nil, err
+		}
+	}
+}
+
+// UploadFrom writes everything read from r to a file on the server with
+// the given permission bits, and returns the number of bytes sent. It
+// starts over when interrupted.
+func (c *Client) UploadFrom(ctx context.Context, r io.Reader, remotePath string, mode os.FileMode) (int64, error) {
+	resp, err := c.upload(ctx, r, uploadSpec{remotePath: remotePath, mode: mode})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Size, nil
+}
+
+// transferID names an upload so that the server can resume it: the same
+// file, unchanged, uploaded to the same destination gets the same ID
+func transferID(localPath, remotePath string, info os.FileInfo) string {
+	if abs, err := filepath.Abs(localPath); err == nil {
+		localPath = abs
+	}
+	h := sha256.New()
+	for _, s := range []string{localPath, remotePath, strconv.FormatInt(info.Size(), 10), strconv.FormatInt(info.ModTime().UnixNano(), 10)} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// uploadOffset returns where an interrupted upload goes on, zero when the
+// server kept none of it or cannot resume uploads
+func (c *Client) uploadOffset(ctx context.Context, id, remotePath string) int64 {
+	if c.sessionID == "" {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+	resp, err := c.client.GetUploadOffset(ctx, &pb.GetUploadOffsetRequest{
+		SessionId:  c.sessionID,
+		TransferId: id,
+		Path:       remotePath,
+	})
+	if err != nil {
+		return 0
+	}
+	return resp.Offset
+}
+
+// upload sends what is read from r as the part of the file starting at
+// the spec's offset, followed by the checksum of the whole file
+func (c *Client) upload(ctx context.Context, r io.Reader, spec uploadSpec) (*pb.UploadResponse, error) {
+	if c.sessionID == "" {
+		return nil, fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.Upload(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start upload: %w", err)
+	}
+
+	var h hash.Hash
+	if spec.sum == "" && spec.offset == 0 {
+		h = sha256.New()
+		r = io.TeeReader(r, h)
+	}
+
+	offset := spec.offset
+	req := &pb.UploadRequest{
+		SessionId:  c.sessionID,
+		Path:       spec.remotePath,
+		Mode:       uint32(spec.mode.Perm()),
+		Conflict:   spec.conflict,
+		TransferId: spec.id,
+		Offset:     offset,
+	}
+	buf := make([]byte, files.ChunkSize)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 || req.Path != "" {
+			req.Data = buf[:n]
+			if err := stream.Send(req); err != nil {
+				// The server's reason is returned by CloseAndRecv
+				req = nil
+				break
+			}
+			offset += int64(n)
+			req = &pb.UploadRequest{Offset: offset}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			stream.CloseSend()
+			return nil, readErr
+		}
+	}
+
+	// The checksum follows the data so that it can be computed on the way
+	if req != nil {
+		req.Sha256 = spec.sum
+		if h != nil {
+			req.Sha256 = hex.EncodeToString(h.Sum(nil))
+		}
+		stream.Send(req)
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %w", err)
+	}
+	return resp, nil
+}
+
+// Download copies a remote file to a local path, keeping its permission
+// bits, and returns the number of bytes received. The local file is only
+// replaced once the download is complete. A download cut off by the
+// connection dropping goes on from the last byte received, provided the
+// remote file did not change in the meantime.
+func (c *Client) Download(ctx context.Context, remotePath, localPath string) (int64, error) {
+	var w *files.Writer
+	defer func() {
+		if w != nil {
+			w.Abort()
+		}
+	}()
+
+	// first is the first chunk of the first try, which resumed tries are
+	// checked against
+	var first *pb.FileChunk
+	size := int64(0)
+	receive := func(chunk *pb.FileChunk, start bool) error {
+		switch {
+		case start && first == nil:
+			// The first chunk carries the mode the file is created with
+			first = chunk
+			var err error
+			if w, err = files.Create(localPath, os.FileMode(chunk.Mode)); err != nil {
+				return err
+			}
+		case start && chunk.Offset != size:
+			return fmt.Errorf("download of %s was interrupted and the server cannot resume it", remotePath)
+		case start && (chunk.Size != first.Size || chunk.ModTimeUnixMs != first.ModTimeUnixMs):
+			return fmt.Errorf("%s changed on the server while it was being downloaded", remotePath)
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return err
+		}
+		size += int64(len(chunk.Data))
+		return nil
+	}
+
+	for retries := 0; ; retries++ {
+		if size > 0 {
+			c.logger.Info("Resuming download", "path", remotePath, "offset", size)
+		}
+		err := c.download(ctx, remotePath, size, receive)
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.Unavailable || retries == transferRetries || c.reconnectForTransfer(ctx, err) != nil {
+			return 0, err
+		}
+	}
+	if w == nil {
+		return 0, fmt.Errorf("download failed: no data received")
+	}
+
+	if err := w.Commit(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// download streams a remote file from offset to receive, telling it which
+// chunk is the first of the stream
+func (c *Client) download(ctx context.Context, remotePath string, offset int64, receive func(chunk *pb.FileChunk, start bool) error) error {
+	if c.sessionID == "" {
+		return fmt.Errorf("no active session")
+	}
+
+	stream, err := c.client.Download(ctx, &pb.DownloadRequest{
+		SessionId: c.sessionID,
+		Path:      remotePath,
+		Offset:    offset,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start download: %w", err)
+	}
+
+	for start := true; ; start = false {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("download failed: %w", err)
+		}
+		if err := receive(chunk, start); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnectForTransfer reconnects after a transfer was cut off, returning
+// the transfer's error when the server stays out of reach
+func (c *Client) reconnectForTransfer(ctx context.Context, cause error) error {
+	c.logger.Warn("Transfer interrupted, reconnecting", "error", cause)
+	for attempt := 1; attempt <= transferRetries; attempt++ {
+		select {
+		case <-time.After(time.Duration(attempt) * transferRetryDelay):
+		case <-ctx.Done():
+			return cause
+		}
+		err := c.Reconnect(ctx)
+		if err == nil {
+			return nil
+		}
+		c.logger.Info("Reconnect failed", "attempt", attempt, "error", err)
+	}
+	return cause
+}
+
+// handleUpload implements "upload [-conflict policy] <local> [remote]"
+func (s *Shell) handleUpload(ctx context.Context, args []string) error {
+	usage := fmt.Errorf("usage: upload [-conflict overwrite|fail|rename] <local-path> [remote-path]")
+	flags := flag.NewFlagSet("upload", flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	policy := flags.String("conflict", "overwrite", "What to do when the remote file exists: overwrite, fail or rename (keep it and upload to <path>.1)")
+	if err := flags.Parse(args); err != nil {
+		return usage
+	}
+	args = flags.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return usage
+	}
+	conflict, ok := pb.UploadRequest_Conflict_value[strings.ToUpper(*policy)]
+	if !ok {
+		return fmt.Errorf("unknown conflict policy %q (want overwrite, fail or rename)", *policy)
+	}
+
+	remote := filepath.Base(args[0])
+	if len(args) == 2 {
+		remote = args[1]
+		if strings.HasSuffix(remote, "/") {
+			remote += filepath.Base(args[0])
+		}
+	}
+
+	resp, err := s.client.UploadFile(ctx, args[0], remote, pb.UploadRequest_Conflict(conflict))
+	if status.Code(err) == codes.Unavailable {
+		return fmt.Errorf("%w (use 'reconnect', then run the upload again to resume it)", err)
+	}
+	if err != nil {
+		return err
+	}
+	// With rename the file may have gone elsewhere
+	if path.Base(resp.Path) != path.Base(remote) {
+		remote = resp.Path
+	}
+	fmt.Printf("Uploaded %s to %s (%s)\n", args[0], remote, format.Bytes(resp.Size))
+	return nil
+}
+
+// handleDownload implements "download <remote> [local]"
+func (s *Shell) handleDownload(ctx context.Context, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: download <remote-path> [local-path]")
+	}
+
+	local := path.Base(args[0])
+	if len(args) == 2 {
+		local = args[1]
+		if info, err := os.Stat(local); err == nil && info.IsDir() {
+			local = filepath.Join(local, path.Base(args[0]))
+		}
+	}
+
+	size, err := s.client.Download(ctx, args[0], local)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %s to %s (%s)\n", args[0], local, format.Bytes(size))
+	return nil
+}
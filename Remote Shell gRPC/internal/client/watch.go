@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// minWatchInterval keeps watch from hammering the server
+const minWatchInterval = 100 * time.Millisecond
+
+// handleWatch runs a remote command repeatedly and redraws its output,
+// like watch(1), until a key is pressed
+func (s *Shell) handleWatch(ctx context.Context, input string) error {
+	fields := strings.Fields(input)
+	if len(fields) < 3 {
+		return fmt.Errorf("usage: watch <interval> <command>")
+	}
+
+	interval, err := parseInterval(fields[1])
+	if err != nil {
+		return err
+	}
+	// Keep the command exactly as typed
+	command := strings.TrimSpace(input[strings.Index(input, fields[1])+len(fields[1]):])
+
+	ctx, stop, err := untilKeypress(ctx)
+	if err != nil {
+		return fmt.Errorf("watch needs an interactive terminal: %w", err)
+	}
+	defer stop()
+
+	for {
+		var out bytes.Buffer
+		err := s.client.ExecuteCommandStream(ctx, command, serverTimeout, func(output *pb.CommandOutput) {
+			switch {
+			case output.IsComplete:
+				if output.ExitCode != 0 {
+					fmt.Fprintf(&out, "[Exit code: %d]\n", output.ExitCode)
+				}
+			case isBinary(output) && !s.config.RawOutput:
+				out.WriteString("[binary output suppressed]\n")
+			default:
+				out.Write(output.Data)
+			}
+		})
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Raw mode disables output post-processing, so add the carriage
+		// returns ourselves
+		fmt.Print("\033[2J\033[H")
+		fmt.Printf("Every %s: %s    %s\r\n\r\n", interval, command, time.Now().Format("15:04:05"))
+		fmt.Print(strings.ReplaceAll(out.String(), "\n", "\r\n"))
+		if err != nil {
+			fmt.Printf("Error: %v\r\n", err)
+		}
+		fmt.Print("\r\n[press any key to stop]")
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+			continue
+		}
+		break
+	}
+
+	return nil
+}
+
+// parseInterval accepts a duration ("500ms", "2s") or plain seconds ("2")
+func parseInterval(s string) (time.Duration, error) {
+	interval, err := time.ParseDuration(s)
+	if err != nil {
+		seconds, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		interval = time.Duration(seconds * float64(time.Second))
+	}
+	if interval < minWatchInterval {
+		return 0, fmt.Errorf("interval must be at least %s", minWatchInterval)
+	}
+	return interval, nil
+}
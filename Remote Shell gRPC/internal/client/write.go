@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"remote-shell-rpc/pkg/format"
+)
+
+// writeMode is the permission bits of files created with write
+const writeMode = 0644
+
+// handleWrite implements "write <remote-path> <<EOF": the lines typed after
+// it, up to one holding only the delimiter, become the contents of the
+// remote file. {{name}} variables in them are filled in unless the
+// delimiter is quoted, as in <<'EOF'. Other forms of write, such as
+// write(1) messaging a user, run as a remote command.
+func (s *Shell) handleWrite(ctx context.Context, input string, args []string) error {
+	if len(args) == 3 && args[1] == "<<" {
+		args = []string{args[0], "<<" + args[2]}
+	}
+	if len(args) != 2 || !strings.HasPrefix(args[1], "<<") {
+		return s.executeRemoteCommand(ctx, input)
+	}
+	if s.scriptDepth > 0 {
+		return fmt.Errorf("write can only read its contents at the prompt; use upload in scripts")
+	}
+
+	delimiter := strings.TrimPrefix(args[1], "<<")
+	quoted := false
+	if len(delimiter) >= 2 && (delimiter[0] == '\'' || delimiter[0] == '"') && delimiter[len(delimiter)-1] == delimiter[0] {
+		delimiter = delimiter[1 : len(delimiter)-1]
+		quoted = true
+	}
+	if delimiter == "" {
+		return fmt.Errorf("usage: write <remote-path> <<EOF")
+	}
+
+	var contents strings.Builder
+	for {
+		fmt.Print("> ")
+		line, err := s.reader.ReadString('\n')
+		if strings.TrimRight(line, "\r\n") == delimiter {
+			break
+		}
+		if err == io.EOF {
+			return fmt.Errorf("input ended before %s; nothing was written", delimiter)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		contents.WriteString(strings.TrimRight(line, "\r\n"))
+		contents.WriteByte('\n')
+	}
+
+	text := contents.String()
+	if !quoted {
+		text = s.expandVars(text)
+	}
+	size, err := s.client.UploadFrom(ctx, strings.NewReader(text), args[0], writeMode)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%s)\n", args[0], format.Bytes(size))
+	return nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// checkACME verifies the ACME settings without contacting the CA
+func checkACME(cfg Config) error {
+	if len(cfg.ACMEDomains) == 0 {
+		return fmt.Errorf("acme requires at least one domain")
+	}
+	if cfg.ACMECacheDir == "" {
+		return fmt.Errorf("acme requires a cache directory")
+	}
+	// The cache directory is created on first use, its parent must exist
+	dir := cfg.ACMECacheDir
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		dir = filepath.Dir(dir)
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// usesACME reports whether any listener gets its certificate from ACME
+func (c Config) usesACME() bool {
+	for _, lc := range c.listenerConfigs() {
+		if lc.TLS.ACME {
+			return true
+		}
+	}
+	return false
+}
+
+// acmeManager returns the manager that obtains and renews certificates
+// from the ACME CA, creating it on first use
+func (s *Server) acmeManager() (*autocert.Manager, error) {
+	if s.acme != nil {
+		return s.acme, nil
+	}
+	if err := checkACME(s.config); err != nil {
+		return nil, err
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(s.config.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(s.config.ACMEDomains...),
+		Email:      s.config.ACMEEmail,
+	}
+	// An internal CA that speaks ACME is used instead of Let's Encrypt
+	if s.config.ACMEDirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: s.config.ACMEDirectoryURL}
+	}
+	s.acme = m
+	return m, nil
+}
+
+// startACME serves HTTP-01 challenges when configured and requests the
+// certificates of all domains in the background, so that problems show up
+// in the log at startup rather than at the first client's handshake.
+// Certificates are renewed automatically before they expire.
+func (s *Server) startACME() {
+	if s.acme == nil {
+		return
+	}
+
+	if s.config.ACMEHTTPAddress != "" {
+		s.acmeHTTP = &http.Server{
+			Addr:              s.config.ACMEHTTPAddress,
+			Handler:           s.acme.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := s.acmeHTTP.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("ACME challenge server failed", "address", s.config.ACMEHTTPAddress, "error", err.Error())
+			}
+		}()
+	}
+
+	for _, domain := range s.config.ACMEDomains {
+		go func(domain string) {
+			// Asks for the ECDSA certificate that gRPC clients are served
+			hello := &tls.ClientHelloInfo{
+				ServerName:   domain,
+				CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			}
+			if _, err := s.acme.GetCertificate(hello); err != nil {
+				s.logger.Error("Failed to obtain certificate", "domain", domain, "error", err.Error())
+				return
+			}
+			s.logger.Info("Certificate ready", "domain", domain)
+		}(domain)
+	}
+}
+
+// stopACME stops the HTTP-01 challenge server
+func (s *Server) stopACME() {
+	if s.acmeHTTP != nil {
+		s.acmeHTTP.Close()
+	}
+}
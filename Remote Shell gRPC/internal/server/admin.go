@@ -0,0 +1,423 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/approval"
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/executor"
+)
+
+// AdminServer implements the operator-only AdminService
+type AdminServer struct {
+	pb.UnimplementedAdminServiceServer
+	server *Server
+}
+
+// NewAdminServer creates an AdminServer backed by the given shell server
+func NewAdminServer(s *Server) *AdminServer {
+	return &AdminServer{server: s}
+}
+
+// QueryAudit returns recorded commands matching the given filters
+func (a *AdminServer) QueryAudit(ctx context.Context, req *pb.QueryAuditRequest) (*pb.QueryAuditResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	querier, ok := a.server.audit.(audit.Querier)
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "audit storage is not configured")
+	}
+
+	q := audit.Query{
+		SessionID: req.SessionId,
+		ClientID:  req.ClientId,
+		Severity:  req.Severity,
+		Limit:     int(req.Limit),
+	}
+	if req.SinceUnixMs > 0 {
+		q.Since = time.UnixMilli(req.SinceUnixMs)
+	}
+	if req.UntilUnixMs > 0 {
+		q.Until = time.UnixMilli(req.UntilUnixMs)
+	}
+
+	records, err := querier.QueryCommands(ctx, q)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to query audit records: %v", err)
+	}
+
+	resp := &pb.QueryAuditResponse{
+		Records: make([]*pb.AuditRecord, 0, len(records)),
+	}
+	for _, rec := range records {
+		resp.Records = append(resp.Records, &pb.AuditRecord{
+			SessionId:       rec.SessionID,
+			ClientId:        rec.ClientID,
+			Command:         rec.Command,
+			ExitCode:        int32(rec.ExitCode),
+			Error:           rec.Error,
+			StartedAtUnixMs: rec.StartedAt.UnixMilli(),
+			ExecutionTimeMs: rec.Duration.Milliseconds(),
+			Severity:        rec.Severity,
+			Decision:        rec.Decision,
+			Approver:        rec.Approver,
+		})
+	}
+	return resp, nil
+}
+
+// ListApprovals returns commands waiting for administrator approval
+func (a *AdminServer) ListApprovals(ctx context.Context, req *pb.ListApprovalsRequest) (*pb.ListApprovalsResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	pending := a.server.approvals.List()
+	resp := &pb.ListApprovalsResponse{
+		Approvals: make([]*pb.ApprovalRequest, 0, len(pending)),
+	}
+	for _, r := range pending {
+		resp.Approvals = append(resp.Approvals, &pb.ApprovalRequest{
+			Id:                r.ID,
+			SessionId:         r.SessionID,
+			ClientId:          r.ClientID,
+			Command:           r.Command,
+			RequestedAtUnixMs: r.RequestedAt.UnixMilli(),
+		})
+	}
+	return resp, nil
+}
+
+// DecideApproval approves or denies a pending command. The approver is
+// the identity the call authenticates with, which may not be the one that
+// ran the command; the name it gives is only kept as a note.
+func (a *AdminServer) DecideApproval(ctx context.Context, req *pb.DecideApprovalRequest) (*pb.DecideApprovalResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	pending, err := a.server.approvals.Get(req.Id)
+	if err == approval.ErrNotFound {
+		return nil, status.Error(codes.NotFound, "approval request not found")
+	}
+	approver := identity(ctx)
+	if approver == pending.Requester {
+		a.server.logger.Warn("Approval decision by the requester refused",
+			"approval_id", req.Id,
+			"identity", approver,
+		)
+		return nil, status.Error(codes.PermissionDenied, "commands must be decided by someone other than the client that ran them")
+	}
+	if note := cleanClientField(req.Approver); note != "" {
+		approver += " (" + note + ")"
+	}
+
+	decision, err := a.server.approvals.Decide(req.Id, req.Approve, approver, req.Reason)
+	if err != nil {
+		if err == approval.ErrNotFound {
+			return nil, status.Error(codes.NotFound, "approval request not found")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to decide approval: %v", err)
+	}
+
+	return &pb.DecideApprovalResponse{
+		Success: true,
+		Message: fmt.Sprintf("command %s", decision.State),
+	}, nil
+}
+
+// DrainNode moves every session to another server
+func (a *AdminServer) DrainNode(ctx context.Context, req *pb.DrainNodeRequest) (*pb.DrainNodeResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.TargetAddress == "" {
+		return nil, status.Error(codes.InvalidArgument, "target_address is required")
+	}
+
+	creds, err := drainCredentials(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	migrated, failed, err := a.server.drain(ctx, req.TargetAddress, req.TargetToken, creds)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to drain: %v", err)
+	}
+
+	return &pb.DrainNodeResponse{
+		Migrated: int32(migrated),
+		Failed:   failed,
+	}, nil
+}
+
+// UndrainNode makes a drained node accept new sessions again
+func (a *AdminServer) UndrainNode(ctx context.Context, req *pb.UndrainNodeRequest) (*pb.UndrainNodeResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	target := a.server.undrain()
+	a.server.logger.Info("Drain ended", "target", target)
+	return &pb.UndrainNodeResponse{PreviousTarget: target}, nil
+}
+
+// ImportSession recreates a session moved from a draining server
+func (a *AdminServer) ImportSession(ctx context.Context, req *pb.ImportSessionRequest) (*pb.ImportSessionResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.Session == nil || req.Session.SessionId == "" || req.Session.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session with session_id and client_id is required")
+	}
+
+	sess, err := a.server.importSession(req.Session)
+	if err != nil {
+		return nil, err
+	}
+
+	a.server.logger.Info("Session imported",
+		"session_id", sess.ID,
+		"client_id", sess.ClientID,
+		"working_dir", sess.GetWorkingDir(),
+	)
+	return &pb.ImportSessionResponse{Success: true}, nil
+}
+
+// SetMaintenance turns maintenance mode on or off
+func (a *AdminServer) SetMaintenance(ctx context.Context, req *pb.SetMaintenanceRequest) (*pb.SetMaintenanceResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	m := a.server.setMaintenance(req.Enabled, req.ReadOnly, req.Message)
+	a.server.logger.Info("Maintenance mode changed",
+		"enabled", m.enabled,
+		"read_only", m.readOnly,
+		"message", m.message,
+	)
+
+	return &pb.SetMaintenanceResponse{
+		Enabled:  m.enabled,
+		ReadOnly: m.readOnly,
+		Message:  m.message,
+	}, nil
+}
+
+// SetBanner replaces the banner shown to clients at login
+func (a *AdminServer) SetBanner(ctx context.Context, req *pb.SetBannerRequest) (*pb.SetBannerResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	a.server.bannerMu.Lock()
+	a.server.banner = req.Banner
+	a.server.bannerMu.Unlock()
+
+	a.server.logger.Info("Banner changed", "length", len(req.Banner))
+	return &pb.SetBannerResponse{Success: true}, nil
+}
+
+// ListSessions returns every active session with its resource usage
+func (a *AdminServer) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	sessions := a.server.sessionManager.List()
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+
+	resp := &pb.ListSessionsResponse{
+		Sessions: make([]*pb.SessionInfo, 0, len(sessions)),
+	}
+	for _, sess := range sessions {
+		resp.Sessions = append(resp.Sessions, sessionInfo(sess))
+	}
+	return resp, nil
+}
+
+// GetServerHealth reports the server's own resource usage
+func (a *AdminServer) GetServerHealth(ctx context.Context, req *pb.GetServerHealthRequest) (*pb.GetServerHealthResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	h := a.server.checkHealth()
+	streams, _ := a.server.streams.Active("")
+	bans := a.server.bans.Stats()
+	resp := &pb.GetServerHealthResponse{
+		Goroutines:         int32(h.sample.Goroutines),
+		HeapBytes:          h.sample.HeapBytes,
+		OpenFiles:          int32(h.sample.OpenFDs),
+		Sessions:           int32(a.server.sessionManager.Count()),
+		ActiveStreams:      int32(streams),
+		Exceeded:           h.exceeded,
+		RefusingCommands:   len(h.exceeded) > 0 && a.server.config.RefuseWhenOverloaded,
+		FailedAuthAttempts: bans.Failures,
+		BansIssued:         bans.Bans,
+		BannedPeers:        int32(bans.Banned),
+		Methods:            a.server.metrics.snapshot(),
+		Panics:             a.server.metrics.panicCount(),
+	}
+	a.server.capacityStats(resp)
+	return resp, nil
+}
+
+// SetSessionPriority changes the priority of a session's later commands
+func (a *AdminServer) SetSessionPriority(ctx context.Context, req *pb.SetSessionPriorityRequest) (*pb.SetSessionPriorityResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	priority := executor.Priority{
+		Nice:    int(req.Nice),
+		IOClass: req.IoClass,
+		IOLevel: int(req.IoLevel),
+	}
+	if err := priority.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	sess, err := a.server.findSession(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	sess.Executor.SetPriority(priority)
+
+	a.server.logger.Info("Session priority changed",
+		"session_id", sess.ID,
+		"priority", priority.String(),
+	)
+	return &pb.SetSessionPriorityResponse{Session: sessionInfo(sess)}, nil
+}
+
+// ListBans returns the hosts banned for failed authentication attempts
+func (a *AdminServer) ListBans(ctx context.Context, req *pb.ListBansRequest) (*pb.ListBansResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	bans := a.server.bans.List()
+	resp := &pb.ListBansResponse{
+		Bans: make([]*pb.BanInfo, 0, len(bans)),
+	}
+	for _, b := range bans {
+		resp.Bans = append(resp.Bans, &pb.BanInfo{
+			Peer:        b.Peer,
+			UntilUnixMs: b.Until.UnixMilli(),
+			Count:       int32(b.Count),
+		})
+	}
+	return resp, nil
+}
+
+// ClearBan lifts the ban of a host, or of every host
+func (a *AdminServer) ClearBan(ctx context.Context, req *pb.ClearBanRequest) (*pb.ClearBanResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.Peer == "" && !req.All {
+		return nil, status.Error(codes.InvalidArgument, "peer is required unless all is set")
+	}
+
+	peer := req.Peer
+	if req.All {
+		peer = ""
+	}
+	cleared := a.server.bans.Clear(peer)
+
+	a.server.logger.Info("Bans cleared",
+		"peer", req.Peer,
+		"all", req.All,
+		"cleared", cleared,
+	)
+	return &pb.ClearBanResponse{Cleared: int32(cleared)}, nil
+}
+
+// WatchSession streams a session's commands and output to an operator.
+// The session's participants are told when the watch starts and ends.
+func (a *AdminServer) WatchSession(req *pb.WatchSessionRequest, stream pb.AdminService_WatchSessionServer) error {
+	ctx := stream.Context()
+	if err := a.authorize(ctx); err != nil {
+		return err
+	}
+	if req.SessionId == "" {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	sess, err := a.server.findSession(req.SessionId)
+	if err != nil {
+		return err
+	}
+
+	watcher := cleanClientField(req.Name)
+	if watcher == "" {
+		watcher = "an administrator"
+	}
+
+	sub := sess.Mirror().Subscribe(attachBuffer)
+	defer sub.Close()
+
+	a.server.logger.Warn("Admin watching session",
+		"session_id", sess.ID,
+		"client_id", sess.ClientID,
+		"watcher", watcher,
+		"client_addr", peerAddr(ctx),
+	)
+	notify(sess, fmt.Sprintf("This session is being watched by %s", watcher))
+	defer func() {
+		notify(sess, fmt.Sprintf("%s stopped watching this session", watcher))
+		a.server.logger.Info("Admin stopped watching session", "session_id", sess.ID, "watcher", watcher)
+	}()
+
+	err = stream.Send(&pb.SessionEvent{
+		Type:        pb.SessionEvent_ATTACHED,
+		Participant: watcher,
+		SessionId:   sess.ID,
+		WorkingDir:  sess.GetWorkingDir(),
+		TimeUnixMs:  time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return relayEvents(ctx, sess, sub, stream, func() error { return nil })
+}
+
+// authorize checks the admin token sent as "authorization: Bearer <token>"
+func (a *AdminServer) authorize(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		a.server.authFailed(ctx)
+		return status.Error(codes.Unauthenticated, "missing admin token")
+	}
+
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.server.config.AdminToken)) == 1 {
+			return nil
+		}
+	}
+	a.server.authFailed(ctx)
+	return status.Error(codes.Unauthenticated, "invalid admin token")
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/approval"
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/session"
+)
+
+// compilePatterns compiles the configured patterns, logging and skipping
+// invalid ones
+func (s *Server) compilePatterns(name string, patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			s.logger.Error("Ignoring invalid pattern", "setting", name, "pattern", pattern, "error", err.Error())
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// requiresApproval reports whether a command is restricted and must be
+// approved by an administrator before it runs
+func (s *Server) requiresApproval(command string) bool {
+	for _, re := range s.approvalPatterns {
+		if re.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitApproval submits a restricted command and blocks until an
+// administrator decides on it. notify, if set, is called once the request
+// is pending so that the client can be told what it is waiting for.
+func (s *Server) awaitApproval(ctx context.Context, sess *session.Session, command string, notify func(approval.Request) error) error {
+	req, err := s.approvals.Submit(sess.ID, sess.ClientID, identity(ctx), command)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to submit approval request: %v", err)
+	}
+
+	s.logger.Info("Command awaiting approval",
+		"approval_id", req.ID,
+		"session_id", sess.ID,
+		"command", command,
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.ApprovalTimeout)
+	defer cancel()
+
+	if notify != nil {
+		if err := notify(req); err != nil {
+			s.approvals.Decide(req.ID, false, "", "client went away")
+			s.auditRefusal(sess, command, audit.DecisionDenied, "", err)
+			return err
+		}
+	}
+
+	decision, err := s.approvals.Wait(ctx, req.ID)
+	if err != nil {
+		s.logger.Info("Approval request abandoned",
+			"approval_id", req.ID,
+			"session_id", sess.ID,
+			"error", err.Error(),
+		)
+		if err == context.DeadlineExceeded {
+			err = status.Error(codes.DeadlineExceeded, "timed out waiting for approval")
+		} else {
+			err = status.FromContextError(err).Err()
+		}
+		s.auditRefusal(sess, command, audit.DecisionDenied, "", err)
+		return err
+	}
+
+	s.logger.Warn("Approval decided",
+		"approval_id", req.ID,
+		"session_id", sess.ID,
+		"command", command,
+		"state", string(decision.State),
+		"approver", decision.Approver,
+	)
+
+	if decision.State != approval.StateApproved {
+		msg := fmt.Sprintf("command denied by %s", decision.Approver)
+		if decision.Reason != "" {
+			msg += ": " + decision.Reason
+		}
+		err := status.Error(codes.PermissionDenied, msg)
+		s.auditRefusal(sess, command, audit.DecisionDenied, decision.Approver, err)
+		return err
+	}
+
+	s.approvedMu.Lock()
+	s.approvedBy[sess.ID+"\x00"+command] = decision.Approver
+	s.approvedMu.Unlock()
+	return nil
+}
+
+// awaitApprovalStream makes a restricted command wait for an administrator
+// on a streaming RPC, telling the client what it is waiting for and when
+// the command starts. Other commands pass straight through.
+func (s *Server) awaitApprovalStream(sess *session.Session, command string, out outputStream) error {
+	if !s.requiresApproval(command) {
+		return nil
+	}
+	notify := func(r approval.Request) error {
+		const message = "command requires administrator approval"
+		return out.Send(&pb.CommandOutput{
+			Approval: &pb.ApprovalNotice{
+				ApprovalId: r.ID,
+				Message:    message,
+			},
+			State: &pb.CommandState{
+				State:   pb.CommandState_PENDING_APPROVAL,
+				Message: message,
+			},
+		})
+	}
+	if err := s.awaitApproval(out.Context(), sess, command, notify); err != nil {
+		return err
+	}
+	return out.Send(&pb.CommandOutput{State: &pb.CommandState{State: pb.CommandState_RUNNING}})
+}
+
+// takeApprover returns who approved a command that is now running, if it
+// needed approval
+func (s *Server) takeApprover(sess *session.Session, command string) (string, bool) {
+	key := sess.ID + "\x00" + command
+	s.approvedMu.Lock()
+	defer s.approvedMu.Unlock()
+	approver, ok := s.approvedBy[key]
+	delete(s.approvedBy, key)
+	return approver, ok
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// adminContext is the context of a call sending the admin token
+func adminContext(ip string) context.Context {
+	return metadata.NewIncomingContext(peerContext(ip), metadata.Pairs("authorization", "Bearer admin-secret"))
+}
+
+// restrictedCommand runs a command that needs approval as the client ctx
+// identifies, in the background
+func restrictedCommand(t *testing.T, s *Server, ctx context.Context) <-chan error {
+	t.Helper()
+	sessionID := createSession(t, s, ctx, "client1")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.ExecuteCommand(ctx, &pb.CommandRequest{SessionId: sessionID, Command: "echo restricted"})
+		done <- err
+	}()
+	return done
+}
+
+func newApprovalServer(t *testing.T) *Server {
+	return newTestServer(t, func(cfg *Config) {
+		cfg.AdminToken = "admin-secret"
+		cfg.ApprovalPatterns = []string{"^echo restricted"}
+	})
+}
+
+func TestDecideApproval_Approver(t *testing.T) {
+	s := newApprovalServer(t)
+	done := restrictedCommand(t, s, peerContext("192.0.2.1"))
+	id := pendingApproval(t, s)
+
+	adminCtx := adminContext("192.0.2.9")
+	_, err := NewAdminServer(s).DecideApproval(adminCtx, &pb.DecideApprovalRequest{
+		Id:       id,
+		Approver: "alice",
+		Reason:   "not now",
+	})
+	if err != nil {
+		t.Fatalf("DecideApproval() error = %v", err)
+	}
+
+	// The denial names the authenticated admin, with the name only as a note
+	err = <-done
+	want := "command denied by " + identity(adminCtx) + " (alice): not now"
+	if status.Code(err) != codes.PermissionDenied || !strings.Contains(err.Error(), want) {
+		t.Errorf("ExecuteCommand() error = %v, want PermissionDenied with %q", err, want)
+	}
+}
+
+func TestDecideApproval_OwnCommand(t *testing.T) {
+	s := newApprovalServer(t)
+
+	// A client holding the admin token runs the command itself
+	ctx, cancel := context.WithCancel(adminContext("192.0.2.1"))
+	defer cancel()
+	done := restrictedCommand(t, s, ctx)
+	id := pendingApproval(t, s)
+
+	_, err := NewAdminServer(s).DecideApproval(adminContext("192.0.2.9"), &pb.DecideApprovalRequest{
+		Id:       id,
+		Approve:  true,
+		Approver: "someone else",
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DecideApproval() of the requester's own command error = %v, want PermissionDenied", err)
+	}
+
+	cancel()
+	if err := <-done; status.Code(err) != codes.Canceled {
+		t.Errorf("ExecuteCommand() error = %v, want the command still pending until cancelled", err)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// banKey returns what the caller's failed attempts count against: the
+// identity it logged in with or its verified client certificate, else its
+// host. Unix socket and relay connections have no host to ban, since all
+// local clients share the socket and every tunnel has a new address;
+// their key is empty unless they authenticated.
+func banKey(ctx context.Context) string {
+	if id, ok := ctx.Value(identityKey{}).(string); ok {
+		return id
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if subject, ok := certSubject(p); ok {
+		return "cert:" + subject
+	}
+	switch p.Addr.Network() {
+	case "tcp", "tcp4", "tcp6":
+		return peerHost(p.Addr.String())
+	}
+	return ""
+}
+
+// checkBanned rejects requests from a host or identity banned for failing
+// to authenticate too often
+func (s *Server) checkBanned(ctx context.Context) error {
+	key := banKey(ctx)
+	if key == "" {
+		return nil
+	}
+	until, banned := s.bans.Banned(key)
+	if !banned {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied,
+		"too many failed attempts; banned until %s", until.UTC().Format(time.RFC3339))
+}
+
+// authFailed records a failed authentication attempt by the caller, such
+// as a wrong admin token or a session of another client, and bans its
+// host or identity once it has failed too often
+func (s *Server) authFailed(ctx context.Context) {
+	key := banKey(ctx)
+	if key == "" {
+		return
+	}
+	if until, banned := s.bans.Fail(key); banned {
+		s.logger.Warn("Client banned after failed authentication attempts",
+			"client", key,
+			"until", until.UTC().Format(time.RFC3339),
+		)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// unixContext is the context of a request over the server's Unix socket
+func unixContext() context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.UnixAddr{Name: "@", Net: "unix"},
+	})
+}
+
+func TestAuthFailed_Bans(t *testing.T) {
+	relayToken := func(tunnel, token string) context.Context {
+		return metadata.NewIncomingContext(relayContext(tunnel), metadata.Pairs("authorization", "Bearer "+token))
+	}
+	login := func(ctx context.Context, id string) context.Context {
+		return context.WithValue(ctx, identityKey{}, id)
+	}
+
+	tests := []struct {
+		name   string
+		failed context.Context
+		// banned and allowed are other requests after the failures
+		banned  context.Context
+		allowed context.Context
+	}{
+		{"host", peerContext("192.0.2.1"), peerContext("192.0.2.1"), peerContext("192.0.2.2")},
+		{"cert", certContext("192.0.2.1", "alice"), certContext("192.0.2.2", "alice"), certContext("192.0.2.1", "bob")},
+		{"unix socket", unixContext(), nil, unixContext()},
+		{"unix socket login", login(unixContext(), "key:a"), login(unixContext(), "key:a"), unixContext()},
+		{"relay", relayToken("a1", "token-a"), nil, relayToken("a2", "token-b")},
+		{"relay login", login(relayContext("a1"), "key:a"), login(relayContext("a2"), "key:a"), relayToken("a3", "token-b")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, func(cfg *Config) { cfg.BanMaxFailures = 2 })
+			for i := 0; i < 3; i++ {
+				s.authFailed(tt.failed)
+			}
+
+			method := pb.ShellService_CreateSession_FullMethodName
+			if tt.banned != nil {
+				if _, err := s.authenticate(tt.banned, method); status.Code(err) != codes.PermissionDenied {
+					t.Errorf("authenticate() of the failing client error = %v, want PermissionDenied", err)
+				}
+			} else if _, err := s.authenticate(tt.failed, method); err != nil {
+				t.Errorf("authenticate() of the failing client error = %v, want no ban without a host", err)
+			}
+			if _, err := s.authenticate(tt.allowed, method); err != nil {
+				t.Errorf("authenticate() of another client error = %v", err)
+			}
+		})
+	}
+}
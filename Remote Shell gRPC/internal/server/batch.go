@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/session"
+)
+
+// maxBatchCommands bounds the work a single batch may ask for
+const maxBatchCommands = 100
+
+// ExecuteBatch runs a list of commands in order and returns their results
+func (s *Server) ExecuteBatch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if len(req.Commands) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "commands are required")
+	}
+	if len(req.Commands) > maxBatchCommands {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d commands are allowed", maxBatchCommands)
+	}
+	for i, command := range req.Commands {
+		if strings.TrimSpace(command) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "command %d is empty", i+1)
+		}
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.checkOverload(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp := &pb.BatchResponse{
+		Results: make([]*pb.BatchResult, 0, len(req.Commands)),
+	}
+	for i, command := range req.Commands {
+		// The client has gone, so nobody is waiting for the rest
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+
+		result := s.runBatchCommand(ctx, sess, req, command)
+		resp.Results = append(resp.Results, result)
+
+		failed := result.Rejected != "" || result.Response.ExitCode != 0
+		if failed && req.Policy == pb.BatchRequest_STOP_ON_FAILURE && i < len(req.Commands)-1 {
+			resp.Stopped = true
+			break
+		}
+	}
+	resp.ExecutionTimeMs = time.Since(start).Milliseconds()
+
+	s.logger.Debug("Batch executed",
+		"session_id", sess.ID,
+		"commands", len(resp.Results),
+		"stopped", resp.Stopped,
+	)
+	return resp, nil
+}
+
+// runBatchCommand runs one command of a batch the way ExecuteCommand would.
+// There is no one to answer a confirmation, so commands that need one are
+// refused.
+func (s *Server) runBatchCommand(ctx context.Context, sess *session.Session, batch *pb.BatchRequest, command string) *pb.BatchResult {
+	req := &pb.CommandRequest{
+		SessionId:      batch.SessionId,
+		Command:        command,
+		TimeoutSeconds: batch.TimeoutSeconds,
+		WorkingDir:     batch.WorkingDir,
+		Env:            batch.Env,
+	}
+	result := &pb.BatchResult{Command: command}
+
+	response, err := func() (*pb.CommandResponse, error) {
+		if err := s.checkStrict(sess, req.Command); err != nil {
+			return nil, err
+		}
+		if err := s.expandMacro(sess.ID, req); err != nil {
+			return nil, err
+		}
+		opts, err := commandOptions(sess, req)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.checkDangerous(sess, req.Command, false); err != nil {
+			return nil, err
+		}
+		if s.requiresApproval(req.Command) {
+			if err := s.awaitApproval(ctx, sess, req.Command, nil); err != nil {
+				return nil, err
+			}
+		}
+		return s.runCommand(ctx, sess, req, opts)
+	}()
+	if err != nil {
+		result.Rejected = status.Convert(err).Message()
+		return result
+	}
+
+	result.Response = response
+	return result
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// capacityState tracks a session limit changed at runtime
+type capacityState struct {
+	// configured is the limit the server started with
+	configured int
+	// until is when the configured limit comes back; zero when the
+	// change is not temporary
+	until time.Time
+	timer *time.Timer
+}
+
+// setMaxSessions changes the session limit, restoring the configured one
+// for n <= 0. A positive duration reverts the change once it has passed.
+// It returns the previous limit and when the configured one comes back.
+func (s *Server) setMaxSessions(n int, d time.Duration) (previous int, until time.Time) {
+	s.capacityMu.Lock()
+	defer s.capacityMu.Unlock()
+
+	if s.capacity.timer != nil {
+		s.capacity.timer.Stop()
+		s.capacity.timer = nil
+	}
+	s.capacity.until = time.Time{}
+	if n <= 0 {
+		n = s.capacity.configured
+	}
+	previous = s.sessionManager.SetMaxSessions(n)
+
+	if d > 0 && n != s.capacity.configured {
+		var timer *time.Timer
+		timer = time.AfterFunc(d, func() {
+			s.capacityMu.Lock()
+			defer s.capacityMu.Unlock()
+			// A later change replaced this one
+			if s.capacity.timer != timer {
+				return
+			}
+			s.sessionManager.SetMaxSessions(s.capacity.configured)
+			s.capacity.timer = nil
+			s.capacity.until = time.Time{}
+			s.logger.Info("Session limit restored", "max_sessions", s.capacity.configured)
+		})
+		s.capacity.timer = timer
+		s.capacity.until = time.Now().Add(d)
+	}
+	return previous, s.capacity.until
+}
+
+// SetMaxSessions changes how many sessions the server allows at once
+func (a *AdminServer) SetMaxSessions(ctx context.Context, req *pb.SetMaxSessionsRequest) (*pb.SetMaxSessionsResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.MaxSessions < 0 {
+		return nil, status.Error(codes.InvalidArgument, "max_sessions must not be negative")
+	}
+	if req.DurationMs < 0 {
+		return nil, status.Error(codes.InvalidArgument, "duration_ms must not be negative")
+	}
+
+	duration := time.Duration(req.DurationMs) * time.Millisecond
+	previous, until := a.server.setMaxSessions(int(req.MaxSessions), duration)
+	current := a.server.sessionManager.MaxSessions()
+	a.server.logger.Info("Session limit changed",
+		"max_sessions", current,
+		"previous", previous,
+		"duration", duration.String(),
+	)
+
+	resp := &pb.SetMaxSessionsResponse{
+		MaxSessions: int32(current),
+		Previous:    int32(previous),
+	}
+	if !until.IsZero() {
+		resp.UntilUnixMs = until.UnixMilli()
+	}
+	return resp, nil
+}
+
+// capacityStats describes how many sessions the server allows and who
+// holds them, for the health report
+func (s *Server) capacityStats(resp *pb.GetServerHealthResponse) {
+	s.capacityMu.Lock()
+	resp.ConfiguredMaxSessions = int32(s.capacity.configured)
+	if !s.capacity.until.IsZero() {
+		resp.MaxSessionsUntilUnixMs = s.capacity.until.UnixMilli()
+	}
+	s.capacityMu.Unlock()
+
+	resp.MaxSessions = int32(s.sessionManager.MaxSessions())
+	resp.SessionsRejected = s.sessionManager.Rejected()
+	for client, n := range s.sessionManager.CountByOwner() {
+		resp.Clients = append(resp.Clients, &pb.ClientSessions{Client: client, Sessions: int32(n)})
+	}
+	sort.Slice(resp.Clients, func(i, j int) bool {
+		if resp.Clients[i].Sessions != resp.Clients[j].Sessions {
+			return resp.Clients[i].Sessions > resp.Clients[j].Sessions
+		}
+		return resp.Clients[i].Client < resp.Clients[j].Client
+	})
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/files"
+	"remote-shell-rpc/pkg/session"
+)
+
+// Lines of captured output returned by default and at most
+const (
+	defaultTailLines = 20
+	maxTailLines     = 1000
+)
+
+// capturedOutput is the result of a command whose output went to a file
+type capturedOutput struct {
+	path     string
+	size     int64
+	tail     []byte
+	exitCode int
+	cpu      time.Duration
+	timedOut bool
+	cpuLimit bool
+}
+
+// errorText is the error recorded in the audit log for the command
+func (o capturedOutput) errorText() string {
+	if o.cpuLimit {
+		return executor.ErrCPULimitExceeded.Error()
+	}
+	return ""
+}
+
+// openOutputFile creates the file a command's output is captured in. A
+// name ending in "/" gets a new file in that directory.
+func openOutputFile(sess *session.Session, name string) (*os.File, error) {
+	path := sessionPath(sess, name)
+	var (
+		f   *os.File
+		err error
+	)
+	if strings.HasSuffix(name, "/") {
+		f, err = os.CreateTemp(path, "output-*.log")
+	} else {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	}
+	if err != nil {
+		return nil, fileError(name, err)
+	}
+	return f, nil
+}
+
+// runCaptured runs a command with its stdout and stderr written to the
+// requested file, and returns the tail of that file
+func (s *Server) runCaptured(ctx context.Context, sess *session.Session, req *pb.CommandRequest, opts executor.Options) (capturedOutput, error) {
+	lines := int(req.TailLines)
+	if lines <= 0 {
+		lines = defaultTailLines
+	}
+	if lines > maxTailLines {
+		lines = maxTailLines
+	}
+
+	f, err := openOutputFile(sess, req.OutputFile)
+	if err != nil {
+		return capturedOutput{}, err
+	}
+	defer f.Close()
+
+	// Full chunks keep the number of writes down; nothing is waiting for
+	// the output while it goes to the file
+	opts.FlushInterval = time.Hour
+
+	outputCh, err := sess.Executor.ExecuteStreamWithOptions(ctx, req.Command, opts)
+	if err != nil {
+		os.Remove(f.Name())
+		if err == executor.ErrEmptyCommand {
+			return capturedOutput{}, status.Error(codes.InvalidArgument, "empty command")
+		}
+		return capturedOutput{}, status.Errorf(codes.Internal, "failed to execute command: %v", err)
+	}
+
+	out := capturedOutput{path: f.Name(), exitCode: -1}
+	var writeErr error
+	for output := range outputCh {
+		if output.IsComplete {
+			out.exitCode = output.ExitCode
+			out.cpu = output.CPUTime
+			out.cpuLimit = output.CPULimitExceeded
+		}
+		// Keep draining after a failed write so the command is not
+		// blocked on a full pipe
+		if writeErr == nil && len(output.Data) > 0 {
+			_, writeErr = f.Write(output.Data)
+			out.size += int64(len(output.Data))
+		}
+		output.Release()
+	}
+	out.timedOut = ctx.Err() == context.DeadlineExceeded
+
+	if err := f.Close(); writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return out, status.Errorf(codes.Internal, "failed to write %s: %v", req.OutputFile, writeErr)
+	}
+
+	out.tail, err = files.Tail(out.path, lines)
+	if err != nil {
+		return out, fileError(req.OutputFile, err)
+	}
+
+	s.logger.Info("Command output saved",
+		"session_id", sess.ID,
+		"path", out.path,
+		"size", out.size,
+	)
+	return out, nil
+}
+
+// executeCaptured runs a command for ExecuteCommand with its output saved
+// to a file, returning the tail in place of the full output
+func (s *Server) executeCaptured(ctx context.Context, sess *session.Session, req *pb.CommandRequest, opts executor.Options) (*pb.CommandResponse, error) {
+	start := time.Now()
+	out, err := s.runCaptured(ctx, sess, req, opts)
+	if out.path != "" {
+		sess.RecordCommand(time.Since(start), out.cpu)
+		s.auditCommand(sess, req.Command, start, out.exitCode, out.errorText())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.CommandResponse{
+		ExitCode:        int32(out.exitCode),
+		ExecutionTimeMs: time.Since(start).Milliseconds(),
+		OutputFile:      out.path,
+		OutputBytes:     out.size,
+		WorkingDir:      sess.GetWorkingDir(),
+	}
+	if out.timedOut {
+		resp.Error = "command execution timeout"
+		resp.ExitCode = -1
+	}
+	if out.cpuLimit {
+		resp.Error = executor.ErrCPULimitExceeded.Error()
+	}
+	if tail, ok := s.output.text(out.tail); ok {
+		resp.Output = string(tail)
+	} else {
+		resp.Binary = true
+		resp.BinaryOutput = out.tail
+	}
+	sess.AddBytesStreamed(len(out.tail))
+	return resp, nil
+}
+
+// streamCaptured runs a command for ExecuteCommandStream with its output
+// saved to a file, sending only the tail followed by the completion message
+func (s *Server) streamCaptured(ctx context.Context, sess *session.Session, req *pb.CommandRequest, opts executor.Options, stream outputStream) error {
+	start := time.Now()
+	out, err := s.runCaptured(ctx, sess, req, opts)
+	if out.path != "" {
+		sess.RecordCommand(time.Since(start), out.cpu)
+		s.auditCommand(sess, req.Command, start, out.exitCode, out.errorText())
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(out.tail) > 0 {
+		data, ok := s.output.text(out.tail)
+		if err := stream.Send(&pb.CommandOutput{
+			Type:   pb.CommandOutput_STDOUT,
+			Data:   data,
+			Binary: !ok,
+		}); err != nil {
+			return err
+		}
+		sess.AddBytesStreamed(len(out.tail))
+	}
+
+	return stream.Send(&pb.CommandOutput{
+		IsComplete:       true,
+		ExitCode:         int32(out.exitCode),
+		OutputFile:       out.path,
+		OutputBytes:      out.size,
+		WorkingDir:       sess.GetWorkingDir(),
+		CpuLimitExceeded: out.cpuLimit,
+		ExecutionTimeMs:  time.Since(start).Milliseconds(),
+		StartedAtUnixMs:  start.UnixMilli(),
+		FinishedAtUnixMs: time.Now().UnixMilli(),
+	})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/netfilter"
+	"remote-shell-rpc/pkg/sshauth"
+	"remote-shell-rpc/pkg/systemd"
+	"remote-shell-rpc/pkg/tlsreload"
+)
+
+// redacted replaces secrets in the effective configuration
+const redacted = "<redacted>"
+
+// Check verifies, without starting the server, that it could start with
+// the configuration and serve commands: the shells and init script exist,
+// the patterns compile, the audit database can be created and the port is
+// free. It returns every problem found.
+func Check(cfg Config) []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if cfg.AgentMode && cfg.RelayAddress == "" {
+		fail("agent mode requires a relay address")
+	}
+	if _, err := netfilter.New(cfg.AllowedNetworks, cfg.DeniedNetworks); err != nil {
+		fail("%v", err)
+	}
+
+	shells := append([]string{cfg.Shell}, cfg.AllowedShells...)
+	for _, shell := range shells {
+		if _, err := exec.LookPath(shell); err != nil {
+			fail("shell %s: %v", shell, err)
+		}
+	}
+	if cfg.InitScript != "" {
+		if info, err := os.Stat(cfg.InitScript); err != nil {
+			fail("init script: %v", err)
+		} else if !info.Mode().IsRegular() {
+			fail("init script %s is not a regular file", cfg.InitScript)
+		}
+	}
+	if cfg.IsolateNetwork {
+		if err := executor.CheckNetworkIsolation(cfg.Shell); err != nil {
+			fail("isolate_network: %v", err)
+		}
+	}
+
+	for _, pattern := range cfg.ApprovalPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fail("approval pattern %q: %v", pattern, err)
+		}
+	}
+	for _, rule := range cfg.DangerousRules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			fail("dangerous rule %q: %v", rule.Pattern, err)
+		}
+	}
+	for _, rule := range cfg.TimeoutRules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			fail("timeout rule %q: %v", rule.Pattern, err)
+		}
+	}
+	if cfg.Umask != "" {
+		if _, err := executor.ParseUmask(cfg.Umask); err != nil {
+			fail("umask: %v", err)
+		}
+	}
+	if err := ValidStrictAllow(cfg.StrictAllow); err != nil {
+		fail("strict_allow: %v", err)
+	}
+	if _, err := newOutputCodec(cfg.OutputEncoding, cfg.InvalidUTF8); err != nil {
+		fail("output encoding: %v", err)
+	}
+
+	if cfg.AuthorizedKeysFile != "" {
+		if err := checkLoginConfig(sshauth.New(sshauth.Config{AuthorizedKeysFile: cfg.AuthorizedKeysFile})); err != nil {
+			fail("%v", err)
+		}
+	}
+	if cfg.OIDCIssuer != "" {
+		if err := checkOIDC(cfg); err != nil {
+			fail("%v", err)
+		}
+	}
+	// Without the auth interceptor these settings would silently do nothing
+	if !cfg.Interceptors.Auth && (cfg.AuthorizedKeysFile != "" || cfg.OIDCIssuer != "" ||
+		len(cfg.AllowedNetworks) > 0 || len(cfg.DeniedNetworks) > 0) {
+		fail("logins or network lists are configured but interceptors.auth is off")
+	}
+	if cfg.usesACME() {
+		if err := checkACME(cfg); err != nil {
+			fail("%v", err)
+		}
+	}
+	if err := checkAudit(cfg.AuditDriver, cfg.AuditDSN); err != nil {
+		fail("audit: %v", err)
+	}
+
+	// In agent mode the server does not listen, and with socket
+	// activation systemd holds the main port
+	if !cfg.AgentMode {
+		for i, lc := range cfg.listenerConfigs() {
+			if err := checkListener(lc); err != nil {
+				fail("listener %s: %v", lc.Address, err)
+				continue
+			}
+			if lc.TLS.Enabled() && !lc.TLS.ACME {
+				if _, err := tlsreload.New(lc.TLS.CertFile, lc.TLS.KeyFile); err != nil {
+					fail("listener %s: %v", lc.Address, err)
+				}
+			}
+			if i == 0 && systemd.Activated() {
+				continue
+			}
+			if err := checkBind(lc); err != nil {
+				fail("cannot listen on %s: %v", lc.Address, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// checkBind checks that a listener's address is free. A Unix socket is
+// not created, which would replace a stale one.
+func checkBind(lc ListenerConfig) error {
+	if lc.Network == "unix" {
+		if _, err := staleSocket(lc.Address); err != nil {
+			return err
+		}
+		if info, err := os.Stat(lc.Address); err == nil && info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", lc.Address)
+		}
+		if info, err := os.Stat(filepath.Dir(lc.Address)); err != nil || !info.IsDir() {
+			return fmt.Errorf("directory %s does not exist", filepath.Dir(lc.Address))
+		}
+		return nil
+	}
+	l, err := net.Listen(lc.Network, lc.Address)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// checkAudit checks the audit settings without opening the database,
+// which would create it. Postgres is not contacted.
+func checkAudit(driver, dsn string) error {
+	switch driver {
+	case "":
+		return nil
+	case audit.DriverPostgres:
+		if dsn == "" {
+			return fmt.Errorf("postgres requires a dsn")
+		}
+		return nil
+	case audit.DriverSQLite:
+		path := strings.TrimPrefix(dsn, "file:")
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+		if path == "" || path == ":memory:" {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		if info, err := os.Stat(dir); err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		return nil
+	default:
+		return audit.ErrUnsupportedDriver
+	}
+}
+
+// Redacted returns a copy of the configuration with tokens and database
+// passwords hidden, for printing
+func (c Config) Redacted() Config {
+	if c.AdminToken != "" {
+		c.AdminToken = redacted
+	}
+	if c.RelayToken != "" {
+		c.RelayToken = redacted
+	}
+	// URL and key=value forms of a postgres DSN
+	if u, err := url.Parse(c.AuditDSN); err == nil && u.User != nil {
+		c.AuditDSN = u.Redacted()
+	}
+	c.AuditDSN = dsnPassword.ReplaceAllString(c.AuditDSN, "${1}"+redacted)
+	return c
+}
+
+// dsnPassword matches the password of a key=value DSN
+var dsnPassword = regexp.MustCompile(`(\bpassword=)\S+`)
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+
+	"remote-shell-rpc/pkg/session"
+	pb "remote-shell-rpc/proto"
+)
+
+// maxClientInfoLen caps each field a client reports about itself
+const maxClientInfoLen = 128
+
+// clientInfo takes the description a client sent at login. The fields are
+// free text from the client, so control characters are dropped and long
+// values cut short before they reach logs and the audit database.
+func clientInfo(info *pb.ClientInfo) session.ClientInfo {
+	return session.ClientInfo{
+		OS:             cleanClientField(info.GetOs()),
+		Hostname:       cleanClientField(info.GetHostname()),
+		Version:        cleanClientField(info.GetClientVersion()),
+		Username:       cleanClientField(info.GetUsername()),
+		MaxRecvMsgSize: int(info.GetMaxRecvMsgSize()),
+	}
+}
+
+// cleanClientField makes a reported value safe to log
+func cleanClientField(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(value))
+	if len(value) > maxClientInfoLen {
+		value = strings.ToValidUTF8(value[:maxClientInfoLen], "")
+	}
+	return value
+}
+
+// clientInfoProto converts a session's client description for RPC replies
+func clientInfoProto(info session.ClientInfo) *pb.ClientInfo {
+	if info == (session.ClientInfo{}) {
+		return nil
+	}
+	return &pb.ClientInfo{
+		Os:             info.OS,
+		Hostname:       info.Hostname,
+		ClientVersion:  info.Version,
+		Username:       info.Username,
+		MaxRecvMsgSize: int32(info.MaxRecvMsgSize),
+	}
+}
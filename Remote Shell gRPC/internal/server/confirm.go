@@ -0,0 +1,114 @@
+package server
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/session"
+)
+
+// holdForConfirmation parks a dangerous command on the session and returns
+// the challenge the client must answer before it runs
+func (s *Server) holdForConfirmation(sess *session.Session, req *pb.CommandRequest) (*pb.ConfirmationChallenge, error) {
+	pending := session.PendingCommand{
+		Command:        req.Command,
+		TimeoutSeconds: req.TimeoutSeconds,
+		WorkingDir:     req.WorkingDir,
+		Env:            req.Env,
+		Stdin:          req.Stdin,
+		OutputFile:     req.OutputFile,
+		TailLines:      req.TailLines,
+		NoGlob:         req.Glob == pb.CommandRequest_NOGLOB,
+		Argv:           req.Argv,
+		Reason:         "command matches a dangerous pattern",
+		ExpiresAt:      time.Now().Add(s.config.ConfirmTimeout),
+	}
+
+	token, err := sess.AddPending(pending)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to hold command: %v", err)
+	}
+
+	s.logger.Info("Dangerous command awaiting confirmation",
+		"session_id", sess.ID,
+		"command", req.Command,
+	)
+
+	return &pb.ConfirmationChallenge{
+		Token:           token,
+		Reason:          pending.Reason,
+		ExpiresAtUnixMs: pending.ExpiresAt.UnixMilli(),
+	}, nil
+}
+
+// ConfirmCommand approves or rejects a command held for confirmation.
+// Approved commands run immediately and their output is streamed back.
+func (s *Server) ConfirmCommand(req *pb.ConfirmCommandRequest, stream pb.ShellService_ConfirmCommandServer) error {
+	if req.SessionId == "" {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.Token == "" {
+		return status.Error(codes.InvalidArgument, "token is required")
+	}
+
+	sess, err := s.lookupSession(stream.Context(), req.SessionId)
+	if err != nil {
+		return err
+	}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.checkOverload(); err != nil {
+		return err
+	}
+
+	pending, err := sess.TakePending(req.Token)
+	if err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+
+	if !req.Approve {
+		s.logger.Info("Dangerous command rejected by user",
+			"session_id", sess.ID,
+			"command", pending.Command,
+		)
+		s.auditRefusal(sess, pending.Command, audit.DecisionRejected, "",
+			status.Error(codes.Canceled, "rejected by user"))
+		return nil
+	}
+
+	s.logger.Warn("Dangerous command confirmed by user",
+		"session_id", sess.ID,
+		"command", pending.Command,
+	)
+
+	// Confirming a command does not stand in for an administrator's
+	// approval
+	out := &sequencedStream{outputStream: stream}
+	if err := s.awaitApprovalStream(sess, pending.Command, out); err != nil {
+		return err
+	}
+
+	// Globs were expanded before the command was held
+	glob := pb.CommandRequest_SHELL
+	if pending.NoGlob {
+		glob = pb.CommandRequest_NOGLOB
+	}
+	return s.runCommandStream(sess, &pb.CommandRequest{
+		SessionId:      sess.ID,
+		Command:        pending.Command,
+		TimeoutSeconds: pending.TimeoutSeconds,
+		WorkingDir:     pending.WorkingDir,
+		Env:            pending.Env,
+		Stdin:          pending.Stdin,
+		OutputFile:     pending.OutputFile,
+		TailLines:      pending.TailLines,
+		Glob:           glob,
+		Argv:           pending.Argv,
+	}, out)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// confirmedCommand holds a command that needs both confirmation and
+// approval for confirmation, confirms it in the background and returns
+// the stream it runs on and the result of ConfirmCommand
+func confirmedCommand(t *testing.T, s *Server) (*testStream, <-chan error) {
+	t.Helper()
+	ctx := peerContext("192.0.2.1")
+	sessionID := createSession(t, s, ctx, "client1")
+
+	held := newTestStream(ctx)
+	err := s.ExecuteCommandStream(&pb.CommandRequest{SessionId: sessionID, Command: "echo restricted"}, held)
+	if err != nil {
+		t.Fatalf("ExecuteCommandStream() error = %v", err)
+	}
+	challenge := held.confirmation()
+	if challenge == nil {
+		t.Fatal("command ran without asking for confirmation")
+	}
+
+	stream := newTestStream(ctx)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ConfirmCommand(&pb.ConfirmCommandRequest{
+			SessionId: sessionID,
+			Token:     challenge.Token,
+			Approve:   true,
+		}, stream)
+	}()
+	return stream, done
+}
+
+// pendingApproval waits for a command to be submitted for approval
+func pendingApproval(t *testing.T, s *Server) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if pending := s.approvals.List(); len(pending) > 0 {
+			return pending[0].ID
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("command was not submitted for approval")
+	return ""
+}
+
+func newConfirmApprovalServer(t *testing.T) *Server {
+	return newTestServer(t, func(cfg *Config) {
+		cfg.DangerousRules = []DangerousRule{{Pattern: "^echo restricted", Action: ActionConfirm}}
+		cfg.ApprovalPatterns = []string{"restricted"}
+	})
+}
+
+func TestConfirmCommand_StillNeedsApproval(t *testing.T) {
+	s := newConfirmApprovalServer(t)
+	stream, done := confirmedCommand(t, s)
+
+	id := pendingApproval(t, s)
+	if out := stream.stdout(); out != "" {
+		t.Fatalf("command ran before approval, output %q", out)
+	}
+	if _, err := s.approvals.Decide(id, false, "admin", "not now"); err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+
+	err := <-done
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ConfirmCommand() error = %v, want PermissionDenied", err)
+	}
+	if out := stream.stdout(); out != "" {
+		t.Errorf("denied command ran, output %q", out)
+	}
+}
+
+func TestConfirmCommand_RunsOnceApproved(t *testing.T) {
+	s := newConfirmApprovalServer(t)
+	stream, done := confirmedCommand(t, s)
+
+	id := pendingApproval(t, s)
+	if _, err := s.approvals.Decide(id, true, "admin", ""); err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("ConfirmCommand() error = %v", err)
+	}
+	if out := stream.stdout(); !strings.Contains(out, "restricted") {
+		t.Errorf("output = %q, want the command's output", out)
+	}
+}
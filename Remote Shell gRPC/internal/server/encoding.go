@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Handling of output that is not valid UTF-8
+const (
+	InvalidUTF8Replace = "replace"
+	InvalidUTF8Binary  = "binary"
+)
+
+// outputCodec turns raw command output into UTF-8 text. Protobuf strings
+// and JSON encoders reject invalid UTF-8, so output in another locale is
+// transcoded first and anything still invalid is either replaced with
+// U+FFFD or passed through flagged as binary.
+type outputCodec struct {
+	decoder *encoding.Decoder
+	binary  bool
+}
+
+// newOutputCodec creates a codec for the given source encoding name
+// (e.g. "iso-8859-1", "windows-1252", "shift_jis"); empty means UTF-8
+func newOutputCodec(name, invalid string) (*outputCodec, error) {
+	c := &outputCodec{binary: invalid == InvalidUTF8Binary}
+	if name == "" {
+		return c, nil
+	}
+
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if enc != encoding.Nop {
+		if canonical, _ := htmlindex.Name(enc); canonical != "utf-8" {
+			c.decoder = enc.NewDecoder()
+		}
+	}
+	return c, nil
+}
+
+// text converts raw output to UTF-8. It returns false when the data is not
+// valid text and should be sent as binary instead. Data containing NUL
+// bytes is always treated as binary.
+func (c *outputCodec) text(raw []byte) ([]byte, bool) {
+	if bytes.IndexByte(raw, 0) >= 0 {
+		return raw, false
+	}
+	if c.decoder != nil {
+		if decoded, err := c.decoder.Bytes(raw); err == nil {
+			raw = decoded
+		}
+	}
+	if utf8.Valid(raw) {
+		return raw, true
+	}
+	if c.binary {
+		return raw, false
+	}
+	return bytes.ToValidUTF8(raw, []byte("\uFFFD")), true
+}
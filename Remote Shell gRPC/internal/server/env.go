@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/session"
+)
+
+// envName matches valid environment variable names
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// handleEnvCommand keeps simple "export NAME=value" and "unset NAME"
+// commands in the session environment, like cd keeps the directory.
+// Anything more involved runs in the shell as usual.
+func (s *Server) handleEnvCommand(sess *session.Session, command string, parts []string) (bool, *pb.CommandResponse) {
+	if len(parts) < 2 || strings.ContainsAny(command, ";&|<>`$()\\\n") {
+		return false, nil
+	}
+
+	vars := make(map[string]string, len(parts)-1)
+	for _, arg := range parts[1:] {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !envName.MatchString(name) || hasValue == (parts[0] == "unset") {
+			return false, nil
+		}
+		vars[name] = unquote(value)
+	}
+
+	changed := make([]string, 0, len(vars))
+	for name, value := range vars {
+		if parts[0] == "unset" {
+			sess.UnsetEnv(name)
+		} else {
+			sess.SetEnv(name, value)
+		}
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	return true, &pb.CommandResponse{ExitCode: 0, ChangedEnv: changed}
+}
+
+// unquote removes one pair of matching surrounding quotes
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// SaveEnv stores the session environment and working directory
+func (s *Server) SaveEnv(ctx context.Context, req *pb.SaveEnvRequest) (*pb.SaveEnvResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := sess.SaveEnv(req.Name)
+	if err != nil {
+		if err == session.ErrTooManyEnvs {
+			return nil, status.Error(codes.ResourceExhausted, "too many environment snapshots")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to save environment: %v", err)
+	}
+
+	s.logger.Debug("Environment saved",
+		"session_id", sess.ID,
+		"name", snap.Name,
+		"variables", len(snap.Environment),
+	)
+	return &pb.SaveEnvResponse{Success: true}, nil
+}
+
+// LoadEnv restores a saved environment and working directory
+func (s *Server) LoadEnv(ctx context.Context, req *pb.LoadEnvRequest) (*pb.LoadEnvResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	snap, err := sess.LoadEnv(req.Name)
+	if err != nil {
+		if err == session.ErrEnvNotFound {
+			return nil, status.Errorf(codes.NotFound, "no environment snapshot named %q", req.Name)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to load environment: %v", err)
+	}
+
+	resp := &pb.LoadEnvResponse{}
+	if info, err := os.Stat(snap.WorkingDir); err == nil && info.IsDir() {
+		sess.SetWorkingDir(snap.WorkingDir)
+	} else {
+		resp.Message = fmt.Sprintf("directory %s no longer exists", snap.WorkingDir)
+	}
+	resp.WorkingDir = sess.GetWorkingDir()
+
+	s.logger.Debug("Environment loaded", "session_id", sess.ID, "name", snap.Name)
+	return resp, nil
+}
+
+// ListEnvs returns the environment snapshots of a session
+func (s *Server) ListEnvs(ctx context.Context, req *pb.ListEnvsRequest) (*pb.ListEnvsResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := sess.EnvSnapshots()
+	resp := &pb.ListEnvsResponse{
+		Snapshots: make([]*pb.EnvSnapshot, 0, len(snaps)),
+	}
+	for _, snap := range snaps {
+		resp.Snapshots = append(resp.Snapshots, &pb.EnvSnapshot{
+			Name:          snap.Name,
+			WorkingDir:    snap.WorkingDir,
+			Variables:     int32(len(snap.Environment)),
+			SavedAtUnixMs: snap.SavedAt.UnixMilli(),
+		})
+	}
+	return resp, nil
+}
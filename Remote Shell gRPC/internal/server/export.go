@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bufio"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/files"
+	pb "remote-shell-rpc/proto"
+)
+
+// exportWriter sends what is written to it as export chunks
+type exportWriter struct {
+	stream pb.AdminService_ExportAuditServer
+}
+
+// Write implements io.Writer
+func (w exportWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&pb.ExportAuditChunk{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ExportAudit streams the audit records matching the request's filters in
+// the requested format. Records are read and sent as they are found, so
+// exports of any size use little memory.
+func (a *AdminServer) ExportAudit(req *pb.ExportAuditRequest, stream pb.AdminService_ExportAuditServer) error {
+	ctx := stream.Context()
+	if err := a.authorize(ctx); err != nil {
+		return err
+	}
+
+	querier, ok := a.server.audit.(audit.Querier)
+	if !ok {
+		return status.Error(codes.FailedPrecondition, "audit storage is not configured")
+	}
+
+	format := req.Format
+	if format == "" {
+		format = audit.FormatJSONL
+	}
+	out := bufio.NewWriterSize(exportWriter{stream: stream}, files.ChunkSize)
+	exporter, err := audit.NewExporter(out, format)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	q := audit.Query{
+		SessionID: req.SessionId,
+		ClientID:  req.ClientId,
+		Decision:  req.Decision,
+	}
+	if req.SinceUnixMs > 0 {
+		q.Since = time.UnixMilli(req.SinceUnixMs)
+	}
+	if req.UntilUnixMs > 0 {
+		q.Until = time.UnixMilli(req.UntilUnixMs)
+	}
+	if req.HasExitCode {
+		exitCode := int(req.ExitCode)
+		q.ExitCode = &exitCode
+	}
+
+	exported := 0
+	err = querier.EachCommand(ctx, q, func(rec audit.CommandRecord) error {
+		exported++
+		return exporter.Write(rec)
+	})
+	if err == nil {
+		err = exporter.Flush()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "failed to export audit records: %v", err)
+	}
+
+	a.server.logger.Info("Audit records exported",
+		"records", exported,
+		"format", format,
+		"client_addr", peerAddr(ctx),
+	)
+	return nil
+}
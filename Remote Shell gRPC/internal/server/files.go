@@ -0,0 +1,420 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/files"
+	"remote-shell-rpc/pkg/session"
+)
+
+// sessionPath resolves a path from a request against the session directory
+func sessionPath(sess *session.Session, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(sess.GetWorkingDir(), path)
+}
+
+// fileError converts a file system error into a gRPC status error
+func fileError(path string, err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return status.Errorf(codes.NotFound, "%s: no such file or directory", path)
+	case os.IsPermission(err):
+		return status.Errorf(codes.PermissionDenied, "%s: permission denied", path)
+	case err == files.ErrIsDirectory:
+		return status.Errorf(codes.InvalidArgument, "%s is a directory", path)
+	case err == files.ErrExists:
+		return status.Errorf(codes.AlreadyExists, "%s already exists", path)
+	}
+	return status.Errorf(codes.Internal, "%s: %v", path, err)
+}
+
+// conflicts maps upload conflict policies to the file package's
+var conflicts = map[pb.UploadRequest_Conflict]files.Conflict{
+	pb.UploadRequest_OVERWRITE: files.Overwrite,
+	pb.UploadRequest_FAIL:      files.Fail,
+	pb.UploadRequest_RENAME:    files.Rename,
+}
+
+// entryTypes maps directory entry types to their protobuf values
+var entryTypes = map[files.EntryType]pb.DirectoryEntry_EntryType{
+	files.TypeFile:      pb.DirectoryEntry_FILE,
+	files.TypeDirectory: pb.DirectoryEntry_DIRECTORY,
+	files.TypeSymlink:   pb.DirectoryEntry_SYMLINK,
+	files.TypeOther:     pb.DirectoryEntry_OTHER,
+}
+
+// ListDirectory returns the entries of a directory
+func (s *Server) ListDirectory(ctx context.Context, req *pb.ListDirectoryRequest) (*pb.ListDirectoryResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	path := sessionPath(sess, req.Path)
+	if info, err := os.Stat(path); err != nil {
+		return nil, fileError(req.Path, err)
+	} else if !info.IsDir() {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is not a directory", req.Path)
+	}
+
+	entries, err := files.List(path)
+	if err != nil {
+		return nil, fileError(req.Path, err)
+	}
+
+	resp := &pb.ListDirectoryResponse{
+		Path:    path,
+		Entries: make([]*pb.DirectoryEntry, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, &pb.DirectoryEntry{
+			Name:          e.Name,
+			Size:          e.Size,
+			Mode:          uint32(e.Mode.Perm()),
+			ModTimeUnixMs: e.ModTime.UnixMilli(),
+			Type:          entryTypes[e.Type],
+			LinkTarget:    e.LinkTarget,
+		})
+	}
+	return resp, nil
+}
+
+// StatFile returns information about a file and optionally its checksum
+func (s *Server) StatFile(ctx context.Context, req *pb.StatFileRequest) (*pb.StatFileResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.Path == "" {
+		return nil, status.Error(codes.InvalidArgument, "path is required")
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	path := sessionPath(sess, req.Path)
+	info, err := files.Stat(path, req.Checksum)
+	if err != nil {
+		return nil, fileError(req.Path, err)
+	}
+
+	return &pb.StatFileResponse{
+		Path:          path,
+		Size:          info.Size,
+		Mode:          uint32(info.Mode.Perm()),
+		ModTimeUnixMs: info.ModTime.UnixMilli(),
+		Type:          entryTypes[info.Type],
+		Owner:         info.Owner,
+		Group:         info.Group,
+		Sha256:        info.SHA256,
+	}, nil
+}
+
+// Upload writes a file sent by the client. The file is written under a
+// temporary name and only moved into place once complete and, when the
+// client sent a checksum, verified. An upload with a transfer ID that is
+// interrupted is kept for a while, and an upload with the same ID goes on
+// from where it stopped.
+func (s *Server) Upload(stream pb.ShellService_UploadServer) error {
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "empty upload")
+	}
+	if err != nil {
+		return err
+	}
+	if first.SessionId == "" {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if first.Path == "" {
+		return status.Error(codes.InvalidArgument, "path is required")
+	}
+
+	sess, err := s.lookupSession(stream.Context(), first.SessionId)
+	if err != nil {
+		return err
+	}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+
+	conflict, ok := conflicts[first.Conflict]
+	if !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown conflict policy %v", first.Conflict)
+	}
+	path := sessionPath(sess, first.Path)
+	// Checked again when the file is moved into place, but failing now
+	// saves sending it
+	if _, err := os.Lstat(path); err == nil && conflict == files.Fail {
+		return fileError(first.Path, files.ErrExists)
+	}
+	mode := os.FileMode(first.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	mode = executor.ApplyUmask(mode, sess.GetUmask())
+
+	start := time.Now()
+	var w *files.Writer
+	key := ""
+	if first.TransferId != "" && s.partials != nil {
+		key = sess.ID + "/" + first.TransferId
+		w = s.partials.Take(key)
+	}
+	if w != nil && w.Path() != path {
+		w.Abort()
+		w = nil
+	}
+	resumedAt := int64(0)
+	if w != nil {
+		if resumedAt, err = w.Offset(); err != nil {
+			w.Abort()
+			return fileError(first.Path, err)
+		}
+	} else if w, err = files.Create(path, mode); err != nil {
+		return fileError(first.Path, err)
+	}
+	kept := false
+	defer func() {
+		if !kept {
+			w.Abort()
+		}
+	}()
+	// keep holds on to what was received so that the client can resume
+	keep := func() {
+		if key != "" {
+			s.partials.Keep(key, w)
+			kept = true
+		}
+	}
+
+	size := resumedAt
+	checksum := ""
+	for req := first; ; {
+		if key != "" && req.Offset != size {
+			keep()
+			return status.Errorf(codes.FailedPrecondition, "upload of %s continues at offset %d, not %d", first.Path, size, req.Offset)
+		}
+		if len(req.Data) > 0 {
+			if _, err := w.Write(req.Data); err != nil {
+				return fileError(first.Path, err)
+			}
+			size += int64(len(req.Data))
+		}
+		if req.Sha256 != "" {
+			checksum = req.Sha256
+		}
+
+		req, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			keep()
+			if kept {
+				s.logger.Info("Upload interrupted", "session_id", sess.ID, "path", path, "offset", size)
+			}
+			return err
+		}
+		sess.UpdateActivity()
+	}
+
+	if checksum != "" {
+		sum, err := w.Checksum()
+		if err != nil {
+			return fileError(first.Path, err)
+		}
+		if !strings.EqualFold(sum, checksum) {
+			s.logger.Warn("Upload checksum mismatch", "session_id", sess.ID, "path", path, "sha256", sum, "expected", checksum)
+			return status.Errorf(codes.DataLoss, "checksum of %s does not match: received %s, expected %s", first.Path, sum, checksum)
+		}
+	}
+	if path, err = w.CommitWith(conflict); err != nil {
+		return fileError(first.Path, err)
+	}
+
+	s.logger.Info("File uploaded", "session_id", sess.ID, "path", path, "size", size, "resumed_at", resumedAt)
+	s.auditCommand(sess, "upload "+path, start, 0, "")
+
+	return stream.SendAndClose(&pb.UploadResponse{
+		Path:      path,
+		Size:      size,
+		ResumedAt: resumedAt,
+	})
+}
+
+// GetUploadOffset reports how much of an interrupted upload the server
+// kept, so that the client sends only the rest
+func (s *Server) GetUploadOffset(ctx context.Context, req *pb.GetUploadOffsetRequest) (*pb.GetUploadOffsetResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.TransferId == "" {
+		return nil, status.Error(codes.InvalidArgument, "transfer_id is required")
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.GetUploadOffsetResponse{}
+	if s.partials != nil {
+		path, offset, ok := s.partials.Lookup(sess.ID + "/" + req.TransferId)
+		if ok && path == sessionPath(sess, req.Path) {
+			resp.Offset = offset
+		}
+	}
+	return resp, nil
+}
+
+// Download streams the contents of a file to the client
+func (s *Server) Download(req *pb.DownloadRequest, stream pb.ShellService_DownloadServer) error {
+	if req.SessionId == "" {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.Path == "" {
+		return status.Error(codes.InvalidArgument, "path is required")
+	}
+
+	sess, err := s.lookupSession(stream.Context(), req.SessionId)
+	if err != nil {
+		return err
+	}
+
+	path := sessionPath(sess, req.Path)
+	f, err := os.Open(path)
+	if err != nil {
+		return fileError(req.Path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fileError(req.Path, err)
+	}
+	if info.IsDir() {
+		return fileError(req.Path, files.ErrIsDirectory)
+	}
+
+	if req.Offset < 0 || req.Offset > info.Size() {
+		return status.Errorf(codes.OutOfRange, "offset %d is outside %s (%d bytes)", req.Offset, req.Path, info.Size())
+	}
+	if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+		return fileError(req.Path, err)
+	}
+
+	start := time.Now()
+	chunk := &pb.FileChunk{
+		Size:          info.Size(),
+		Mode:          uint32(info.Mode().Perm()),
+		ModTimeUnixMs: info.ModTime().UnixMilli(),
+		Offset:        req.Offset,
+	}
+	offset := req.Offset
+	sent := false
+	buf := make([]byte, files.ChunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+			offset += int64(n)
+			chunk = &pb.FileChunk{Offset: offset}
+			sent = true
+			sess.UpdateActivity()
+			sess.AddBytesStreamed(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fileError(req.Path, err)
+		}
+	}
+
+	// An empty file still sends its size and mode
+	if !sent {
+		if err := stream.Send(chunk); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("File downloaded", "session_id", sess.ID, "path", path, "size", info.Size(), "offset", req.Offset)
+	s.auditCommand(sess, "download "+path, start, 0, "")
+	return nil
+}
+
+// TailFile streams the end of a file and everything appended to it until
+// the client cancels
+func (s *Server) TailFile(req *pb.TailFileRequest, stream pb.ShellService_TailFileServer) error {
+	if req.SessionId == "" {
+		return status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if req.Path == "" {
+		return status.Error(codes.InvalidArgument, "path is required")
+	}
+
+	sess, err := s.lookupSession(stream.Context(), req.SessionId)
+	if err != nil {
+		return err
+	}
+
+	path := sessionPath(sess, req.Path)
+	opts := files.DefaultTailOptions()
+	if req.Lines > 0 {
+		opts.Lines = int(req.Lines)
+	}
+
+	s.logger.Info("Tailing file", "session_id", sess.ID, "path", path)
+
+	start := time.Now()
+	err = files.Follow(stream.Context(), path, opts, func(data []byte, rotated bool) error {
+		sess.UpdateActivity()
+		sess.AddBytesStreamed(len(data))
+		text, ok := s.output.text(data)
+		return stream.Send(&pb.TailFileOutput{
+			Data:    text,
+			Rotated: rotated,
+			Binary:  !ok,
+		})
+	})
+
+	// File reads do not go through the command path, so record them here
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	s.auditCommand(sess, "tail -f "+path, start, 0, errText)
+
+	if err != nil {
+		if stream.Context().Err() != nil {
+			return nil
+		}
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return fileError(req.Path, err)
+	}
+	return nil
+}
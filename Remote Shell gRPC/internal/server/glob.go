@@ -0,0 +1,153 @@
+package server
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/session"
+	"remote-shell-rpc/pkg/shellparse"
+	pb "remote-shell-rpc/proto"
+)
+
+// globMatchLimit caps the paths one pattern may expand to
+const globMatchLimit = 10000
+
+// expandGlobs replaces the pathname patterns of a command with the quoted
+// paths they match when the request asks for Glob EXPAND, returning what
+// each pattern matched. Patterns are matched against the command's working
+// directory the way bash does: names starting with a dot only match
+// patterns that start with one too. Like macros, this happens before the
+// policy checks, so they see the paths.
+func (s *Server) expandGlobs(sess *session.Session, req *pb.CommandRequest) ([]*pb.GlobExpansion, error) {
+	if req.Glob != pb.CommandRequest_EXPAND || len(req.Argv) > 0 {
+		return nil, nil
+	}
+	globs, err := shellparse.Globs(req.Command)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "cannot expand globs: %v", err)
+	}
+
+	dir := sess.GetWorkingDir()
+	if req.WorkingDir != "" {
+		if filepath.IsAbs(req.WorkingDir) {
+			dir = req.WorkingDir
+		} else {
+			dir = filepath.Join(dir, req.WorkingDir)
+		}
+	}
+
+	var command strings.Builder
+	var expansions []*pb.GlobExpansion
+	last := 0
+	for _, glob := range globs {
+		matches, err := globMatches(dir, glob.Pattern)
+		if err != nil {
+			// The shell takes a malformed pattern literally
+			continue
+		}
+		if len(matches) == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "no matches found: %s", glob.Pattern)
+		}
+		if len(matches) > globMatchLimit {
+			return nil, status.Errorf(codes.InvalidArgument, "%s matches more than %d paths", glob.Pattern, globMatchLimit)
+		}
+
+		command.WriteString(req.Command[last:glob.Start])
+		for i, match := range matches {
+			if i > 0 {
+				command.WriteByte(' ')
+			}
+			command.WriteString(executor.ShellQuote(match))
+		}
+		last = glob.End
+		expansions = append(expansions, &pb.GlobExpansion{Pattern: glob.Pattern, Matches: matches})
+	}
+	if len(expansions) == 0 {
+		return nil, nil
+	}
+	command.WriteString(req.Command[last:])
+
+	s.logger.Debug("Globs expanded",
+		"session_id", sess.ID,
+		"patterns", len(expansions),
+		"command", command.String(),
+	)
+	req.Command = command.String()
+	return expansions, nil
+}
+
+// globMatches returns the paths matching a shell pattern, relative to dir
+// unless the pattern is absolute, in sorted order. It fails for a
+// malformed pattern.
+func globMatches(dir, pattern string) ([]string, error) {
+	// Shells negate a bracket expression with "!", Go with "^"
+	pattern = strings.ReplaceAll(pattern, "[!", "[^")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	segments := strings.Split(pattern, "/")
+	matches := []string{""}
+	if segments[0] == "" {
+		matches, segments = []string{"/"}, segments[1:]
+	}
+	for _, segment := range segments {
+		var next []string
+		for _, prefix := range matches {
+			if !strings.ContainsAny(segment, "*?[") {
+				next = append(next, joinGlob(prefix, segment))
+				continue
+			}
+			base := prefix
+			if !filepath.IsAbs(base) {
+				base = filepath.Join(dir, base)
+			}
+			entries, err := os.ReadDir(base)
+			if err != nil {
+				continue
+			}
+			for _, entry := range entries {
+				name := entry.Name()
+				if strings.HasPrefix(name, ".") && !strings.HasPrefix(segment, ".") {
+					continue
+				}
+				if ok, _ := path.Match(segment, name); ok {
+					next = append(next, joinGlob(prefix, name))
+				}
+			}
+		}
+		matches = next
+	}
+
+	// Literal segments were taken on trust; keep the paths that exist
+	found := matches[:0]
+	for _, match := range matches {
+		full := match
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(dir, full)
+			if strings.HasSuffix(match, "/") {
+				full += "/"
+			}
+		}
+		if _, err := os.Lstat(full); err == nil {
+			found = append(found, match)
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// joinGlob appends a path segment to a partial match
+func joinGlob(prefix, segment string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return prefix + segment
+	}
+	return prefix + "/" + segment
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/idempotency"
+	"remote-shell-rpc/pkg/session"
+)
+
+// executeOnce runs a command with an idempotency key. A retry with the same
+// key gets the result of the first attempt instead of running the command
+// again, and waits for it if it is still running.
+func (s *Server) executeOnce(ctx context.Context, sess *session.Session, req *pb.CommandRequest) (*pb.CommandResponse, error) {
+	value, replayed, err := s.idempotency.Do(ctx, sess.ID, req.IdempotencyKey, requestFingerprint(req),
+		func() (interface{}, error) {
+			return s.executeCommand(ctx, sess, req)
+		},
+		keepResult,
+	)
+	if errors.Is(err, idempotency.ErrKeyReused) {
+		return nil, status.Error(codes.InvalidArgument, "idempotency_key was already used for a different command")
+	}
+	if err != nil && err == ctx.Err() {
+		return nil, status.FromContextError(err).Err()
+	}
+	if replayed {
+		s.logger.Info("Replayed command result",
+			"session_id", sess.ID,
+			"idempotency_key", req.IdempotencyKey,
+			"code", status.Code(err).String(),
+		)
+	}
+	resp, _ := value.(*pb.CommandResponse)
+	return resp, err
+}
+
+// requestFingerprint identifies what a request asks for, so that a key
+// reused for a different command is noticed
+func requestFingerprint(req *pb.CommandRequest) string {
+	fingerprint := proto.Clone(req).(*pb.CommandRequest)
+	fingerprint.IdempotencyKey = ""
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(fingerprint)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// keepResult reports whether the result of a command is kept for retries:
+// the command ran, or it failed part way and the error carries its output.
+// Refusals and confirmation challenges are not kept, so that a retry asks
+// again.
+func keepResult(value interface{}, err error) bool {
+	if err != nil {
+		for _, detail := range status.Convert(err).Details() {
+			if _, ok := detail.(*pb.CommandResponse); ok {
+				return true
+			}
+		}
+		return false
+	}
+	resp, _ := value.(*pb.CommandResponse)
+	return resp != nil && resp.Confirmation == nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// serverIDHeader carries the server's identity in the response headers of
+// every call
+const serverIDHeader = "x-server-id"
+
+// newServerID returns "<name>/<uuid>" for a server process: the configured
+// name, or the host name, and a random UUID that changes on every start
+func newServerID(name string) string {
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return name
+	}
+	// Version 4, variant 10
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%s/%x-%x-%x-%x-%x", name, b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// identityUnary sends the server ID with every unary response
+func (s *Server) identityUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	grpc.SetHeader(ctx, metadata.Pairs(serverIDHeader, s.serverID))
+	return handler(ctx, req)
+}
+
+// identityStream sends the server ID in the header of every stream
+func (s *Server) identityStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ss.SetHeader(metadata.Pairs(serverIDHeader, s.serverID))
+	return handler(srv, ss)
+}
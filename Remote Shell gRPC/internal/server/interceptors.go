@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/format"
+	"remote-shell-rpc/pkg/recovery"
+)
+
+// InterceptorConfig turns the stages every request passes through on and
+// off. Their order is fixed in code; see interceptors.
+type InterceptorConfig struct {
+	// Recovery turns panics in handlers into Internal errors instead of
+	// crashing the server
+	Recovery bool `yaml:"recovery"`
+	// Metrics counts requests, failures and time per method for
+	// "admin health"
+	Metrics bool `yaml:"metrics"`
+	// Logging logs failed requests, and every request at debug level
+	Logging bool `yaml:"logging"`
+	// Auth applies the network lists, bans and logins. Only turn it off
+	// when a proxy in front of the server does this.
+	Auth bool `yaml:"auth"`
+	// RateLimit applies max_connections and max_streams_per_client to
+	// streams
+	RateLimit bool `yaml:"rate_limit"`
+	// Audit logs every request with the caller's identity and outcome
+	Audit bool `yaml:"audit"`
+}
+
+// interceptors returns the enabled interceptors in the order requests pass
+// through them. Recovery comes first so that it catches panics in every
+// later stage; metrics and logging come before auth so that they see the
+// requests it refuses; the stream limit is only taken by clients that
+// passed auth; and audit comes last so that it knows who the caller is.
+func (s *Server) interceptors() ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	var (
+		unary  []grpc.UnaryServerInterceptor
+		stream []grpc.StreamServerInterceptor
+	)
+	add := func(enabled bool, u grpc.UnaryServerInterceptor, st grpc.StreamServerInterceptor) {
+		if !enabled {
+			return
+		}
+		if u != nil {
+			unary = append(unary, u)
+		}
+		if st != nil {
+			stream = append(stream, st)
+		}
+	}
+
+	cfg := s.config.Interceptors
+	add(true, s.identityUnary, s.identityStream)
+	add(cfg.Recovery, recovery.UnaryServerInterceptor(s.panicked), recovery.StreamServerInterceptor(s.panicked))
+	add(cfg.Metrics, s.metricsUnary, s.metricsStream)
+	add(cfg.Logging, s.logUnary, s.logStream)
+	add(cfg.Auth, s.authUnary, s.authStream)
+	add(cfg.RateLimit, nil, s.limitStream)
+	add(cfg.Audit, s.auditUnary, s.auditStream)
+	return unary, stream
+}
+
+// panicked logs a panic the recovery interceptor caught and counts it
+func (s *Server) panicked(p recovery.Panic) {
+	s.metrics.recordPanic()
+	s.logger.Error("Panic recovered",
+		"request_id", p.RequestID,
+		"method", p.Method,
+		"panic", fmt.Sprint(p.Value),
+		"stack", string(p.Stack),
+	)
+}
+
+// metricsUnary records the outcome and duration of unary requests
+func (s *Server) metricsUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	s.metrics.record(info.FullMethod, time.Since(start), err)
+	return resp, err
+}
+
+// metricsStream records the outcome and duration of streams
+func (s *Server) metricsStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	s.metrics.record(info.FullMethod, time.Since(start), err)
+	return err
+}
+
+// logUnary logs unary requests
+func (s *Server) logUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	s.logger.Debug("Request received",
+		"method", info.FullMethod,
+		"client", peerAddr(ctx),
+	)
+
+	resp, err := handler(ctx, req)
+
+	duration := time.Since(start)
+	if err != nil {
+		s.logger.Warn("Request failed",
+			"method", info.FullMethod,
+			"duration", format.Duration(duration),
+			"error", err.Error(),
+		)
+	} else {
+		s.logger.Debug("Request completed",
+			"method", info.FullMethod,
+			"duration", format.Duration(duration),
+		)
+	}
+	return resp, err
+}
+
+// logStream logs streams
+func (s *Server) logStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	s.logger.Debug("Stream started",
+		"method", info.FullMethod,
+		"client", peerAddr(ss.Context()),
+	)
+
+	err := handler(srv, ss)
+
+	duration := time.Since(start)
+	if err != nil {
+		s.logger.Warn("Stream failed",
+			"method", info.FullMethod,
+			"duration", format.Duration(duration),
+			"error", err.Error(),
+		)
+	} else {
+		s.logger.Debug("Stream completed",
+			"method", info.FullMethod,
+			"duration", format.Duration(duration),
+		)
+	}
+	return err
+}
+
+// authUnary refuses unary requests from denied networks, banned clients and
+// clients that have not logged in
+func (s *Server) authUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStream refuses streams from denied networks, banned clients and
+// clients that have not logged in
+func (s *Server) authStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if ctx != ss.Context() {
+		ss = &loginStream{ServerStream: ss, ctx: ctx}
+	}
+	return handler(srv, ss)
+}
+
+// authenticate applies the network lists, bans and logins to a request and
+// returns its context with the client's identity
+func (s *Server) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if err := s.checkNetwork(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.checkBanned(ctx); err != nil {
+		return nil, err
+	}
+	loggedIn, err := s.checkLogin(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	// The identity of a login may be banned on its own
+	if loggedIn != ctx {
+		if err := s.checkBanned(loggedIn); err != nil {
+			return nil, err
+		}
+		ctx = loggedIn
+	}
+	if err := checkRelayIdentity(ctx, method); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// limitStream holds a stream slot for the client while a stream runs
+func (s *Server) limitStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	release, err := s.acquireStream(peerAddr(ss.Context()))
+	if err != nil {
+		s.logger.Warn("Stream rejected",
+			"method", info.FullMethod,
+			"client", peerAddr(ss.Context()),
+			"error", status.Convert(err).Message(),
+		)
+		return err
+	}
+	defer release()
+	return handler(srv, ss)
+}
+
+// auditUnary logs who made a unary request and how it ended
+func (s *Server) auditUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	s.auditRequest(ctx, info.FullMethod, start, err)
+	return resp, err
+}
+
+// auditStream logs who opened a stream and how it ended
+func (s *Server) auditStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	s.auditRequest(ss.Context(), info.FullMethod, start, err)
+	return err
+}
+
+// auditRequest logs a finished request with the caller's identity
+func (s *Server) auditRequest(ctx context.Context, method string, start time.Time, err error) {
+	s.logger.Info("Request audited",
+		"method", method,
+		"identity", identity(ctx),
+		"client", peerAddr(ctx),
+		"code", status.Code(err).String(),
+		"duration", format.Duration(time.Since(start)),
+	)
+}
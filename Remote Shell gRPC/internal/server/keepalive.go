@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/format"
+	pb "remote-shell-rpc/proto"
+)
+
+// keepAliveMisses is how many pings in a row may go unanswered before a
+// watched command past its timeout counts as abandoned
+const keepAliveMisses = 3
+
+// errAbandoned ends a watched command whose client stopped answering
+var errAbandoned = errors.New("keep-alive pings went unanswered")
+
+// keepAliveKey marks the context of a watched command, whose timeout is
+// enforced by its watchdog
+type keepAliveKey struct{}
+
+// keptAlive reports whether ctx belongs to a watched command
+func keptAlive(ctx context.Context) bool {
+	return ctx.Value(keepAliveKey{}) != nil
+}
+
+// ExecuteCommandWatched runs a command like ExecuteCommandStream while
+// pinging the client, letting it run past its timeout for as long as the
+// client answers
+func (s *Server) ExecuteCommandWatched(stream pb.ShellService_ExecuteCommandWatchedServer) error {
+	if s.config.KeepAliveInterval <= 0 {
+		return status.Error(codes.Unimplemented, "watched commands are disabled on this server")
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	req := first.Command
+	if req == nil {
+		return status.Error(codes.InvalidArgument, "the first message must carry the command")
+	}
+	// The watchdog looks up the timeout by the command
+	if err := requestCommand(req); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(context.WithValue(stream.Context(), keepAliveKey{}, true))
+	defer cancel(nil)
+	watched := &watchedStream{ShellService_ExecuteCommandWatchedServer: stream, ctx: ctx, lastAck: time.Now()}
+
+	go watched.receiveAcks()
+	go s.watchdog(watched, cancel, s.commandTimeout(req))
+
+	err = s.executeCommandStream(req, watched)
+	if errors.Is(context.Cause(ctx), errAbandoned) {
+		s.logger.Info("Killed abandoned command",
+			"session_id", req.SessionId,
+			"command", req.Command,
+			"timeout", format.Duration(s.commandTimeout(req)),
+		)
+		return status.Errorf(codes.DeadlineExceeded, "command killed: %v", errAbandoned)
+	}
+	return err
+}
+
+// watchdog pings the client of a watched command and kills the command
+// once it is past its timeout and the client stopped answering
+func (s *Server) watchdog(w *watchedStream, kill context.CancelCauseFunc, timeout time.Duration) {
+	start := time.Now()
+	ticker := time.NewTicker(s.config.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for id := uint64(1); ; id++ {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if time.Since(start) >= timeout && time.Since(w.acknowledged()) > keepAliveMisses*s.config.KeepAliveInterval {
+			kill(errAbandoned)
+			return
+		}
+		if err := w.Send(&pb.CommandOutput{KeepAlive: &pb.KeepAlivePing{Id: id}}); err != nil {
+			s.logger.Debug("Failed to send keep-alive ping", "error", err.Error())
+		}
+	}
+}
+
+// watchedStream is the output stream of a watched command. Sends are
+// serialized, as pings are sent alongside the command's output.
+type watchedStream struct {
+	pb.ShellService_ExecuteCommandWatchedServer
+	ctx    context.Context
+	sendMu sync.Mutex
+	// lastAck is when the client last acknowledged a ping
+	lastAck time.Time
+	ackMu   sync.Mutex
+}
+
+// Context returns the command's context, which the watchdog cancels
+func (w *watchedStream) Context() context.Context {
+	return w.ctx
+}
+
+// Send sends a message, waiting for any other send to finish
+func (w *watchedStream) Send(out *pb.CommandOutput) error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	return w.ShellService_ExecuteCommandWatchedServer.Send(out)
+}
+
+// receiveAcks notes the client's acknowledgements until it closes its side
+// of the stream
+func (w *watchedStream) receiveAcks() {
+	for {
+		msg, err := w.Recv()
+		if err != nil {
+			return
+		}
+		if msg.Ack != 0 {
+			w.ackMu.Lock()
+			w.lastAck = time.Now()
+			w.ackMu.Unlock()
+		}
+	}
+}
+
+// acknowledged returns when the client last acknowledged a ping
+func (w *watchedStream) acknowledged() time.Time {
+	w.ackMu.Lock()
+	defer w.ackMu.Unlock()
+	return w.lastAck
+}
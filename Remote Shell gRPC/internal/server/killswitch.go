@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// lockdownMessage is shown to clients refused after a locking kill switch
+const lockdownMessage = "the server is locked down"
+
+// terminateAll kills every running command and closes every session. With
+// lock set new sessions are refused until maintenance mode is turned off.
+// It returns the number of sessions closed.
+func (s *Server) terminateAll(trigger, reason string, lock bool) int {
+	// Lock first so that clients cannot create new sessions meanwhile
+	if lock {
+		s.setMaintenance(true, true, lockdownMessage)
+	}
+
+	terminated := 0
+	for _, sess := range s.sessionManager.List() {
+		if err := s.sessionManager.Delete(sess.ID); err != nil {
+			continue
+		}
+		s.sessionClosed(sess)
+		terminated++
+	}
+
+	s.logger.Error("Security event: all sessions terminated",
+		"event", "kill_switch",
+		"trigger", trigger,
+		"reason", reason,
+		"sessions", terminated,
+		"locked", lock,
+	)
+	return terminated
+}
+
+// TerminateAllSessions is the kill switch: it closes every session and
+// kills their commands
+func (a *AdminServer) TerminateAllSessions(ctx context.Context, req *pb.TerminateAllSessionsRequest) (*pb.TerminateAllSessionsResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	n := a.server.terminateAll("admin "+peerAddr(ctx), req.Reason, req.Lock)
+	return &pb.TerminateAllSessionsResponse{Terminated: int32(n)}, nil
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleKillSwitch closes every session and locks the server when the
+// process receives SIGUSR2
+func (s *Server) handleKillSwitch() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR2)
+
+	for range sigCh {
+		s.terminateAll("SIGUSR2", "", true)
+	}
+}
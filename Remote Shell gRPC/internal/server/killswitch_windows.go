@@ -0,0 +1,7 @@
+//go:build windows
+
+package server
+
+// handleKillSwitch is a no-op on Windows, which has no SIGUSR2; use the
+// TerminateAllSessions admin RPC instead
+func (s *Server) handleKillSwitch() {}
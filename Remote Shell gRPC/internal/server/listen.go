@@ -0,0 +1,323 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"remote-shell-rpc/pkg/netfilter"
+	"remote-shell-rpc/pkg/relay"
+	"remote-shell-rpc/pkg/systemd"
+	"remote-shell-rpc/pkg/tlsreload"
+)
+
+// ListenerConfig is an address the server serves on next to its host and
+// port, such as a Unix socket or an explicit IPv4 or IPv6 address
+type ListenerConfig struct {
+	// Network is "tcp", "tcp4", "tcp6" or "unix"
+	Network string    `yaml:"network"`
+	Address string    `yaml:"address"`
+	TLS     TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig secures a listener. Without a certificate the listener
+// accepts plaintext connections.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ACME gets the certificate from the server's ACME CA instead of
+	// files
+	ACME bool `yaml:"acme"`
+}
+
+// Enabled reports whether the listener uses TLS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.ACME
+}
+
+// certReloadInterval is how often certificate files are checked for
+// changes
+const certReloadInterval = 10 * time.Second
+
+// listenerTLS returns the TLS configuration of a listener. Certificates
+// from files are reloaded when the files change, see watchCertificates;
+// those from ACME are renewed by the ACME manager.
+func (s *Server) listenerTLS(t TLSConfig) (*tls.Config, error) {
+	if t.ACME {
+		m, err := s.acmeManager()
+		if err != nil {
+			return nil, err
+		}
+		// Answers TLS-ALPN-01 challenges on the listener itself
+		cfg := m.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+		return cfg, nil
+	}
+
+	certs, err := tlsreload.New(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s.certs = append(s.certs, certs)
+	return &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// watchCertificates reloads the listeners' certificate files when they
+// change until stop is closed. Connections that are already open keep
+// the certificate they were established with.
+func (s *Server) watchCertificates(stop <-chan struct{}) {
+	for _, certs := range s.certs {
+		certs := certs
+		go certs.Watch(certReloadInterval, stop, func(err error) {
+			if err != nil {
+				s.logger.Warn("Failed to reload TLS certificate", "cert_file", certs.CertFile(), "error", err.Error())
+				return
+			}
+			s.logger.Info("TLS certificate reloaded", "cert_file", certs.CertFile(), "expires", certs.Expires())
+		})
+	}
+}
+
+// listenerConfigs returns every address the server is configured to serve
+// on, the main host and port first
+func (c Config) listenerConfigs() []ListenerConfig {
+	main := ListenerConfig{
+		Network: "tcp",
+		Address: net.JoinHostPort(c.Host, fmt.Sprint(c.Port)),
+		TLS:     c.TLS,
+	}
+	return append([]ListenerConfig{main}, c.Listeners...)
+}
+
+// checkListener verifies a listener's network and address
+func checkListener(lc ListenerConfig) error {
+	switch lc.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("unsupported network %q", lc.Network)
+	}
+	if lc.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if lc.TLS.ACME && (lc.TLS.CertFile != "" || lc.TLS.KeyFile != "") {
+		return fmt.Errorf("tls uses either acme or certificate files")
+	}
+	return nil
+}
+
+// listen opens a listener, removing a stale Unix socket first
+func listen(lc ListenerConfig) (net.Listener, error) {
+	if lc.Network == "unix" {
+		stale, err := staleSocket(lc.Address)
+		if err != nil {
+			return nil, err
+		}
+		if stale {
+			os.Remove(lc.Address)
+		}
+	}
+	return net.Listen(lc.Network, lc.Address)
+}
+
+// staleSocket reports whether path is a Unix socket left behind by a
+// process that is gone. A socket something still listens on is an error.
+func staleSocket(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false, nil
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return true, nil
+	}
+	conn.Close()
+	return false, fmt.Errorf("%s is in use", path)
+}
+
+// openListeners opens every configured listener. Sockets passed by systemd
+// socket activation replace the main host and port and use its TLS
+// settings. TCP listeners are wrapped by the network filter and listeners
+// with TLS by secureListener.
+func (s *Server) openListeners() ([]net.Listener, error) {
+	var opened []net.Listener
+	closeAll := func() {
+		for _, l := range opened {
+			l.Close()
+		}
+	}
+
+	configs := s.config.listenerConfigs()
+	activated, err := systemd.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the sockets from systemd: %w", err)
+	}
+	if len(activated) > 0 {
+		s.logger.Info("Using sockets from systemd", "count", len(activated))
+	}
+
+	for i, lc := range configs {
+		if err := checkListener(lc); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("listener %s: %w", lc.Address, err)
+		}
+		var creds credentials.TransportCredentials
+		if lc.TLS.Enabled() {
+			tlsCfg, err := s.listenerTLS(lc.TLS)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("listener %s: %w", lc.Address, err)
+			}
+			creds = credentials.NewTLS(tlsCfg)
+		}
+
+		ls := activated
+		if i > 0 || len(activated) == 0 {
+			l, err := listen(lc)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("failed to listen on %s: %w", lc.Address, err)
+			}
+			ls = []net.Listener{l}
+		}
+		for _, l := range ls {
+			// Unix sockets have no address to filter by
+			if l.Addr().Network() == "tcp" {
+				l = netfilter.Listener(l, s.networks)
+			}
+			if creds != nil {
+				l = &secureListener{Listener: l, creds: creds}
+			}
+			opened = append(opened, l)
+		}
+	}
+	return opened, nil
+}
+
+// listenRelay registers the server with its relay. Tunnels get a TLS
+// handshake with the main listener's settings, so that the shell traffic
+// is encrypted end to end and the relay cannot read or change it.
+func (s *Server) listenRelay() (net.Listener, error) {
+	creds, err := s.config.relayCredentials()
+	if err != nil {
+		return nil, fmt.Errorf("relay %s: %w", s.config.RelayAddress, err)
+	}
+	var tunnelCreds credentials.TransportCredentials
+	if s.config.TLS.Enabled() {
+		tlsCfg, err := s.listenerTLS(s.config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("relay %s: %w", s.config.RelayAddress, err)
+		}
+		tunnelCreds = credentials.NewTLS(tlsCfg)
+	} else {
+		s.logger.Warn("Serving relay clients without TLS; the relay can read and change their traffic")
+	}
+
+	l, err := relay.Listen(s.config.RelayAddress, s.config.RelayName, s.config.RelayToken, creds, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	if tunnelCreds != nil {
+		return &secureListener{Listener: l, creds: tunnelCreds}, nil
+	}
+	return l, nil
+}
+
+// relayCredentials returns the transport security for connecting to the
+// relay: TLS unless plaintext is asked for, since the relay token is sent
+// along
+func (c Config) relayCredentials() (credentials.TransportCredentials, error) {
+	if c.RelayPlaintext {
+		return insecure.NewCredentials(), nil
+	}
+	tlsCfg := &tls.Config{
+		ServerName: c.RelayServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.RelayCAFile != "" {
+		pem, err := os.ReadFile(c.RelayCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.RelayCAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// listenerName describes a listener in logs
+func listenerName(l net.Listener) string {
+	name := l.Addr().Network() + ":" + l.Addr().String()
+	if _, ok := l.(*secureListener); ok {
+		name += " (tls)"
+	}
+	return name
+}
+
+// secureListener marks the connections it accepts for a TLS handshake
+type secureListener struct {
+	net.Listener
+	creds credentials.TransportCredentials
+}
+
+// Accept returns the next connection, marked with the listener's TLS
+// credentials
+func (l *secureListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &secureConn{Conn: conn, creds: l.creds}, nil
+}
+
+// secureConn is a connection accepted by a secureListener
+type secureConn struct {
+	net.Conn
+	creds credentials.TransportCredentials
+}
+
+// listenerCredentials lets one gRPC server serve plaintext and TLS
+// listeners: connections from a secureListener get a TLS handshake with
+// that listener's configuration, all others none
+type listenerCredentials struct{}
+
+// ServerHandshake implements credentials.TransportCredentials
+func (listenerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if sc, ok := conn.(*secureConn); ok {
+		return sc.creds.ServerHandshake(sc.Conn)
+	}
+	return insecure.NewCredentials().ServerHandshake(conn)
+}
+
+// ClientHandshake implements credentials.TransportCredentials; the server
+// never dials
+func (listenerCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("listener credentials are for servers only")
+}
+
+// Info implements credentials.TransportCredentials
+func (listenerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+// Clone implements credentials.TransportCredentials
+func (c listenerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+// OverrideServerName implements credentials.TransportCredentials
+func (listenerCredentials) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/relay"
+)
+
+// startRelay runs a plaintext relay on a random local port
+func startRelay(t *testing.T, token string) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer()
+	pb.RegisterRelayServiceServer(g, relay.NewServer(relay.Config{Token: token}, nil))
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	return lis.Addr().String()
+}
+
+// serveRelay registers s with the relay and serves the shell service to
+// the clients it tunnels
+func serveRelay(t *testing.T, s *Server) {
+	t.Helper()
+
+	l, err := s.listenRelay()
+	if err != nil {
+		t.Fatalf("listenRelay() error = %v", err)
+	}
+	g := grpc.NewServer(grpc.Creds(listenerCredentials{}))
+	pb.RegisterShellServiceServer(g, s)
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+}
+
+// dialRelay connects to the server registered with the relay as name
+func dialRelay(t *testing.T, address, name string, creds credentials.TransportCredentials) pb.ShellServiceClient {
+	t.Helper()
+
+	d, err := relay.NewDialer(address, nil)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	conn, err := grpc.NewClient("passthrough:///"+name,
+		grpc.WithContextDialer(d.DialContext),
+		grpc.WithTransportCredentials(creds),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShellServiceClient(conn)
+}
+
+// createSessionEventually creates a session, retrying while the server
+// registers with the relay
+func createSessionEventually(ctx context.Context, client pb.ShellServiceClient) error {
+	for {
+		_, err := client.CreateSession(ctx, &pb.CreateSessionRequest{ClientId: "client1"})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestListenRelay_TLS(t *testing.T) {
+	_, certPEM, keyPEM := selfSignedCert(t, "node1")
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	address := startRelay(t, "secret")
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.TLS = TLSConfig{CertFile: certFile, KeyFile: keyFile}
+		cfg.RelayAddress = address
+		cfg.RelayName = "node1"
+		cfg.RelayToken = "secret"
+		cfg.RelayPlaintext = true
+	})
+	serveRelay(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The server's certificate is checked end to end, through the relay
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	client := dialRelay(t, address, "node1", credentials.NewTLS(&tls.Config{RootCAs: pool}))
+	if err := createSessionEventually(ctx, client); err != nil {
+		t.Fatalf("CreateSession() over TLS through the relay error = %v", err)
+	}
+
+	// The tunnel does not offer plaintext
+	plainCtx, plainCancel := context.WithTimeout(ctx, time.Second)
+	defer plainCancel()
+	plain := dialRelay(t, address, "node1", insecure.NewCredentials())
+	if _, err := plain.CreateSession(plainCtx, &pb.CreateSessionRequest{ClientId: "client2"}); err == nil {
+		t.Error("CreateSession() in plaintext through the relay succeeded, want error")
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/oidc"
+	"remote-shell-rpc/pkg/sshauth"
+	pb "remote-shell-rpc/proto"
+)
+
+// loginMethods are the ShellService RPCs that need no login
+var loginMethods = map[string]bool{
+	pb.ShellService_GetLoginChallenge_FullMethodName: true,
+	pb.ShellService_Login_FullMethodName:             true,
+}
+
+// identityKey carries the identity of a logged-in client in the context
+// of its requests
+type identityKey struct{}
+
+// checkLoginConfig verifies that the authorized keys can be read
+func checkLoginConfig(logins *sshauth.Authenticator) error {
+	n, err := logins.CheckKeys()
+	if err != nil {
+		return fmt.Errorf("authorized keys: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("authorized keys: the file lists no keys")
+	}
+	return nil
+}
+
+// checkOIDC verifies the single sign-on settings. ID tokens are only
+// trusted from a provider reached over HTTPS, except on the local host.
+func checkOIDC(cfg Config) error {
+	u, err := url.Parse(cfg.OIDCIssuer)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("oidc issuer %q is not a URL", cfg.OIDCIssuer)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname())) {
+		return fmt.Errorf("oidc issuer %q must use https", cfg.OIDCIssuer)
+	}
+	if cfg.OIDCAudience == "" {
+		return fmt.Errorf("oidc audience is required with an issuer")
+	}
+	return nil
+}
+
+// isLoopback reports whether host names the local host
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// prepareSSO contacts the identity provider at startup so that a wrong
+// issuer is logged at once rather than at the first login
+func (s *Server) prepareSSO() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.sso.Prepare(ctx); err != nil {
+		s.logger.Warn("Failed to reach the single sign-on provider", "issuer", s.config.OIDCIssuer, "error", err.Error())
+	}
+}
+
+// GetLoginChallenge returns a challenge to sign with an SSH key
+func (s *Server) GetLoginChallenge(ctx context.Context, req *pb.GetLoginChallengeRequest) (*pb.GetLoginChallengeResponse, error) {
+	if s.logins == nil {
+		return nil, status.Error(codes.FailedPrecondition, "SSH key login is not enabled on this server")
+	}
+	challenge, expires, err := s.logins.Challenge()
+	if errors.Is(err, sshauth.ErrTooManyLogins) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create challenge: %v", err)
+	}
+	return &pb.GetLoginChallengeResponse{
+		Challenge:       challenge,
+		ExpiresAtUnixMs: expires.UnixMilli(),
+	}, nil
+}
+
+// Login checks a signed challenge and returns a login token
+func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	if s.logins == nil {
+		return nil, status.Error(codes.FailedPrecondition, "SSH key login is not enabled on this server")
+	}
+
+	clientAddr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		clientAddr = p.Addr.String()
+	}
+
+	sig := &ssh.Signature{Format: req.SignatureFormat, Blob: req.Signature}
+	token, login, err := s.logins.Login(req.PublicKey, req.Challenge, sig)
+	if err != nil {
+		s.authFailed(ctx)
+		s.logger.Warn("SSH key login failed", "client", clientAddr, "error", err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	s.logger.Info("SSH key login",
+		"client", clientAddr,
+		"fingerprint", login.Fingerprint,
+		"comment", login.Comment,
+	)
+	return &pb.LoginResponse{Token: token, Fingerprint: login.Fingerprint}, nil
+}
+
+// checkLogin requires a login on every request when the server requires
+// SSH key or single sign-on logins, except for logging in and the
+// AdminService, which has its own token. It returns the context with the
+// identity of the key or of the single sign-on user, which then owns the
+// client's sessions across logins.
+func (s *Server) checkLogin(ctx context.Context, method string) (context.Context, error) {
+	if (s.logins == nil && s.sso == nil) || loginMethods[method] || strings.HasPrefix(method, "/"+pb.AdminService_ServiceDesc.ServiceName+"/") {
+		return ctx, nil
+	}
+	token := bearerToken(ctx)
+	if s.logins != nil {
+		if login, ok := s.logins.Check(token); ok {
+			return context.WithValue(ctx, identityKey{}, "key:"+login.Fingerprint), nil
+		}
+	}
+	// Login tokens are opaque; ID tokens are JWTs
+	if s.sso != nil && strings.Count(token, ".") == 2 {
+		claims, err := s.sso.Verify(ctx, token)
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			s.logger.Warn("Single sign-on token refused", "client", peerAddr(ctx), "error", err.Error())
+			return ctx, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err != nil {
+			s.logger.Error("Failed to verify single sign-on token", "error", err.Error())
+			return ctx, status.Error(codes.Unavailable, "cannot verify the login: the identity provider is unreachable")
+		}
+		return context.WithValue(ctx, identityKey{}, "oidc:"+claims.Issuer+"#"+claims.Subject), nil
+	}
+
+	switch {
+	case s.logins == nil:
+		return ctx, status.Error(codes.Unauthenticated, "login required: log in with single sign-on")
+	case s.sso == nil:
+		return ctx, status.Error(codes.Unauthenticated, "login required: log in with an SSH key")
+	}
+	return ctx, status.Error(codes.Unauthenticated, "login required: log in with an SSH key or single sign-on")
+}
+
+// loginStream is a stream with the context of a logged-in client
+type loginStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context carrying the client's identity
+func (l *loginStream) Context() context.Context {
+	return l.ctx
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// macroPrefix marks a command as a macro invocation, e.g. "@diskcheck"
+const macroPrefix = "@"
+
+// expandMacro replaces a macro invocation in the request with the command
+// it stands for. Policy checks run afterwards, so they see the expansion.
+func (s *Server) expandMacro(sessionID string, req *pb.CommandRequest) error {
+	command := strings.TrimSpace(req.Command)
+	if len(req.Argv) > 0 || !strings.HasPrefix(command, macroPrefix) {
+		return nil
+	}
+
+	name := strings.TrimPrefix(command, macroPrefix)
+	expanded, ok := s.config.Macros[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "unknown macro %q", name)
+	}
+
+	s.logger.Info("Macro expanded",
+		"session_id", sessionID,
+		"macro", name,
+		"command", expanded,
+	)
+	req.Command = expanded
+	return nil
+}
+
+// ListMacros returns the macros defined in the server configuration
+func (s *Server) ListMacros(ctx context.Context, req *pb.ListMacrosRequest) (*pb.ListMacrosResponse, error) {
+	names := make([]string, 0, len(s.config.Macros))
+	for name := range s.config.Macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resp := &pb.ListMacrosResponse{
+		Macros: make([]*pb.Macro, 0, len(names)),
+	}
+	for _, name := range names {
+		resp.Macros = append(resp.Macros, &pb.Macro{
+			Name:    name,
+			Command: s.config.Macros[name],
+		})
+	}
+	return resp, nil
+}
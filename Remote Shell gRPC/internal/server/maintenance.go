@@ -0,0 +1,75 @@
+package server
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// defaultMaintenanceMessage is shown when neither the request nor the
+// configuration provides one
+const defaultMaintenanceMessage = "the server is in maintenance mode"
+
+// maintenanceState is the current maintenance mode of the server
+type maintenanceState struct {
+	enabled  bool
+	readOnly bool
+	message  string
+}
+
+// setMaintenance turns maintenance mode on or off
+func (s *Server) setMaintenance(enabled, readOnly bool, message string) maintenanceState {
+	if message == "" {
+		message = s.config.MaintenanceMessage
+	}
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	s.maintenance = maintenanceState{
+		enabled:  enabled,
+		readOnly: enabled && readOnly,
+		message:  message,
+	}
+	return s.maintenance
+}
+
+// maintenanceMode returns the current maintenance mode
+func (s *Server) maintenanceMode() maintenanceState {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	return s.maintenance
+}
+
+// checkNewSession rejects new sessions during maintenance. Clients that
+// resume their existing session are let through.
+func (s *Server) checkNewSession(clientID string) error {
+	m := s.maintenanceMode()
+	if !m.enabled {
+		return nil
+	}
+	if _, err := s.sessionManager.GetByClientID(clientID); err == nil {
+		return nil
+	}
+
+	st := status.New(codes.Unavailable, m.message)
+	if detailed, err := st.WithDetails(&pb.MaintenanceNotice{Message: m.message}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// checkWritable rejects commands and uploads while the server is in
+// read-only maintenance; reading files keeps working
+func (s *Server) checkWritable() error {
+	m := s.maintenanceMode()
+	if !m.readOnly {
+		return nil
+	}
+	return status.Error(codes.FailedPrecondition, fmt.Sprintf("server is read-only for maintenance: %s", m.message))
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// rpcMetrics counts requests per method since the server started
+type rpcMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodMetrics
+	panics  int64
+}
+
+// methodMetrics are the counters of a single method
+type methodMetrics struct {
+	calls    int64
+	failures int64
+	total    time.Duration
+}
+
+// newRPCMetrics creates empty counters
+func newRPCMetrics() *rpcMetrics {
+	return &rpcMetrics{methods: make(map[string]*methodMetrics)}
+}
+
+// record counts a finished request
+func (m *rpcMetrics) record(method string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mm, ok := m.methods[method]
+	if !ok {
+		mm = &methodMetrics{}
+		m.methods[method] = mm
+	}
+	mm.calls++
+	if err != nil {
+		mm.failures++
+	}
+	mm.total += duration
+}
+
+// recordPanic counts a panic recovered from a handler
+func (m *rpcMetrics) recordPanic() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.panics++
+}
+
+// panicCount returns the number of panics recovered so far
+func (m *rpcMetrics) panicCount() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.panics
+}
+
+// snapshot returns the counters of every method called so far, by name
+func (m *rpcMetrics) snapshot() []*pb.MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]*pb.MethodStats, 0, len(m.methods))
+	for method, mm := range m.methods {
+		stats = append(stats, &pb.MethodStats{
+			Method:      method,
+			Calls:       mm.calls,
+			Failures:    mm.failures,
+			TotalTimeMs: mm.total.Milliseconds(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Method < stats[j].Method })
+	return stats
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/session"
+)
+
+// findSession returns a session with errors as gRPC status errors,
+// redirecting clients of sessions that were moved to another server. It
+// does not check who owns the session.
+func (s *Server) findSession(id string) (*session.Session, error) {
+	sess, err := s.sessionManager.Get(id)
+	if err == nil {
+		return sess, nil
+	}
+	if err != session.ErrSessionNotFound {
+		return nil, status.Errorf(codes.Internal, "failed to get session: %v", err)
+	}
+
+	s.migrationMu.Lock()
+	address, moved := s.moved[id]
+	s.migrationMu.Unlock()
+	if moved {
+		return nil, movedError(address)
+	}
+	return nil, status.Error(codes.NotFound, "session not found")
+}
+
+// drainTarget returns the server new sessions are sent to while this node
+// drains, or "" when it is not draining
+func (s *Server) drainTarget() string {
+	s.migrationMu.Lock()
+	defer s.migrationMu.Unlock()
+	return s.draining
+}
+
+// movedError tells a client to reconnect to another server. The address is
+// attached as a SessionMoved status detail.
+func movedError(address string) error {
+	st := status.New(codes.Unavailable, fmt.Sprintf("session moved to %s", address))
+	if detailed, err := st.WithDetails(&pb.SessionMoved{Address: address}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// undrain makes the node accept new sessions again, returning the target
+// it was draining to
+func (s *Server) undrain() string {
+	s.migrationMu.Lock()
+	defer s.migrationMu.Unlock()
+	target := s.draining
+	s.draining = ""
+	return target
+}
+
+// drainCredentials returns the transport security for connecting to the
+// target of a drain: TLS unless plaintext is asked for, since the target's
+// admin token is sent along
+func drainCredentials(req *pb.DrainNodeRequest) (credentials.TransportCredentials, error) {
+	if req.TargetPlaintext {
+		return insecure.NewCredentials(), nil
+	}
+	tlsCfg := &tls.Config{ServerName: req.TargetServerName}
+	if len(req.TargetCa) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(req.TargetCa) {
+			return nil, fmt.Errorf("no certificates found in target_ca")
+		}
+		tlsCfg.RootCAs = pool
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// drain moves every session to the target server. The node stops
+// accepting new sessions first so that no session is left behind. When
+// some sessions cannot be moved the node accepts new sessions again, as it
+// keeps serving those.
+func (s *Server) drain(ctx context.Context, target, token string, creds credentials.TransportCredentials) (int, []string, error) {
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to connect to %s: %w", target, err)
+	}
+	defer conn.Close()
+
+	admin := pb.NewAdminServiceClient(conn)
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+
+	s.migrationMu.Lock()
+	s.draining = target
+	s.migrationMu.Unlock()
+
+	s.logger.Info("Draining sessions", "target", target, "sessions", s.sessionManager.Count())
+
+	migrated := 0
+	var failed []string
+	for _, sess := range s.sessionManager.List() {
+		state := sess.Snapshot()
+
+		importCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		_, err := admin.ImportSession(importCtx, &pb.ImportSessionRequest{
+			Session: stateToProto(state),
+		})
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to migrate session",
+				"session_id", state.ID,
+				"target", target,
+				"error", err.Error(),
+			)
+			failed = append(failed, fmt.Sprintf("%s: %s", state.ID, status.Convert(err).Message()))
+			continue
+		}
+
+		// Record the redirect before the session disappears so that
+		// clients never see it as missing
+		s.migrationMu.Lock()
+		s.moved[state.ID] = target
+		s.migrationMu.Unlock()
+		s.sessionManager.Delete(state.ID)
+		migrated++
+
+		s.logger.Info("Session migrated", "session_id", state.ID, "target", target)
+	}
+
+	if len(failed) > 0 {
+		s.undrain()
+		s.logger.Warn("Drain incomplete, accepting sessions again",
+			"target", target,
+			"migrated", migrated,
+			"failed", len(failed),
+		)
+	}
+	return migrated, failed, nil
+}
+
+// importSession recreates a session moved from another server
+func (s *Server) importSession(st *pb.SessionState) (*session.Session, error) {
+	shell, err := s.resolveShell(filepath.Base(st.Shell))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := session.Options{
+		Shell:          shell,
+		LoginShell:     st.LoginShell,
+		IsolateNetwork: s.config.IsolateNetwork,
+		Limits:         s.config.Limits,
+		Priority:       s.config.Priority,
+		InheritEnv:     s.config.InheritEnv,
+		Owner:          st.Owner,
+		Client:         clientInfo(st.ClientInfo),
+	}
+	if st.Umask != "" {
+		umask, err := executor.ParseUmask(st.Umask)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		opts.Umask = umask
+	}
+	if st.SourceInitScript {
+		if s.config.InitScript == "" {
+			return nil, status.Error(codes.FailedPrecondition, "no init script is configured on the server")
+		}
+		opts.InitScript = s.config.InitScript
+	}
+
+	state := session.State{
+		ID:          st.SessionId,
+		ClientID:    st.ClientId,
+		Options:     opts,
+		WorkingDir:  st.WorkingDir,
+		Environment: st.Env,
+		Rows:        st.Rows,
+		Cols:        st.Cols,
+	}
+	if st.CreatedAtUnixMs > 0 {
+		state.CreatedAt = time.UnixMilli(st.CreatedAtUnixMs)
+	}
+
+	sess, err := s.sessionManager.Import(state)
+	if err != nil {
+		switch err {
+		case session.ErrSessionExists:
+			return nil, status.Error(codes.AlreadyExists, "session already exists")
+		case session.ErrMaxSessions:
+			return nil, status.Error(codes.ResourceExhausted, "maximum sessions reached")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to import session: %v", err)
+	}
+
+	// A session may move back to a node it was drained from earlier
+	s.migrationMu.Lock()
+	delete(s.moved, sess.ID)
+	s.migrationMu.Unlock()
+
+	return sess, nil
+}
+
+// stateToProto converts a session snapshot for transfer
+func stateToProto(state session.State) *pb.SessionState {
+	return &pb.SessionState{
+		SessionId:        state.ID,
+		ClientId:         state.ClientID,
+		Shell:            state.Options.Shell,
+		LoginShell:       state.Options.LoginShell,
+		SourceInitScript: state.Options.InitScript != "",
+		WorkingDir:       state.WorkingDir,
+		Env:              state.Environment,
+		Rows:             state.Rows,
+		Cols:             state.Cols,
+		CreatedAtUnixMs:  state.CreatedAt.UnixMilli(),
+		Owner:            state.Options.Owner,
+		ClientInfo:       clientInfoProto(state.Options.Client),
+		Umask:            state.Options.Umask,
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// selfSignedCert creates a self-signed certificate for the given host
+// names and IP addresses, returning it with the PEM encodings of the
+// certificate and its key
+func selfSignedCert(t *testing.T, hosts ...string) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// startTarget serves the admin service of s over TLS with a self-signed
+// certificate for 127.0.0.1, returning its address and the certificate
+func startTarget(t *testing.T, s *Server) (string, []byte) {
+	t.Helper()
+	cert, certPEM, _ := selfSignedCert(t, "127.0.0.1")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	pb.RegisterAdminServiceServer(g, NewAdminServer(s))
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	return lis.Addr().String(), certPEM
+}
+
+// drainTo drains s to the target with the given request settings
+func drainTo(t *testing.T, s *Server, req *pb.DrainNodeRequest) (int, []string) {
+	t.Helper()
+	creds, err := drainCredentials(req)
+	if err != nil {
+		t.Fatalf("drainCredentials() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	migrated, failed, err := s.drain(ctx, req.TargetAddress, req.TargetToken, creds)
+	if err != nil {
+		t.Fatalf("drain() error = %v", err)
+	}
+	return migrated, failed
+}
+
+func TestDrain_MovesSessions(t *testing.T) {
+	source := newTestServer(t, nil)
+	target := newTestServer(t, func(cfg *Config) { cfg.AdminToken = "target-token" })
+	address, ca := startTarget(t, target)
+
+	owner := peerContext("192.0.2.1")
+	sessionID := createSession(t, source, owner, "client1")
+	sess, _ := source.sessionManager.Get(sessionID)
+	sess.SetEnv("STAGE", "test")
+
+	migrated, failed := drainTo(t, source, &pb.DrainNodeRequest{
+		TargetAddress: address,
+		TargetToken:   "target-token",
+		TargetCa:      ca,
+	})
+	if migrated != 1 || len(failed) != 0 {
+		t.Fatalf("drain() = %d, %v, want 1 migrated and none failed", migrated, failed)
+	}
+
+	moved, err := target.sessionManager.Get(sessionID)
+	if err != nil {
+		t.Fatalf("session was not imported: %v", err)
+	}
+	if moved.Options.Owner != "addr:192.0.2.1" {
+		t.Errorf("imported owner = %q, want %q", moved.Options.Owner, "addr:192.0.2.1")
+	}
+	if value, _ := moved.GetEnv("STAGE"); value != "test" {
+		t.Errorf("imported STAGE = %q, want %q", value, "test")
+	}
+
+	// Clients are sent to the target, for old and new sessions alike
+	_, err = source.ExecuteCommand(owner, &pb.CommandRequest{SessionId: sessionID, Command: "true"})
+	if !isMoved(err, address) {
+		t.Errorf("ExecuteCommand() on a moved session error = %v, want a redirect to %s", err, address)
+	}
+	_, err = source.CreateSession(owner, &pb.CreateSessionRequest{ClientId: "client2"})
+	if !isMoved(err, address) {
+		t.Errorf("CreateSession() while draining error = %v, want a redirect to %s", err, address)
+	}
+
+	if previous := source.undrain(); previous != address {
+		t.Errorf("undrain() = %q, want %q", previous, address)
+	}
+	createSession(t, source, owner, "client2")
+}
+
+func TestDrain_FailureAcceptsSessions(t *testing.T) {
+	target := newTestServer(t, func(cfg *Config) { cfg.AdminToken = "target-token" })
+	address, ca := startTarget(t, target)
+
+	tests := []struct {
+		name string
+		req  *pb.DrainNodeRequest
+	}{
+		{"wrong token", &pb.DrainNodeRequest{TargetAddress: address, TargetToken: "wrong", TargetCa: ca}},
+		// The token is not sent to a server that cannot be verified
+		{"unverified target", &pb.DrainNodeRequest{TargetAddress: address, TargetToken: "target-token"}},
+		{"plaintext to TLS", &pb.DrainNodeRequest{TargetAddress: address, TargetToken: "target-token", TargetPlaintext: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newTestServer(t, nil)
+			sessionID := createSession(t, source, peerContext("192.0.2.1"), "client1")
+
+			migrated, failed := drainTo(t, source, tt.req)
+			if migrated != 0 || len(failed) != 1 {
+				t.Fatalf("drain() = %d, %v, want 1 failed", migrated, failed)
+			}
+			if _, err := source.sessionManager.Get(sessionID); err != nil {
+				t.Errorf("session left the source: %v", err)
+			}
+			if target := source.drainTarget(); target != "" {
+				t.Errorf("drainTarget() = %q after a failed drain, want none", target)
+			}
+			createSession(t, source, peerContext("192.0.2.1"), "client2")
+		})
+	}
+	if target.sessionManager.Count() != 0 {
+		t.Errorf("target has %d sessions, want 0", target.sessionManager.Count())
+	}
+}
+
+func TestDrainCredentials_InvalidCA(t *testing.T) {
+	_, err := drainCredentials(&pb.DrainNodeRequest{TargetCa: []byte("not a certificate")})
+	if err == nil {
+		t.Error("drainCredentials() with an invalid CA succeeded, want error")
+	}
+}
+
+func TestImportSession(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) { cfg.MaxConnections = 1 })
+	state := &pb.SessionState{
+		SessionId:  "0123456789abcdef",
+		ClientId:   "client1",
+		Shell:      "/bin/sh",
+		WorkingDir: t.TempDir(),
+		Env:        map[string]string{"STAGE": "test"},
+		Owner:      "token:abc",
+	}
+
+	// A session that comes back clears its redirect
+	s.moved[state.SessionId] = "elsewhere:50051"
+	sess, err := s.importSession(state)
+	if err != nil {
+		t.Fatalf("importSession() error = %v", err)
+	}
+	t.Cleanup(func() { s.sessionManager.Delete(sess.ID) })
+	if sess.Options.Owner != "token:abc" || sess.GetWorkingDir() != state.WorkingDir {
+		t.Errorf("imported owner %q in %q, want %q in %q", sess.Options.Owner, sess.GetWorkingDir(), "token:abc", state.WorkingDir)
+	}
+	if _, err := s.findSession(state.SessionId); err != nil {
+		t.Errorf("findSession() error = %v", err)
+	}
+
+	if _, err := s.importSession(state); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("importSession() again error = %v, want AlreadyExists", err)
+	}
+	other := &pb.SessionState{SessionId: "fedcba9876543210", ClientId: "client2", Shell: "/bin/sh"}
+	if _, err := s.importSession(other); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("importSession() over the session limit error = %v, want ResourceExhausted", err)
+	}
+	other.Shell = "/bin/no-such-shell"
+	if _, err := s.importSession(other); err == nil {
+		t.Error("importSession() with a shell that is not allowed succeeded, want error")
+	}
+}
+
+// isMoved reports whether err redirects the client to address
+func isMoved(err error, address string) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return false
+	}
+	for _, detail := range st.Details() {
+		if moved, ok := detail.(*pb.SessionMoved); ok && moved.Address == address {
+			return true
+		}
+	}
+	return false
+}
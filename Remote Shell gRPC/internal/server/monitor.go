@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/monitor"
+)
+
+// healthState is the result of the latest self-monitoring sample
+type healthState struct {
+	sample monitor.Sample
+	// exceeded describes the limits the sample is over
+	exceeded []string
+}
+
+// thresholds returns the configured self-monitoring limits
+func (s *Server) thresholds() monitor.Thresholds {
+	return monitor.Thresholds{
+		Goroutines: s.config.MaxGoroutines,
+		HeapBytes:  uint64(s.config.MaxHeapMB) << 20,
+		OpenFDs:    s.config.MaxOpenFiles,
+	}
+}
+
+// runMonitor samples the server's resource usage until stop is closed
+func (s *Server) runMonitor(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.config.MonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.checkHealth()
+		}
+	}
+}
+
+// checkHealth takes a sample and records it. Warnings are logged for as
+// long as a limit is exceeded, so they keep showing up in the logs.
+func (s *Server) checkHealth() healthState {
+	sample := monitor.Read()
+	h := healthState{
+		sample:   sample,
+		exceeded: s.thresholds().Exceeded(sample),
+	}
+
+	s.healthMu.Lock()
+	wasOver := len(s.health.exceeded) > 0
+	s.health = h
+	s.healthMu.Unlock()
+
+	switch {
+	case len(h.exceeded) > 0:
+		s.logger.Warn("Resource usage above limits",
+			"exceeded", strings.Join(h.exceeded, ", "),
+			"refusing_commands", s.config.RefuseWhenOverloaded,
+		)
+	case wasOver:
+		s.logger.Info("Resource usage back within limits",
+			"goroutines", sample.Goroutines,
+			"heap_mb", sample.HeapBytes>>20,
+			"open_files", sample.OpenFDs,
+		)
+	default:
+		s.logger.Debug("Resource usage",
+			"goroutines", sample.Goroutines,
+			"heap_mb", sample.HeapBytes>>20,
+			"open_files", sample.OpenFDs,
+		)
+	}
+	return h
+}
+
+// checkOverload rejects new commands while the server is over its
+// resource limits and configured to refuse them
+func (s *Server) checkOverload() error {
+	if !s.config.RefuseWhenOverloaded {
+		return nil
+	}
+
+	s.healthMu.Lock()
+	exceeded := s.health.exceeded
+	s.healthMu.Unlock()
+
+	if len(exceeded) == 0 {
+		return nil
+	}
+	return status.Errorf(codes.ResourceExhausted, "server is overloaded (%s); try again later", strings.Join(exceeded, ", "))
+}
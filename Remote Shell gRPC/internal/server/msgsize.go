@@ -0,0 +1,64 @@
+package server
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"remote-shell-rpc/pkg/session"
+	pb "remote-shell-rpc/proto"
+)
+
+// Limits on the size of one gRPC message, in bytes
+const (
+	// DefaultMaxMsgSize is gRPC's own default for received messages
+	DefaultMaxMsgSize = 4 << 20
+	// MinMsgSize is the smallest limit accepted; file transfers send
+	// chunks of up to 32 KB
+	MinMsgSize = 64 * 1024
+)
+
+// msgOverhead is room left in a message for the fields besides the output
+// data
+const msgOverhead = 4 * 1024
+
+// ChunkLimit returns the most output data that fits in a message of at
+// most msgSize bytes
+func ChunkLimit(msgSize int) int {
+	return msgSize - msgOverhead
+}
+
+// msgLimit returns the largest message the server may send to a session's
+// client: the smaller of its own send limit and the receive limit the
+// client reported. Reports below MinMsgSize are ignored.
+func (s *Server) msgLimit(sess *session.Session) int {
+	limit := s.config.MaxSendMsgSize
+	if limit <= 0 {
+		limit = DefaultMaxMsgSize
+	}
+	if client := sess.Options.Client.MaxRecvMsgSize; client >= MinMsgSize && client < limit {
+		limit = client
+	}
+	return limit
+}
+
+// chunkSize returns the output chunk size for a session's streams, cut down
+// to fit the messages its client accepts
+func (s *Server) chunkSize(sess *session.Session) int {
+	size := s.config.ChunkSizeBytes
+	if limit := ChunkLimit(s.msgLimit(sess)); size > limit {
+		size = limit
+	}
+	return size
+}
+
+// checkResponseSize fails a unary response that is too large for a single
+// message, with a hint instead of gRPC's own error
+func (s *Server) checkResponseSize(sess *session.Session, resp *pb.CommandResponse) error {
+	limit := s.msgLimit(sess)
+	if size := proto.Size(resp); size > limit {
+		return status.Errorf(codes.ResourceExhausted,
+			"response of %d bytes exceeds the message size limit of %d bytes; use ExecuteCommandStream or output_file for large output", size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// checkNetwork rejects requests from addresses outside the allowed
+// networks. The listener already drops such connections; this also covers
+// any other way in. Clients reached through a relay have no address of
+// their own and are left to the relay.
+func (s *Server) checkNetwork(ctx context.Context) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil || p.Addr.Network() != "tcp" {
+		return nil
+	}
+	if s.networks.AllowedAddr(p.Addr) {
+		return nil
+	}
+	s.logger.Warn("Request from a refused network", "client", p.Addr.String())
+	return status.Error(codes.PermissionDenied, "connections from this address are not allowed")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/session"
+)
+
+// identity names the client making a request so that sessions can be bound
+// to it: the SSH key or single sign-on user it logged in with, else the
+// bearer token it sends, else the subject of its verified TLS certificate,
+// else its IP address.
+// Tokens are hashed so they are never kept or logged.
+func identity(ctx context.Context) string {
+	if id, ok := ctx.Value(identityKey{}).(string); ok {
+		return id
+	}
+	if token := bearerToken(ctx); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:8])
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+	if subject, ok := certSubject(p); ok {
+		return "cert:" + subject
+	}
+	return "addr:" + peerHost(p.Addr.String())
+}
+
+// certSubject returns the subject of the peer's verified TLS client
+// certificate
+func certSubject(p *peer.Peer) (string, bool) {
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return "", false
+	}
+	return tlsInfo.State.VerifiedChains[0][0].Subject.String(), true
+}
+
+// relayed reports whether a request came through a relay tunnel. Its
+// address names the tunnel, which is new for every connection.
+func relayed(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	return ok && p.Addr != nil && p.Addr.Network() == "relay"
+}
+
+// checkRelayIdentity refuses requests from relayed clients that have
+// nothing but their tunnel to identify them by, since they could never
+// get back to their sessions and anyone else could not be told apart
+// from them. Logging in is allowed, as it gives them an identity.
+func checkRelayIdentity(ctx context.Context, method string) error {
+	if !relayed(ctx) || loginMethods[method] || !strings.HasPrefix(identity(ctx), "addr:") {
+		return nil
+	}
+	return status.Error(codes.Unauthenticated,
+		"clients reaching the server through a relay must send a token, a client certificate or log in")
+}
+
+// bearerToken returns the token a request sends as
+// "authorization: Bearer <token>"
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok && token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// lookupSession returns the session for an RPC as a gRPC status error,
+// refusing clients other than the one that created it and the guests it
+// let run commands
+func (s *Server) lookupSession(ctx context.Context, id string) (*session.Session, error) {
+	sess, err := s.findSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAccess(ctx, sess, true); err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// checkOwner returns PermissionDenied unless the request comes from the
+// client that owns the session, and Unauthenticated unless it carries a
+// valid session token
+func (s *Server) checkOwner(ctx context.Context, sess *session.Session) error {
+	who := identity(ctx)
+	if sess.CheckOwner(who) == nil {
+		return s.checkSessionToken(ctx, sess, who)
+	}
+	return s.denySession(ctx, sess, who)
+}
+
+// checkAccess returns PermissionDenied unless the request comes from the
+// session's owner or a guest allowed to watch it, or with write to run
+// commands in it, and Unauthenticated unless it carries a valid session
+// token
+func (s *Server) checkAccess(ctx context.Context, sess *session.Session, write bool) error {
+	who := identity(ctx)
+	if sess.CheckAccess(who, write) == nil {
+		return s.checkSessionToken(ctx, sess, who)
+	}
+	return s.denySession(ctx, sess, who)
+}
+
+// denySession logs and counts a request for a session the caller may not
+// use
+func (s *Server) denySession(ctx context.Context, sess *session.Session, who string) error {
+	s.logger.Warn("Session used by another client",
+		"session_id", sess.ID,
+		"identity", who,
+	)
+	s.authFailed(ctx)
+	return status.Error(codes.PermissionDenied, "session belongs to another client")
+}
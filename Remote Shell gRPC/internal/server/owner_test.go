@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// tokenContext is the context of a request sending a bearer token
+func tokenContext(ip, token string) context.Context {
+	return metadata.NewIncomingContext(peerContext(ip), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// certContext is the context of a request over TLS with a verified client
+// certificate for the given common name
+func certContext(ip, name string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000},
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+		},
+	})
+}
+
+func TestSessionOwner_CrossClientAccess(t *testing.T) {
+	tests := []struct {
+		name  string
+		owner context.Context
+		other context.Context
+	}{
+		{"token", tokenContext("192.0.2.1", "token-a"), tokenContext("192.0.2.1", "token-b")},
+		{"cert", certContext("192.0.2.1", "alice"), certContext("192.0.2.1", "bob")},
+		{"address", peerContext("192.0.2.1"), peerContext("192.0.2.2")},
+		{"login", context.WithValue(peerContext("192.0.2.1"), identityKey{}, "key:a"), context.WithValue(peerContext("192.0.2.1"), identityKey{}, "key:b")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, nil)
+			sessionID := createSession(t, s, tt.owner, "client1")
+			req := &pb.CommandRequest{SessionId: sessionID, Command: "echo hi"}
+
+			if _, err := s.ExecuteCommand(tt.other, req); status.Code(err) != codes.PermissionDenied {
+				t.Errorf("ExecuteCommand() by another client error = %v, want PermissionDenied", err)
+			}
+			stream := newTestStream(tt.other)
+			if err := s.ExecuteCommandStream(req, stream); status.Code(err) != codes.PermissionDenied {
+				t.Errorf("ExecuteCommandStream() by another client error = %v, want PermissionDenied", err)
+			}
+			if out := stream.stdout(); out != "" {
+				t.Errorf("ExecuteCommandStream() by another client sent output %q", out)
+			}
+			closeReq := &pb.CloseSessionRequest{SessionId: sessionID}
+			if _, err := s.CloseSession(tt.other, closeReq); status.Code(err) != codes.PermissionDenied {
+				t.Errorf("CloseSession() by another client error = %v, want PermissionDenied", err)
+			}
+
+			// Taking over the client ID does not give access either
+			_, err := s.CreateSession(tt.other, &pb.CreateSessionRequest{ClientId: "client1"})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Errorf("CreateSession() with another client's ID error = %v, want PermissionDenied", err)
+			}
+
+			resp, err := s.ExecuteCommand(tt.owner, req)
+			if err != nil {
+				t.Fatalf("ExecuteCommand() by the owner error = %v", err)
+			}
+			if resp.Output != "hi\n" {
+				t.Errorf("ExecuteCommand() by the owner output = %q, want %q", resp.Output, "hi\n")
+			}
+			if _, err := s.CloseSession(tt.owner, closeReq); err != nil {
+				t.Errorf("CloseSession() by the owner error = %v", err)
+			}
+		})
+	}
+}
+
+// relayAddr is the address of a connection through a relay tunnel
+type relayAddr string
+
+func (a relayAddr) Network() string { return "relay" }
+func (a relayAddr) String() string  { return string(a) }
+
+// relayContext is the context of a request through the given relay tunnel
+func relayContext(tunnel string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: relayAddr("relay/" + tunnel)})
+}
+
+func TestSessionOwner_Relayed(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	// A tunnel alone does not identify a client
+	anonymous := relayContext("a1")
+	if _, err := s.authenticate(anonymous, pb.ShellService_CreateSession_FullMethodName); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("authenticate() of a relayed client without credentials error = %v, want Unauthenticated", err)
+	}
+	if _, err := s.authenticate(anonymous, pb.ShellService_GetLoginChallenge_FullMethodName); err != nil {
+		t.Errorf("authenticate() of a relayed login error = %v", err)
+	}
+
+	// A token identifies the client across tunnels
+	first := metadata.NewIncomingContext(relayContext("b1"), metadata.Pairs("authorization", "Bearer token-a"))
+	second := metadata.NewIncomingContext(relayContext("b2"), metadata.Pairs("authorization", "Bearer token-a"))
+	for _, ctx := range []context.Context{first, second} {
+		if _, err := s.authenticate(ctx, pb.ShellService_CreateSession_FullMethodName); err != nil {
+			t.Fatalf("authenticate() of a relayed client with a token error = %v", err)
+		}
+	}
+	sessionID := createSession(t, s, first, "client1")
+	resp, err := s.ExecuteCommand(second, &pb.CommandRequest{SessionId: sessionID, Command: "echo hi"})
+	if err != nil {
+		t.Fatalf("ExecuteCommand() through a new tunnel error = %v", err)
+	}
+	if resp.Output != "hi\n" {
+		t.Errorf("ExecuteCommand() output = %q, want %q", resp.Output, "hi\n")
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+)
+
+// maxPipelineStages bounds the processes a single pipeline may start
+const maxPipelineStages = 32
+
+// ExecutePipeline runs commands connected by pipes and reports every stage
+func (s *Server) ExecutePipeline(ctx context.Context, req *pb.PipelineRequest) (*pb.PipelineResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if len(req.Commands) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "commands are required")
+	}
+	if len(req.Commands) > maxPipelineStages {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d commands are allowed", maxPipelineStages)
+	}
+	for i, command := range req.Commands {
+		if strings.TrimSpace(command) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "command %d is empty", i+1)
+		}
+	}
+
+	sess, err := s.lookupSession(ctx, req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.checkOverload(); err != nil {
+		return nil, err
+	}
+
+	opts, err := commandOptions(sess, &pb.CommandRequest{
+		WorkingDir: req.WorkingDir,
+		Env:        req.Env,
+		Stdin:      req.Stdin,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Every stage is expanded and timed like the same command run alone
+	commands := make([]string, len(req.Commands))
+	opts.StageTimeouts = make([]time.Duration, len(req.Commands))
+	var timeout time.Duration
+	for i, command := range req.Commands {
+		stage := &pb.CommandRequest{Command: command, TimeoutSeconds: req.TimeoutSeconds}
+		if err := s.expandMacro(sess.ID, stage); err != nil {
+			return nil, err
+		}
+		commands[i] = stage.Command
+		opts.StageTimeouts[i] = s.commandTimeout(stage)
+		timeout = max(timeout, opts.StageTimeouts[i])
+	}
+
+	// The pipeline as a shell would show it, for approval and the audit log
+	display := strings.Join(commands, " | ")
+
+	// A confirmation holds a single command, so stages that would need
+	// one are refused. Strict mode only looks at what the client sent, as
+	// for single commands.
+	for i, command := range commands {
+		if err := s.checkStrict(sess, req.Commands[i]); err != nil {
+			return nil, status.Errorf(codes.PermissionDenied, "stage %d: %s", i+1, status.Convert(err).Message())
+		}
+		if _, err := s.checkDangerous(sess, command, false); err != nil {
+			return nil, status.Errorf(codes.PermissionDenied, "stage %d: %s", i+1, status.Convert(err).Message())
+		}
+	}
+
+	if s.requiresApproval(display) {
+		if err := s.awaitApproval(ctx, sess, display, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	release, _, err := s.waitTurn(ctx, sess, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := commandContext(ctx, sess, timeout)
+	defer cancel()
+
+	sess.UpdateActivity()
+
+	s.logger.Debug("Executing pipeline",
+		"session_id", req.SessionId,
+		"command", display,
+	)
+
+	start := time.Now()
+	result, err := sess.Executor.ExecutePipeline(ctx, commands, opts)
+	if result != nil {
+		var cpu time.Duration
+		for _, stage := range result.Stages {
+			cpu += stage.CPUTime
+		}
+		sess.RecordCommand(result.ExecutionTime, cpu)
+	}
+	if err != nil {
+		if err == executor.ErrCommandTimeout {
+			s.auditCommand(sess, display, start, -1, err.Error())
+			return nil, status.Error(codes.DeadlineExceeded, "command execution timeout")
+		}
+		if err == executor.ErrEmptyCommand {
+			return nil, status.Error(codes.InvalidArgument, "empty command")
+		}
+		if result == nil {
+			return nil, status.Errorf(codes.Internal, "failed to execute pipeline: %v", err)
+		}
+		s.logger.Warn("Pipeline execution failed",
+			"session_id", req.SessionId,
+			"command", display,
+			"error", err.Error(),
+		)
+	}
+
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	s.auditCommand(sess, display, start, result.ExitCode(), errText)
+
+	resp := &pb.PipelineResponse{
+		ExitCode:        int32(result.ExitCode()),
+		ExecutionTimeMs: result.ExecutionTime.Milliseconds(),
+		Stages:          make([]*pb.PipelineStage, 0, len(result.Stages)),
+	}
+	if output, ok := s.output.text([]byte(result.Output)); ok {
+		resp.Output = string(output)
+	} else {
+		resp.Binary = true
+		resp.BinaryOutput = []byte(result.Output)
+	}
+
+	sent := len(result.Output)
+	for _, stage := range result.Stages {
+		// Error output is diagnostic text, so it is never passed through
+		// as binary
+		stderr := strings.ToValidUTF8(stage.Error, "\uFFFD")
+		if text, ok := s.output.text([]byte(stage.Error)); ok {
+			stderr = string(text)
+		}
+		resp.Stages = append(resp.Stages, &pb.PipelineStage{
+			Command:         stage.Command,
+			ExitCode:        int32(stage.ExitCode),
+			Error:           stderr,
+			ExecutionTimeMs: stage.ExecutionTime.Milliseconds(),
+			CpuTimeMs:       stage.CPUTime.Milliseconds(),
+		})
+		sent += len(stage.Error)
+	}
+	sess.AddBytesStreamed(sent)
+	return resp, nil
+}
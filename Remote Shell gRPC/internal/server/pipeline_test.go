@@ -0,0 +1,54 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+func TestExecutePipeline_StageTimeout(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.CommandTimeout = time.Minute
+		cfg.TimeoutRules = []TimeoutRule{{Pattern: `^sleep`, Timeout: 200 * time.Millisecond}}
+	})
+	ctx := peerContext("192.0.2.1")
+	id := createSession(t, s, ctx, "client1")
+
+	start := time.Now()
+	_, err := s.ExecutePipeline(ctx, &pb.PipelineRequest{
+		SessionId: id,
+		Commands:  []string{"echo hi", "sleep 5"},
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("ExecutePipeline() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("ExecutePipeline() ran for %v, want the stage's own timeout", elapsed)
+	}
+}
+
+func TestExecutePipeline_Macro(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.Macros = map[string]string{"greet": "echo hello"}
+	})
+	ctx := peerContext("192.0.2.1")
+	id := createSession(t, s, ctx, "client1")
+
+	resp, err := s.ExecutePipeline(ctx, &pb.PipelineRequest{
+		SessionId: id,
+		Commands:  []string{"@greet", "tr a-z A-Z"},
+	})
+	if err != nil {
+		t.Fatalf("ExecutePipeline() error = %v", err)
+	}
+	if resp.Output != "HELLO\n" {
+		t.Errorf("ExecutePipeline() output = %q, want %q", resp.Output, "HELLO\n")
+	}
+	if resp.Stages[0].Command != "echo hello" {
+		t.Errorf("stage 1 command = %q, want the expanded macro", resp.Stages[0].Command)
+	}
+}
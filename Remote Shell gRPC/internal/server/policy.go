@@ -0,0 +1,77 @@
+package server
+
+import (
+	"regexp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/session"
+)
+
+// dangerousRule is a DangerousRule with its pattern compiled
+type dangerousRule struct {
+	re     *regexp.Regexp
+	action string
+}
+
+// compileDangerousRules compiles the configured rules, skipping invalid ones
+func (s *Server) compileDangerousRules(rules []DangerousRule) []dangerousRule {
+	compiled := make([]dangerousRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			s.logger.Error("Ignoring invalid pattern", "setting", "dangerous_rules", "pattern", rule.Pattern, "error", err.Error())
+			continue
+		}
+		compiled = append(compiled, dangerousRule{re: re, action: rule.Action})
+	}
+	return compiled
+}
+
+// dangerousAction returns the policy action for a command, or "" when the
+// command is not dangerous
+func (s *Server) dangerousAction(command string) string {
+	for _, rule := range s.dangerousRules {
+		if rule.re.MatchString(command) {
+			return rule.action
+		}
+	}
+	if executor.IsDangerousCommand(command) {
+		return s.config.DangerousAction
+	}
+	return ""
+}
+
+// checkDangerous applies the dangerous-command policy. It reports whether
+// the command must be held for confirmation, or returns an error when it is
+// blocked. Callers that cannot hold a command pass canConfirm false, which
+// blocks such commands instead.
+func (s *Server) checkDangerous(sess *session.Session, command string, canConfirm bool) (bool, error) {
+	action := s.dangerousAction(command)
+	switch {
+	case action == "":
+		return false, nil
+	case action == ActionAudit:
+		s.logger.Warn("Dangerous command allowed",
+			"session_id", sess.ID,
+			"command", command,
+		)
+		return false, nil
+	case action == ActionConfirm && canConfirm:
+		return true, nil
+	}
+
+	s.logger.Warn("Dangerous command blocked",
+		"session_id", sess.ID,
+		"command", command,
+	)
+	err := status.Error(codes.PermissionDenied, "dangerous command blocked")
+	if action == ActionConfirm {
+		err = status.Error(codes.PermissionDenied, "dangerous command needs confirmation; run it on its own")
+	}
+	s.auditRefusal(sess, command, audit.DecisionBlocked, "", err)
+	return false, err
+}
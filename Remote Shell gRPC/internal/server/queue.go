@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/session"
+	pb "remote-shell-rpc/proto"
+)
+
+// waitTurn waits until the session may run another command. While the
+// command is queued, queued, if set, is told its place in the queue. It
+// returns a function to call when the command is done and whether the
+// command had to wait.
+func (s *Server) waitTurn(ctx context.Context, sess *session.Session, queued func(*pb.CommandState) error) (func(), bool, error) {
+	if s.config.MaxCommandsPerSession <= 0 {
+		return func() {}, false, nil
+	}
+
+	release, waited, err := sess.WaitTurn(ctx, s.config.MaxCommandsPerSession, s.config.MaxQueuedPerSession, func(position int) error {
+		if queued == nil {
+			return nil
+		}
+		running, waiting := sess.QueuedCommands()
+		return queued(&pb.CommandState{
+			State:    pb.CommandState_QUEUED,
+			Position: int32(position),
+			Running:  int32(running),
+			Queued:   int32(waiting),
+			Message:  fmt.Sprintf("session busy, waiting for %d command(s) ahead", running+position-1),
+		})
+	})
+	if errors.Is(err, session.ErrSessionBusy) {
+		running, waiting := sess.QueuedCommands()
+		st := status.Newf(codes.ResourceExhausted,
+			"session busy: %d command(s) running and %d queued; try again later", running, waiting)
+		if detailed, err := st.WithDetails(&pb.CommandState{
+			State:   pb.CommandState_BUSY,
+			Running: int32(running),
+			Queued:  int32(waiting),
+		}); err == nil {
+			st = detailed
+		}
+		return nil, false, st.Err()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, false, err
+	}
+
+	if waited {
+		s.logger.Debug("Queued command started", "session_id", sess.ID)
+	}
+	return release, waited, nil
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/peer"
@@ -18,9 +23,22 @@ import (
 
 	pb "remote-shell-rpc/proto"
 
+	"remote-shell-rpc/pkg/approval"
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/ban"
 	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/files"
+	"remote-shell-rpc/pkg/format"
+	"remote-shell-rpc/pkg/idempotency"
+	"remote-shell-rpc/pkg/limit"
 	"remote-shell-rpc/pkg/logger"
+	"remote-shell-rpc/pkg/netfilter"
+	"remote-shell-rpc/pkg/oidc"
 	"remote-shell-rpc/pkg/session"
+	"remote-shell-rpc/pkg/sessiontoken"
+	"remote-shell-rpc/pkg/sshauth"
+	"remote-shell-rpc/pkg/systemd"
+	"remote-shell-rpc/pkg/tlsreload"
 )
 
 // Config holds server configuration
@@ -30,30 +48,307 @@ type Config struct {
 	MaxConnections int           `yaml:"max_connections"`
 	CommandTimeout time.Duration `yaml:"command_timeout"`
 	Shell          string        `yaml:"shell"`
+	// MaxStreamsPerClient caps the streams one client host keeps open at
+	// once; MaxConnections caps them across all clients. Zero disables
+	// the per-client limit.
+	MaxStreamsPerClient int `yaml:"max_streams_per_client"`
+	// MaxRecvMsgSize and MaxSendMsgSize cap the size of one gRPC message
+	// in bytes. Streamed output is chunked to fit the send limit and the
+	// limit each client reports.
+	MaxRecvMsgSize int `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize int `yaml:"max_send_msg_size"`
+	// Name identifies the server in the server ID it reports, together
+	// with a UUID generated at startup; empty uses the host name
+	Name string `yaml:"name"`
+	// AllowedNetworks and DeniedNetworks restrict the addresses clients
+	// may connect from, as CIDR networks or single addresses. A denied
+	// network always wins; with no allowed networks every other address
+	// may connect.
+	AllowedNetworks []string `yaml:"allowed_networks"`
+	DeniedNetworks  []string `yaml:"denied_networks"`
+	// AllowedShells lists the shell paths clients may pick per session;
+	// the default shell is always allowed
+	AllowedShells []string `yaml:"allowed_shells"`
+	// InitScript is a server-provided script that sessions may ask to
+	// source before each command
+	InitScript string `yaml:"init_script"`
+	// IsolateNetwork runs session commands in their own network
+	// namespace, so they can compute but not reach the network
+	IsolateNetwork bool `yaml:"isolate_network"`
+	// Limits are resource limits such as open files and processes set
+	// for every command
+	Limits executor.Limits `yaml:"limits"`
+	// Priority is the scheduling and IO priority commands run at unless
+	// an administrator changes it for a session
+	Priority executor.Priority `yaml:"priority"`
+	// InheritEnv selects the variables of the server's own environment
+	// that commands inherit; by default only PATH, HOME and LANG
+	InheritEnv executor.EnvPolicy `yaml:"inherit_env"`
+	// ChunkSizeBytes is the most streamed output sent in one message and
+	// FlushInterval how long output may be held back to fill one; zero
+	// sends whole lines as soon as they are read
+	ChunkSizeBytes int           `yaml:"chunk_size_bytes"`
+	FlushInterval  time.Duration `yaml:"flush_interval"`
+	// MaxCommandsPerSession caps the commands a session runs at once;
+	// further ones wait in a queue of up to MaxQueuedPerSession commands
+	// and clients are told their place in it. Zero disables the limit.
+	MaxCommandsPerSession int    `yaml:"max_commands_per_session"`
+	MaxQueuedPerSession   int    `yaml:"max_queued_per_session"`
+	AuditDriver           string `yaml:"audit_driver"`
+	AuditDSN              string `yaml:"audit_dsn"`
+	AdminToken            string `yaml:"admin_token"`
+	// DangerousAction decides what happens to dangerous commands:
+	// ActionBlock rejects them, ActionConfirm asks the user first and
+	// ActionAudit runs them but audits them with high severity
+	DangerousAction string        `yaml:"dangerous_action"`
+	ConfirmTimeout  time.Duration `yaml:"confirm_timeout"`
+	// DangerousRules are checked in order before the built-in list of
+	// dangerous commands; the first rule matching a command decides its
+	// action
+	DangerousRules []DangerousRule `yaml:"dangerous_rules"`
+	// ApprovalPatterns are regular expressions for restricted commands
+	// that need an administrator's approval before they run
+	ApprovalPatterns []string      `yaml:"approval_patterns"`
+	ApprovalTimeout  time.Duration `yaml:"approval_timeout"`
+	// OutputEncoding is the character set commands write in; output is
+	// transcoded to UTF-8. Empty means the output is already UTF-8.
+	OutputEncoding string `yaml:"output_encoding"`
+	// InvalidUTF8 decides what happens to output that is still not valid
+	// UTF-8: InvalidUTF8Replace or InvalidUTF8Binary
+	InvalidUTF8 string `yaml:"invalid_utf8"`
+	// RelayAddress, when set, registers the server with a relay under
+	// RelayName so that clients can reach it without inbound connections
+	RelayAddress string `yaml:"relay_address"`
+	RelayName    string `yaml:"relay_name"`
+	RelayToken   string `yaml:"relay_token"`
+	// The connection to the relay uses TLS, verified with RelayCAFile
+	// (the system roots when empty) against RelayServerName (the relay's
+	// host when empty), unless RelayPlaintext is set
+	RelayCAFile     string `yaml:"relay_ca_file"`
+	RelayServerName string `yaml:"relay_server_name"`
+	RelayPlaintext  bool   `yaml:"relay_plaintext"`
+	// AgentMode serves only through the relay and opens no listening
+	// port, for machines that cannot accept inbound connections
+	AgentMode bool `yaml:"agent_mode"`
+	// AllowSharing lets session owners invite other clients to watch
+	// their session or run commands in it
+	AllowSharing bool `yaml:"allow_sharing"`
+	// Macros are named commands clients run by sending "@name"
+	Macros map[string]string `yaml:"macros"`
+	// Banner is shown to clients when they log in
+	Banner string `yaml:"banner"`
+	// MaintenanceMessage is shown to clients turned away during
+	// maintenance when the admin does not give a message
+	MaintenanceMessage string `yaml:"maintenance_message"`
+	// MonitorInterval is how often the server samples its own goroutines,
+	// heap and open files; zero turns the monitor off
+	MonitorInterval time.Duration `yaml:"monitor_interval"`
+	// Limits above which warnings are logged; zero disables a limit
+	MaxGoroutines int `yaml:"max_goroutines"`
+	MaxHeapMB     int `yaml:"max_heap_mb"`
+	MaxOpenFiles  int `yaml:"max_open_files"`
+	// RefuseWhenOverloaded also rejects new commands while a limit is
+	// exceeded
+	RefuseWhenOverloaded bool `yaml:"refuse_when_overloaded"`
+	// BanMaxFailures failed authentication attempts from one host or
+	// authenticated identity within BanWindow ban it for BanDuration,
+	// doubling with every further ban up to BanMaxDuration; zero disables
+	// banning
+	BanMaxFailures int           `yaml:"ban_max_failures"`
+	BanWindow      time.Duration `yaml:"ban_window"`
+	BanDuration    time.Duration `yaml:"ban_duration"`
+	BanMaxDuration time.Duration `yaml:"ban_max_duration"`
+	// TLS secures Host and Port. Listeners are further addresses served
+	// next to them, such as a Unix socket or explicit IPv4 and IPv6
+	// addresses, each with its own TLS settings.
+	TLS       TLSConfig        `yaml:"tls"`
+	Listeners []ListenerConfig `yaml:"listeners"`
+	// ACMEDomains are the names certificates are obtained and renewed
+	// for, from Let's Encrypt or the CA at ACMEDirectoryURL, for
+	// listeners with tls.acme. ACMECacheDir keeps the account key and the
+	// certificates. ACMEHTTPAddress, when set, serves HTTP-01 challenges;
+	// otherwise the CA must reach a TLS listener on port 443.
+	ACMEDomains      []string `yaml:"acme_domains"`
+	ACMEEmail        string   `yaml:"acme_email"`
+	ACMECacheDir     string   `yaml:"acme_cache_dir"`
+	ACMEDirectoryURL string   `yaml:"acme_directory_url"`
+	ACMEHTTPAddress  string   `yaml:"acme_http_address"`
+	// AuthorizedKeysFile, when set, requires clients to log in with an
+	// SSH key listed in it before they can use the ShellService. Login
+	// tokens expire after LoginTokenTTL without use.
+	AuthorizedKeysFile string        `yaml:"authorized_keys_file"`
+	LoginTokenTTL      time.Duration `yaml:"login_token_ttl"`
+	// OIDCIssuer, when set, lets clients log in with single sign-on: they
+	// send an ID token issued by this OpenID Connect provider for
+	// OIDCAudience, usually the client ID the shell is registered with.
+	// With AuthorizedKeysFile set as well, either login is accepted.
+	OIDCIssuer   string `yaml:"oidc_issuer"`
+	OIDCAudience string `yaml:"oidc_audience"`
+	// SessionTokenTTL is how long the session tokens handed out by
+	// CreateSession are valid. Every request for a session must carry one
+	// and clients renew them before they expire. Zero disables them.
+	SessionTokenTTL time.Duration `yaml:"session_token_ttl"`
+	// IdempotencyTTL is how long the result of an ExecuteCommand call
+	// with an idempotency key is kept for retries, for at most
+	// IdempotencyKeys keys per session. Zero disables idempotency keys.
+	IdempotencyTTL  time.Duration `yaml:"idempotency_ttl"`
+	IdempotencyKeys int           `yaml:"idempotency_keys"`
+	// Interceptors turns the stages requests pass through on and off
+	Interceptors InterceptorConfig `yaml:"interceptors"`
+	// SoftTimeout warns the user, with its result, about a command that
+	// ran at least this long, before long jobs start hitting the hard
+	// timeout. Zero disables the warning.
+	SoftTimeout time.Duration `yaml:"soft_timeout"`
+	// KeepAliveInterval is how often ExecuteCommandWatched pings its
+	// client; past their timeout, watched commands run while the pings
+	// are answered, up to KeepAliveMax. A zero interval disables
+	// ExecuteCommandWatched.
+	KeepAliveInterval time.Duration `yaml:"keep_alive_interval"`
+	KeepAliveMax      time.Duration `yaml:"keep_alive_max"`
+	// TimeoutRules set the timeout of commands that do not ask for one;
+	// the first rule matching a command applies, and CommandTimeout to
+	// commands matching none
+	TimeoutRules []TimeoutRule `yaml:"timeout_rules"`
+	// Umask is the octal file mode creation mask of sessions that do not
+	// choose one, applied to their commands and uploaded files. Empty
+	// keeps the server's own for commands and the requested modes for
+	// uploads.
+	Umask string `yaml:"umask"`
+	// UploadResumeTTL is how long an interrupted upload with a transfer
+	// ID is kept for the client to resume. Zero discards interrupted
+	// uploads.
+	UploadResumeTTL time.Duration `yaml:"upload_resume_ttl"`
+	// StrictMode rejects commands that chain, substitute or redirect
+	// commands, for deployments that only want single commands run;
+	// pipes are still allowed. StrictAllow names the StrictConstructs
+	// that are allowed anyway.
+	StrictMode  bool     `yaml:"strict_mode"`
+	StrictAllow []string `yaml:"strict_allow"`
+}
+
+// Policy actions for dangerous commands
+const (
+	ActionBlock   = "block"
+	ActionConfirm = "confirm"
+	ActionAudit   = "audit"
+)
+
+// DangerousRule applies a policy action to commands matching a regular
+// expression
+type DangerousRule struct {
+	Pattern string `yaml:"pattern"`
+	Action  string `yaml:"action"`
+}
+
+// TimeoutRule sets the timeout of commands matching a regular expression
+type TimeoutRule struct {
+	Pattern string        `yaml:"pattern"`
+	Timeout time.Duration `yaml:"timeout"`
 }
 
 // DefaultConfig returns the default server configuration
 func DefaultConfig() Config {
 	return Config{
-		Host:           "0.0.0.0",
-		Port:           50051,
-		MaxConnections: 100,
-		CommandTimeout: 30 * time.Second,
-		Shell:          "/bin/bash",
+		Host:                "0.0.0.0",
+		Port:                50051,
+		MaxConnections:      100,
+		MaxStreamsPerClient: 10,
+		MaxRecvMsgSize:      DefaultMaxMsgSize,
+		MaxSendMsgSize:      DefaultMaxMsgSize,
+		CommandTimeout:      30 * time.Second,
+		KeepAliveInterval:   10 * time.Second,
+		KeepAliveMax:        24 * time.Hour,
+		ChunkSizeBytes:      executor.DefaultChunkSize,
+		MaxQueuedPerSession: 10,
+		Shell:               "/bin/bash",
+		DangerousAction:     ActionBlock,
+		ConfirmTimeout:      2 * time.Minute,
+		ApprovalTimeout:     10 * time.Minute,
+		InvalidUTF8:         InvalidUTF8Replace,
+		MonitorInterval:     30 * time.Second,
+		MaxGoroutines:       10000,
+		AllowSharing:        true,
+		BanMaxFailures:      10,
+		BanWindow:           time.Minute,
+		BanDuration:         time.Minute,
+		BanMaxDuration:      time.Hour,
+		LoginTokenTTL:       12 * time.Hour,
+		SessionTokenTTL:     15 * time.Minute,
+		IdempotencyTTL:      10 * time.Minute,
+		IdempotencyKeys:     100,
+		UploadResumeTTL:     10 * time.Minute,
+		InheritEnv:          executor.EnvPolicy{Baseline: executor.DefaultBaselineEnv, Deny: executor.DefaultDenyEnv},
+		Interceptors: InterceptorConfig{
+			Recovery:  true,
+			Metrics:   true,
+			Logging:   true,
+			Auth:      true,
+			RateLimit: true,
+		},
 	}
 }
 
+// outputStream is the server side of an RPC streaming command output
+type outputStream interface {
+	grpc.ServerStream
+	Send(*pb.CommandOutput) error
+}
+
 // Server represents the gRPC shell server
 type Server struct {
 	pb.UnimplementedShellServiceServer
 	config         Config
+	serverID       string
 	sessionManager *session.Manager
 	logger         *logger.Logger
 	grpcServer     *grpc.Server
+	audit          audit.Sink
+	approvals      *approval.Manager
+	output         *outputCodec
+	streams        *limit.Limiter
+	bans           *ban.Tracker
+	networks       *netfilter.Filter
+	networksErr    error
+	acme           *autocert.Manager
+	acmeHTTP       *http.Server
+	certs          []*tlsreload.Reloader
+	logins         *sshauth.Authenticator
+	sso            *oidc.Verifier
+	sessionTokens  *sessiontoken.Issuer
+	metrics        *rpcMetrics
+	idempotency    *idempotency.Cache
+	partials       *files.Partials
+
+	// Session migration state: the node sessions are drained to and where
+	// each moved session went
+	migrationMu sync.Mutex
+	draining    string
+	moved       map[string]string
+
+	maintenanceMu sync.Mutex
+	maintenance   maintenanceState
+
+	capacityMu sync.Mutex
+	capacity   capacityState
+
+	bannerMu sync.RWMutex
+	banner   string
+
+	healthMu sync.Mutex
+	health   healthState
+
+	// Administrators who approved commands that are about to run, by
+	// session and command, for the audit log
+	approvedMu sync.Mutex
+	approvedBy map[string]string
+
+	approvalPatterns []*regexp.Regexp
+	dangerousRules   []dangerousRule
+	timeoutRules     []timeoutRule
 }
 
 // New creates a new Server with the given configuration
-func New(cfg Config, log *logger.Logger) *Server {
+func New(cfg Config, log *logger.Logger) (*Server, error) {
 	if log == nil {
 		log = logger.Default()
 	}
@@ -62,37 +357,215 @@ func New(cfg Config, log *logger.Logger) *Server {
 		MaxSessions: cfg.MaxConnections,
 	}
 
-	return &Server{
+	s := &Server{
 		config:         cfg,
+		serverID:       newServerID(cfg.Name),
 		sessionManager: session.NewManager(sessionCfg),
 		logger:         log.WithComponent("server"),
+		audit:          audit.Nop(),
+		approvals:      approval.NewManager(),
+		moved:          make(map[string]string),
+		approvedBy:     make(map[string]string),
+		metrics:        newRPCMetrics(),
+		banner:         cfg.Banner,
+		streams:        limit.New(cfg.MaxConnections, cfg.MaxStreamsPerClient),
+		bans: ban.New(ban.Config{
+			MaxFailures: cfg.BanMaxFailures,
+			Window:      cfg.BanWindow,
+			Duration:    cfg.BanDuration,
+			MaxDuration: cfg.BanMaxDuration,
+		}),
+	}
+	s.capacity.configured = s.sessionManager.MaxSessions()
+	s.approvalPatterns = s.compilePatterns("approval_patterns", cfg.ApprovalPatterns)
+	s.dangerousRules = s.compileDangerousRules(cfg.DangerousRules)
+	s.timeoutRules = s.compileTimeoutRules(cfg.TimeoutRules)
+
+	output, err := newOutputCodec(cfg.OutputEncoding, cfg.InvalidUTF8)
+	if err != nil {
+		s.logger.Warn("Unknown output encoding, assuming UTF-8",
+			"encoding", cfg.OutputEncoding,
+			"error", err.Error(),
+		)
+		output, _ = newOutputCodec("", cfg.InvalidUTF8)
+	}
+	s.output = output
+
+	if cfg.IdempotencyTTL > 0 {
+		s.idempotency = idempotency.New(cfg.IdempotencyTTL, cfg.IdempotencyKeys)
+	}
+	if cfg.UploadResumeTTL > 0 {
+		s.partials = files.NewPartials(cfg.UploadResumeTTL)
+	}
+
+	s.networks, s.networksErr = netfilter.New(cfg.AllowedNetworks, cfg.DeniedNetworks)
+
+	if cfg.AuthorizedKeysFile != "" {
+		s.logins = sshauth.New(sshauth.Config{
+			AuthorizedKeysFile: cfg.AuthorizedKeysFile,
+			TokenTTL:           cfg.LoginTokenTTL,
+		})
+	}
+	if cfg.OIDCIssuer != "" {
+		s.sso = oidc.NewVerifier(cfg.OIDCIssuer, cfg.OIDCAudience)
 	}
+	if cfg.SessionTokenTTL > 0 {
+		// Without a key every session token check would pass
+		tokens, err := sessiontoken.New(cfg.SessionTokenTTL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create session token key: %w", err)
+		}
+		s.sessionTokens = tokens
+	}
+
+	return s, nil
 }
 
 // Start starts the gRPC server
 func (s *Server) Start() error {
-	address := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
-	listener, err := net.Listen("tcp", address)
+	if s.config.AgentMode && s.config.RelayAddress == "" {
+		return fmt.Errorf("agent mode requires a relay address")
+	}
+	// Refuse to start rather than accept clients from anywhere
+	if s.networksErr != nil {
+		return s.networksErr
+	}
+	// Refuse to start rather than let nobody, or anybody, log in
+	if s.logins != nil {
+		if err := checkLoginConfig(s.logins); err != nil {
+			return err
+		}
+		s.logger.Info("SSH key login required", "authorized_keys", s.config.AuthorizedKeysFile)
+	}
+	if s.sso != nil {
+		if err := checkOIDC(s.config); err != nil {
+			return err
+		}
+		s.logger.Info("Single sign-on login required", "issuer", s.config.OIDCIssuer, "audience", s.config.OIDCAudience)
+		go s.prepareSSO()
+	}
+	// Refuse to start rather than run commands with network access
+	if s.config.IsolateNetwork {
+		if err := executor.CheckNetworkIsolation(s.config.Shell); err != nil {
+			return err
+		}
+	}
+
+	// In agent mode the relay is the only way in
+	var listeners []net.Listener
+	if !s.config.AgentMode {
+		var err error
+		listeners, err = s.openListeners()
+		if err != nil {
+			return err
+		}
+	}
+	closeListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	sink, err := audit.Open(audit.Config{
+		Driver: s.config.AuditDriver,
+		DSN:    s.config.AuditDSN,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", address, err)
+		closeListeners()
+		return fmt.Errorf("failed to open audit sink: %w", err)
+	}
+	s.audit = sink
+	defer s.audit.Close()
+
+	if s.config.MonitorInterval > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.runMonitor(stop)
 	}
 
 	// Create gRPC server with interceptors
-	s.grpcServer = grpc.NewServer(
-		grpc.UnaryInterceptor(s.unaryInterceptor),
-		grpc.StreamInterceptor(s.streamInterceptor),
-	)
+	unary, stream := s.interceptors()
+	serverOpts := []grpc.ServerOption{
+		grpc.Creds(listenerCredentials{}),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+		// Share write buffers between connections instead of keeping one
+		// per connection
+		grpc.SharedWriteBuffer(true),
+	}
+	if s.config.MaxRecvMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(s.config.MaxRecvMsgSize))
+	}
+	if s.config.MaxSendMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxSendMsgSize(s.config.MaxSendMsgSize))
+	}
+	s.grpcServer = grpc.NewServer(serverOpts...)
 
 	// Register the shell service
 	pb.RegisterShellServiceServer(s.grpcServer, s)
 
-	s.logger.Info("Server starting", "address", address)
+	// The admin service is only exposed when an admin token is configured
+	if s.config.AdminToken != "" {
+		pb.RegisterAdminServiceServer(s.grpcServer, NewAdminServer(s))
+	}
+
+	// Also serve clients that reach us through a relay
+	var relayListener net.Listener
+	if s.config.RelayAddress != "" {
+		relayListener, err = s.listenRelay()
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		s.logger.Info("Serving through relay", "relay", s.config.RelayAddress, "name", s.config.RelayName)
+	}
+
+	s.startACME()
+	defer s.stopACME()
+	if len(s.certs) > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		s.watchCertificates(stop)
+	}
 
-	// Handle graceful shutdown
+	// Handle graceful shutdown and the kill switch
 	go s.handleShutdown()
+	go s.handleKillSwitch()
+
+	if len(listeners) == 0 {
+		s.logger.Info("Server starting in agent mode", "relay", s.config.RelayAddress, "name", s.config.RelayName, "server_id", s.serverID)
+		s.sdNotify("READY=1\nSTATUS=Serving through relay " + s.config.RelayAddress)
+		if err := s.grpcServer.Serve(relayListener); err != nil {
+			return fmt.Errorf("failed to serve: %w", err)
+		}
+		return nil
+	}
+
+	if relayListener != nil {
+		go func() {
+			if err := s.grpcServer.Serve(relayListener); err != nil {
+				s.logger.Error("Relay listener failed", "error", err.Error())
+			}
+		}()
+	}
+
+	names := make([]string, len(listeners))
+	for i, l := range listeners {
+		names[i] = listenerName(l)
+	}
+	for _, l := range listeners[1:] {
+		go func(l net.Listener) {
+			if err := s.grpcServer.Serve(l); err != nil {
+				s.logger.Error("Listener failed", "address", listenerName(l), "error", err.Error())
+			}
+		}(l)
+	}
+
+	s.logger.Info("Server starting", "addresses", strings.Join(names, ", "), "server_id", s.serverID)
+	s.sdNotify("READY=1\nSTATUS=Serving on " + strings.Join(names, ", "))
 
 	// Start serving
-	if err := s.grpcServer.Serve(listener); err != nil {
+	if err := s.grpcServer.Serve(listeners[0]); err != nil {
 		return fmt.Errorf("failed to serve: %w", err)
 	}
 
@@ -103,10 +576,19 @@ func (s *Server) Start() error {
 func (s *Server) Stop() {
 	if s.grpcServer != nil {
 		s.logger.Info("Stopping server gracefully")
+		s.sdNotify("STOPPING=1")
 		s.grpcServer.GracefulStop()
 	}
 }
 
+// sdNotify reports the server's state to systemd when it runs as a
+// notify service
+func (s *Server) sdNotify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		s.logger.Warn("Failed to notify systemd", "error", err.Error())
+	}
+}
+
 // handleShutdown handles OS signals for graceful shutdown
 func (s *Server) handleShutdown() {
 	sigCh := make(chan os.Signal, 1)
@@ -117,123 +599,202 @@ func (s *Server) handleShutdown() {
 	s.Stop()
 }
 
-// unaryInterceptor is a gRPC unary interceptor for logging and recovery
-func (s *Server) unaryInterceptor(
-	ctx context.Context,
-	req interface{},
-	info *grpc.UnaryServerInfo,
-	handler grpc.UnaryHandler,
-) (interface{}, error) {
-	start := time.Now()
+// acquireStream reserves a stream slot for a client. Clients are told
+// apart by host, so reconnecting from another port does not escape the
+// limit.
+func (s *Server) acquireStream(clientAddr string) (func(), error) {
+	host := peerHost(clientAddr)
+	release, err := s.streams.Acquire(host)
+	if err != nil {
+		max, maxPerClient := s.streams.Limits()
+		if err == limit.ErrKeyLimitReached {
+			return nil, status.Errorf(codes.ResourceExhausted,
+				"too many concurrent streams from %s (limit %d); wait for running commands to finish", host, maxPerClient)
+		}
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"server is at its limit of %d concurrent streams; try again later", max)
+	}
+	return release, nil
+}
 
-	// Get client address
-	clientAddr := "unknown"
-	if p, ok := peer.FromContext(ctx); ok {
-		clientAddr = p.Addr.String()
+// CreateSession creates a new shell session for a client
+func (s *Server) CreateSession(ctx context.Context, req *pb.CreateSessionRequest) (*pb.CreateSessionResponse, error) {
+	if req.ClientId == "" {
+		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+	}
+	if target := s.drainTarget(); target != "" {
+		return nil, movedError(target)
+	}
+	if err := s.checkNewSession(req.ClientId); err != nil {
+		return nil, err
 	}
 
-	s.logger.Debug("Request received",
-		"method", info.FullMethod,
-		"client", clientAddr,
-	)
+	shell, err := s.resolveShell(req.Shell)
+	if err != nil {
+		return nil, err
+	}
 
-	// Handle panic recovery
-	defer func() {
-		if r := recover(); r != nil {
-			s.logger.Error("Panic recovered", "method", info.FullMethod, "panic", r)
+	opts := session.Options{
+		Shell:          shell,
+		LoginShell:     req.LoginShell,
+		IsolateNetwork: s.config.IsolateNetwork,
+		Limits:         s.config.Limits,
+		Priority:       s.config.Priority,
+		InheritEnv:     s.config.InheritEnv,
+		Owner:          identity(ctx),
+		Client:         clientInfo(req.ClientInfo),
+		Umask:          s.config.Umask,
+	}
+	if req.Umask != "" {
+		umask, err := executor.ParseUmask(req.Umask)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-	}()
-
-	// Call the handler
-	resp, err := handler(ctx, req)
+		opts.Umask = umask
+	}
+	if req.SourceInitScript {
+		if s.config.InitScript == "" {
+			return nil, status.Error(codes.FailedPrecondition, "no init script is configured on the server")
+		}
+		opts.InitScript = s.config.InitScript
+	}
 
-	// Log completion
-	duration := time.Since(start)
+	sess, err := s.sessionManager.CreateWithOptions(req.ClientId, opts)
 	if err != nil {
-		s.logger.Warn("Request failed",
-			"method", info.FullMethod,
-			"duration", duration,
-			"error", err.Error(),
-		)
-	} else {
-		s.logger.Debug("Request completed",
-			"method", info.FullMethod,
-			"duration", duration,
-		)
+		if err == session.ErrMaxSessions {
+			return nil, status.Error(codes.ResourceExhausted, "maximum sessions reached")
+		}
+		if err == session.ErrNotOwner {
+			s.authFailed(ctx)
+			return nil, status.Error(codes.PermissionDenied, "client_id is in use by another client")
+		}
+		return nil, status.Errorf(codes.Internal, "failed to create session: %v", err)
+	}
+	if req.Rows > 0 && req.Cols > 0 {
+		sess.SetTerminalSize(req.Rows, req.Cols)
 	}
 
-	return resp, err
-}
+	client := sess.Options.Client
+	s.logger.Info("Session created",
+		"session_id", sess.ID,
+		"client_id", req.ClientId,
+		"shell", sess.Shell,
+		"client_os", client.OS,
+		"client_hostname", client.Hostname,
+		"client_version", client.Version,
+		"client_user", client.Username,
+	)
 
-// streamInterceptor is a gRPC stream interceptor for logging and recovery
-func (s *Server) streamInterceptor(
-	srv interface{},
-	ss grpc.ServerStream,
-	info *grpc.StreamServerInfo,
-	handler grpc.StreamHandler,
-) error {
-	start := time.Now()
+	auditCtx, cancel := auditContext()
+	defer cancel()
+	s.recordAudit("session start", s.audit.SessionStarted(auditCtx, audit.SessionRecord{
+		SessionID: sess.ID,
+		ClientID:  sess.ClientID,
+		PeerAddr:  peerAddr(ctx),
+		CreatedAt: sess.CreatedAt,
+
+		ClientOS:       client.OS,
+		ClientHostname: client.Hostname,
+		ClientVersion:  client.Version,
+		ClientUser:     client.Username,
+	}))
+
+	token, expires := s.issueSessionToken(sess, opts.Owner)
+	return &pb.CreateSessionResponse{
+		SessionId:                   sess.ID,
+		WorkingDirectory:            sess.WorkingDir,
+		Shell:                       sess.Shell,
+		Banner:                      s.currentBanner(),
+		SessionToken:                token,
+		SessionTokenExpiresAtUnixMs: expires,
+		ServerId:                    s.serverID,
+	}, nil
+}
 
-	// Get client address
-	clientAddr := "unknown"
-	if p, ok := peer.FromContext(ss.Context()); ok {
-		clientAddr = p.Addr.String()
+// resolveShell maps a requested shell name or path to an allowed shell path
+func (s *Server) resolveShell(requested string) (string, error) {
+	if requested == "" {
+		return s.config.Shell, nil
 	}
 
-	s.logger.Debug("Stream started",
-		"method", info.FullMethod,
-		"client", clientAddr,
-	)
-
-	// Handle panic recovery
-	defer func() {
-		if r := recover(); r != nil {
-			s.logger.Error("Panic recovered in stream", "method", info.FullMethod, "panic", r)
+	allowed := append([]string{s.config.Shell}, s.config.AllowedShells...)
+	for _, path := range allowed {
+		if requested == path || requested == filepath.Base(path) {
+			if _, err := os.Stat(path); err != nil {
+				return "", status.Errorf(codes.FailedPrecondition, "shell %s is not installed on the server", path)
+			}
+			return path, nil
 		}
-	}()
+	}
 
-	err := handler(srv, ss)
+	names := make([]string, 0, len(allowed))
+	for _, path := range allowed {
+		names = append(names, filepath.Base(path))
+	}
+	return "", status.Errorf(codes.InvalidArgument, "shell %q is not allowed (allowed: %s)", requested, strings.Join(names, ", "))
+}
+
+// Resize records a new client terminal size for a session
+func (s *Server) Resize(ctx context.Context, req *pb.ResizeRequest) (*pb.ResizeResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
 
-	duration := time.Since(start)
+	sess, err := s.lookupSession(ctx, req.SessionId)
 	if err != nil {
-		s.logger.Warn("Stream failed",
-			"method", info.FullMethod,
-			"duration", duration,
-			"error", err.Error(),
-		)
-	} else {
-		s.logger.Debug("Stream completed",
-			"method", info.FullMethod,
-			"duration", duration,
-		)
+		return nil, err
 	}
 
-	return err
+	sess.SetTerminalSize(req.Rows, req.Cols)
+	s.logger.Debug("Terminal resized", "session_id", sess.ID, "rows", req.Rows, "cols", req.Cols)
+
+	return &pb.ResizeResponse{Success: true}, nil
 }
 
-// CreateSession creates a new shell session for a client
-func (s *Server) CreateSession(ctx context.Context, req *pb.CreateSessionRequest) (*pb.CreateSessionResponse, error) {
-	if req.ClientId == "" {
-		return nil, status.Error(codes.InvalidArgument, "client_id is required")
+// GetSessionInfo returns the state of a session and the resources it has
+// used so far
+func (s *Server) GetSessionInfo(ctx context.Context, req *pb.GetSessionInfoRequest) (*pb.GetSessionInfoResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
 	}
 
-	sess, err := s.sessionManager.Create(req.ClientId)
+	// Read-only guests may look too
+	sess, err := s.findSession(req.SessionId)
 	if err != nil {
-		if err == session.ErrMaxSessions {
-			return nil, status.Error(codes.ResourceExhausted, "maximum sessions reached")
-		}
-		return nil, status.Errorf(codes.Internal, "failed to create session: %v", err)
+		return nil, err
+	}
+	if err := s.checkAccess(ctx, sess, false); err != nil {
+		return nil, err
 	}
 
-	s.logger.Info("Session created",
-		"session_id", sess.ID,
-		"client_id", req.ClientId,
-	)
+	info := sessionInfo(sess)
+	// The environment may hold secrets, so watchers do not get it
+	if sess.CheckAccess(identity(ctx), true) == nil {
+		info.Env = sess.Snapshot().Environment
+	}
+	return &pb.GetSessionInfoResponse{Session: info}, nil
+}
 
-	return &pb.CreateSessionResponse{
-		SessionId:        sess.ID,
-		WorkingDirectory: sess.WorkingDir,
-	}, nil
+// sessionInfo describes a session for GetSessionInfo and ListSessions
+func sessionInfo(sess *session.Session) *pb.SessionInfo {
+	stats := sess.Stats()
+	priority := sess.Executor.GetPriority()
+	return &pb.SessionInfo{
+		SessionId:          sess.ID,
+		ClientId:           sess.ClientID,
+		Shell:              sess.Shell,
+		WorkingDir:         sess.GetWorkingDir(),
+		CreatedAtUnixMs:    sess.CreatedAt.UnixMilli(),
+		LastActivityUnixMs: sess.GetLastActivity().UnixMilli(),
+		Commands:           stats.Commands,
+		WallTimeMs:         stats.WallTime.Milliseconds(),
+		CpuTimeMs:          stats.CPUTime.Milliseconds(),
+		BytesStreamed:      stats.BytesStreamed,
+		Nice:               int32(priority.Nice),
+		IoClass:            priority.IOClass,
+		IoLevel:            int32(priority.IOLevel),
+		ClientInfo:         clientInfoProto(sess.Options.Client),
+	}
 }
 
 // CloseSession terminates an existing shell session
@@ -242,15 +803,20 @@ func (s *Server) CloseSession(ctx context.Context, req *pb.CloseSessionRequest)
 		return nil, status.Error(codes.InvalidArgument, "session_id is required")
 	}
 
-	err := s.sessionManager.Delete(req.SessionId)
+	sess, err := s.sessionManager.Get(req.SessionId)
+	if err == nil {
+		if err := s.checkOwner(ctx, sess); err != nil {
+			return nil, err
+		}
+		err = s.sessionManager.Delete(req.SessionId)
+	}
 	if err != nil {
 		if err == session.ErrSessionNotFound {
 			return nil, status.Error(codes.NotFound, "session not found")
 		}
 		return nil, status.Errorf(codes.Internal, "failed to close session: %v", err)
 	}
-
-	s.logger.Info("Session closed", "session_id", req.SessionId)
+	s.sessionClosed(sess)
 
 	return &pb.CloseSessionResponse{
 		Success: true,
@@ -258,41 +824,129 @@ func (s *Server) CloseSession(ctx context.Context, req *pb.CloseSessionRequest)
 	}, nil
 }
 
+// sessionClosed logs the totals of a session that was removed and writes
+// them to its audit record
+func (s *Server) sessionClosed(sess *session.Session) {
+	stats := sess.Stats()
+	s.logger.Info("Session closed",
+		"session_id", sess.ID,
+		"commands", stats.Commands,
+		"wall_time", format.Duration(stats.WallTime),
+		"cpu_time", format.Duration(stats.CPUTime),
+		"bytes_streamed", format.Bytes(stats.BytesStreamed),
+	)
+	if s.idempotency != nil {
+		s.idempotency.Forget(sess.ID)
+	}
+
+	auditCtx, cancel := auditContext()
+	defer cancel()
+	s.recordAudit("session close", s.audit.SessionClosed(auditCtx, audit.SessionRecord{
+		SessionID:     sess.ID,
+		ClientID:      sess.ClientID,
+		ClosedAt:      time.Now(),
+		Commands:      stats.Commands,
+		WallTime:      stats.WallTime,
+		CPUTime:       stats.CPUTime,
+		BytesStreamed: stats.BytesStreamed,
+	}))
+}
+
 // ExecuteCommand runs a command and returns the complete result
 func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*pb.CommandResponse, error) {
 	if req.SessionId == "" {
 		return nil, status.Error(codes.InvalidArgument, "session_id is required")
 	}
-	if req.Command == "" {
-		return nil, status.Error(codes.InvalidArgument, "command is required")
+	if err := requestCommand(req); err != nil {
+		return nil, err
 	}
 
 	// Get session
-	sess, err := s.sessionManager.Get(req.SessionId)
+	sess, err := s.lookupSession(ctx, req.SessionId)
 	if err != nil {
-		if err == session.ErrSessionNotFound {
-			return nil, status.Error(codes.NotFound, "session not found")
-		}
-		return nil, status.Errorf(codes.Internal, "failed to get session: %v", err)
+		return nil, err
+	}
+	var resp *pb.CommandResponse
+	if req.IdempotencyKey != "" && s.idempotency != nil {
+		resp, err = s.executeOnce(ctx, sess, req)
+	} else {
+		resp, err = s.executeCommand(ctx, sess, req)
+	}
+	if err == nil {
+		err = s.checkResponseSize(sess, resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// executeCommand runs a command in a session once its request is validated
+func (s *Server) executeCommand(ctx context.Context, sess *session.Session, req *pb.CommandRequest) (*pb.CommandResponse, error) {
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := s.checkOverload(); err != nil {
+		return nil, err
+	}
+
+	// Macros are the administrator's own commands, so strict mode only
+	// looks at what the client sent
+	if err := s.checkStrict(sess, req.Command); err != nil {
+		return nil, err
+	}
+	if err := s.expandMacro(sess.ID, req); err != nil {
+		return nil, err
+	}
+	globs, err := s.expandGlobs(sess, req)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := commandOptions(sess, req)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check for dangerous commands
-	if executor.IsDangerousCommand(req.Command) {
-		return nil, status.Error(codes.PermissionDenied, "dangerous command blocked")
+	hold, err := s.checkDangerous(sess, req.Command, true)
+	if err != nil {
+		return nil, err
+	}
+	if hold {
+		challenge, err := s.holdForConfirmation(sess, req)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.CommandResponse{Confirmation: challenge, ExitCode: -1}, nil
 	}
 
-	// Handle special commands
-	if handled, response := s.handleSpecialCommand(sess, req.Command); handled {
-		return response, nil
+	// Restricted commands wait for an administrator
+	if s.requiresApproval(req.Command) {
+		if err := s.awaitApproval(ctx, sess, req.Command, nil); err != nil {
+			return nil, err
+		}
 	}
 
-	// Set timeout
-	timeout := s.config.CommandTimeout
-	if req.TimeoutSeconds > 0 {
-		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	resp, err := s.runCommand(ctx, sess, req, opts)
+	if resp != nil {
+		resp.Globs = globs
 	}
+	return resp, err
+}
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+// execCommand executes an already validated command and returns the
+// complete result
+func (s *Server) execCommand(ctx context.Context, sess *session.Session, req *pb.CommandRequest, opts executor.Options) (*pb.CommandResponse, error) {
+	// Handle special commands
+	if handled, response := s.handleSpecialCommand(sess, req); handled {
+		sess.RecordCommand(0, 0)
+		s.auditCommand(sess, req.Command, time.Now(), int(response.ExitCode), response.Error)
+		response.WorkingDir = sess.GetWorkingDir()
+		return response, nil
+	}
+
+	ctx, cancel := commandContext(ctx, sess, s.commandTimeout(req))
 	defer cancel()
 
 	sess.UpdateActivity()
@@ -302,15 +956,35 @@ func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*p
 		"command", req.Command,
 	)
 
+	if req.OutputFile != "" {
+		return s.executeCaptured(ctx, sess, req, opts)
+	}
+
 	// Execute command
-	result, err := sess.Executor.Execute(ctx, req.Command)
+	start := time.Now()
+	result, err := sess.Executor.ExecuteWithOptions(ctx, req.Command, opts)
+	if result != nil {
+		sess.RecordCommand(result.ExecutionTime, result.CPUTime)
+	}
 	if err != nil {
 		if err == executor.ErrCommandTimeout {
-			return nil, status.Error(codes.DeadlineExceeded, "command execution timeout")
+			s.auditCommand(sess, req.Command, start, -1, err.Error())
+			return nil, s.partialResult(sess, result, -1,
+				status.New(codes.DeadlineExceeded, "command execution timeout"))
+		}
+		if err == executor.ErrCPULimitExceeded {
+			s.auditCommand(sess, req.Command, start, result.ExitCode, err.Error())
+			return nil, s.partialResult(sess, result, result.ExitCode,
+				status.Newf(codes.ResourceExhausted, "cpu time limit of %ds exceeded", s.config.Limits.CPUSeconds))
 		}
 		if err == executor.ErrEmptyCommand {
 			return nil, status.Error(codes.InvalidArgument, "empty command")
 		}
+		if err == executor.ErrCommandKilled && sess.Context().Err() != nil {
+			s.auditCommand(sess, req.Command, start, -1, err.Error())
+			return nil, s.partialResult(sess, result, -1,
+				status.New(codes.Aborted, "command killed: the session was closed"))
+		}
 		s.logger.Warn("Command execution failed",
 			"session_id", req.SessionId,
 			"command", req.Command,
@@ -318,56 +992,174 @@ func (s *Server) ExecuteCommand(ctx context.Context, req *pb.CommandRequest) (*p
 		)
 	}
 
-	return &pb.CommandResponse{
-		Output:          result.Output,
-		Error:           result.Error,
-		ExitCode:        int32(result.ExitCode),
-		ExecutionTimeMs: result.ExecutionTime.Milliseconds(),
-	}, nil
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+	s.auditCommand(sess, req.Command, start, result.ExitCode, errText)
+	return s.commandResponse(sess, result, result.ExitCode), nil
+}
+
+// commandResponse converts the result of a command into a response
+func (s *Server) commandResponse(sess *session.Session, result *executor.Result, exitCode int) *pb.CommandResponse {
+	finished := time.Now()
+	resp := &pb.CommandResponse{
+		ExitCode:         int32(exitCode),
+		ExecutionTimeMs:  result.ExecutionTime.Milliseconds(),
+		WorkingDir:       sess.GetWorkingDir(),
+		StartedAtUnixMs:  finished.Add(-result.ExecutionTime).UnixMilli(),
+		FinishedAtUnixMs: finished.UnixMilli(),
+	}
+	stdout, stdoutOK := s.output.text([]byte(result.Output))
+	stderr, stderrOK := s.output.text([]byte(result.Error))
+	if stdoutOK && stderrOK {
+		resp.Output = string(stdout)
+		resp.Error = string(stderr)
+	} else {
+		resp.Binary = true
+		resp.BinaryOutput = []byte(result.Output)
+		resp.BinaryError = []byte(result.Error)
+	}
+	sess.AddBytesStreamed(len(result.Output) + len(result.Error))
+	return resp
+}
+
+// partialOutputLimit caps each output stream attached to an error, which
+// travels in the response trailers
+const partialOutputLimit = 64 * 1024
+
+// partialResult attaches the output a command produced before it failed to
+// the error, so that clients can show what happened up to that point. Only
+// the end of long output is kept.
+func (s *Server) partialResult(sess *session.Session, result *executor.Result, exitCode int, st *status.Status) error {
+	if result == nil {
+		return st.Err()
+	}
+	partial := *result
+	partial.Output = outputTail(partial.Output, partialOutputLimit)
+	partial.Error = outputTail(partial.Error, partialOutputLimit)
+	if detailed, err := st.WithDetails(s.commandResponse(sess, &partial, exitCode)); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// outputTail returns the last max bytes of output, starting at a whole
+// UTF-8 character where possible
+func outputTail(output string, max int) string {
+	if len(output) <= max {
+		return output
+	}
+	start := len(output) - max
+	for i := 0; i < utf8.UTFMax && start+i < len(output); i++ {
+		if utf8.RuneStart(output[start+i]) {
+			return output[start+i:]
+		}
+	}
+	return output[start:]
 }
 
 // ExecuteCommandStream runs a command and streams the output
 func (s *Server) ExecuteCommandStream(req *pb.CommandRequest, stream pb.ShellService_ExecuteCommandStreamServer) error {
+	return s.executeCommandStream(req, stream)
+}
+
+// executeCommandStream validates a command and streams its output
+func (s *Server) executeCommandStream(req *pb.CommandRequest, stream outputStream) error {
 	if req.SessionId == "" {
 		return status.Error(codes.InvalidArgument, "session_id is required")
 	}
-	if req.Command == "" {
-		return status.Error(codes.InvalidArgument, "command is required")
+	if err := requestCommand(req); err != nil {
+		return err
 	}
 
 	// Get session
-	sess, err := s.sessionManager.Get(req.SessionId)
+	sess, err := s.lookupSession(stream.Context(), req.SessionId)
 	if err != nil {
-		if err == session.ErrSessionNotFound {
-			return status.Error(codes.NotFound, "session not found")
-		}
-		return status.Errorf(codes.Internal, "failed to get session: %v", err)
+		return err
+	}
+	out := &sequencedStream{outputStream: stream}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if err := s.checkOverload(); err != nil {
+		return err
+	}
+
+	// Macros are the administrator's own commands, so strict mode only
+	// looks at what the client sent
+	if err := s.checkStrict(sess, req.Command); err != nil {
+		return err
+	}
+	if err := s.expandMacro(sess.ID, req); err != nil {
+		return err
+	}
+	globs, err := s.expandGlobs(sess, req)
+	if err != nil {
+		return err
 	}
 
 	// Check for dangerous commands
-	if executor.IsDangerousCommand(req.Command) {
-		return status.Error(codes.PermissionDenied, "dangerous command blocked")
+	hold, err := s.checkDangerous(sess, req.Command, true)
+	if err != nil {
+		return err
+	}
+	if hold {
+		challenge, err := s.holdForConfirmation(sess, req)
+		if err != nil {
+			return err
+		}
+		return out.Send(&pb.CommandOutput{
+			Confirmation: challenge,
+			IsComplete:   true,
+			ExitCode:     -1,
+		})
+	}
+
+	// Restricted commands wait for an administrator
+	if err := s.awaitApprovalStream(sess, req.Command, out); err != nil {
+		return err
+	}
+
+	if len(globs) > 0 {
+		return s.runCommandStream(sess, req, &globStream{outputStream: out, globs: globs})
 	}
+	return s.runCommandStream(sess, req, out)
+}
+
+// streamCommand executes an already validated command and streams its
+// output
+func (s *Server) streamCommand(sess *session.Session, req *pb.CommandRequest, stream outputStream) error {
+	opts, err := commandOptions(sess, req)
+	if err != nil {
+		return err
+	}
+	opts.ChunkSizeBytes = s.chunkSize(sess)
+	opts.FlushInterval = s.config.FlushInterval
 
 	// Handle special commands
-	if handled, response := s.handleSpecialCommand(sess, req.Command); handled {
+	if handled, response := s.handleSpecialCommand(sess, req); handled {
+		sess.RecordCommand(0, 0)
+		s.auditCommand(sess, req.Command, time.Now(), int(response.ExitCode), response.Error)
+
 		// Send as stream output
 		output := &pb.CommandOutput{
 			Type:       pb.CommandOutput_STDOUT,
 			Data:       []byte(response.Output),
 			IsComplete: true,
 			ExitCode:   response.ExitCode,
+			WorkingDir: sess.GetWorkingDir(),
+			ChangedEnv: response.ChangedEnv,
 		}
 		return stream.Send(output)
 	}
 
-	// Set timeout
-	timeout := s.config.CommandTimeout
-	if req.TimeoutSeconds > 0 {
-		timeout = time.Duration(req.TimeoutSeconds) * time.Second
+	timeout := s.commandTimeout(req)
+	if keptAlive(stream.Context()) {
+		// The watchdog enforces the timeout while pings are answered
+		timeout = max(timeout, s.config.KeepAliveMax)
 	}
-
-	ctx, cancel := context.WithTimeout(stream.Context(), timeout)
+	ctx, cancel := commandContext(stream.Context(), sess, timeout)
 	defer cancel()
 
 	sess.UpdateActivity()
@@ -377,8 +1169,13 @@ func (s *Server) ExecuteCommandStream(req *pb.CommandRequest, stream pb.ShellSer
 		"command", req.Command,
 	)
 
+	if req.OutputFile != "" {
+		return s.streamCaptured(ctx, sess, req, opts, stream)
+	}
+
 	// Execute command with streaming
-	outputCh, err := sess.Executor.ExecuteStream(ctx, req.Command)
+	start := time.Now()
+	outputCh, err := sess.Executor.ExecuteStreamWithOptions(ctx, req.Command, opts)
 	if err != nil {
 		if err == executor.ErrEmptyCommand {
 			return status.Error(codes.InvalidArgument, "empty command")
@@ -386,23 +1183,53 @@ func (s *Server) ExecuteCommandStream(req *pb.CommandRequest, stream pb.ShellSer
 		return status.Errorf(codes.Internal, "failed to execute command: %v", err)
 	}
 
-	// Stream output to client
+	// Record the command once streaming finishes; a missing completion
+	// message means the command was cut short
+	exitCode := -1
+	var cpu time.Duration
+	errText := ""
+	defer func() {
+		sess.RecordCommand(time.Since(start), cpu)
+		s.auditCommand(sess, req.Command, start, exitCode, errText)
+	}()
+
+	// Stream output to client. One message is reused for every chunk:
+	// Send marshals it before returning, and nothing else holds on to it
+	// as no stats handler is installed. The chunk's buffer then goes back
+	// to the executor.
+	msg := &pb.CommandOutput{}
 	for output := range outputCh {
-		var outputType pb.CommandOutput_OutputType
+		if output.IsComplete {
+			exitCode = output.ExitCode
+			cpu = output.CPUTime
+			if output.CPULimitExceeded {
+				errText = executor.ErrCPULimitExceeded.Error()
+			}
+		}
+		sess.AddBytesStreamed(len(output.Data))
+
 		if output.Type == executor.Stderr {
-			outputType = pb.CommandOutput_STDERR
+			msg.Type = pb.CommandOutput_STDERR
 		} else {
-			outputType = pb.CommandOutput_STDOUT
+			msg.Type = pb.CommandOutput_STDOUT
 		}
 
-		msg := &pb.CommandOutput{
-			Type:       outputType,
-			Data:       output.Data,
-			IsComplete: output.IsComplete,
-			ExitCode:   int32(output.ExitCode),
+		data, ok := s.output.text(output.Data)
+		msg.Data = data
+		msg.IsComplete = output.IsComplete
+		msg.ExitCode = int32(output.ExitCode)
+		msg.Binary = !ok
+		if output.IsComplete {
+			msg.WorkingDir = sess.GetWorkingDir()
+			msg.CpuLimitExceeded = output.CPULimitExceeded
+			msg.ExecutionTimeMs = time.Since(start).Milliseconds()
+			msg.StartedAtUnixMs = start.UnixMilli()
+			msg.FinishedAtUnixMs = time.Now().UnixMilli()
 		}
 
-		if err := stream.Send(msg); err != nil {
+		err := stream.Send(msg)
+		output.Release()
+		if err != nil {
 			s.logger.Warn("Failed to send stream output",
 				"session_id", req.SessionId,
 				"error", err.Error(),
@@ -411,20 +1238,96 @@ func (s *Server) ExecuteCommandStream(req *pb.CommandRequest, stream pb.ShellSer
 		}
 	}
 
+	// Closing the session, e.g. with the kill switch, killed the command
+	if exitCode == -1 && sess.Context().Err() != nil {
+		errText = executor.ErrCommandKilled.Error()
+		return status.Error(codes.Aborted, "command killed: the session was closed")
+	}
+
+	return nil
+}
+
+// requestCommand checks that a request carries either a command or an
+// argument vector, filling in the command with the quoted vector so that
+// policies, logs and the audit log see what runs
+func requestCommand(req *pb.CommandRequest) error {
+	if len(req.Argv) == 0 {
+		if req.Command == "" {
+			return status.Error(codes.InvalidArgument, "command is required")
+		}
+		return nil
+	}
+
+	if req.Argv[0] == "" {
+		return status.Error(codes.InvalidArgument, "argv[0] must name a program")
+	}
+	for _, arg := range req.Argv {
+		if strings.ContainsRune(arg, 0) {
+			return status.Error(codes.InvalidArgument, "argv must not contain NUL bytes")
+		}
+	}
+	command := executor.QuoteArgv(req.Argv)
+	if req.Command != "" && req.Command != command {
+		return status.Error(codes.InvalidArgument, "command and argv cannot both be set")
+	}
+	req.Command = command
 	return nil
 }
 
+// commandOptions validates the per-command overrides of a request
+func commandOptions(sess *session.Session, req *pb.CommandRequest) (executor.Options, error) {
+	opts := executor.Options{
+		WorkingDir: req.WorkingDir,
+		Env:        req.Env,
+		Stdin:      req.Stdin,
+	}
+
+	if opts.WorkingDir != "" {
+		dir := opts.WorkingDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(sess.GetWorkingDir(), dir)
+		}
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			return opts, status.Errorf(codes.InvalidArgument, "working_dir %s is not a directory", req.WorkingDir)
+		}
+		opts.WorkingDir = dir
+	}
+
+	for key := range opts.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			return opts, status.Errorf(codes.InvalidArgument, "invalid environment variable name %q", key)
+		}
+	}
+
+	// Nothing in an argument vector is globbed anyway
+	opts.Argv = req.Argv
+	if req.Glob == pb.CommandRequest_NOGLOB && len(req.Argv) == 0 {
+		if !executor.SupportsNoGlob(sess.Shell) {
+			return opts, status.Errorf(codes.FailedPrecondition, "%s cannot turn off globbing", filepath.Base(sess.Shell))
+		}
+		opts.NoGlob = true
+	}
+
+	return opts, nil
+}
+
 // handleSpecialCommand handles special built-in commands like cd
-func (s *Server) handleSpecialCommand(sess *session.Session, command string) (bool, *pb.CommandResponse) {
-	command = strings.TrimSpace(command)
+func (s *Server) handleSpecialCommand(sess *session.Session, req *pb.CommandRequest) (bool, *pb.CommandResponse) {
+	command := strings.TrimSpace(req.Command)
 	parts := strings.Fields(command)
-	if len(parts) == 0 {
+	// Argument vectors run a program, never a builtin
+	if len(parts) == 0 || len(req.Argv) > 0 {
 		return false, nil
 	}
 
 	switch parts[0] {
 	case "cd":
 		return s.handleCdCommand(sess, parts)
+	case "export", "unset":
+		return s.handleEnvCommand(sess, command, parts)
+	case "umask":
+		return s.handleUmaskCommand(sess, parts)
 	}
 
 	return false, nil
@@ -486,7 +1389,114 @@ func (s *Server) handleCdCommand(sess *session.Session, parts []string) (bool, *
 	}
 }
 
+// auditCommand records a command execution in the audit sink
+func (s *Server) auditCommand(sess *session.Session, command string, start time.Time, exitCode int, errText string) {
+	// Dangerous commands only get here when allowed or confirmed
+	action := s.dangerousAction(command)
+	severity := audit.SeverityNormal
+	if action != "" {
+		severity = audit.SeverityHigh
+	}
+
+	decision := audit.DecisionAllowed
+	approver, approved := s.takeApprover(sess, command)
+	switch {
+	case approved:
+		decision = audit.DecisionApproved
+	case action == ActionConfirm:
+		decision = audit.DecisionConfirmed
+	case action == ActionAudit:
+		decision = audit.DecisionAudited
+	}
+
+	ctx, cancel := auditContext()
+	defer cancel()
+	s.recordAudit("command", s.audit.CommandExecuted(ctx, audit.CommandRecord{
+		SessionID: sess.ID,
+		ClientID:  sess.ClientID,
+		Command:   command,
+		ExitCode:  exitCode,
+		Error:     errText,
+		StartedAt: start,
+		Duration:  time.Since(start),
+		Severity:  severity,
+		Decision:  decision,
+		Approver:  approver,
+	}))
+}
+
+// auditRefusal records a command the policy refused to run
+func (s *Server) auditRefusal(sess *session.Session, command, decision, approver string, err error) {
+	severity := audit.SeverityNormal
+	if s.dangerousAction(command) != "" {
+		severity = audit.SeverityHigh
+	}
+
+	ctx, cancel := auditContext()
+	defer cancel()
+	s.recordAudit("command", s.audit.CommandExecuted(ctx, audit.CommandRecord{
+		SessionID: sess.ID,
+		ClientID:  sess.ClientID,
+		Command:   command,
+		ExitCode:  -1,
+		Error:     status.Convert(err).Message(),
+		StartedAt: time.Now(),
+		Severity:  severity,
+		Decision:  decision,
+		Approver:  approver,
+	}))
+}
+
+// commandContext limits a command to the timeout and stops it when its
+// session is closed
+func commandContext(ctx context.Context, sess *session.Session, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	stop := context.AfterFunc(sess.Context(), cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// auditContext returns a context for audit writes. It is detached from the
+// request so that timed out or cancelled commands are still recorded.
+func auditContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Second)
+}
+
+// recordAudit logs audit sink failures; auditing never fails a request
+func (s *Server) recordAudit(event string, err error) {
+	if err != nil {
+		s.logger.Warn("Failed to write audit record", "event", event, "error", err.Error())
+	}
+}
+
+// peerAddr returns the remote address of the caller
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// peerHost strips the port from a peer address, so that clients are told
+// apart by host
+func peerHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 // GetSessionCount returns the number of active sessions
 func (s *Server) GetSessionCount() int {
 	return s.sessionManager.Count()
 }
+
+// currentBanner returns the banner shown to clients at login
+func (s *Server) currentBanner() string {
+	s.bannerMu.RLock()
+	defer s.bannerMu.RUnlock()
+	return s.banner
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/logger"
+)
+
+// newTestServer creates a server that is not listening, with its
+// configuration adjusted by configure. Session tokens are off, so that
+// requests are told apart by identity alone.
+func newTestServer(t *testing.T, configure func(*Config)) *Server {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Shell = "/bin/sh"
+	cfg.SessionTokenTTL = 0
+	if configure != nil {
+		configure(&cfg)
+	}
+	s, err := New(cfg, logger.New(logger.Config{Level: logger.LevelError, Output: io.Discard}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+// peerContext is the context of a request from the given IP address
+func peerContext(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000},
+	})
+}
+
+// createSession creates a session for clientID as the client ctx
+// identifies
+func createSession(t *testing.T, s *Server, ctx context.Context, clientID string) string {
+	t.Helper()
+	resp, err := s.CreateSession(ctx, &pb.CreateSessionRequest{ClientId: clientID})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	t.Cleanup(func() { s.sessionManager.Delete(resp.SessionId) })
+	return resp.SessionId
+}
+
+// testStream collects the output a streaming RPC sends
+type testStream struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	outputs []*pb.CommandOutput
+}
+
+func newTestStream(ctx context.Context) *testStream {
+	return &testStream{ctx: ctx}
+}
+
+// Send keeps a copy of output, as senders may reuse their messages
+func (t *testStream) Send(output *pb.CommandOutput) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.outputs = append(t.outputs, proto.Clone(output).(*pb.CommandOutput))
+	return nil
+}
+
+// stdout returns the standard output sent so far
+func (t *testStream) stdout() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []byte
+	for _, output := range t.outputs {
+		if output.Type == pb.CommandOutput_STDOUT {
+			out = append(out, output.Data...)
+		}
+	}
+	return string(out)
+}
+
+// confirmation returns the confirmation challenge sent, if any
+func (t *testStream) confirmation() *pb.ConfirmationChallenge {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, output := range t.outputs {
+		if output.Confirmation != nil {
+			return output.Confirmation
+		}
+	}
+	return nil
+}
+
+func (t *testStream) Context() context.Context     { return t.ctx }
+func (t *testStream) SetHeader(metadata.MD) error  { return nil }
+func (t *testStream) SendHeader(metadata.MD) error { return nil }
+func (t *testStream) SetTrailer(metadata.MD)       {}
+func (t *testStream) SendMsg(any) error            { return nil }
+func (t *testStream) RecvMsg(any) error            { return io.EOF }
+
+var _ grpc.ServerStream = (*testStream)(nil)
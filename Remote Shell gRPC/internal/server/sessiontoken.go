@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/session"
+	"remote-shell-rpc/pkg/sessiontoken"
+	pb "remote-shell-rpc/proto"
+)
+
+// sessionTokenHeader is the metadata key clients send session tokens in
+const sessionTokenHeader = "x-session-token"
+
+// issueSessionToken returns a token for who to use the session and when
+// it expires in Unix milliseconds, or nothing when tokens are disabled
+func (s *Server) issueSessionToken(sess *session.Session, who string) (string, int64) {
+	if s.sessionTokens == nil {
+		return "", 0
+	}
+	token, expires := s.sessionTokens.Issue(sess.ID, who)
+	return token, expires.UnixMilli()
+}
+
+// checkSessionToken returns Unauthenticated unless the request carries a
+// valid token issued to who for the session
+func (s *Server) checkSessionToken(ctx context.Context, sess *session.Session, who string) error {
+	if s.sessionTokens == nil {
+		return nil
+	}
+	token := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(sessionTokenHeader); len(values) > 0 {
+			token = values[0]
+		}
+	}
+	if token == "" {
+		return status.Error(codes.Unauthenticated, "session token required")
+	}
+
+	err := s.sessionTokens.Verify(token, sess.ID, who)
+	if errors.Is(err, sessiontoken.ErrExpired) {
+		return status.Error(codes.Unauthenticated, "session token expired; reconnect to get a new one")
+	}
+	if err != nil {
+		s.logger.Warn("Invalid session token",
+			"session_id", sess.ID,
+			"identity", who,
+		)
+		s.authFailed(ctx)
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil
+}
+
+// RenewToken replaces a valid session token with a new one
+func (s *Server) RenewToken(ctx context.Context, req *pb.RenewTokenRequest) (*pb.RenewTokenResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if s.sessionTokens == nil {
+		return nil, status.Error(codes.FailedPrecondition, "session tokens are not enabled on this server")
+	}
+
+	// Read-only guests renew their tokens too
+	sess, err := s.findSession(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkAccess(ctx, sess, false); err != nil {
+		return nil, err
+	}
+
+	token, expires := s.issueSessionToken(sess, identity(ctx))
+	return &pb.RenewTokenResponse{SessionToken: token, ExpiresAtUnixMs: expires}, nil
+}
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/session"
+	"remote-shell-rpc/pkg/share"
+	"remote-shell-rpc/pkg/streamcheck"
+	pb "remote-shell-rpc/proto"
+)
+
+// Invitation lifetimes
+const (
+	defaultInvitationTTL = 15 * time.Minute
+	maxInvitationTTL     = 24 * time.Hour
+)
+
+// attachBuffer is how many events an attached client may fall behind
+// before events are dropped for it
+const attachBuffer = 256
+
+// ShareSession creates an invitation to a session, or revokes all of them
+func (s *Server) ShareSession(ctx context.Context, req *pb.ShareSessionRequest) (*pb.ShareSessionResponse, error) {
+	if req.SessionId == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	if !s.config.AllowSharing {
+		return nil, status.Error(codes.FailedPrecondition, "session sharing is disabled on this server")
+	}
+
+	sess, err := s.findSession(req.SessionId)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkOwner(ctx, sess); err != nil {
+		return nil, err
+	}
+
+	if req.Revoke {
+		revoked := sess.Unshare()
+		// Wakes attached guests so they notice they were removed
+		sess.Mirror().Publish(share.Event{Type: share.Notice, Data: []byte("sharing ended by the owner")})
+		s.logger.Info("Session sharing revoked", "session_id", sess.ID, "guests", revoked)
+		return &pb.ShareSessionResponse{Revoked: int32(revoked)}, nil
+	}
+
+	ttl := time.Duration(req.TtlSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultInvitationTTL
+	}
+	if ttl > maxInvitationTTL {
+		ttl = maxInvitationTTL
+	}
+
+	token, expiresAt, err := sess.Invite(req.ReadWrite, ttl)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create invitation: %v", err)
+	}
+
+	s.logger.Info("Session shared",
+		"session_id", sess.ID,
+		"read_write", req.ReadWrite,
+		"expires_at", expiresAt,
+	)
+	return &pb.ShareSessionResponse{
+		Invitation:      sess.ID + "." + token,
+		ExpiresAtUnixMs: expiresAt.UnixMilli(),
+	}, nil
+}
+
+// AttachSession streams what happens in a session to its owner or a guest
+func (s *Server) AttachSession(req *pb.AttachSessionRequest, stream pb.ShellService_AttachSessionServer) error {
+	ctx := stream.Context()
+	who := identity(ctx)
+
+	var (
+		sess      *session.Session
+		name      string
+		readWrite bool
+		guest     bool
+	)
+	switch {
+	case req.Invitation != "":
+		if !s.config.AllowSharing {
+			return status.Error(codes.FailedPrecondition, "session sharing is disabled on this server")
+		}
+		id, token, ok := strings.Cut(req.Invitation, ".")
+		if !ok {
+			return status.Error(codes.InvalidArgument, "malformed invitation")
+		}
+		found, err := s.sessionManager.Get(id)
+		if err != nil {
+			s.authFailed(ctx)
+			return status.Error(codes.PermissionDenied, session.ErrInvalidInvitation.Error())
+		}
+		joined, err := found.Join(token, who, guestName(req, who))
+		if err != nil {
+			s.authFailed(ctx)
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		sess, name, readWrite, guest = found, joined.Name, joined.ReadWrite, true
+
+		s.logger.Info("Guest joined session",
+			"session_id", sess.ID,
+			"guest", name,
+			"identity", who,
+			"read_write", readWrite,
+		)
+
+	case req.SessionId != "":
+		found, err := s.findSession(req.SessionId)
+		if err != nil {
+			return err
+		}
+		if err := s.checkAccess(ctx, found, false); err != nil {
+			return err
+		}
+		sess = found
+		name = s.participant(ctx, sess)
+		readWrite = sess.CheckAccess(who, true) == nil
+		_, guest = sess.Guest(who)
+
+	default:
+		return status.Error(codes.InvalidArgument, "invitation or session_id is required")
+	}
+
+	sub := sess.Mirror().Subscribe(attachBuffer)
+	defer sub.Close()
+
+	if guest {
+		sess.Mirror().Publish(share.Event{Type: share.Joined, Participant: name})
+		defer sess.Mirror().Publish(share.Event{Type: share.Left, Participant: name})
+	}
+
+	token, expires := s.issueSessionToken(sess, who)
+	err := stream.Send(&pb.SessionEvent{
+		Type:                        pb.SessionEvent_ATTACHED,
+		Participant:                 name,
+		SessionId:                   sess.ID,
+		ReadWrite:                   readWrite,
+		WorkingDir:                  sess.GetWorkingDir(),
+		TimeUnixMs:                  time.Now().UnixMilli(),
+		SessionToken:                token,
+		SessionTokenExpiresAtUnixMs: expires,
+	})
+	if err != nil {
+		return err
+	}
+
+	return relayEvents(ctx, sess, sub, stream, func() error {
+		// Guests removed by the owner stop receiving events
+		if guest && sess.CheckAccess(who, false) != nil {
+			return status.Error(codes.PermissionDenied, "session is no longer shared with you")
+		}
+		return nil
+	})
+}
+
+// relayEvents sends a session's events to an attached client until the
+// client goes away or the session is closed. check is called before every
+// event and ends the attachment when it fails.
+func relayEvents(ctx context.Context, sess *session.Session, sub *share.Subscription, stream interface {
+	Send(*pb.SessionEvent) error
+}, check func() error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.Events():
+			if !ok {
+				// The session was closed, which ends the attachment
+				return stream.Send(&pb.SessionEvent{
+					Type:       pb.SessionEvent_NOTICE,
+					Data:       []byte("session closed"),
+					SessionId:  sess.ID,
+					TimeUnixMs: time.Now().UnixMilli(),
+				})
+			}
+			if err := check(); err != nil {
+				return err
+			}
+			if n := sub.Dropped(); n > 0 {
+				notice := share.Event{Type: share.Notice, Data: []byte(fmt.Sprintf("%d events dropped", n)), Time: time.Now()}
+				if err := stream.Send(sessionEvent(notice)); err != nil {
+					return err
+				}
+			}
+			if err := stream.Send(sessionEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// guestName is the name a guest is shown as to the other participants
+func guestName(req *pb.AttachSessionRequest, who string) string {
+	if name := cleanClientField(req.Name); name != "" {
+		return name
+	}
+	info := clientInfo(req.ClientInfo)
+	switch {
+	case info.Username != "" && info.Hostname != "":
+		return info.Username + "@" + info.Hostname
+	case info.Username != "":
+		return info.Username
+	}
+	return who
+}
+
+// participant names the caller in a session's events: the client ID for
+// the owner and the name given at join for guests
+func (s *Server) participant(ctx context.Context, sess *session.Session) string {
+	who := identity(ctx)
+	if guest, ok := sess.Guest(who); ok && sess.CheckOwner(who) != nil {
+		return guest.Name
+	}
+	return sess.ClientID
+}
+
+// sessionEvent converts a mirrored event for the wire
+func sessionEvent(e share.Event) *pb.SessionEvent {
+	ev := &pb.SessionEvent{
+		Participant: e.Participant,
+		Command:     e.Command,
+		Data:        e.Data,
+		Stderr:      e.Stderr,
+		Binary:      e.Binary,
+		ExitCode:    int32(e.ExitCode),
+		WorkingDir:  e.WorkingDir,
+		TimeUnixMs:  e.Time.UnixMilli(),
+	}
+	switch e.Type {
+	case share.CommandStarted:
+		ev.Type = pb.SessionEvent_COMMAND_STARTED
+	case share.Output:
+		ev.Type = pb.SessionEvent_OUTPUT
+	case share.CommandFinished:
+		ev.Type = pb.SessionEvent_COMMAND_FINISHED
+	case share.Joined:
+		ev.Type = pb.SessionEvent_JOINED
+	case share.Left:
+		ev.Type = pb.SessionEvent_LEFT
+	default:
+		ev.Type = pb.SessionEvent_NOTICE
+	}
+	return ev
+}
+
+// notify tells a session's participants something: those attached at once
+// and the one running the next command with its result
+func notify(sess *session.Session, msg string) {
+	sess.Notify(msg)
+	sess.Mirror().Publish(share.Event{Type: share.Notice, Data: []byte(msg)})
+}
+
+// runCommand executes an already validated command and returns the
+// complete result with the session's notices, mirroring it to the
+// clients attached to the session
+func (s *Server) runCommand(ctx context.Context, sess *session.Session, req *pb.CommandRequest, opts executor.Options) (*pb.CommandResponse, error) {
+	release, _, err := s.waitTurn(ctx, sess, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, err := s.mirrorCommand(ctx, sess, req, opts)
+	if resp != nil {
+		resp.Notices = sess.TakeNotices()
+		elapsed := time.Duration(resp.ExecutionTimeMs) * time.Millisecond
+		if msg := durationNotice(elapsed, s.config.SoftTimeout, s.commandTimeout(req), false); msg != "" {
+			resp.Notices = append(resp.Notices, msg)
+		}
+	}
+	return resp, err
+}
+
+// mirrorCommand executes an already validated command, mirroring it to
+// the clients attached to the session
+func (s *Server) mirrorCommand(ctx context.Context, sess *session.Session, req *pb.CommandRequest, opts executor.Options) (*pb.CommandResponse, error) {
+	mirror := sess.Mirror()
+	if mirror.Watchers() == 0 {
+		return s.execCommand(ctx, sess, req, opts)
+	}
+
+	who := s.participant(ctx, sess)
+	mirror.Publish(share.Event{Type: share.CommandStarted, Participant: who, Command: req.Command})
+
+	resp, err := s.execCommand(ctx, sess, req, opts)
+
+	finished := share.Event{Type: share.CommandFinished, Participant: who, Command: req.Command, ExitCode: -1}
+	if err != nil {
+		finished.Data = []byte(status.Convert(err).Message())
+	} else {
+		stdout, stderr := []byte(resp.Output), []byte(resp.Error)
+		if resp.Binary {
+			stdout, stderr = resp.BinaryOutput, resp.BinaryError
+		}
+		if len(stdout) > 0 {
+			mirror.Publish(share.Event{Type: share.Output, Participant: who, Data: stdout, Binary: resp.Binary})
+		}
+		if len(stderr) > 0 {
+			mirror.Publish(share.Event{Type: share.Output, Participant: who, Data: stderr, Stderr: true, Binary: resp.Binary})
+		}
+		finished.ExitCode = int(resp.ExitCode)
+		finished.WorkingDir = resp.WorkingDir
+	}
+	mirror.Publish(finished)
+	return resp, err
+}
+
+// runCommandStream executes an already validated command and streams its
+// output, followed by the session's notices, mirroring it to the clients
+// attached to the session
+func (s *Server) runCommandStream(sess *session.Session, req *pb.CommandRequest, stream outputStream) error {
+	release, waited, err := s.waitTurn(stream.Context(), sess, func(state *pb.CommandState) error {
+		return stream.Send(&pb.CommandOutput{State: state})
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+	if waited {
+		if err := stream.Send(&pb.CommandOutput{State: &pb.CommandState{State: pb.CommandState_RUNNING}}); err != nil {
+			return err
+		}
+	}
+
+	stream = &noticeStream{outputStream: stream, sess: sess, soft: s.config.SoftTimeout, hard: s.commandTimeout(req)}
+
+	mirror := sess.Mirror()
+	if mirror.Watchers() == 0 {
+		return s.streamCommand(sess, req, stream)
+	}
+
+	who := s.participant(stream.Context(), sess)
+	mirror.Publish(share.Event{Type: share.CommandStarted, Participant: who, Command: req.Command})
+
+	mirrored := &mirroredStream{outputStream: stream, mirror: mirror, participant: who, command: req.Command}
+	err = s.streamCommand(sess, req, mirrored)
+	if !mirrored.finished {
+		finished := share.Event{Type: share.CommandFinished, Participant: who, Command: req.Command, ExitCode: -1}
+		if err != nil {
+			finished.Data = []byte(status.Convert(err).Message())
+		}
+		mirror.Publish(finished)
+	}
+	return err
+}
+
+// mirroredStream copies the output sent to a client to the session's
+// attached clients
+type mirroredStream struct {
+	outputStream
+	mirror      *share.Hub
+	participant string
+	command     string
+	finished    bool
+}
+
+// Send publishes the output and completion of a command before sending it
+func (m *mirroredStream) Send(out *pb.CommandOutput) error {
+	if len(out.Data) > 0 {
+		m.mirror.Publish(share.Event{
+			Type:        share.Output,
+			Participant: m.participant,
+			Data:        out.Data,
+			Stderr:      out.Type == pb.CommandOutput_STDERR,
+			Binary:      out.Binary,
+		})
+	}
+	if out.IsComplete {
+		m.finished = true
+		m.mirror.Publish(share.Event{
+			Type:        share.CommandFinished,
+			Participant: m.participant,
+			Command:     m.command,
+			ExitCode:    int(out.ExitCode),
+			WorkingDir:  out.WorkingDir,
+		})
+	}
+	return m.outputStream.Send(out)
+}
+
+// sequencedStream numbers the messages of a command's output and sums them
+// up on the final one, so that clients can check that nothing was lost. The
+// summary also goes into the stream's trailer.
+type sequencedStream struct {
+	outputStream
+	sender streamcheck.Sender
+}
+
+// Send numbers the message, and adds the summary to the completion message
+// and the trailer, before sending it
+func (seq *sequencedStream) Send(out *pb.CommandOutput) error {
+	out.Sequence = seq.sender.Next(out.Data)
+	if out.IsComplete {
+		sum := seq.sender.Summary()
+		out.Summary = &pb.StreamSummary{
+			Chunks:   sum.Chunks,
+			Bytes:    sum.Bytes,
+			Checksum: sum.Checksum,
+		}
+		seq.SetTrailer(commandTrailer(out, sum))
+	}
+	return seq.outputStream.Send(out)
+}
+
+// noticeStream attaches the session's queued notices to the final message
+// of a command's output, with a warning when the command ran longer than
+// the soft timeout
+type noticeStream struct {
+	outputStream
+	sess       *session.Session
+	soft, hard time.Duration
+}
+
+// Send adds the notices to the completion message before sending it
+func (n *noticeStream) Send(out *pb.CommandOutput) error {
+	if out.IsComplete {
+		out.Notices = n.sess.TakeNotices()
+		elapsed := time.Duration(out.ExecutionTimeMs) * time.Millisecond
+		if msg := durationNotice(elapsed, n.soft, n.hard, true); msg != "" {
+			out.Notices = append(out.Notices, msg)
+		}
+	}
+	return n.outputStream.Send(out)
+}
+
+// globStream attaches the patterns expanded for a command to the final
+// message of its output
+type globStream struct {
+	outputStream
+	globs []*pb.GlobExpansion
+}
+
+// Send adds the expanded patterns to the completion message before
+// sending it
+func (g *globStream) Send(out *pb.CommandOutput) error {
+	if out.IsComplete {
+		out.Globs = g.globs
+	}
+	return g.outputStream.Send(out)
+}
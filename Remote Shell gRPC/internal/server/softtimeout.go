@@ -0,0 +1,24 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"remote-shell-rpc/pkg/format"
+)
+
+// durationNotice returns a warning for a command that ran for elapsed,
+// at least soft but below its hard timeout, or "" when it was quick
+// enough or the soft timeout is disabled. Unary calls are pointed at
+// streaming, which shows output while a command runs.
+func durationNotice(elapsed, soft, hard time.Duration, streamed bool) string {
+	if soft <= 0 || elapsed < soft {
+		return ""
+	}
+	hint := "stream its output or run it in the background"
+	if streamed {
+		hint = "run it in the background (nohup ... &) or save its output to a file"
+	}
+	return fmt.Sprintf("Command took %s of its %s timeout; for long jobs, %s",
+		format.Duration(elapsed), format.Duration(hard), hint)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"remote-shell-rpc/pkg/audit"
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/session"
+	"remote-shell-rpc/pkg/shellparse"
+)
+
+// Shell constructs that strict mode rejects unless StrictAllow names them.
+// Pipes and grouping are always allowed, except into a shell or eval.
+const (
+	// StrictSeparator is ";", "&" and newlines between commands
+	StrictSeparator = "separator"
+	StrictAnd       = "and"
+	StrictOr        = "or"
+	// StrictSubstitution is $(...), `...` and process substitution
+	StrictSubstitution = "substitution"
+	// StrictRedirect is any redirection, including here-documents
+	StrictRedirect = "redirect"
+)
+
+// StrictConstructs are the constructs StrictAllow may name
+var StrictConstructs = []string{StrictSeparator, StrictAnd, StrictOr, StrictSubstitution, StrictRedirect}
+
+// strictMaxNesting bounds how deep "sh -c" and eval scripts are followed;
+// deeper commands are refused
+const strictMaxNesting = 4
+
+// strictConstruct returns the construct an operator belongs to, or "" for
+// operators strict mode allows
+func strictConstruct(op string) string {
+	switch op {
+	case ";", "&", ";;":
+		return StrictSeparator
+	case "&&":
+		return StrictAnd
+	case "||":
+		return StrictOr
+	}
+	if shellparse.IsRedirect(op) {
+		return StrictRedirect
+	}
+	return ""
+}
+
+// checkStrict rejects a command that chains, substitutes or redirects
+// commands while strict mode is on, unless the construct is allowed, and
+// one that pipes or redirects a script into a shell or eval, whatever is
+// allowed. Commands that cannot be parsed are rejected too, since they
+// cannot be checked.
+func (s *Server) checkStrict(sess *session.Session, command string) error {
+	if !s.config.StrictMode {
+		return nil
+	}
+
+	refused := ""
+	syntax, err := inspectStrict(command, 0)
+	switch {
+	case err != nil:
+		refused = fmt.Sprintf("a command that cannot be parsed (%v)", err)
+	case syntax.Substitution && !slices.Contains(s.config.StrictAllow, StrictSubstitution):
+		refused = "command substitution"
+	case syntax.FedScript:
+		refused = "input to a shell or eval"
+	default:
+		for _, op := range syntax.Operators {
+			construct := strictConstruct(op)
+			if construct != "" && !slices.Contains(s.config.StrictAllow, construct) {
+				refused = fmt.Sprintf("%q", op)
+				break
+			}
+		}
+	}
+	if refused == "" {
+		return nil
+	}
+
+	s.logger.Warn("Command refused by strict mode",
+		"session_id", sess.ID,
+		"command", command,
+	)
+	err = status.Errorf(codes.PermissionDenied, "strict mode runs single commands only; %s is not allowed", refused)
+	s.auditRefusal(sess, command, audit.DecisionBlocked, "", err)
+	return err
+}
+
+// strictSyntax is the syntax of a command line as strict mode sees it
+type strictSyntax struct {
+	shellparse.Syntax
+	// FedScript is set when a pipe or input redirection feeds a shell or
+	// eval, whose script is then out of sight
+	FedScript bool
+}
+
+// inspectStrict returns the syntax a command line uses, including that of
+// the scripts it runs through "sh -c" or eval, which would otherwise hide
+// a chain inside a single command
+func inspectStrict(command string, depth int) (strictSyntax, error) {
+	var syntax strictSyntax
+	if depth > strictMaxNesting {
+		return syntax, fmt.Errorf("scripts nested more than %d deep", strictMaxNesting)
+	}
+	var err error
+	syntax.Syntax, err = shellparse.Inspect(command)
+	if err != nil {
+		return syntax, err
+	}
+	commands, err := shellparse.Parse(command)
+	if err != nil {
+		return syntax, err
+	}
+	for _, cmd := range commands {
+		script, ok := executor.NestedScript(cmd)
+		if executor.ReadsScript(cmd) || (ok && fedInput(cmd)) {
+			syntax.FedScript = true
+		}
+		if !ok {
+			continue
+		}
+		nested, err := inspectStrict(script, depth+1)
+		if err != nil {
+			return syntax, err
+		}
+		syntax.Operators = append(syntax.Operators, nested.Operators...)
+		syntax.Substitution = syntax.Substitution || nested.Substitution
+		syntax.FedScript = syntax.FedScript || nested.FedScript
+	}
+	return syntax, nil
+}
+
+// fedInput reports whether a command reads from a pipe or an input
+// redirection
+func fedInput(cmd shellparse.Command) bool {
+	if cmd.FromPipe {
+		return true
+	}
+	for _, r := range cmd.Redirects {
+		switch r.Op {
+		case "<", "<<", "<<<", "<&", "<>":
+			return true
+		}
+	}
+	return false
+}
+
+// ValidStrictAllow checks that a StrictAllow setting only names known
+// constructs
+func ValidStrictAllow(allow []string) error {
+	for _, construct := range allow {
+		if !slices.Contains(StrictConstructs, construct) {
+			return fmt.Errorf("unknown construct %q (want one of %v)", construct, StrictConstructs)
+		}
+	}
+	return nil
+}
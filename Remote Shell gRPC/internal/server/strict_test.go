@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+)
+
+func TestCheckStrict(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) { cfg.StrictMode = true })
+	sess, err := s.sessionManager.Get(createSession(t, s, peerContext("192.0.2.1"), "client1"))
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	deep := "ls; ls"
+	for i := 0; i <= strictMaxNesting; i++ {
+		deep = "sh -c " + executor.ShellQuote(deep)
+	}
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"ls -l", true},
+		{"ls | wc -l", true},
+		{"echo 'a; b'", true},
+		{"sh -c 'ls -l'", true},
+		{"eval ls", true},
+		{"ls; ls", false},
+		{"sh -c 'a; b'", false},
+		{"bash -c 'x && y'", false},
+		{"bash -xc 'x || y'", false},
+		{"eval 'a; b'", false},
+		{"sudo -u root sh -c 'a; b'", false},
+		{`sh -c "bash -c 'a && b'"`, false},
+		{"sh -c 'echo $(id)'", false},
+		{"sh -c 'ls > out'", false},
+		{"echo 'a; b' | sh", false},
+		{"echo ls | bash -s", false},
+		{"ls | sh -c 'wc -l'", false},
+		{"echo ls | (sh)", false},
+		{"sh -c 'echo ls | sh'", false},
+		{"ls | grep sh", true},
+		{deep, false},
+	}
+	for _, tt := range tests {
+		err := s.checkStrict(sess, tt.command)
+		if tt.allowed && err != nil {
+			t.Errorf("checkStrict(%q) error = %v, want allowed", tt.command, err)
+		}
+		if !tt.allowed && status.Code(err) != codes.PermissionDenied {
+			t.Errorf("checkStrict(%q) error = %v, want PermissionDenied", tt.command, err)
+		}
+	}
+}
+
+func TestCheckStrict_AllowedConstructs(t *testing.T) {
+	s := newTestServer(t, func(cfg *Config) {
+		cfg.StrictMode = true
+		cfg.StrictAllow = []string{StrictSeparator, StrictRedirect}
+	})
+	sessionID := createSession(t, s, peerContext("192.0.2.1"), "client1")
+	ctx := peerContext("192.0.2.1")
+
+	resp, err := s.ExecuteCommand(ctx, &pb.CommandRequest{SessionId: sessionID, Command: "sh -c 'echo a; echo b'"})
+	if err != nil {
+		t.Fatalf("ExecuteCommand() error = %v", err)
+	}
+	if resp.Output != "a\nb\n" {
+		t.Errorf("output = %q, want %q", resp.Output, "a\nb\n")
+	}
+
+	_, err = s.ExecuteCommand(ctx, &pb.CommandRequest{SessionId: sessionID, Command: "bash -c 'true && echo a'"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ExecuteCommand() error = %v, want PermissionDenied", err)
+	}
+
+	// Allowing redirections does not let a script be fed to a shell
+	_, err = s.ExecuteCommand(ctx, &pb.CommandRequest{SessionId: sessionID, Command: "bash <<< 'echo a && echo b'"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ExecuteCommand() of a here-string into bash error = %v, want PermissionDenied", err)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"regexp"
+	"time"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// timeoutRule is a TimeoutRule with its pattern compiled
+type timeoutRule struct {
+	re      *regexp.Regexp
+	timeout time.Duration
+}
+
+// compileTimeoutRules compiles the configured rules, skipping invalid ones
+func (s *Server) compileTimeoutRules(rules []TimeoutRule) []timeoutRule {
+	compiled := make([]timeoutRule, 0, len(rules))
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			s.logger.Error("Ignoring invalid pattern", "setting", "timeout_rules", "pattern", rule.Pattern, "error", err.Error())
+			continue
+		}
+		compiled = append(compiled, timeoutRule{re: re, timeout: rule.Timeout})
+	}
+	return compiled
+}
+
+// commandTimeout returns the hard timeout of a command: the one it asks
+// for, that of the first timeout rule matching it, or the configured
+// default
+func (s *Server) commandTimeout(req *pb.CommandRequest) time.Duration {
+	if req.TimeoutSeconds > 0 {
+		return time.Duration(req.TimeoutSeconds) * time.Second
+	}
+	for _, rule := range s.timeoutRules {
+		if rule.re.MatchString(req.Command) {
+			return rule.timeout
+		}
+	}
+	return s.config.CommandTimeout
+}
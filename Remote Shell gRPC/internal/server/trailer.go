@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+
+	"remote-shell-rpc/pkg/streamcheck"
+	pb "remote-shell-rpc/proto"
+)
+
+// Trailer keys summing up a command's output stream, for tools that read
+// gRPC trailers rather than the final message
+const (
+	trailerExitCode      = "x-exit-code"
+	trailerExecutionTime = "x-execution-time-ms"
+	trailerChunks        = "x-output-chunks"
+	trailerBytes         = "x-output-bytes"
+	trailerChecksum      = "x-output-crc32c"
+	trailerTruncated     = "x-output-truncated"
+	trailerCPULimit      = "x-cpu-limit-exceeded"
+)
+
+// commandTrailer returns the trailer for the final message of a command's
+// output stream. The output counts as truncated when it went to a file on
+// the server and only its tail was streamed.
+func commandTrailer(out *pb.CommandOutput, sum streamcheck.Summary) metadata.MD {
+	return metadata.Pairs(
+		trailerExitCode, strconv.Itoa(int(out.ExitCode)),
+		trailerExecutionTime, strconv.FormatInt(out.ExecutionTimeMs, 10),
+		trailerChunks, strconv.FormatUint(sum.Chunks, 10),
+		trailerBytes, strconv.FormatUint(sum.Bytes, 10),
+		trailerChecksum, fmt.Sprintf("%08x", sum.Checksum),
+		trailerTruncated, strconv.FormatBool(out.OutputFile != ""),
+		trailerCPULimit, strconv.FormatBool(out.CpuLimitExceeded),
+	)
+}
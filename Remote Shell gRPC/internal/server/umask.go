@@ -0,0 +1,24 @@
+package server
+
+import (
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/session"
+)
+
+// handleUmaskCommand keeps "umask <octal>" in the session, so that it holds
+// for later commands and uploads rather than only the shell it ran in.
+// Other forms, such as printing the mask or symbolic modes, run in the
+// shell, which starts with the session's mask.
+func (s *Server) handleUmaskCommand(sess *session.Session, parts []string) (bool, *pb.CommandResponse) {
+	if len(parts) != 2 {
+		return false, nil
+	}
+	umask, err := executor.ParseUmask(parts[1])
+	if err != nil {
+		return false, nil
+	}
+	sess.SetUmask(umask)
+	return true, &pb.CommandResponse{ExitCode: 0}
+}
@@ -0,0 +1,182 @@
+// Package approval implements the two-person approval workflow: restricted
+// commands wait in a pending state until an administrator approves or
+// denies them.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Common errors
+var (
+	ErrNotFound = errors.New("approval request not found")
+)
+
+// State is the lifecycle state of an approval request
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateApproved  State = "approved"
+	StateDenied    State = "denied"
+	StateCancelled State = "cancelled"
+)
+
+// Request is a command waiting for approval
+type Request struct {
+	ID        string
+	SessionID string
+	ClientID  string
+	// Requester is the identity of the client that ran the command
+	Requester   string
+	Command     string
+	RequestedAt time.Time
+	State       State
+	Approver    string
+	Reason      string
+	DecidedAt   time.Time
+}
+
+// entry tracks a request and wakes up waiters once it is decided
+type entry struct {
+	req  Request
+	done chan struct{}
+}
+
+// Manager holds pending approval requests
+type Manager struct {
+	requests map[string]*entry
+	mu       sync.Mutex
+}
+
+// NewManager creates an empty approval manager
+func NewManager() *Manager {
+	return &Manager{
+		requests: make(map[string]*entry),
+	}
+}
+
+// Submit registers a new pending request for a command run by requester
+func (m *Manager) Submit(sessionID, clientID, requester, command string) (Request, error) {
+	id, err := generateID()
+	if err != nil {
+		return Request{}, err
+	}
+
+	e := &entry{
+		req: Request{
+			ID:          id,
+			SessionID:   sessionID,
+			ClientID:    clientID,
+			Requester:   requester,
+			Command:     command,
+			RequestedAt: time.Now(),
+			State:       StatePending,
+		},
+		done: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.requests[id] = e
+	m.mu.Unlock()
+
+	return e.req, nil
+}
+
+// List returns all pending requests, oldest first
+func (m *Manager) List() []Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := make([]Request, 0, len(m.requests))
+	for _, e := range m.requests {
+		pending = append(pending, e.req)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].RequestedAt.Before(pending[j].RequestedAt)
+	})
+	return pending
+}
+
+// Get returns a pending request
+func (m *Manager) Get(id string) (Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.requests[id]
+	if !ok {
+		return Request{}, ErrNotFound
+	}
+	return e.req, nil
+}
+
+// Decide approves or denies a pending request
+func (m *Manager) Decide(id string, approve bool, approver, reason string) (Request, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Decided requests are removed, so a second decision is not found
+	e, ok := m.requests[id]
+	if !ok {
+		return Request{}, ErrNotFound
+	}
+
+	e.req.State = StateDenied
+	if approve {
+		e.req.State = StateApproved
+	}
+	e.req.Approver = approver
+	e.req.Reason = reason
+	e.req.DecidedAt = time.Now()
+
+	delete(m.requests, id)
+	close(e.done)
+
+	return e.req, nil
+}
+
+// Wait blocks until the request is decided or the context ends. A request
+// abandoned through the context is withdrawn and reported as cancelled.
+func (m *Manager) Wait(ctx context.Context, id string) (Request, error) {
+	m.mu.Lock()
+	e, ok := m.requests[id]
+	m.mu.Unlock()
+	if !ok {
+		return Request{}, ErrNotFound
+	}
+
+	select {
+	case <-e.done:
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return e.req, nil
+	case <-ctx.Done():
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The decision may have raced with the cancellation
+	if e.req.State != StatePending {
+		return e.req, nil
+	}
+	e.req.State = StateCancelled
+	delete(m.requests, id)
+	close(e.done)
+	return e.req, ctx.Err()
+}
+
+// generateID generates a unique request ID
+func generateID() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
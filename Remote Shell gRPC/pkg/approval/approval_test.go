@@ -0,0 +1,74 @@
+package approval
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManager_ApproveWakesWaiter(t *testing.T) {
+	m := NewManager()
+
+	req, err := m.Submit("s1", "c1", "token:c1", "systemctl restart nginx")
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	if pending := m.List(); len(pending) != 1 || pending[0].ID != req.ID {
+		t.Fatalf("List() = %v, want the submitted request", pending)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		m.Decide(req.ID, true, "alice", "")
+	}()
+
+	got, err := m.Wait(context.Background(), req.ID)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if got.State != StateApproved || got.Approver != "alice" {
+		t.Errorf("Wait() = %s by %q, want approved by alice", got.State, got.Approver)
+	}
+
+	if pending := m.List(); len(pending) != 0 {
+		t.Errorf("List() after decision has %d entries, want 0", len(pending))
+	}
+}
+
+func TestManager_Deny(t *testing.T) {
+	m := NewManager()
+	req, _ := m.Submit("s1", "c1", "token:c1", "reboot")
+
+	got, err := m.Decide(req.ID, false, "bob", "not during business hours")
+	if err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+	if got.State != StateDenied || got.Reason != "not during business hours" {
+		t.Errorf("Decide() = %+v, want denied with reason", got)
+	}
+
+	if _, err := m.Decide(req.ID, true, "bob", ""); err != ErrNotFound {
+		t.Errorf("second Decide() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestManager_WaitCancelled(t *testing.T) {
+	m := NewManager()
+	req, _ := m.Submit("s1", "c1", "token:c1", "reboot")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	got, err := m.Wait(ctx, req.ID)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got.State != StateCancelled {
+		t.Errorf("Wait() state = %s, want %s", got.State, StateCancelled)
+	}
+
+	if _, err := m.Decide(req.ID, true, "alice", ""); err != ErrNotFound {
+		t.Errorf("Decide() on cancelled request error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,143 @@
+// Package audit records session lifecycle and command execution events
+// so operators can review what was run on the server.
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Common errors
+var (
+	ErrUnsupportedDriver = errors.New("unsupported audit driver")
+	ErrQueryUnsupported  = errors.New("audit sink does not support queries")
+)
+
+// SessionRecord describes a session lifecycle event
+type SessionRecord struct {
+	SessionID string
+	ClientID  string
+	PeerAddr  string
+	CreatedAt time.Time
+	ClosedAt  time.Time
+
+	// What the client reported about itself at login
+	ClientOS       string
+	ClientHostname string
+	ClientVersion  string
+	ClientUser     string
+
+	// Usage summary, set when the session closes
+	Commands      int64
+	WallTime      time.Duration
+	CPUTime       time.Duration
+	BytesStreamed int64
+}
+
+// Command record severities
+const (
+	SeverityNormal = "normal"
+	// SeverityHigh marks commands matching a dangerous-command rule
+	SeverityHigh = "high"
+)
+
+// Policy decisions on commands. Commands that were refused are recorded
+// with an exit code of -1 and the reason as the error.
+const (
+	// DecisionAllowed is for commands no policy applied to
+	DecisionAllowed = "allowed"
+	// DecisionAudited is for dangerous commands the policy lets run
+	DecisionAudited = "audited"
+	// DecisionConfirmed is for dangerous commands the user confirmed
+	DecisionConfirmed = "confirmed"
+	// DecisionApproved is for restricted commands an administrator approved
+	DecisionApproved = "approved"
+	// DecisionBlocked is for dangerous commands the policy refused
+	DecisionBlocked = "blocked"
+	// DecisionRejected is for dangerous commands the user did not confirm
+	DecisionRejected = "rejected"
+	// DecisionDenied is for restricted commands that were not approved,
+	// because an administrator denied them or nobody decided in time
+	DecisionDenied = "denied"
+)
+
+// CommandRecord describes a single command execution
+type CommandRecord struct {
+	SessionID string
+	ClientID  string
+	Command   string
+	ExitCode  int
+	Error     string
+	StartedAt time.Time
+	Duration  time.Duration
+	// Severity is SeverityNormal when empty
+	Severity string
+	// Decision is DecisionAllowed when empty
+	Decision string
+	// Approver decided on an approved or denied command
+	Approver string
+}
+
+// Query holds filters for looking up command records.
+// Zero values mean "no filter" for the corresponding field.
+type Query struct {
+	SessionID string
+	ClientID  string
+	Since     time.Time
+	Until     time.Time
+	Severity  string
+	Decision  string
+	// ExitCode, when set, only matches commands that exited with it
+	ExitCode *int
+	Limit    int
+}
+
+// Sink stores audit records
+type Sink interface {
+	SessionStarted(ctx context.Context, rec SessionRecord) error
+	SessionClosed(ctx context.Context, rec SessionRecord) error
+	CommandExecuted(ctx context.Context, rec CommandRecord) error
+	Close() error
+}
+
+// Querier is implemented by sinks that can search stored records
+type Querier interface {
+	QueryCommands(ctx context.Context, q Query) ([]CommandRecord, error)
+	// EachCommand calls fn with every record matching the query, oldest
+	// first, without holding them all in memory. It stops at the first
+	// error fn returns.
+	EachCommand(ctx context.Context, q Query, fn func(CommandRecord) error) error
+}
+
+// Config holds audit configuration
+type Config struct {
+	Driver string // "sqlite" or "postgres"; empty disables auditing
+	DSN    string
+}
+
+// Open creates the sink described by the configuration.
+// An empty driver returns a no-op sink.
+func Open(cfg Config) (Sink, error) {
+	switch cfg.Driver {
+	case "":
+		return Nop(), nil
+	case DriverSQLite, DriverPostgres:
+		return OpenDB(cfg.Driver, cfg.DSN)
+	default:
+		return nil, ErrUnsupportedDriver
+	}
+}
+
+// nopSink discards all records
+type nopSink struct{}
+
+// Nop returns a sink that discards all records
+func Nop() Sink {
+	return nopSink{}
+}
+
+func (nopSink) SessionStarted(context.Context, SessionRecord) error  { return nil }
+func (nopSink) SessionClosed(context.Context, SessionRecord) error   { return nil }
+func (nopSink) CommandExecuted(context.Context, CommandRecord) error { return nil }
+func (nopSink) Close() error                                         { return nil }
@@ -0,0 +1,281 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Supported database drivers
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// DBSink stores audit records in a SQL database
+type DBSink struct {
+	db     *sql.DB
+	driver string
+}
+
+// OpenDB opens the database, creating the audit tables and indexes if needed
+func OpenDB(driver, dsn string) (*DBSink, error) {
+	var sqlDriver string
+	switch driver {
+	case DriverSQLite:
+		sqlDriver = "sqlite3"
+	case DriverPostgres:
+		sqlDriver = "postgres"
+	default:
+		return nil, ErrUnsupportedDriver
+	}
+
+	db, err := sql.Open(sqlDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit database: %w", err)
+	}
+	if driver == DriverSQLite {
+		// SQLite does not handle concurrent writers well
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &DBSink{db: db, driver: driver}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate creates the audit schema
+func (s *DBSink) migrate() error {
+	idColumn := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.driver == DriverPostgres {
+		idColumn = "id BIGSERIAL PRIMARY KEY"
+	}
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS audit_sessions (
+			session_id TEXT PRIMARY KEY,
+			client_id  TEXT NOT NULL,
+			peer_addr  TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			closed_at  TIMESTAMP NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_sessions_client ON audit_sessions (client_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_sessions_created ON audit_sessions (created_at)`,
+		`CREATE TABLE IF NOT EXISTS audit_commands (
+			` + idColumn + `,
+			session_id  TEXT NOT NULL,
+			client_id   TEXT NOT NULL,
+			command     TEXT NOT NULL,
+			exit_code   INTEGER NOT NULL,
+			error       TEXT NOT NULL DEFAULT '',
+			started_at  TIMESTAMP NOT NULL,
+			duration_ms BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_commands_session ON audit_commands (session_id, started_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_commands_started ON audit_commands (started_at)`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := s.db.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to migrate audit schema: %w", err)
+		}
+	}
+
+	// Columns added after the first release
+	for _, col := range []struct{ table, definition string }{
+		{"audit_sessions", "commands BIGINT NOT NULL DEFAULT 0"},
+		{"audit_sessions", "wall_time_ms BIGINT NOT NULL DEFAULT 0"},
+		{"audit_sessions", "cpu_time_ms BIGINT NOT NULL DEFAULT 0"},
+		{"audit_sessions", "bytes_streamed BIGINT NOT NULL DEFAULT 0"},
+		{"audit_commands", "severity TEXT NOT NULL DEFAULT 'normal'"},
+		{"audit_sessions", "client_os TEXT NOT NULL DEFAULT ''"},
+		{"audit_sessions", "client_hostname TEXT NOT NULL DEFAULT ''"},
+		{"audit_sessions", "client_version TEXT NOT NULL DEFAULT ''"},
+		{"audit_sessions", "client_user TEXT NOT NULL DEFAULT ''"},
+		{"audit_commands", "decision TEXT NOT NULL DEFAULT 'allowed'"},
+		{"audit_commands", "approver TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := s.addColumn(col.table, col.definition); err != nil {
+			return fmt.Errorf("failed to migrate audit schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column to a table unless it already exists
+func (s *DBSink) addColumn(table, definition string) error {
+	if s.driver == DriverPostgres {
+		_, err := s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN IF NOT EXISTS ` + definition)
+		return err
+	}
+
+	// SQLite has no IF NOT EXISTS for columns
+	name := strings.Fields(definition)[0]
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, name).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = s.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + definition)
+	return err
+}
+
+// SessionStarted records a new session. Re-attaching to an existing
+// session keeps the original record.
+func (s *DBSink) SessionStarted(ctx context.Context, rec SessionRecord) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		`INSERT INTO audit_sessions
+		 (session_id, client_id, peer_addr, created_at, client_os, client_hostname, client_version, client_user)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (session_id) DO NOTHING`),
+		rec.SessionID, rec.ClientID, rec.PeerAddr, rec.CreatedAt.UTC(),
+		rec.ClientOS, rec.ClientHostname, rec.ClientVersion, rec.ClientUser,
+	)
+	return err
+}
+
+// SessionClosed records the end of a session and its usage summary
+func (s *DBSink) SessionClosed(ctx context.Context, rec SessionRecord) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		`UPDATE audit_sessions
+		 SET closed_at = ?, commands = ?, wall_time_ms = ?, cpu_time_ms = ?, bytes_streamed = ?
+		 WHERE session_id = ?`),
+		rec.ClosedAt.UTC(), rec.Commands, rec.WallTime.Milliseconds(),
+		rec.CPUTime.Milliseconds(), rec.BytesStreamed, rec.SessionID,
+	)
+	return err
+}
+
+// CommandExecuted records a command execution
+func (s *DBSink) CommandExecuted(ctx context.Context, rec CommandRecord) error {
+	severity := rec.Severity
+	if severity == "" {
+		severity = SeverityNormal
+	}
+	decision := rec.Decision
+	if decision == "" {
+		decision = DecisionAllowed
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		`INSERT INTO audit_commands
+		 (session_id, client_id, command, exit_code, error, started_at, duration_ms, severity, decision, approver)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		rec.SessionID, rec.ClientID, rec.Command, rec.ExitCode, rec.Error,
+		rec.StartedAt.UTC(), rec.Duration.Milliseconds(), severity, decision, rec.Approver,
+	)
+	return err
+}
+
+// QueryCommands returns command records matching the query, oldest first
+func (s *DBSink) QueryCommands(ctx context.Context, q Query) ([]CommandRecord, error) {
+	var records []CommandRecord
+	err := s.EachCommand(ctx, q, func(rec CommandRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// EachCommand calls fn with every command record matching the query,
+// oldest first
+func (s *DBSink) EachCommand(ctx context.Context, q Query, fn func(CommandRecord) error) error {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if q.SessionID != "" {
+		where = append(where, "session_id = ?")
+		args = append(args, q.SessionID)
+	}
+	if q.ClientID != "" {
+		where = append(where, "client_id = ?")
+		args = append(args, q.ClientID)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, q.Since.UTC())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "started_at < ?")
+		args = append(args, q.Until.UTC())
+	}
+	if q.Severity != "" {
+		where = append(where, "severity = ?")
+		args = append(args, q.Severity)
+	}
+	if q.Decision != "" {
+		where = append(where, "decision = ?")
+		args = append(args, q.Decision)
+	}
+	if q.ExitCode != nil {
+		where = append(where, "exit_code = ?")
+		args = append(args, *q.ExitCode)
+	}
+
+	query := `SELECT session_id, client_id, command, exit_code, error, started_at, duration_ms, severity, decision, approver
+		FROM audit_commands`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY started_at, id"
+	if q.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit records: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			rec        CommandRecord
+			durationMs int64
+		)
+		if err := rows.Scan(&rec.SessionID, &rec.ClientID, &rec.Command, &rec.ExitCode,
+			&rec.Error, &rec.StartedAt, &durationMs, &rec.Severity, &rec.Decision, &rec.Approver); err != nil {
+			return fmt.Errorf("failed to read audit record: %w", err)
+		}
+		rec.Duration = time.Duration(durationMs) * time.Millisecond
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Close closes the database
+func (s *DBSink) Close() error {
+	return s.db.Close()
+}
+
+// rebind converts ? placeholders to the driver's placeholder style
+func (s *DBSink) rebind(query string) string {
+	if s.driver != DriverPostgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) *DBSink {
+	t.Helper()
+	sink, err := OpenDB(DriverSQLite, filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatalf("OpenDB() error = %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func TestDBSink_QueryCommands(t *testing.T) {
+	sink := openTestDB(t)
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	records := []CommandRecord{
+		{SessionID: "s1", ClientID: "c1", Command: "ls", StartedAt: base},
+		{SessionID: "s1", ClientID: "c1", Command: "pwd", StartedAt: base.Add(10 * time.Minute)},
+		{SessionID: "s2", ClientID: "c2", Command: "false", ExitCode: 1, StartedAt: base.Add(20 * time.Minute), Severity: SeverityHigh, Decision: DecisionAudited},
+	}
+	for _, rec := range records {
+		if err := sink.CommandExecuted(ctx, rec); err != nil {
+			t.Fatalf("CommandExecuted() error = %v", err)
+		}
+	}
+
+	zero, one := 0, 1
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"all", Query{}, []string{"ls", "pwd", "false"}},
+		{"by session", Query{SessionID: "s1"}, []string{"ls", "pwd"}},
+		{"by client", Query{ClientID: "c2"}, []string{"false"}},
+		{"since", Query{Since: base.Add(5 * time.Minute)}, []string{"pwd", "false"}},
+		{"until", Query{Until: base.Add(5 * time.Minute)}, []string{"ls"}},
+		{"limit", Query{Limit: 2}, []string{"ls", "pwd"}},
+		{"by severity", Query{Severity: SeverityHigh}, []string{"false"}},
+		{"normal severity", Query{Severity: SeverityNormal}, []string{"ls", "pwd"}},
+		{"by decision", Query{Decision: DecisionAudited}, []string{"false"}},
+		{"allowed", Query{Decision: DecisionAllowed}, []string{"ls", "pwd"}},
+		{"by exit code", Query{ExitCode: &one}, []string{"false"}},
+		{"exit code zero", Query{ExitCode: &zero}, []string{"ls", "pwd"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sink.QueryCommands(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("QueryCommands() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("QueryCommands() returned %d records, want %d", len(got), len(tt.want))
+			}
+			for i, rec := range got {
+				if rec.Command != tt.want[i] {
+					t.Errorf("record %d command = %s, want %s", i, rec.Command, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDBSink_SessionStartedTwice(t *testing.T) {
+	sink := openTestDB(t)
+	ctx := context.Background()
+
+	rec := SessionRecord{SessionID: "s1", ClientID: "c1", CreatedAt: time.Now()}
+	if err := sink.SessionStarted(ctx, rec); err != nil {
+		t.Fatalf("SessionStarted() error = %v", err)
+	}
+	if err := sink.SessionStarted(ctx, rec); err != nil {
+		t.Errorf("SessionStarted() on existing session error = %v", err)
+	}
+
+	rec.ClosedAt = time.Now()
+	if err := sink.SessionClosed(ctx, rec); err != nil {
+		t.Errorf("SessionClosed() error = %v", err)
+	}
+}
+
+func TestDBSink_SessionClosedSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.db")
+	sink, err := OpenDB(DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("OpenDB() error = %v", err)
+	}
+	ctx := context.Background()
+
+	rec := SessionRecord{SessionID: "s1", ClientID: "c1", CreatedAt: time.Now()}
+	if err := sink.SessionStarted(ctx, rec); err != nil {
+		t.Fatalf("SessionStarted() error = %v", err)
+	}
+	rec.ClosedAt = time.Now()
+	rec.Commands = 3
+	rec.WallTime = 1500 * time.Millisecond
+	rec.CPUTime = 20 * time.Millisecond
+	rec.BytesStreamed = 4096
+	if err := sink.SessionClosed(ctx, rec); err != nil {
+		t.Fatalf("SessionClosed() error = %v", err)
+	}
+	sink.Close()
+
+	// Reopening runs the migration against the existing schema
+	sink, err = OpenDB(DriverSQLite, path)
+	if err != nil {
+		t.Fatalf("OpenDB() on existing database error = %v", err)
+	}
+	defer sink.Close()
+
+	var commands, wallMs, cpuMs, bytes int64
+	err = sink.db.QueryRow(
+		`SELECT commands, wall_time_ms, cpu_time_ms, bytes_streamed FROM audit_sessions WHERE session_id = ?`, "s1",
+	).Scan(&commands, &wallMs, &cpuMs, &bytes)
+	if err != nil {
+		t.Fatalf("query session summary error = %v", err)
+	}
+	if commands != 3 || wallMs != 1500 || cpuMs != 20 || bytes != 4096 {
+		t.Errorf("session summary = %d commands, %dms wall, %dms cpu, %d bytes; want 3, 1500, 20, 4096",
+			commands, wallMs, cpuMs, bytes)
+	}
+}
+
+func TestDBSink_SessionClientInfo(t *testing.T) {
+	sink := openTestDB(t)
+	ctx := context.Background()
+
+	rec := SessionRecord{
+		SessionID:      "s1",
+		ClientID:       "c1",
+		CreatedAt:      time.Now(),
+		ClientOS:       "linux/amd64",
+		ClientHostname: "laptop",
+		ClientVersion:  "1.4.0",
+		ClientUser:     "alice",
+	}
+	if err := sink.SessionStarted(ctx, rec); err != nil {
+		t.Fatalf("SessionStarted() error = %v", err)
+	}
+
+	var os, hostname, version, user string
+	err := sink.db.QueryRow(
+		`SELECT client_os, client_hostname, client_version, client_user FROM audit_sessions WHERE session_id = ?`, "s1",
+	).Scan(&os, &hostname, &version, &user)
+	if err != nil {
+		t.Fatalf("query client info error = %v", err)
+	}
+	if os != rec.ClientOS || hostname != rec.ClientHostname || version != rec.ClientVersion || user != rec.ClientUser {
+		t.Errorf("client info = %q %q %q %q, want %q %q %q %q",
+			os, hostname, version, user, rec.ClientOS, rec.ClientHostname, rec.ClientVersion, rec.ClientUser)
+	}
+}
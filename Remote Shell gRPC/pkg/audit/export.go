@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Export formats
+const (
+	// FormatJSONL writes one JSON object per line
+	FormatJSONL = "jsonl"
+	// FormatCSV writes comma-separated values with a header row
+	FormatCSV = "csv"
+)
+
+// csvHeader names the columns of CSV exports
+var csvHeader = []string{
+	"started_at", "session_id", "client_id", "command", "exit_code", "error",
+	"duration_ms", "severity", "decision", "approver",
+}
+
+// exportRecord is how a command record appears in JSONL exports
+type exportRecord struct {
+	StartedAt  string `json:"started_at"`
+	SessionID  string `json:"session_id"`
+	ClientID   string `json:"client_id"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+	Severity   string `json:"severity"`
+	Decision   string `json:"decision"`
+	Approver   string `json:"approver,omitempty"`
+}
+
+// Exporter writes command records in an export format
+type Exporter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// NewExporter creates an exporter writing to w in the given format. CSV
+// exports start with a header row.
+func NewExporter(w io.Writer, format string) (*Exporter, error) {
+	e := &Exporter{format: format}
+	switch format {
+	case FormatJSONL:
+		e.json = json.NewEncoder(w)
+		e.json.SetEscapeHTML(false)
+	case FormatCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(csvHeader); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown export format %q (expected %s or %s)", format, FormatJSONL, FormatCSV)
+	}
+	return e, nil
+}
+
+// Write writes a single record
+func (e *Exporter) Write(rec CommandRecord) error {
+	out := exportRecord{
+		StartedAt:  rec.StartedAt.UTC().Format(time.RFC3339Nano),
+		SessionID:  rec.SessionID,
+		ClientID:   rec.ClientID,
+		Command:    rec.Command,
+		ExitCode:   rec.ExitCode,
+		Error:      rec.Error,
+		DurationMs: rec.Duration.Milliseconds(),
+		Severity:   rec.Severity,
+		Decision:   rec.Decision,
+		Approver:   rec.Approver,
+	}
+	if out.Severity == "" {
+		out.Severity = SeverityNormal
+	}
+	if out.Decision == "" {
+		out.Decision = DecisionAllowed
+	}
+
+	if e.json != nil {
+		return e.json.Encode(out)
+	}
+	return e.csv.Write([]string{
+		out.StartedAt, out.SessionID, out.ClientID, out.Command,
+		strconv.Itoa(out.ExitCode), out.Error,
+		strconv.FormatInt(out.DurationMs, 10), out.Severity, out.Decision, out.Approver,
+	})
+}
+
+// Flush writes any buffered data to the underlying writer
+func (e *Exporter) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
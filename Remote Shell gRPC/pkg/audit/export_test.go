@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExporter_Formats(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []CommandRecord{
+		{SessionID: "s1", ClientID: "c1", Command: "ls", StartedAt: started, Duration: 1500 * time.Millisecond},
+		{SessionID: "s1", ClientID: "c1", Command: "rm -rf /", ExitCode: -1, Error: "blocked by policy",
+			StartedAt: started.Add(time.Minute), Severity: SeverityHigh, Decision: DecisionBlocked},
+	}
+
+	var buf bytes.Buffer
+	e, err := NewExporter(&buf, FormatJSONL)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	for _, rec := range records {
+		if err := e.Write(rec); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	e.Flush()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("JSONL export has %d lines, want 2", len(lines))
+	}
+	var first map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("JSONL line is not JSON: %v", err)
+	}
+	if first["started_at"] != "2026-01-02T03:04:05Z" || first["decision"] != DecisionAllowed || first["duration_ms"] != 1500.0 {
+		t.Errorf("JSONL record = %v", first)
+	}
+
+	buf.Reset()
+	e, err = NewExporter(&buf, FormatCSV)
+	if err != nil {
+		t.Fatalf("NewExporter() error = %v", err)
+	}
+	for _, rec := range records {
+		e.Write(rec)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV export does not parse: %v", err)
+	}
+	if len(rows) != 3 || rows[0][0] != "started_at" {
+		t.Fatalf("CSV export = %v, want a header and 2 rows", rows)
+	}
+	if got := rows[2]; got[3] != "rm -rf /" || got[4] != "-1" || got[8] != DecisionBlocked {
+		t.Errorf("CSV row = %v", got)
+	}
+
+	if _, err := NewExporter(&buf, "xml"); err == nil {
+		t.Error("NewExporter() with an unknown format succeeded")
+	}
+}
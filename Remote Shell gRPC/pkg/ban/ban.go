@@ -0,0 +1,208 @@
+// Package ban tracks failed authentication attempts per peer and bans
+// peers that fail too often, for twice as long each time they are banned
+// again, so that tokens and session IDs cannot be guessed by brute force.
+package ban
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config controls when peers are banned and for how long
+type Config struct {
+	// MaxFailures failed attempts within Window ban a peer; zero
+	// disables banning
+	MaxFailures int
+	Window      time.Duration
+	// Duration is the length of a peer's first ban. Every further ban
+	// doubles it, up to MaxDuration. A peer that stays clean for
+	// MaxDuration after a ban ends starts over.
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// Ban describes a banned peer
+type Ban struct {
+	Peer  string
+	Until time.Time
+	// Count is how many times the peer has been banned in a row
+	Count int
+}
+
+// Stats are counters of the tracker's activity
+type Stats struct {
+	// Failures and Bans count failed attempts and bans since the
+	// tracker was created
+	Failures int64
+	Bans     int64
+	// Banned is the number of peers banned right now
+	Banned int
+}
+
+// peer is the state kept for one peer address
+type peer struct {
+	failures []time.Time
+	until    time.Time
+	count    int
+}
+
+// Tracker counts failed attempts and bans peers
+type Tracker struct {
+	cfg   Config
+	peers map[string]*peer
+	stats Stats
+	mu    sync.Mutex
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// New creates a tracker with the given configuration
+func New(cfg Config) *Tracker {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Minute
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = time.Minute
+	}
+	if cfg.MaxDuration < cfg.Duration {
+		cfg.MaxDuration = cfg.Duration
+	}
+	return &Tracker{
+		cfg:   cfg,
+		peers: make(map[string]*peer),
+		now:   time.Now,
+	}
+}
+
+// Banned returns when the peer's ban ends, if it is banned
+func (t *Tracker) Banned(addr string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[addr]
+	if !ok || !t.now().Before(p.until) {
+		return time.Time{}, false
+	}
+	return p.until, true
+}
+
+// Fail records a failed attempt by the peer. It returns the end of the ban
+// when this attempt got the peer banned.
+func (t *Tracker) Fail(addr string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stats.Failures++
+	if t.cfg.MaxFailures <= 0 {
+		return time.Time{}, false
+	}
+
+	now := t.now()
+	t.prune(now)
+
+	p, ok := t.peers[addr]
+	if !ok {
+		p = &peer{}
+		t.peers[addr] = p
+	}
+	if now.Before(p.until) {
+		return time.Time{}, false
+	}
+
+	// Only failures within the window count
+	recent := p.failures[:0]
+	for _, at := range p.failures {
+		if now.Sub(at) < t.cfg.Window {
+			recent = append(recent, at)
+		}
+	}
+	p.failures = append(recent, now)
+	if len(p.failures) < t.cfg.MaxFailures {
+		return time.Time{}, false
+	}
+
+	duration := t.cfg.Duration
+	for i := 0; i < p.count && duration < t.cfg.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > t.cfg.MaxDuration {
+		duration = t.cfg.MaxDuration
+	}
+	p.count++
+	p.until = now.Add(duration)
+	p.failures = nil
+	t.stats.Bans++
+	return p.until, true
+}
+
+// prune forgets peers that have stayed clean long enough, so that their
+// next ban is short again and memory does not grow with every address
+func (t *Tracker) prune(now time.Time) {
+	for addr, p := range t.peers {
+		last := p.until
+		if n := len(p.failures); n > 0 && p.failures[n-1].After(last) {
+			last = p.failures[n-1]
+		}
+		if now.Sub(last) > t.cfg.MaxDuration && now.Sub(last) > t.cfg.Window {
+			delete(t.peers, addr)
+		}
+	}
+}
+
+// List returns the banned peers, the longest ban first
+func (t *Tracker) List() []Ban {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var bans []Ban
+	for addr, p := range t.peers {
+		if now.Before(p.until) {
+			bans = append(bans, Ban{Peer: addr, Until: p.until, Count: p.count})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if !bans[i].Until.Equal(bans[j].Until) {
+			return bans[i].Until.After(bans[j].Until)
+		}
+		return bans[i].Peer < bans[j].Peer
+	})
+	return bans
+}
+
+// Clear lifts the ban of a peer and forgets its failures, or those of all
+// peers when addr is empty. It returns the number of bans lifted.
+func (t *Tracker) Clear(addr string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	cleared := 0
+	for a, p := range t.peers {
+		if addr != "" && a != addr {
+			continue
+		}
+		if now.Before(p.until) {
+			cleared++
+		}
+		delete(t.peers, a)
+	}
+	return cleared
+}
+
+// Stats returns the tracker's counters
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.stats
+	now := t.now()
+	for _, p := range t.peers {
+		if now.Before(p.until) {
+			stats.Banned++
+		}
+	}
+	return stats
+}
@@ -0,0 +1,125 @@
+package ban
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for the tracker
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newTracker(cfg Config) (*Tracker, *clock) {
+	c := &clock{now: time.Unix(1000, 0)}
+	t := New(cfg)
+	t.now = c.Now
+	return t, c
+}
+
+func TestTracker_BanAfterThreshold(t *testing.T) {
+	tr, c := newTracker(Config{MaxFailures: 3, Window: time.Minute, Duration: time.Minute, MaxDuration: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		if _, banned := tr.Fail("10.0.0.1"); banned {
+			t.Fatalf("Fail() #%d banned the peer, want only after 3", i+1)
+		}
+	}
+	until, banned := tr.Fail("10.0.0.1")
+	if !banned || !until.Equal(c.now.Add(time.Minute)) {
+		t.Fatalf("third Fail() = %v, %v; want a ban until %v", until, banned, c.now.Add(time.Minute))
+	}
+
+	if _, banned := tr.Banned("10.0.0.1"); !banned {
+		t.Error("Banned() = false during the ban")
+	}
+	if _, banned := tr.Banned("10.0.0.2"); banned {
+		t.Error("Banned() = true for another peer")
+	}
+
+	c.now = c.now.Add(time.Minute)
+	if _, banned := tr.Banned("10.0.0.1"); banned {
+		t.Error("Banned() = true after the ban ended")
+	}
+}
+
+func TestTracker_Window(t *testing.T) {
+	tr, c := newTracker(Config{MaxFailures: 3, Window: time.Minute, Duration: time.Minute})
+
+	// Failures spread wider than the window never add up to a ban
+	for i := 0; i < 10; i++ {
+		if _, banned := tr.Fail("10.0.0.1"); banned {
+			t.Fatalf("Fail() #%d banned the peer", i+1)
+		}
+		c.now = c.now.Add(31 * time.Second)
+	}
+}
+
+func TestTracker_ExponentialBans(t *testing.T) {
+	tr, c := newTracker(Config{MaxFailures: 1, Window: time.Minute, Duration: time.Minute, MaxDuration: 5 * time.Minute})
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, d := range want {
+		until, banned := tr.Fail("10.0.0.1")
+		if !banned || until.Sub(c.now) != d {
+			t.Fatalf("ban #%d = %v, %v; want %v", i+1, until.Sub(c.now), banned, d)
+		}
+		// Attempts during a ban do not extend it
+		if _, banned := tr.Fail("10.0.0.1"); banned {
+			t.Fatalf("Fail() during ban #%d started a new ban", i+1)
+		}
+		c.now = until
+	}
+
+	// A peer that stays clean long enough starts over
+	c.now = c.now.Add(10 * time.Minute)
+	until, _ := tr.Fail("10.0.0.1")
+	if d := until.Sub(c.now); d != time.Minute {
+		t.Errorf("ban after a clean period = %v, want %v", d, time.Minute)
+	}
+}
+
+func TestTracker_ListClearStats(t *testing.T) {
+	tr, _ := newTracker(Config{MaxFailures: 1, Window: time.Minute, Duration: time.Minute, MaxDuration: time.Hour})
+
+	tr.Fail("10.0.0.1")
+	tr.Fail("10.0.0.1")
+	tr.Fail("10.0.0.2")
+
+	bans := tr.List()
+	if len(bans) != 2 || bans[0].Peer != "10.0.0.1" || bans[1].Peer != "10.0.0.2" {
+		t.Fatalf("List() = %+v, want 10.0.0.1 and 10.0.0.2", bans)
+	}
+
+	stats := tr.Stats()
+	if stats.Failures != 3 || stats.Bans != 2 || stats.Banned != 2 {
+		t.Errorf("Stats() = %+v, want 3 failures, 2 bans, 2 banned", stats)
+	}
+
+	if n := tr.Clear("10.0.0.1"); n != 1 {
+		t.Errorf("Clear(10.0.0.1) = %d, want 1", n)
+	}
+	if _, banned := tr.Banned("10.0.0.1"); banned {
+		t.Error("Banned() = true after Clear()")
+	}
+	if n := tr.Clear(""); n != 1 {
+		t.Errorf("Clear(\"\") = %d, want 1", n)
+	}
+	if len(tr.List()) != 0 {
+		t.Errorf("List() after clearing all = %+v, want none", tr.List())
+	}
+}
+
+func TestTracker_Disabled(t *testing.T) {
+	tr, _ := newTracker(Config{})
+	for i := 0; i < 100; i++ {
+		if _, banned := tr.Fail("10.0.0.1"); banned {
+			t.Fatal("Fail() banned a peer with banning disabled")
+		}
+	}
+	if stats := tr.Stats(); stats.Failures != 100 {
+		t.Errorf("Stats().Failures = %d, want 100", stats.Failures)
+	}
+}
@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// argvCommand returns the shell statement that replaces the shell with an
+// argument vector, and the arguments to pass after it so that the shell
+// sees the vector as its positional parameters rather than as code
+func argvCommand(shell string, argv []string) (string, []string) {
+	if filepath.Base(shell) == "fish" {
+		return "exec $argv", argv
+	}
+	// The first argument after the command becomes $0
+	return `exec "$@"`, append([]string{filepath.Base(shell)}, argv...)
+}
+
+// lookPath finds a program the way a shell started in dir with env would:
+// names with a slash are taken relative to dir, others are searched for in
+// the environment's PATH. It returns the path to run and whether an
+// executable file was found there.
+func lookPath(name, dir string, env []string) (string, bool) {
+	candidates := []string{name}
+	if !strings.Contains(name, "/") {
+		candidates = nil
+		for _, entry := range filepath.SplitList(envPath(env)) {
+			if entry == "" {
+				entry = "."
+			}
+			candidates = append(candidates, filepath.Join(entry, name))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if !filepath.IsAbs(candidate) && dir != "" {
+			candidate = filepath.Join(dir, candidate)
+		}
+		info, err := os.Stat(candidate)
+		if err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// envPath returns the PATH of an environment, or of the server when env is
+// nil
+func envPath(env []string) string {
+	if env == nil {
+		return os.Getenv("PATH")
+	}
+	path := ""
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = value
+		}
+	}
+	return path
+}
+
+// QuoteArgv joins an argument vector into a command line that the shell
+// would split back into the same vector, quoting only the arguments that
+// need it so that it stays readable in logs and policy patterns
+func QuoteArgv(argv []string) string {
+	words := make([]string, len(argv))
+	for i, arg := range argv {
+		// A leading name=value would be taken as an assignment
+		if arg == "" || strings.Trim(arg, safeArgChars) != "" || (i == 0 && strings.Contains(arg, "=")) {
+			arg = ShellQuote(arg)
+		}
+		words[i] = arg
+	}
+	return strings.Join(words, " ")
+}
+
+// safeArgChars are the characters with no special meaning to the shell
+const safeArgChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-./:@%+=,"
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os"
+	"syscall"
+)
+
+// killedForCPU reports whether the shell, or the last command it waited
+// for, died of one of the signals the kernel sends when RLIMIT_CPU runs out
+func killedForCPU(state *os.ProcessState) bool {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal() == syscall.SIGKILL || ws.Signal() == syscall.SIGXCPU
+	}
+	code := state.ExitCode()
+	return code == 128+int(syscall.SIGKILL) || code == 128+int(syscall.SIGXCPU)
+}
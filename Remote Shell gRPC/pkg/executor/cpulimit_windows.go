@@ -0,0 +1,10 @@
+//go:build windows
+
+package executor
+
+import "os"
+
+// killedForCPU always reports false as Windows has no CPU time rlimit
+func killedForCPU(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,424 @@
+package executor
+
+import (
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"remote-shell-rpc/pkg/shellparse"
+)
+
+// dangerousPatterns are matched against the raw text of commands that
+// cannot be parsed
+var dangerousPatterns = []string{
+	"rm -rf /",
+	"rm -rf /*",
+	"mkfs",
+	"dd if=/dev/zero",
+	":(){ :|:& };:",
+	"> /dev/sda",
+	"chmod -R 777 /",
+}
+
+// maxNesting bounds how deep "sh -c" and eval arguments are parsed
+const maxNesting = 4
+
+// commandPrefixes run the command given in their arguments
+var commandPrefixes = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "nice": true, "nohup": true,
+	"time": true, "exec": true, "command": true, "builtin": true,
+	"timeout": true, "ionice": true, "stdbuf": true, "xargs": true,
+}
+
+// prefixValueFlags are options of command prefixes that take a value
+var prefixValueFlags = map[string]bool{
+	"-u": true, "-g": true, "-n": true, "-c": true, "-C": true, "-D": true,
+	"-p": true, "-s": true, "-k": true, "-U": true, "-h": true, "-t": true,
+}
+
+// shells run the script given with -c, a script file or their standard
+// input
+var shells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "ash": true,
+}
+
+// diskDevices are prefixes of block device paths
+var diskDevices = []string{
+	"/dev/sd", "/dev/hd", "/dev/vd", "/dev/xvd", "/dev/nvme", "/dev/mmcblk", "/dev/disk/",
+}
+
+// IsDangerousCommand checks if a command might be dangerous. The command is
+// parsed like a shell would, so quoting and spacing do not hide a dangerous
+// command and quoted text such as an echo argument does not match. Commands
+// that cannot be parsed are checked against a list of known patterns.
+func IsDangerousCommand(command string) bool {
+	return isDangerous(command, 0)
+}
+
+// isDangerous checks a command line, following nested shell scripts up to
+// maxNesting deep
+func isDangerous(command string, depth int) bool {
+	commands, err := shellparse.Parse(command)
+	if err != nil || depth > maxNesting {
+		return matchesDangerousPattern(command)
+	}
+	for i, cmd := range commands {
+		if isDangerousArgs(cmd, depth) {
+			return true
+		}
+		if ReadsScript(cmd) {
+			script, ok := stdinScript(commands, i)
+			if !ok || isDangerous(script, depth+1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesDangerousPattern is the plain text check for unparsable commands
+func matchesDangerousPattern(command string) bool {
+	cmdLower := strings.ToLower(command)
+	for _, d := range dangerousPatterns {
+		if strings.Contains(cmdLower, strings.ToLower(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDangerousArgs checks a single parsed command
+func isDangerousArgs(cmd shellparse.Command, depth int) bool {
+	for _, r := range cmd.Redirects {
+		if r.Op != "<" && r.Op != "<<" && r.Op != "<<<" && isDiskDevice(r.Target) {
+			return true
+		}
+	}
+
+	args := unwrapCommand(cmd.Args)
+	if len(args) == 0 {
+		return false
+	}
+	name := filepath.Base(args[0])
+
+	// A function that pipes into or backgrounds itself is a fork bomb
+	if cmd.Function != "" && args[0] == cmd.Function && (cmd.Piped || cmd.Background) {
+		return true
+	}
+
+	switch {
+	case name == "rm":
+		return recursiveOnRoot(args[1:], true)
+	case name == "chmod" || name == "chown":
+		return recursiveOnRoot(args[1:], false)
+	case name == "mkfs" || name == "mke2fs" || strings.HasPrefix(name, "mkfs."):
+		return true
+	case name == "dd":
+		for _, arg := range args[1:] {
+			if value, ok := strings.CutPrefix(arg, "if="); ok && (value == "/dev/zero" || value == "/dev/urandom" || value == "/dev/random") {
+				return true
+			}
+			if value, ok := strings.CutPrefix(arg, "of="); ok && isDiskDevice(value) {
+				return true
+			}
+		}
+	}
+	if script, ok := nestedScript(args); ok {
+		return isDangerous(script, depth+1)
+	}
+	return false
+}
+
+// NestedScript returns the script a command hands to another shell with
+// "sh -c" or eval, looking through prefixes such as sudo or env
+func NestedScript(cmd shellparse.Command) (string, bool) {
+	args := unwrapCommand(cmd.Args)
+	if len(args) == 0 {
+		return "", false
+	}
+	return nestedScript(args)
+}
+
+// nestedScript returns the script of an unwrapped shell or eval command
+func nestedScript(args []string) (string, bool) {
+	name := filepath.Base(args[0])
+	switch {
+	case shells[name]:
+		script, inline, _ := shellInput(args[1:])
+		return script, inline
+	case name == "eval" && len(args) > 1:
+		return strings.Join(args[1:], " "), true
+	}
+	return "", false
+}
+
+// ReadsScript reports whether a command is a shell that runs the commands
+// on its standard input, fed by a pipe or an input redirection as in
+// "echo ls | sh" or "bash <<< ls". A shell in a function body counts too,
+// since the function may be called with its input piped in.
+func ReadsScript(cmd shellparse.Command) bool {
+	args := unwrapCommand(cmd.Args)
+	if len(args) == 0 || !shells[filepath.Base(args[0])] {
+		return false
+	}
+	if _, _, stdin := shellInput(args[1:]); !stdin {
+		return false
+	}
+	if cmd.FromPipe || cmd.Function != "" {
+		return true
+	}
+	for _, r := range cmd.Redirects {
+		if isInputRedirect(r.Op) {
+			return true
+		}
+	}
+	return false
+}
+
+// stdinScript returns the script a shell that reads its standard input
+// gets, when the command line holds it: a here-document, a here-string or
+// the output of an echo or cat piped in. Anything else, including text
+// with expansions, cannot be known before the command runs.
+func stdinScript(commands []shellparse.Command, i int) (string, bool) {
+	cmd := commands[i]
+
+	// The last input redirection wins over the pipe
+	script, ok, redirected := redirectedInput(cmd)
+	if redirected {
+		return script, ok
+	}
+	if !cmd.FromPipe || cmd.Function != "" || i == 0 {
+		return "", false
+	}
+
+	// Only a single command writing straight into the pipe can be read;
+	// a group or loop before it ends before its last command
+	prev := commands[i-1]
+	if !prev.Piped || prev.Background {
+		return "", false
+	}
+	args := unwrapCommand(prev.Args)
+	if len(args) == 0 {
+		return "", false
+	}
+	switch filepath.Base(args[0]) {
+	case "echo":
+		if slices.ContainsFunc(prev.Redirects, func(r shellparse.Redirect) bool { return !isInputRedirect(r.Op) }) {
+			return "", false
+		}
+		words := args[1:]
+		for len(words) > 0 && isEchoOption(words[0]) {
+			words = words[1:]
+		}
+		script = strings.Join(words, " ") + "\n"
+	case "cat":
+		if len(args) > 1 || prev.FromPipe {
+			return "", false
+		}
+		script, ok, redirected = redirectedInput(prev)
+		if !ok || !redirected {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	// Some shells' echo interprets escapes, and expansions are only
+	// known at run time
+	if strings.ContainsAny(script, "\\$`") {
+		return "", false
+	}
+	return script, true
+}
+
+// redirectedInput returns the text a command's last input redirection
+// feeds it, if it has one. Only here-documents and here-strings can be
+// read; files and descriptors cannot.
+func redirectedInput(cmd shellparse.Command) (text string, ok, redirected bool) {
+	heredoc := 0
+	for _, r := range cmd.Redirects {
+		switch {
+		case r.Op == "<<":
+			text, ok, redirected = "", false, true
+			if heredoc < len(cmd.Heredocs) {
+				text, ok = cmd.Heredocs[heredoc], true
+			}
+			heredoc++
+		case r.Op == "<<<":
+			text, ok, redirected = r.Target+"\n", true, true
+		case isInputRedirect(r.Op):
+			text, ok, redirected = "", false, true
+		}
+	}
+	if ok && strings.ContainsAny(text, "$`") {
+		return "", false, true
+	}
+	return text, ok, redirected
+}
+
+// isInputRedirect reports whether a redirection operator replaces the
+// standard input of a command
+func isInputRedirect(op string) bool {
+	switch op {
+	case "<", "<<", "<<<", "<&", "<>":
+		return true
+	}
+	return false
+}
+
+// isEchoOption reports whether an echo argument is one of its options
+func isEchoOption(arg string) bool {
+	return len(arg) > 1 && arg[0] == '-' && strings.Trim(arg[1:], "neE") == ""
+}
+
+// shellInput returns where a shell reads its commands from, given the
+// arguments after its name: the script of -c, reported as inline, a script
+// file, or standard input when it has neither or is told to with -s, "-"
+// or /dev/stdin. The -c flag may be combined with others, as in "bash
+// -xc", and the script is the first argument that is not an option.
+func shellInput(args []string) (script string, inline, stdin bool) {
+	command, fromStdin := false, false
+	operand := func(arg string) (string, bool, bool) {
+		switch {
+		case command:
+			return arg, true, false
+		case fromStdin || arg == "/dev/stdin":
+			return "", false, true
+		}
+		// Without -c the first operand is a script file
+		return arg, false, false
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--" || arg == "-":
+			// The remaining arguments are not options
+			if i+1 < len(args) {
+				return operand(args[i+1])
+			}
+			return "", false, !command
+		case strings.HasPrefix(arg, "--"):
+			if arg == "--rcfile" || arg == "--init-file" {
+				i++
+			}
+		case len(arg) > 1 && (arg[0] == '-' || arg[0] == '+'):
+			if arg[0] == '-' && strings.ContainsRune(arg[1:], 'c') {
+				command = true
+			}
+			if arg[0] == '-' && strings.ContainsRune(arg[1:], 's') {
+				fromStdin = true
+			}
+			// -o and -O take the name of an option
+			if strings.ContainsAny(arg[1:], "oO") {
+				i++
+			}
+		default:
+			return operand(arg)
+		}
+	}
+	return "", false, !command
+}
+
+// unwrapCommand strips variable assignments and prefixes such as sudo or
+// env, returning the command that actually runs
+func unwrapCommand(args []string) []string {
+	for len(args) > 0 {
+		if isAssignment(args[0]) {
+			args = args[1:]
+			continue
+		}
+		name := filepath.Base(args[0])
+		if !commandPrefixes[name] {
+			return args
+		}
+		args = args[1:]
+		for len(args) > 0 {
+			arg := args[0]
+			if arg == "--" {
+				args = args[1:]
+				break
+			}
+			if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+				args = args[1:]
+				if prefixValueFlags[arg] && len(args) > 0 {
+					args = args[1:]
+				}
+				continue
+			}
+			if name == "env" && isAssignment(arg) {
+				args = args[1:]
+				continue
+			}
+			break
+		}
+		// timeout takes a duration before the command
+		if name == "timeout" && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	return args
+}
+
+// isAssignment reports whether a word is a NAME=value assignment
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// recursiveOnRoot reports whether the arguments of rm, chmod or chown ask
+// for a recursive operation on the root directory
+func recursiveOnRoot(args []string, rm bool) bool {
+	recursive := false
+	root := false
+	options := true
+	for _, arg := range args {
+		switch {
+		case options && arg == "--":
+			options = false
+		case options && arg == "--no-preserve-root":
+			if rm {
+				return true
+			}
+		case options && arg == "--recursive":
+			recursive = true
+		case options && strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && len(arg) > 1:
+			// rm takes -r as well as -R; for chmod and chown only -R
+			// is recursive
+			if strings.Contains(arg, "R") || (rm && strings.Contains(arg, "r")) {
+				recursive = true
+			}
+		default:
+			if isRoot(arg) {
+				root = true
+			}
+		}
+	}
+	return recursive && root
+}
+
+// isRoot reports whether a path names the root directory or everything
+// in it
+func isRoot(p string) bool {
+	if !strings.HasPrefix(p, "/") {
+		return false
+	}
+	return path.Clean(strings.TrimSuffix(p, "*")) == "/"
+}
+
+// isDiskDevice reports whether a path is a block device of a disk
+func isDiskDevice(p string) bool {
+	for _, prefix := range diskDevices {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
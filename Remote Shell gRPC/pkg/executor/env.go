@@ -0,0 +1,93 @@
+package executor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultBaselineEnv are the variables of the server environment commands
+// start from unless configured otherwise
+var DefaultBaselineEnv = []string{"PATH", "HOME", "LANG"}
+
+// DefaultDenyEnv are the patterns of server environment variables commands
+// do not inherit unless configured otherwise: cloud credentials and
+// anything named like a secret
+var DefaultDenyEnv = []string{
+	"AWS_*",
+	"AZURE_*",
+	"GOOGLE_APPLICATION_CREDENTIALS",
+	"*_TOKEN",
+	"*_SECRET",
+	"*_SECRET_*",
+	"*_PASSWORD",
+	"*_API_KEY",
+	"*_PRIVATE_KEY",
+}
+
+// EnvPolicy decides which variables of the server's own environment
+// commands inherit. Variables a session sets itself are not affected.
+// Commands start from the baseline variables only, unless All opts in to
+// the whole environment. Allow and Deny hold patterns matched against
+// variable names with path.Match, e.g. "LC_*" or "*_TOKEN".
+type EnvPolicy struct {
+	// Baseline names the variables always passed on
+	Baseline []string `yaml:"baseline"`
+	// All passes on the rest of the environment too, as far as Allow lets
+	// it
+	All bool `yaml:"all"`
+	// Allow limits the variables All passes on; empty passes every
+	// variable that is not denied
+	Allow []string `yaml:"allow"`
+	// Deny lists variables that are withheld, even when in the baseline or
+	// allowed
+	Deny []string `yaml:"deny"`
+}
+
+// Validate checks that every pattern is well formed
+func (p EnvPolicy) Validate() error {
+	for _, list := range [][]string{p.Allow, p.Deny} {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Passes reports whether commands inherit the variable name
+func (p EnvPolicy) Passes(name string) bool {
+	if matchesAny(p.Deny, name) {
+		return false
+	}
+	for _, baseline := range p.Baseline {
+		if name == baseline {
+			return true
+		}
+	}
+	return p.All && (len(p.Allow) == 0 || matchesAny(p.Allow, name))
+}
+
+// Inherit returns the "NAME=value" entries of env that commands inherit.
+// The result is never nil, so that an empty environment stays empty.
+func (p EnvPolicy) Inherit(env []string) []string {
+	inherited := make([]string, 0, len(env))
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if p.Passes(name) {
+			inherited = append(inherited, entry)
+		}
+	}
+	return inherited
+}
+
+// matchesAny reports whether name matches one of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package executor
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEnvPolicy_Inherit(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin",
+		"LANG=C.UTF-8",
+		"LC_ALL=C",
+		"AWS_SECRET_ACCESS_KEY=x",
+		"GITHUB_TOKEN=x",
+		"DB_PASSWORD=x",
+	}
+
+	tests := []struct {
+		name   string
+		policy EnvPolicy
+		want   []string
+	}{
+		{"default", EnvPolicy{Baseline: DefaultBaselineEnv, Deny: DefaultDenyEnv}, []string{"PATH=/usr/bin", "LANG=C.UTF-8"}},
+		{"all", EnvPolicy{All: true, Deny: DefaultDenyEnv}, []string{"PATH=/usr/bin", "LANG=C.UTF-8", "LC_ALL=C"}},
+		{"allow", EnvPolicy{All: true, Allow: []string{"PATH", "LC_*", "GITHUB_TOKEN"}}, []string{"PATH=/usr/bin", "LC_ALL=C", "GITHUB_TOKEN=x"}},
+		{"allow without all", EnvPolicy{Baseline: []string{"LANG"}, Allow: []string{"PATH"}}, []string{"LANG=C.UTF-8"}},
+		{"deny wins", EnvPolicy{Baseline: []string{"DB_PASSWORD"}, All: true, Allow: []string{"PATH", "*_TOKEN"}, Deny: DefaultDenyEnv}, []string{"PATH=/usr/bin"}},
+		{"nothing", EnvPolicy{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Inherit(env); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Inherit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := (EnvPolicy{Deny: []string{"["}}).Validate(); err == nil {
+		t.Error("Validate() accepted a malformed pattern")
+	}
+}
+
+func TestExecutor_EmptyEnvironment(t *testing.T) {
+	t.Setenv("EXECUTOR_TEST_VAR", "inherited")
+
+	// An empty environment must not fall back to the server's
+	e := New(DefaultConfig())
+	e.SetEnvironment([]string{})
+	result, err := e.ExecuteWithOptions(context.Background(), "echo \"[$EXECUTOR_TEST_VAR]\"", Options{Env: map[string]string{"OTHER": "1"}})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error = %v", err)
+	}
+	if result.Output != "[]\n" {
+		t.Errorf("output = %q, want %q", result.Output, "[]\n")
+	}
+}
@@ -1,12 +1,14 @@
 package executor
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +21,12 @@ var (
 	ErrInvalidCommand  = errors.New("invalid command")
 	ErrEmptyCommand    = errors.New("empty command")
 	ErrCommandNotFound = errors.New("command not found")
+	// ErrCPULimitExceeded is returned when a command is killed for using
+	// up its CPU time limit
+	ErrCPULimitExceeded = errors.New("cpu time limit exceeded")
+	// ErrIsolationUnsupported is returned when commands cannot be cut off
+	// from the network on this system
+	ErrIsolationUnsupported = errors.New("network isolation is not supported")
 )
 
 // OutputType represents the type of command output
@@ -35,6 +43,54 @@ type Output struct {
 	Data       []byte
 	IsComplete bool
 	ExitCode   int
+	// CPUTime is the user and system time used by the command, set on
+	// the completion message
+	CPUTime time.Duration
+	// CPULimitExceeded is set on the completion message of a command
+	// killed for using up its CPU time limit
+	CPULimitExceeded bool
+
+	// buf is the pooled buffer backing Data
+	buf *[]byte
+}
+
+// Release hands the buffer behind Data back to the executor for reuse by
+// later output. Data must not be used afterwards. Calling it is optional;
+// unreleased buffers are left to the garbage collector.
+func (o *Output) Release() {
+	if o.buf != nil {
+		putBuffer(o.buf)
+		o.buf = nil
+		o.Data = nil
+	}
+}
+
+// DefaultChunkSize is the most output carried by one Output message unless
+// configured otherwise
+const DefaultChunkSize = 32 * 1024
+
+// bufferPools holds a *sync.Pool of output buffers per chunk size
+var bufferPools sync.Map
+
+// getBuffer returns a pooled buffer of the given size
+func getBuffer(size int) *[]byte {
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+// putBuffer returns a buffer to the pool for its size
+func putBuffer(buf *[]byte) {
+	if pool, ok := bufferPools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
 }
 
 // Result represents the complete result of a command execution
@@ -43,6 +99,7 @@ type Result struct {
 	Error         string
 	ExitCode      int
 	ExecutionTime time.Duration
+	CPUTime       time.Duration
 }
 
 // Config holds executor configuration
@@ -51,6 +108,26 @@ type Config struct {
 	DefaultTimeout time.Duration
 	WorkingDir     string
 	Environment    []string
+	// LoginShell starts the shell as a login shell (e.g. bash -lc)
+	LoginShell bool
+	// InitScript is sourced before every command when set
+	InitScript string
+	// ChunkSizeBytes is the most streamed output sent in one message
+	ChunkSizeBytes int
+	// FlushInterval is how long streamed output may be held back to fill
+	// a chunk. Zero sends whole lines as soon as they are read.
+	FlushInterval time.Duration
+	// IsolateNetwork runs every command in a network namespace of its
+	// own, leaving it no network access. It needs Linux and a kernel that
+	// lets the server create namespaces.
+	IsolateNetwork bool
+	// Limits are resource limits set for every command
+	Limits Limits
+	// Priority lowers the scheduling and IO priority of commands
+	Priority Priority
+	// Umask is the octal file mode creation mask commands start with, as
+	// returned by ParseUmask. Empty keeps the server's own.
+	Umask string
 }
 
 // DefaultConfig returns the default executor configuration
@@ -60,9 +137,40 @@ func DefaultConfig() Config {
 		DefaultTimeout: 30 * time.Second,
 		WorkingDir:     "",
 		Environment:    nil,
+		ChunkSizeBytes: DefaultChunkSize,
 	}
 }
 
+// Options override the executor configuration for a single command
+// without changing it for later commands
+type Options struct {
+	// WorkingDir replaces the configured working directory when set
+	WorkingDir string
+	// Env holds extra environment variables layered on top of the
+	// configured environment
+	Env map[string]string
+	// Stdin is fed to the command's standard input when set
+	Stdin []byte
+	// ChunkSizeBytes and FlushInterval replace the configured streaming
+	// settings when set
+	ChunkSizeBytes int
+	FlushInterval  time.Duration
+	// NoGlob runs the command with pathname expansion turned off, in
+	// shells for which SupportsNoGlob is true
+	NoGlob bool
+	// Argv is run in place of the command, which then only describes it:
+	// the program is started directly with these arguments, which no
+	// shell ever parses. The shell is only involved when the session's
+	// login shell, umask, limits or init script must be set up first, or
+	// when the program cannot be found; it then execs the arguments as
+	// they are.
+	Argv []string
+	// StageTimeouts limit the stages of a pipeline one by one, so that
+	// each runs under the timeout it would have on its own. A stage with
+	// none, or zero, only ends with the context.
+	StageTimeouts []time.Duration
+}
+
 // Executor handles shell command execution
 type Executor struct {
 	config Config
@@ -77,6 +185,9 @@ func New(cfg Config) *Executor {
 	if cfg.DefaultTimeout == 0 {
 		cfg.DefaultTimeout = 30 * time.Second
 	}
+	if cfg.ChunkSizeBytes <= 0 {
+		cfg.ChunkSizeBytes = DefaultChunkSize
+	}
 	return &Executor{
 		config: cfg,
 	}
@@ -96,6 +207,35 @@ func (e *Executor) GetWorkingDir() string {
 	return e.config.WorkingDir
 }
 
+// SetPriority sets the scheduling and IO priority of later commands
+func (e *Executor) SetPriority(p Priority) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config.Priority = p
+}
+
+// GetPriority returns the priority commands run at
+func (e *Executor) GetPriority() Priority {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.config.Priority
+}
+
+// SetUmask sets the file mode creation mask of later commands
+func (e *Executor) SetUmask(umask string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config.Umask = umask
+}
+
+// GetUmask returns the file mode creation mask commands start with, empty
+// when they keep the server's
+func (e *Executor) GetUmask() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.config.Umask
+}
+
 // SetEnvironment sets the environment variables for command execution
 func (e *Executor) SetEnvironment(env []string) {
 	e.mu.Lock()
@@ -112,25 +252,19 @@ func (e *Executor) AddEnvironment(env ...string) {
 
 // Execute runs a command and returns the complete result
 func (e *Executor) Execute(ctx context.Context, command string) (*Result, error) {
+	return e.ExecuteWithOptions(ctx, command, Options{})
+}
+
+// ExecuteWithOptions runs a command with per-command overrides and returns
+// the complete result
+func (e *Executor) ExecuteWithOptions(ctx context.Context, command string, opts Options) (*Result, error) {
 	if err := validateCommand(command); err != nil {
 		return nil, err
 	}
 
 	start := time.Now()
 
-	e.mu.RLock()
-	shell := e.config.Shell
-	workingDir := e.config.WorkingDir
-	environment := e.config.Environment
-	e.mu.RUnlock()
-
-	cmd := exec.CommandContext(ctx, shell, "-c", command)
-	if workingDir != "" {
-		cmd.Dir = workingDir
-	}
-	if len(environment) > 0 {
-		cmd.Env = environment
-	}
+	cmd := e.command(ctx, command, opts)
 
 	var stdout, stderr strings.Builder
 	cmd.Stdout = &stdout
@@ -143,6 +277,7 @@ func (e *Executor) Execute(ctx context.Context, command string) (*Result, error)
 		Output:        stdout.String(),
 		Error:         stderr.String(),
 		ExecutionTime: executionTime,
+		CPUTime:       cpuTime(cmd),
 	}
 
 	if err != nil {
@@ -156,6 +291,9 @@ func (e *Executor) Execute(ctx context.Context, command string) (*Result, error)
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
 			result.ExitCode = exitErr.ExitCode()
+			if cpuLimitExceeded(cmd, e.limits()) {
+				return result, ErrCPULimitExceeded
+			}
 			return result, nil
 		}
 
@@ -172,23 +310,17 @@ func (e *Executor) Execute(ctx context.Context, command string) (*Result, error)
 
 // ExecuteStream runs a command and streams the output
 func (e *Executor) ExecuteStream(ctx context.Context, command string) (<-chan Output, error) {
+	return e.ExecuteStreamWithOptions(ctx, command, Options{})
+}
+
+// ExecuteStreamWithOptions runs a command with per-command overrides and
+// streams the output
+func (e *Executor) ExecuteStreamWithOptions(ctx context.Context, command string, opts Options) (<-chan Output, error) {
 	if err := validateCommand(command); err != nil {
 		return nil, err
 	}
 
-	e.mu.RLock()
-	shell := e.config.Shell
-	workingDir := e.config.WorkingDir
-	environment := e.config.Environment
-	e.mu.RUnlock()
-
-	cmd := exec.CommandContext(ctx, shell, "-c", command)
-	if workingDir != "" {
-		cmd.Dir = workingDir
-	}
-	if len(environment) > 0 {
-		cmd.Env = environment
-	}
+	cmd := e.command(ctx, command, opts)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -204,6 +336,16 @@ func (e *Executor) ExecuteStream(ctx context.Context, command string) (<-chan Ou
 		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 
+	e.mu.RLock()
+	stream := streamConfig{chunkSize: e.config.ChunkSizeBytes, flushInterval: e.config.FlushInterval}
+	e.mu.RUnlock()
+	if opts.ChunkSizeBytes > 0 {
+		stream.chunkSize = opts.ChunkSizeBytes
+	}
+	if opts.FlushInterval > 0 {
+		stream.flushInterval = opts.FlushInterval
+	}
+
 	outputCh := make(chan Output, 100)
 
 	go func() {
@@ -215,13 +357,13 @@ func (e *Executor) ExecuteStream(ctx context.Context, command string) (<-chan Ou
 		// Read stdout
 		go func() {
 			defer wg.Done()
-			readOutput(ctx, stdout, Stdout, outputCh)
+			readOutput(ctx, stdout, Stdout, stream, outputCh)
 		}()
 
 		// Read stderr
 		go func() {
 			defer wg.Done()
-			readOutput(ctx, stderr, Stderr, outputCh)
+			readOutput(ctx, stderr, Stderr, stream, outputCh)
 		}()
 
 		wg.Wait()
@@ -236,8 +378,14 @@ func (e *Executor) ExecuteStream(ctx context.Context, command string) (<-chan Ou
 		}
 
 		// Send completion signal
+		done := Output{
+			IsComplete:       true,
+			ExitCode:         exitCode,
+			CPUTime:          cpuTime(cmd),
+			CPULimitExceeded: exitCode != 0 && ctx.Err() == nil && cpuLimitExceeded(cmd, e.limits()),
+		}
 		select {
-		case outputCh <- Output{IsComplete: true, ExitCode: exitCode}:
+		case outputCh <- done:
 		case <-ctx.Done():
 		}
 	}()
@@ -245,52 +393,231 @@ func (e *Executor) ExecuteStream(ctx context.Context, command string) (<-chan Ou
 	return outputCh, nil
 }
 
-// readOutput reads from a reader and sends output to the channel
-func readOutput(ctx context.Context, reader io.Reader, outputType OutputType, ch chan<- Output) {
-	scanner := bufio.NewScanner(reader)
-	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // 1MB max line size
+// limits returns the configured resource limits
+func (e *Executor) limits() Limits {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.config.Limits
+}
 
-	for scanner.Scan() {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			data := append(scanner.Bytes(), '\n')
-			ch <- Output{
-				Type: outputType,
-				Data: data,
+// cpuTime returns the CPU time used by a finished command, including the
+// children it waited for
+func cpuTime(cmd *exec.Cmd) time.Duration {
+	if cmd.ProcessState == nil {
+		return 0
+	}
+	return cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+}
+
+// command builds the shell invocation for a command, or the direct one
+// for an argument vector
+func (e *Executor) command(ctx context.Context, command string, opts Options) *exec.Cmd {
+	e.mu.RLock()
+	shell := e.config.Shell
+	workingDir := e.config.WorkingDir
+	environment := e.config.Environment
+	loginShell := e.config.LoginShell
+	initScript := e.config.InitScript
+	isolate := e.config.IsolateNetwork
+	limits := e.config.Limits
+	priority := e.config.Priority
+	umask := e.config.Umask
+	e.mu.RUnlock()
+
+	if opts.WorkingDir != "" {
+		if filepath.IsAbs(opts.WorkingDir) || workingDir == "" {
+			workingDir = opts.WorkingDir
+		} else {
+			workingDir = filepath.Join(workingDir, opts.WorkingDir)
+		}
+	}
+
+	if len(opts.Env) > 0 {
+		// Copy so the configured environment is never modified
+		base := environment
+		if base == nil {
+			base = os.Environ()
+		}
+		env := make([]string, 0, len(base)+len(opts.Env))
+		env = append(env, base...)
+		for k, v := range opts.Env {
+			env = append(env, k+"="+v)
+		}
+		environment = env
+	}
+
+	limitPrefix := limitCommand(shell, limits)
+	program, direct := "", false
+	if len(opts.Argv) > 0 && !loginShell && initScript == "" && umask == "" && limitPrefix == "" {
+		program, direct = lookPath(opts.Argv[0], workingDir, environment)
+	}
+
+	var name string
+	var args []string
+	if direct {
+		name, args = program, opts.Argv[1:]
+	} else {
+		var params []string
+		if len(opts.Argv) > 0 {
+			command, params = argvCommand(shell, opts.Argv)
+		} else if opts.NoGlob {
+			// Only the command itself is affected; the init script
+			// still globs
+			if prefix := noGlobCommand(shell); prefix != "" {
+				command = prefix + "\n" + command
 			}
 		}
+		if initScript != "" {
+			command = sourceCommand(shell, initScript) + "\n" + command
+		}
+		// The init script may set a umask of its own, so it is set first
+		if umask != "" {
+			command = "umask " + umask + "\n" + command
+		}
+		// Limits come first so that the init script is bound by them too
+		if limitPrefix != "" {
+			command = limitPrefix + "\n" + command
+		}
+
+		name, args = shell, append([]string{"-c", command}, params...)
+		if loginShell {
+			args = append([]string{"-l"}, args...)
+		}
+	}
+	if priority != (Priority{}) {
+		name, args = priorityCommand(priority, name, args)
 	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	if isolate {
+		isolateNetwork(cmd)
+	}
+	killProcessGroup(cmd)
+
+	if workingDir != "" {
+		cmd.Dir = workingDir
+	}
+	// An empty but non-nil environment is kept empty
+	if environment != nil {
+		cmd.Env = environment
+	}
+
+	if len(opts.Stdin) > 0 {
+		cmd.Stdin = bytes.NewReader(opts.Stdin)
+	}
+
+	return cmd
 }
 
-// validateCommand checks if a command is valid
-func validateCommand(command string) error {
-	command = strings.TrimSpace(command)
-	if command == "" {
-		return ErrEmptyCommand
+// sourceCommand returns the shell statement that sources a script
+func sourceCommand(shell, script string) string {
+	if filepath.Base(shell) == "fish" {
+		return "source " + ShellQuote(script)
 	}
-	return nil
+	return ". " + ShellQuote(script)
 }
 
-// IsDangerousCommand checks if a command might be dangerous
-// This is a simple check and can be extended based on requirements
-func IsDangerousCommand(command string) bool {
-	dangerous := []string{
-		"rm -rf /",
-		"rm -rf /*",
-		"mkfs",
-		"dd if=/dev/zero",
-		":(){ :|:& };:",
-		"> /dev/sda",
-		"chmod -R 777 /",
+// noGlobCommand returns the shell statement that turns off pathname
+// expansion, or "" when the shell has none
+func noGlobCommand(shell string) string {
+	switch filepath.Base(shell) {
+	case "fish", "csh", "tcsh":
+		return ""
+	case "zsh":
+		return "setopt noglob"
 	}
+	return "set -f"
+}
+
+// SupportsNoGlob reports whether pathname expansion can be turned off in a
+// shell
+func SupportsNoGlob(shell string) bool {
+	return noGlobCommand(shell) != ""
+}
+
+// ShellQuote quotes a string for safe use as a single shell word
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// streamConfig controls how streamed output is cut into messages
+type streamConfig struct {
+	chunkSize     int
+	flushInterval time.Duration
+}
+
+// readOutput sends output to the channel in chunks of up to the chunk
+// size. Without a flush interval, whole lines are sent as soon as they are
+// read; lines and multi-byte characters are then only split when a line
+// is longer than a chunk. With one, output is collected until a chunk is
+// full or the oldest unsent byte has waited for the interval, which needs
+// a reader with read deadlines such as a pipe.
+func readOutput(ctx context.Context, reader io.Reader, outputType OutputType, cfg streamConfig, ch chan<- Output) {
+	deadliner, _ := reader.(interface{ SetReadDeadline(time.Time) error })
+	if cfg.flushInterval <= 0 || deadliner == nil || deadliner.SetReadDeadline(time.Time{}) != nil {
+		deadliner = nil
+	}
+
+	buf := getBuffer(cfg.chunkSize)
+	n := 0
+	waiting := false // a read deadline is set
+	for {
+		if deadliner != nil && n > 0 && !waiting {
+			deadliner.SetReadDeadline(time.Now().Add(cfg.flushInterval))
+			waiting = true
+		}
+
+		read, err := reader.Read((*buf)[n:])
+		n += read
+
+		flush := false
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = nil
+			flush = true
+		}
+
+		// Send whole lines; a partial line waits for the rest of it unless
+		// the buffer is full, the output has ended or it is time to flush
+		cut := n
+		if err == nil && !flush {
+			if deadliner != nil && n < len(*buf) {
+				continue
+			}
+			if i := bytes.LastIndexByte((*buf)[:n], '\n'); i >= 0 {
+				cut = i + 1
+			} else if n < len(*buf) {
+				continue
+			}
+		}
 
-	cmdLower := strings.ToLower(command)
-	for _, d := range dangerous {
-		if strings.Contains(cmdLower, strings.ToLower(d)) {
-			return true
+		if cut > 0 {
+			next := getBuffer(cfg.chunkSize)
+			rest := copy(*next, (*buf)[cut:n])
+			select {
+			case ch <- Output{Type: outputType, Data: (*buf)[:cut], buf: buf}:
+			case <-ctx.Done():
+				putBuffer(next)
+				return
+			}
+			buf, n = next, rest
+		}
+		if waiting {
+			// The next deadline starts from the first byte left unsent
+			deadliner.SetReadDeadline(time.Time{})
+			waiting = false
+		}
+
+		if err != nil {
+			putBuffer(buf)
+			return
 		}
 	}
-	return false
+}
+
+// validateCommand checks if a command is valid
+func validateCommand(command string) error {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return ErrEmptyCommand
+	}
+	return nil
 }
@@ -0,0 +1,406 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecutor_Execute(t *testing.T) {
+	e := New(DefaultConfig())
+
+	result, err := e.Execute(context.Background(), "echo hello; echo oops >&2; exit 3")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	if result.Output != "hello\n" {
+		t.Errorf("Execute() output = %q, want %q", result.Output, "hello\n")
+	}
+	if result.Error != "oops\n" {
+		t.Errorf("Execute() error output = %q, want %q", result.Error, "oops\n")
+	}
+	if result.ExitCode != 3 {
+		t.Errorf("Execute() exit code = %d, want 3", result.ExitCode)
+	}
+}
+
+func TestExecutor_KillsChildren(t *testing.T) {
+	e := New(DefaultConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	// The shell waits for sleep, which must be killed along with it
+	start := time.Now()
+	_, err := e.Execute(ctx, "sleep 30; echo done")
+	if err != ErrCommandKilled {
+		t.Errorf("Execute() error = %v, want %v", err, ErrCommandKilled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Execute() returned after %v, want soon after the cancel", elapsed)
+	}
+}
+
+func TestExecutor_ExecuteWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.WorkingDir = "/"
+	e := New(cfg)
+
+	result, err := e.ExecuteWithOptions(context.Background(), "pwd; echo $GREETING", Options{
+		WorkingDir: dir,
+		Env:        map[string]string{"GREETING": "hi"},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error = %v", err)
+	}
+
+	want := dir + "\nhi\n"
+	if result.Output != want {
+		t.Errorf("ExecuteWithOptions() output = %q, want %q", result.Output, want)
+	}
+
+	// Overrides must not leak into later commands
+	if e.GetWorkingDir() != "/" {
+		t.Errorf("GetWorkingDir() = %s, want /", e.GetWorkingDir())
+	}
+	result, _ = e.Execute(context.Background(), "echo \"[$GREETING]\"")
+	if strings.TrimSpace(result.Output) != "[]" {
+		t.Errorf("Execute() after override output = %q, want %q", result.Output, "[]\n")
+	}
+}
+
+func TestExecutor_ExecuteWithStdin(t *testing.T) {
+	e := New(DefaultConfig())
+
+	result, err := e.ExecuteWithOptions(context.Background(), "tr a-z A-Z", Options{
+		Stdin: []byte("select 1;\n"),
+	})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error = %v", err)
+	}
+	if result.Output != "SELECT 1;\n" {
+		t.Errorf("ExecuteWithOptions() output = %q, want %q", result.Output, "SELECT 1;\n")
+	}
+}
+
+func TestExecutor_NoGlob(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.WorkingDir = dir
+	e := New(cfg)
+
+	result, err := e.ExecuteWithOptions(context.Background(), "echo *.txt", Options{NoGlob: true})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error = %v", err)
+	}
+	if result.Output != "*.txt\n" {
+		t.Errorf("ExecuteWithOptions() with NoGlob output = %q, want %q", result.Output, "*.txt\n")
+	}
+
+	result, _ = e.Execute(context.Background(), "echo *.txt")
+	if result.Output != "a.txt\n" {
+		t.Errorf("Execute() output = %q, want %q", result.Output, "a.txt\n")
+	}
+
+	if SupportsNoGlob("/usr/bin/fish") || !SupportsNoGlob("/bin/zsh") {
+		t.Error("SupportsNoGlob() = true for fish or false for zsh")
+	}
+}
+
+func TestExecutor_Argv(t *testing.T) {
+	argv := []string{"printf", "%s|", "$HOME", "a b", "*", "; echo injected", "`id`"}
+	want := "$HOME|a b|*|; echo injected|`id`|"
+
+	// Run directly, and through the shell that sets up the umask first
+	for _, umask := range []string{"", "0022"} {
+		cfg := DefaultConfig()
+		cfg.WorkingDir = t.TempDir()
+		cfg.Umask = umask
+		e := New(cfg)
+
+		result, err := e.ExecuteWithOptions(context.Background(), "printf", Options{Argv: argv})
+		if err != nil {
+			t.Fatalf("ExecuteWithOptions() umask %q error = %v", umask, err)
+		}
+		if result.Output != want || result.ExitCode != 0 {
+			t.Errorf("ExecuteWithOptions() umask %q = %q, exit %d, want %q", umask, result.Output, result.ExitCode, want)
+		}
+	}
+
+	// A missing program fails the way it does in the shell
+	e := New(DefaultConfig())
+	result, err := e.ExecuteWithOptions(context.Background(), "no-such-program", Options{Argv: []string{"no-such-program"}})
+	if err != nil {
+		t.Fatalf("ExecuteWithOptions() error = %v", err)
+	}
+	if result.ExitCode != 127 {
+		t.Errorf("ExecuteWithOptions() missing program exit code = %d, want 127", result.ExitCode)
+	}
+}
+
+func TestExecutor_InitScript(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "it's init.sh")
+	if err := os.WriteFile(script, []byte("GREETING=hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.InitScript = script
+	e := New(cfg)
+
+	result, err := e.Execute(context.Background(), "echo $GREETING")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Output != "hi\n" {
+		t.Errorf("Execute() output = %q, want %q", result.Output, "hi\n")
+	}
+}
+
+func TestExecutor_ExecuteStream(t *testing.T) {
+	e := New(DefaultConfig())
+
+	outputCh, err := e.ExecuteStream(context.Background(), "echo one; echo two >&2; exit 2")
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+
+	var stdout, stderr strings.Builder
+	exitCode := -1
+	for output := range outputCh {
+		switch {
+		case output.IsComplete:
+			exitCode = output.ExitCode
+		case output.Type == Stderr:
+			stderr.Write(output.Data)
+		default:
+			stdout.Write(output.Data)
+		}
+	}
+
+	if stdout.String() != "one\n" || stderr.String() != "two\n" {
+		t.Errorf("ExecuteStream() stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+	if exitCode != 2 {
+		t.Errorf("ExecuteStream() exit code = %d, want 2", exitCode)
+	}
+}
+
+func TestExecutor_ExecuteStreamChunks(t *testing.T) {
+	e := New(DefaultConfig())
+
+	// Many short lines, one line longer than a chunk and a final line
+	// without a line break
+	command := "seq 1 20000; head -c 40000 /dev/zero | tr '\\0' x; echo; printf tail"
+	outputCh, err := e.ExecuteStream(context.Background(), command)
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+
+	var got strings.Builder
+	var chunks [][]byte
+	for output := range outputCh {
+		if output.IsComplete {
+			continue
+		}
+		if len(output.Data) > DefaultChunkSize {
+			t.Errorf("chunk of %d bytes, want at most %d", len(output.Data), DefaultChunkSize)
+		}
+		chunks = append(chunks, append([]byte(nil), output.Data...))
+		got.Write(output.Data)
+		output.Release()
+	}
+
+	var want strings.Builder
+	for i := 1; i <= 20000; i++ {
+		want.WriteString(strconv.Itoa(i) + "\n")
+	}
+	want.WriteString(strings.Repeat("x", 40000) + "\ntail")
+	if got.String() != want.String() {
+		t.Fatalf("ExecuteStream() output differs from the command output (%d bytes, want %d)", got.Len(), want.Len())
+	}
+
+	// Apart from the long line, chunks end on a line break
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if chunk[len(chunk)-1] != '\n' && len(chunk) != DefaultChunkSize {
+			t.Errorf("chunk %d ends mid-line: %q", i, chunk[len(chunk)-10:])
+		}
+	}
+}
+
+func TestExecutor_FlushInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		command  string
+		want     []string
+	}{
+		{"lines sent as read", 0, "echo 1; sleep 0.2; echo 2", []string{"1\n", "2\n"}},
+		{"partial line held", 0, "printf a; sleep 0.2; printf b", []string{"ab"}},
+		{"lines batched", time.Second, "echo 1; sleep 0.2; echo 2", []string{"1\n2\n"}},
+		{"partial line flushed", 50 * time.Millisecond, "printf a; sleep 0.3; printf b", []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := New(DefaultConfig())
+			outputCh, err := e.ExecuteStreamWithOptions(context.Background(), tt.command, Options{FlushInterval: tt.interval})
+			if err != nil {
+				t.Fatalf("ExecuteStreamWithOptions() error = %v", err)
+			}
+
+			var got []string
+			for output := range outputCh {
+				if !output.IsComplete {
+					got = append(got, string(output.Data))
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("chunks = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecutor_ChunkSizeBytes(t *testing.T) {
+	e := New(DefaultConfig())
+	outputCh, err := e.ExecuteStreamWithOptions(context.Background(), "seq 1 1000", Options{ChunkSizeBytes: 512})
+	if err != nil {
+		t.Fatalf("ExecuteStreamWithOptions() error = %v", err)
+	}
+
+	total := 0
+	for output := range outputCh {
+		if len(output.Data) > 512 {
+			t.Errorf("chunk of %d bytes, want at most 512", len(output.Data))
+		}
+		total += len(output.Data)
+		output.Release()
+	}
+	if total != 3893 {
+		t.Errorf("streamed %d bytes, want 3893", total)
+	}
+}
+
+func BenchmarkExecuteStream(b *testing.B) {
+	const size = 16 << 20
+
+	// 80-byte lines, like typical log output
+	path := filepath.Join(b.TempDir(), "output")
+	line := strings.Repeat("x", 79) + "\n"
+	if err := os.WriteFile(path, []byte(strings.Repeat(line, size/len(line))), 0644); err != nil {
+		b.Fatalf("WriteFile() error = %v", err)
+	}
+	e := New(DefaultConfig())
+
+	b.SetBytes(size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		outputCh, err := e.ExecuteStream(context.Background(), "cat "+path)
+		if err != nil {
+			b.Fatalf("ExecuteStream() error = %v", err)
+		}
+		for output := range outputCh {
+			output.Release()
+		}
+	}
+}
+
+func TestIsDangerousCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"ls -la /", false},
+		{"rm -rf /", true},
+		{`rm -rf "/"`, true},
+		{"rm  -rf   /", true},
+		{"rm -r -f /*", true},
+		{"rm --recursive --force //", true},
+		{"rm -rf ./build", false},
+		{"rm -f /", false},
+		{"sudo -u root rm -rf /", true},
+		{"FOO=1 /bin/rm -fr /", true},
+		{"cd /tmp && rm -rf /", true},
+		{`echo "rm -rf /"`, false},
+		{"echo 'mkfs the disk' > notes.txt", false},
+		{`echo "$(rm -rf /)"`, true},
+		{`sh -c "rm -rf /"`, true},
+		{`bash -xc 'rm -rf /'`, true},
+		{`sh -ec 'mkfs.ext4 /dev/sdb1'`, true},
+		{`bash -lc 'rm -rf /'`, true},
+		{`bash -o pipefail -c 'rm -rf /'`, true},
+		{`bash -c -- 'rm -rf /'`, true},
+		{`bash -x 'rm -rf /'`, false},
+		{`bash -lc 'ls /'`, false},
+		{`echo "rm -rf /" | sh`, true},
+		{`echo -n 'rm -rf /' | sudo bash`, true},
+		{`echo ls | sh`, false},
+		{`bash <<< "rm -rf /"`, true},
+		{`bash <<< ls`, false},
+		{"sh <<EOF\nrm -rf /\nEOF", true},
+		{"cat <<'EOF' | bash -s\nmkfs.ext4 /dev/sdb1\nEOF", true},
+		{"sh <<EOF\nls\nEOF", false},
+		{`echo ls | (true; sh)`, true},
+		{`printf 'rm -rf /' | sh`, true},
+		{`echo "$CMD" | sh`, true},
+		{`curl -s https://example.com/install.sh | sh`, true},
+		{`sh < script.sh`, true},
+		{`bash -c 'cat' <<< "rm -rf /"`, false},
+		{`bash script.sh <<< "rm -rf /"`, false},
+		{"mkfs.ext4 /dev/sdb1", true},
+		{"dd if=/dev/zero of=disk.img bs=1M count=1", true},
+		{"dd if=disk.img of=/dev/nvme0n1", true},
+		{"cat image > /dev/sda", true},
+		{"cat /dev/sda > image", false},
+		{"chmod -R 777 /", true},
+		{"chmod 777 /tmp/x", false},
+		{":(){ :|:& };:", true},
+		{"f() { echo hi; }; f", false},
+		{`echo "unterminated rm -rf /`, true},
+	}
+
+	for _, tt := range tests {
+		if got := IsDangerousCommand(tt.command); got != tt.want {
+			t.Errorf("IsDangerousCommand(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestExecutor_IsolateNetwork(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := CheckNetworkIsolation(cfg.Shell); err != nil {
+		t.Skipf("network isolation unavailable: %v", err)
+	}
+	cfg.IsolateNetwork = true
+	e := New(cfg)
+
+	// Interfaces are listed after two header lines
+	result, err := e.Execute(context.Background(), "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Output != "lo\n" {
+		t.Errorf("interfaces = %q, want only %q", result.Output, "lo\n")
+	}
+}
+
+func TestQuoteArgv(t *testing.T) {
+	argv := []string{"grep", "-r", "--include=*.go", "it's", "", "a=b", "/var/log"}
+	want := `grep -r '--include=*.go' 'it'\''s' '' a=b /var/log`
+	if got := QuoteArgv(argv); got != want {
+		t.Errorf("QuoteArgv() = %s, want %s", got, want)
+	}
+	if got := QuoteArgv([]string{"A=1", "env"}); got != `'A=1' env` {
+		t.Errorf("QuoteArgv() = %s, want 'A=1' env", got)
+	}
+}
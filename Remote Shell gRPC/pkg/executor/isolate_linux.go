@@ -0,0 +1,37 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isolateNetwork starts a command in a new network namespace, which has
+// only a loopback interface that is down. Unprivileged processes may only
+// create one inside a new user namespace, in which the server's user and
+// group are mapped to themselves.
+func isolateNetwork(cmd *exec.Cmd) {
+	attr := &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	if uid := os.Geteuid(); uid != 0 {
+		gid := os.Getegid()
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: uid, HostID: uid, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: gid, HostID: gid, Size: 1}}
+	}
+	cmd.SysProcAttr = attr
+}
+
+// CheckNetworkIsolation reports whether the shell can be started without
+// network access, which fails when the kernel does not allow the process
+// to create namespaces
+func CheckNetworkIsolation(shell string) error {
+	cmd := exec.Command(shell, "-c", "exit 0")
+	isolateNetwork(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %v", ErrIsolationUnsupported, err)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package executor
+
+import "os/exec"
+
+// isolateNetwork makes the command fail to start, as network namespaces
+// only exist on Linux
+func isolateNetwork(cmd *exec.Cmd) {
+	cmd.Err = ErrIsolationUnsupported
+}
+
+// CheckNetworkIsolation reports whether the shell can be started without
+// network access, which is never the case outside Linux
+func CheckNetworkIsolation(shell string) error {
+	return ErrIsolationUnsupported
+}
@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Limits are resource limits applied to every command. A zero field
+// leaves that limit as inherited from the server.
+type Limits struct {
+	// OpenFiles caps the file descriptors a process may hold
+	// (RLIMIT_NOFILE)
+	OpenFiles uint64 `yaml:"open_files"`
+	// Processes caps the processes running as the server's user,
+	// counted across the whole system (RLIMIT_NPROC). The kernel does not
+	// enforce it for root.
+	Processes uint64 `yaml:"processes"`
+	// CPUSeconds caps the CPU time of each process (RLIMIT_CPU); the
+	// kernel kills a process that uses it up, however long the wall-clock
+	// timeout is
+	CPUSeconds uint64 `yaml:"cpu_seconds"`
+}
+
+// limitExitCode is the exit code of a command whose limits could not be set
+const limitExitCode = 126
+
+// limitCommand returns the shell statements that apply limits before the
+// command runs, or "" when there are none. Setting soft and hard limits
+// together keeps the command from raising them again; the command is not
+// run if the shell cannot set a limit.
+func limitCommand(shell string, limits Limits) string {
+	name := filepath.Base(shell)
+	var statements []string
+	add := func(flag string, value uint64) {
+		if value > 0 {
+			statements = append(statements, fmt.Sprintf("ulimit %s %d || exit %d", flag, value, limitExitCode))
+		}
+	}
+
+	add("-n", limits.OpenFiles)
+	// dash names the process limit -p and other shells -u
+	if name == "dash" {
+		add("-p", limits.Processes)
+	} else {
+		add("-u", limits.Processes)
+	}
+	add("-t", limits.CPUSeconds)
+	return strings.Join(statements, "\n")
+}
+
+// cpuLimitExceeded reports whether a finished command was killed for
+// using up its CPU time limit
+func cpuLimitExceeded(cmd *exec.Cmd, limits Limits) bool {
+	if limits.CPUSeconds == 0 || cmd.ProcessState == nil || !killedForCPU(cmd.ProcessState) {
+		return false
+	}
+	// Resource usage is accounted slightly differently from the limit, so
+	// a killed command may report a little less CPU time
+	limit := time.Duration(limits.CPUSeconds) * time.Second
+	return cpuTime(cmd) >= limit*9/10
+}
@@ -0,0 +1,102 @@
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestExecutor_Limits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Limits = Limits{OpenFiles: 64, Processes: 4096}
+	e := New(cfg)
+
+	result, err := e.Execute(context.Background(), "ulimit -n; ulimit -Hn; ulimit -u")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Output != "64\n64\n4096\n" {
+		t.Errorf("limits = %q, want %q", result.Output, "64\n64\n4096\n")
+	}
+
+	// Descriptors above the limit cannot be opened
+	result, err = e.Execute(context.Background(), "exec 70>/dev/null && echo opened")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.ExitCode == 0 || result.Output != "" {
+		t.Errorf("opening fd 70 with a limit of 64: exit code %d, output %q", result.ExitCode, result.Output)
+	}
+}
+
+func TestExecutor_LimitsDash(t *testing.T) {
+	dash, err := exec.LookPath("dash")
+	if err != nil {
+		t.Skip("dash not installed")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Shell = dash
+	cfg.Limits = Limits{OpenFiles: 32, Processes: 2048}
+	e := New(cfg)
+
+	result, err := e.Execute(context.Background(), "ulimit -n; ulimit -p")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Output != "32\n2048\n" || result.ExitCode != 0 {
+		t.Errorf("limits = %q (exit code %d), want %q", result.Output, result.ExitCode, "32\n2048\n")
+	}
+}
+
+func TestExecutor_CPULimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Limits = Limits{CPUSeconds: 1}
+	e := New(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	result, err := e.Execute(ctx, "while :; do :; done")
+	if err != ErrCPULimitExceeded {
+		t.Fatalf("Execute() error = %v, want %v", err, ErrCPULimitExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command ran for %v, want it killed after about 1s", elapsed)
+	}
+	if result.ExitCode == 0 {
+		t.Errorf("ExitCode = 0, want non-zero")
+	}
+
+	// Commands failing on their own are not mistaken for the limit
+	if _, err := e.Execute(ctx, "exit 137"); err != nil {
+		t.Errorf("Execute() of a failing command error = %v, want nil", err)
+	}
+
+	outputCh, err := e.ExecuteStream(ctx, "echo spinning; while :; do :; done")
+	if err != nil {
+		t.Fatalf("ExecuteStream() error = %v", err)
+	}
+	var done Output
+	for output := range outputCh {
+		if output.IsComplete {
+			done = output
+		}
+	}
+	if !done.IsComplete || !done.CPULimitExceeded {
+		t.Errorf("completion = %+v, want CPULimitExceeded", done)
+	}
+}
+
+func TestLimitCommand(t *testing.T) {
+	if got := limitCommand("/bin/bash", Limits{}); got != "" {
+		t.Errorf("limitCommand() without limits = %q, want empty", got)
+	}
+
+	want := "ulimit -u 10 || exit 126\nulimit -t 5 || exit 126"
+	if got := limitCommand("/bin/zsh", Limits{Processes: 10, CPUSeconds: 5}); got != want {
+		t.Errorf("limitCommand() = %q, want %q", got, want)
+	}
+}
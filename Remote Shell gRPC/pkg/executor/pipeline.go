@@ -0,0 +1,166 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stage is the result of one command of a pipeline
+type Stage struct {
+	Command string
+	// Error is the standard error of this stage alone
+	Error    string
+	ExitCode int
+	// ExecutionTime runs from the start of the pipeline until this stage
+	// exited
+	ExecutionTime time.Duration
+	CPUTime       time.Duration
+}
+
+// PipelineResult represents the complete result of a pipeline
+type PipelineResult struct {
+	// Output is the standard output of the last stage
+	Output        string
+	Stages        []Stage
+	ExecutionTime time.Duration
+}
+
+// ExitCode returns the exit code of the last stage, which is what a shell
+// reports for a pipeline
+func (r *PipelineResult) ExitCode() int {
+	return r.Stages[len(r.Stages)-1].ExitCode
+}
+
+// ExecutePipeline runs commands with the standard output of each one
+// connected to the standard input of the next, like a shell pipeline. opts
+// apply to every stage, except that Stdin is only fed to the first. A stage
+// whose shell is killed by a signal reports exit code -1. A stage that
+// outlives its timeout in StageTimeouts is killed and the pipeline returns
+// ErrCommandTimeout.
+func (e *Executor) ExecutePipeline(ctx context.Context, commands []string, opts Options) (*PipelineResult, error) {
+	if len(commands) == 0 {
+		return nil, ErrEmptyCommand
+	}
+	for _, command := range commands {
+		if err := validateCommand(command); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+
+	cmds := make([]*exec.Cmd, len(commands))
+	stderr := make([]strings.Builder, len(commands))
+	var stdout strings.Builder
+
+	// Every stage has its own context, limited by its timeout
+	stageCtxs := make([]context.Context, len(commands))
+	for i := range commands {
+		stageCtxs[i] = ctx
+		if i < len(opts.StageTimeouts) && opts.StageTimeouts[i] > 0 {
+			var cancel context.CancelFunc
+			stageCtxs[i], cancel = context.WithTimeout(ctx, opts.StageTimeouts[i])
+			defer cancel()
+		}
+	}
+	timedOut := make([]bool, len(commands))
+
+	// Pipe ends held by this process; they are closed once the stages
+	// have their own copies so that every reader sees end of file
+	var pipes []*os.File
+	closePipes := func() {
+		for _, f := range pipes {
+			f.Close()
+		}
+		pipes = nil
+	}
+
+	for i, command := range commands {
+		stageOpts := opts
+		if i > 0 {
+			stageOpts.Stdin = nil
+		}
+		cmds[i] = e.command(stageCtxs[i], command, stageOpts)
+		cmds[i].Stderr = &stderr[i]
+
+		if i > 0 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				closePipes()
+				return nil, fmt.Errorf("failed to create pipe: %w", err)
+			}
+			pipes = append(pipes, r, w)
+			cmds[i-1].Stdout = w
+			cmds[i].Stdin = r
+		}
+	}
+	cmds[len(cmds)-1].Stdout = &stdout
+
+	for i, cmd := range cmds {
+		if err := cmd.Start(); err != nil {
+			closePipes()
+			for _, started := range cmds[:i] {
+				started.Process.Kill()
+				started.Wait()
+			}
+			if errors.Is(err, exec.ErrNotFound) {
+				return nil, ErrCommandNotFound
+			}
+			return nil, fmt.Errorf("failed to start stage %d: %w", i+1, err)
+		}
+	}
+	closePipes()
+
+	result := &PipelineResult{
+		Stages: make([]Stage, len(cmds)),
+	}
+
+	var wg sync.WaitGroup
+	for i, cmd := range cmds {
+		wg.Add(1)
+		go func(i int, cmd *exec.Cmd) {
+			defer wg.Done()
+			err := cmd.Wait()
+			// Checked now, as the timeout may pass while later stages
+			// still run
+			timedOut[i] = stageCtxs[i].Err() == context.DeadlineExceeded
+
+			stage := Stage{
+				Command:       commands[i],
+				ExecutionTime: time.Since(start),
+				CPUTime:       cpuTime(cmd),
+			}
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				stage.ExitCode = exitErr.ExitCode()
+			}
+			result.Stages[i] = stage
+		}(i, cmd)
+	}
+	wg.Wait()
+
+	for i := range result.Stages {
+		result.Stages[i].Error = stderr[i].String()
+	}
+	result.Output = stdout.String()
+	result.ExecutionTime = time.Since(start)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return result, ErrCommandTimeout
+	}
+	if ctx.Err() == context.Canceled {
+		return result, ErrCommandKilled
+	}
+	for _, t := range timedOut {
+		if t {
+			return result, ErrCommandTimeout
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecutor_ExecutePipeline(t *testing.T) {
+	e := New(DefaultConfig())
+
+	result, err := e.ExecutePipeline(context.Background(), []string{
+		"printf 'b\\na\\nc\\n'; echo first >&2",
+		"sort",
+		"tr a-z A-Z; exit 4",
+	}, Options{})
+	if err != nil {
+		t.Fatalf("ExecutePipeline() error = %v", err)
+	}
+
+	if result.Output != "A\nB\nC\n" {
+		t.Errorf("ExecutePipeline() output = %q, want %q", result.Output, "A\nB\nC\n")
+	}
+	if len(result.Stages) != 3 {
+		t.Fatalf("ExecutePipeline() stages = %d, want 3", len(result.Stages))
+	}
+	if result.Stages[0].Error != "first\n" || result.Stages[1].Error != "" {
+		t.Errorf("stage errors = %q, %q; want %q, %q", result.Stages[0].Error, result.Stages[1].Error, "first\n", "")
+	}
+	if result.Stages[0].ExitCode != 0 || result.ExitCode() != 4 {
+		t.Errorf("exit codes = %d, %d; want 0, 4", result.Stages[0].ExitCode, result.ExitCode())
+	}
+	if result.Stages[1].Command != "sort" {
+		t.Errorf("stage 2 command = %q, want %q", result.Stages[1].Command, "sort")
+	}
+}
+
+func TestExecutor_ExecutePipelineStdin(t *testing.T) {
+	e := New(DefaultConfig())
+
+	result, err := e.ExecutePipeline(context.Background(), []string{"cat", "wc -l"}, Options{
+		Stdin: []byte("one\ntwo\n"),
+	})
+	if err != nil {
+		t.Fatalf("ExecutePipeline() error = %v", err)
+	}
+	if result.Output != "2\n" {
+		t.Errorf("ExecutePipeline() output = %q, want %q", result.Output, "2\n")
+	}
+}
+
+func TestExecutor_ExecutePipelineTimeout(t *testing.T) {
+	e := New(DefaultConfig())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err := e.ExecutePipeline(ctx, []string{"sleep 5", "cat"}, Options{})
+	if err != ErrCommandTimeout {
+		t.Errorf("ExecutePipeline() error = %v, want %v", err, ErrCommandTimeout)
+	}
+
+	// A stage's own timeout ends it without waiting for the context
+	start := time.Now()
+	_, err = e.ExecutePipeline(context.Background(), []string{"echo hi", "sleep 5"}, Options{
+		StageTimeouts: []time.Duration{0, 200 * time.Millisecond},
+	})
+	if err != ErrCommandTimeout {
+		t.Errorf("ExecutePipeline() with a stage timeout error = %v, want %v", err, ErrCommandTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("ExecutePipeline() with a stage timeout took %v", elapsed)
+	}
+
+	if _, err := e.ExecutePipeline(context.Background(), []string{"echo", " "}, Options{}); err != ErrEmptyCommand {
+		t.Errorf("ExecutePipeline() with empty stage error = %v, want %v", err, ErrEmptyCommand)
+	}
+}
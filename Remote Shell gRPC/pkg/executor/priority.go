@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// IO scheduling classes commands may be put in
+const (
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// Priority lowers the CPU and IO scheduling priority of commands so that
+// they do not slow down the host's own workloads
+type Priority struct {
+	// Nice is added to the niceness commands start with, from 0 to 19;
+	// higher values leave more CPU time to other processes
+	Nice int `yaml:"nice"`
+	// IOClass is the ionice class: IOClassBestEffort with IOLevel from 0
+	// (highest) to 7, or IOClassIdle, which only gets disk time no other
+	// process wants. Empty leaves the IO priority alone.
+	IOClass string `yaml:"io_class"`
+	IOLevel int    `yaml:"io_level"`
+}
+
+// Validate checks that a priority only lowers that of commands
+func (p Priority) Validate() error {
+	if p.Nice < 0 || p.Nice > 19 {
+		return fmt.Errorf("nice must be between 0 and 19, got %d", p.Nice)
+	}
+	switch p.IOClass {
+	case "", IOClassIdle:
+	case IOClassBestEffort:
+		if p.IOLevel < 0 || p.IOLevel > 7 {
+			return fmt.Errorf("io_level must be between 0 and 7, got %d", p.IOLevel)
+		}
+	default:
+		return fmt.Errorf("io_class must be %q or %q, got %q", IOClassBestEffort, IOClassIdle, p.IOClass)
+	}
+	return nil
+}
+
+// String describes the priority, e.g. "nice 10, idle"
+func (p Priority) String() string {
+	s := "nice " + strconv.Itoa(p.Nice)
+	switch p.IOClass {
+	case IOClassIdle:
+		s += ", idle"
+	case IOClassBestEffort:
+		s += ", best-effort " + strconv.Itoa(p.IOLevel)
+	}
+	return s
+}
+
+// priorityCommand returns the program and arguments that run the shell at
+// the priority. nice and ionice exec the shell in turn, so it keeps their
+// process ID and can still be killed on timeout.
+func priorityCommand(p Priority, shell string, args []string) (string, []string) {
+	argv := append([]string{shell}, args...)
+	switch p.IOClass {
+	case IOClassIdle:
+		argv = append([]string{"ionice", "-c", "3"}, argv...)
+	case IOClassBestEffort:
+		argv = append([]string{"ionice", "-c", "2", "-n", strconv.Itoa(p.IOLevel)}, argv...)
+	}
+	if p.Nice > 0 {
+		argv = append([]string{"nice", "-n", strconv.Itoa(p.Nice)}, argv...)
+	}
+	return argv[0], argv[1:]
+}
@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPriority_Validate(t *testing.T) {
+	valid := []Priority{{}, {Nice: 19}, {IOClass: IOClassIdle}, {Nice: 5, IOClass: IOClassBestEffort, IOLevel: 7}}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Validate(%+v) error = %v", p, err)
+		}
+	}
+
+	invalid := []Priority{{Nice: -1}, {Nice: 20}, {IOClass: "realtime"}, {IOClass: IOClassBestEffort, IOLevel: 8}}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Validate(%+v) error = nil, want error", p)
+		}
+	}
+}
+
+func TestPriorityCommand(t *testing.T) {
+	name, args := priorityCommand(Priority{Nice: 10, IOClass: IOClassBestEffort, IOLevel: 6}, "/bin/sh", []string{"-c", "true"})
+	got := append([]string{name}, args...)
+	want := []string{"nice", "-n", "10", "ionice", "-c", "2", "-n", "6", "/bin/sh", "-c", "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("priorityCommand() = %q, want %q", got, want)
+	}
+
+	name, args = priorityCommand(Priority{IOClass: IOClassIdle}, "/bin/sh", []string{"-c", "true"})
+	got = append([]string{name}, args...)
+	want = []string{"ionice", "-c", "3", "/bin/sh", "-c", "true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("priorityCommand() = %q, want %q", got, want)
+	}
+}
+
+func TestExecutor_Priority(t *testing.T) {
+	for _, tool := range []string{"nice", "ionice"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+
+	e := New(DefaultConfig())
+	base, err := e.Execute(context.Background(), "nice")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	e.SetPriority(Priority{Nice: 5, IOClass: IOClassIdle})
+	result, err := e.Execute(context.Background(), "nice; ionice")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(result.Output), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output = %q, want niceness and IO class", result.Output)
+	}
+
+	niceness, err := strconv.Atoi(strings.TrimSpace(base.Output))
+	if err != nil {
+		t.Fatalf("niceness %q: %v", base.Output, err)
+	}
+	if want := strconv.Itoa(niceness + 5); lines[0] != want {
+		t.Errorf("niceness = %s, want %s", lines[0], want)
+	}
+	if lines[1] != "idle" {
+		t.Errorf("IO class = %q, want %q", lines[1], "idle")
+	}
+}
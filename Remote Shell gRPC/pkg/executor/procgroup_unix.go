@@ -0,0 +1,24 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroup starts the command in a process group of its own and
+// makes cancelling it kill the whole group, so that the programs the shell
+// started die with it instead of running on after a timeout or kill
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// Processes that left the group may still hold the output pipes open
+	cmd.WaitDelay = time.Second
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package executor
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killProcessGroup only bounds the wait for the output pipes on Windows,
+// where cancelling kills the shell but not the programs it started
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = time.Second
+}
@@ -0,0 +1,30 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ParseUmask parses a umask given in octal, e.g. "022" or "0077", and
+// returns it in the four-digit form the shell's umask builtin prints
+func ParseUmask(s string) (string, error) {
+	if len(s) < 1 || len(s) > 4 {
+		return "", fmt.Errorf("umask must be 1 to 4 octal digits, got %q", s)
+	}
+	mask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mask > 0777 {
+		return "", fmt.Errorf("umask must be an octal number from 000 to 777, got %q", s)
+	}
+	return fmt.Sprintf("%04o", mask), nil
+}
+
+// ApplyUmask clears the permission bits of mode that a umask parsed by
+// ParseUmask takes away; an empty umask leaves mode as it is
+func ApplyUmask(mode os.FileMode, umask string) os.FileMode {
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if umask == "" || err != nil {
+		return mode
+	}
+	return mode &^ os.FileMode(mask)
+}
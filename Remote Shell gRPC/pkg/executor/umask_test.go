@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseUmask(t *testing.T) {
+	valid := map[string]string{"022": "0022", "0077": "0077", "7": "0007", "777": "0777", "0000": "0000"}
+	for in, want := range valid {
+		got, err := ParseUmask(in)
+		if err != nil || got != want {
+			t.Errorf("ParseUmask(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "8", "0800", "1777", "00022", "-22"} {
+		if _, err := ParseUmask(in); err == nil {
+			t.Errorf("ParseUmask(%q) error = nil, want error", in)
+		}
+	}
+}
+
+func TestApplyUmask(t *testing.T) {
+	if got := ApplyUmask(0666, "0027"); got != 0640 {
+		t.Errorf("ApplyUmask(0666, 0027) = %o, want 640", got)
+	}
+	if got := ApplyUmask(0755, ""); got != 0755 {
+		t.Errorf("ApplyUmask(0755, \"\") = %o, want 755", got)
+	}
+}
+
+func TestExecutor_Umask(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Shell = "/bin/sh"
+	cfg.Umask = "0077"
+	e := New(cfg)
+
+	result, err := e.Execute(context.Background(), "umask")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if got := strings.TrimSpace(result.Output); got != "0077" {
+		t.Errorf("umask = %q, want 0077", got)
+	}
+
+	e.SetUmask("0022")
+	if got := e.GetUmask(); got != "0022" {
+		t.Errorf("GetUmask() = %q, want 0022", got)
+	}
+	dir := t.TempDir()
+	if _, err := e.Execute(context.Background(), "touch "+dir+"/f"); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	info, err := os.Stat(dir + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("file mode = %o, want 644", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,76 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// EntryType is the kind of a directory entry
+type EntryType string
+
+const (
+	TypeFile      EntryType = "file"
+	TypeDirectory EntryType = "directory"
+	TypeSymlink   EntryType = "symlink"
+	TypeOther     EntryType = "other"
+)
+
+// Entry describes one directory entry. Symlinks are not followed.
+type Entry struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Type    EntryType
+	// LinkTarget is the target of a symlink
+	LinkTarget string
+}
+
+// List returns the entries of a directory sorted by name
+func List(dir string) ([]Entry, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, de := range dirEntries {
+		info, err := de.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+
+		entry := Entry{
+			Name:    de.Name(),
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+			Type:    entryType(info.Mode()),
+		}
+		if entry.Type == TypeSymlink {
+			entry.LinkTarget, _ = os.Readlink(filepath.Join(dir, de.Name()))
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// entryType classifies a file mode
+func entryType(mode os.FileMode) EntryType {
+	switch {
+	case mode.IsRegular():
+		return TypeFile
+	case mode.IsDir():
+		return TypeDirectory
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	}
+	return TypeOther
+}
@@ -0,0 +1,55 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b.txt", filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := List(dir)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("List() returned %d entries, want 3", len(entries))
+	}
+
+	want := []struct {
+		name string
+		typ  EntryType
+	}{
+		{"a", TypeDirectory},
+		{"b.txt", TypeFile},
+		{"c", TypeSymlink},
+	}
+	for i, w := range want {
+		if entries[i].Name != w.name || entries[i].Type != w.typ {
+			t.Errorf("entries[%d] = %s %s, want %s %s", i, entries[i].Name, entries[i].Type, w.name, w.typ)
+		}
+	}
+
+	if entries[1].Size != 5 || entries[1].Mode.Perm() != 0640 {
+		t.Errorf("b.txt size = %d mode = %v, want 5 -rw-r-----", entries[1].Size, entries[1].Mode.Perm())
+	}
+	if entries[2].LinkTarget != "b.txt" {
+		t.Errorf("c link target = %q, want b.txt", entries[2].LinkTarget)
+	}
+}
+
+func TestList_NotFound(t *testing.T) {
+	if _, err := List(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("List() error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package files
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// owner returns the user and group names owning a file, falling back to
+// numeric IDs when they have no name
+func owner(fi os.FileInfo) (string, string) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+
+	uid := strconv.FormatUint(uint64(st.Uid), 10)
+	gid := strconv.FormatUint(uint64(st.Gid), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		uid = u.Username
+	}
+	if g, err := user.LookupGroupId(gid); err == nil {
+		gid = g.Name
+	}
+	return uid, gid
+}
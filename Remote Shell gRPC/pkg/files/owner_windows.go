@@ -0,0 +1,8 @@
+package files
+
+import "os"
+
+// owner is not reported on Windows
+func owner(fi os.FileInfo) (string, string) {
+	return "", ""
+}
@@ -0,0 +1,94 @@
+package files
+
+import (
+	"sync"
+	"time"
+)
+
+// Partials keeps the files of interrupted uploads for a while, by a key the
+// uploader chooses, so that an upload can go on where it stopped instead
+// of starting over
+type Partials struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	partials map[string]*partial
+	now      func() time.Time
+}
+
+// partial is a file kept for resuming
+type partial struct {
+	w       *Writer
+	expires time.Time
+}
+
+// NewPartials creates a registry that discards files not resumed within ttl
+func NewPartials(ttl time.Duration) *Partials {
+	return &Partials{
+		ttl:      ttl,
+		partials: make(map[string]*partial),
+		now:      time.Now,
+	}
+}
+
+// Keep holds on to w under key until it is taken or expires. A file kept
+// under the same key before is discarded.
+func (p *Partials) Keep(key string, w *Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.purge()
+	if old, ok := p.partials[key]; ok {
+		old.w.Abort()
+	}
+	p.partials[key] = &partial{w: w, expires: p.now().Add(p.ttl)}
+}
+
+// Take removes the file kept under key and returns it, or nil when there
+// is none. The caller resumes writing at its offset and either commits
+// it, aborts it or keeps it again.
+func (p *Partials) Take(key string) *Writer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.purge()
+	e, ok := p.partials[key]
+	if !ok {
+		return nil
+	}
+	delete(p.partials, key)
+	return e.w
+}
+
+// Lookup returns the destination of the file kept under key and how much
+// of it was written, and false when there is none
+func (p *Partials) Lookup(key string) (path string, offset int64, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.purge()
+	e, ok := p.partials[key]
+	if !ok {
+		return "", 0, false
+	}
+	offset, err := e.w.Offset()
+	if err != nil {
+		return "", 0, false
+	}
+	return e.w.Path(), offset, true
+}
+
+// Len returns the number of files kept
+func (p *Partials) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.purge()
+	return len(p.partials)
+}
+
+// purge discards the expired files. The caller holds p.mu.
+func (p *Partials) purge() {
+	now := p.now()
+	for key, e := range p.partials {
+		if now.After(e.expires) {
+			e.w.Abort()
+			delete(p.partials, key)
+		}
+	}
+}
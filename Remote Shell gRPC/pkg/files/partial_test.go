@@ -0,0 +1,66 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPartials_Resume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	p := NewPartials(time.Minute)
+
+	w, err := Create(path, 0644)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w.WriteString("first ")
+	p.Keep("s1/t1", w)
+
+	if got, offset, ok := p.Lookup("s1/t1"); !ok || got != path || offset != 6 {
+		t.Errorf("Lookup() = %s, %d, %v; want %s, 6, true", got, offset, ok, path)
+	}
+	if _, _, ok := p.Lookup("s1/other"); ok {
+		t.Errorf("Lookup() of an unknown key = true, want false")
+	}
+
+	w = p.Take("s1/t1")
+	if w == nil {
+		t.Fatal("Take() = nil, want the kept file")
+	}
+	if p.Take("s1/t1") != nil {
+		t.Error("second Take() returned the file again")
+	}
+	w.WriteString("second")
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "first second" {
+		t.Errorf("ReadFile() = %q, %v; want %q", data, err, "first second")
+	}
+}
+
+func TestPartials_Expire(t *testing.T) {
+	dir := t.TempDir()
+	p := NewPartials(time.Minute)
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	w, err := Create(filepath.Join(dir, "out.txt"), 0644)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w.WriteString("partial")
+	p.Keep("s1/t1", w)
+
+	now = now.Add(2 * time.Minute)
+	if p.Len() != 0 {
+		t.Errorf("Len() = %d after the ttl, want 0", p.Len())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("directory has %d entries after expiry, want 0", len(entries))
+	}
+}
@@ -0,0 +1,63 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+)
+
+// Info describes a file. Symlinks are followed.
+type Info struct {
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+	Type    EntryType
+	Owner   string
+	Group   string
+	// SHA256 is the hex-encoded checksum of a regular file, when requested
+	SHA256 string
+}
+
+// Stat returns information about a file, with its SHA-256 checksum when
+// checksum is set
+func Stat(path string, checksum bool) (Info, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime(),
+		Type:    entryType(fi.Mode()),
+	}
+	info.Owner, info.Group = owner(fi)
+
+	if checksum {
+		if fi.IsDir() {
+			return Info{}, ErrIsDirectory
+		}
+		if info.SHA256, err = Checksum(path); err != nil {
+			return Info{}, err
+		}
+	}
+	return info, nil
+}
+
+// Checksum returns the hex-encoded SHA-256 checksum of a file
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,42 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStat_Checksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(path, []byte("hello\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := Stat(path, true)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size != 6 || info.Type != TypeFile || info.Mode.Perm() != 0600 {
+		t.Errorf("Stat() = %d %s %v, want 6 file -rw-------", info.Size, info.Type, info.Mode.Perm())
+	}
+	// sha256sum of "hello\n"
+	want := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	if info.SHA256 != want {
+		t.Errorf("SHA256 = %s, want %s", info.SHA256, want)
+	}
+	if info.Owner == "" && runtime.GOOS != "windows" {
+		t.Error("Owner is empty")
+	}
+
+	info, err = Stat(path, false)
+	if err != nil || info.SHA256 != "" {
+		t.Errorf("Stat() without checksum = %q, %v; want no checksum", info.SHA256, err)
+	}
+}
+
+func TestStat_DirectoryChecksum(t *testing.T) {
+	if _, err := Stat(t.TempDir(), true); err != ErrIsDirectory {
+		t.Errorf("Stat() error = %v, want ErrIsDirectory", err)
+	}
+}
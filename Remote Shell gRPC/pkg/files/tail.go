@@ -0,0 +1,207 @@
+// Package files implements the file operations the server offers next to
+// command execution, such as following a growing log file.
+package files
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Common errors
+var (
+	ErrIsDirectory = errors.New("path is a directory")
+	// ErrExists is returned when committing a file with Fail finds its
+	// destination taken
+	ErrExists = errors.New("file exists")
+)
+
+// ChunkSize is the largest piece of a file read or sent at once
+const ChunkSize = 32 * 1024
+
+// TailOptions controls how a file is followed
+type TailOptions struct {
+	// Lines is the number of lines from the end of the file sent before
+	// following it
+	Lines int
+	// PollInterval is how often the file is checked for new data and
+	// rotation
+	PollInterval time.Duration
+}
+
+// DefaultTailOptions returns the default tail options
+func DefaultTailOptions() TailOptions {
+	return TailOptions{
+		Lines:        10,
+		PollInterval: 250 * time.Millisecond,
+	}
+}
+
+// Follow passes the last lines of a file to fn and then everything
+// appended to it, until ctx is cancelled. When the file is rotated (the
+// path now names a different file) or truncated, it is read again from
+// the start and fn is called with rotated set.
+func Follow(ctx context.Context, path string, opts TailOptions, fn func(data []byte, rotated bool) error) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultTailOptions().PollInterval
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrIsDirectory
+	}
+
+	offset, err := tailOffset(f, info.Size(), opts.Lines)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, ChunkSize)
+	rotated := false
+	drain := func() error {
+		for {
+			n, err := f.Read(buf)
+			if n > 0 {
+				offset += int64(n)
+				if err := fn(buf[:n], rotated); err != nil {
+					return err
+				}
+				rotated = false
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := drain(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := os.Stat(path)
+		if err != nil {
+			// Rotated away and not recreated yet
+			continue
+		}
+
+		if !os.SameFile(info, current) {
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			// Pick up whatever was written before the rotation
+			if err := drain(); err != nil {
+				next.Close()
+				return err
+			}
+			f.Close()
+			f, info, offset = next, current, 0
+			rotated = true
+			continue
+		}
+
+		if current.Size() < offset {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			offset = 0
+			rotated = true
+		}
+	}
+}
+
+// MaxTailBytes limits how much data Tail returns
+const MaxTailBytes = 64 * 1024
+
+// Tail returns the last n lines of a file, cut to its last MaxTailBytes
+// bytes when those lines are longer
+func Tail(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, ErrIsDirectory
+	}
+
+	offset, err := tailOffset(f, info.Size(), n)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size()-offset > MaxTailBytes {
+		offset = info.Size() - MaxTailBytes
+	}
+
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// tailOffset returns the offset of the last n lines of a file of the given
+// size. A trailing newline does not start another line.
+func tailOffset(r io.ReaderAt, size int64, n int) (int64, error) {
+	if n <= 0 {
+		return size, nil
+	}
+
+	buf := make([]byte, ChunkSize)
+	end := size
+	found := 0
+	for end > 0 {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := r.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if chunk[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			found++
+			if found == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
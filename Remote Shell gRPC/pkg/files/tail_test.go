@@ -0,0 +1,145 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTailOffset(t *testing.T) {
+	data := []byte("one\ntwo\nthree\nfour\n")
+
+	tests := []struct {
+		lines int
+		want  string
+	}{
+		{0, ""},
+		{1, "four\n"},
+		{2, "three\nfour\n"},
+		{10, "one\ntwo\nthree\nfour\n"},
+	}
+
+	for _, tt := range tests {
+		offset, err := tailOffset(bytes.NewReader(data), int64(len(data)), tt.lines)
+		if err != nil {
+			t.Fatalf("tailOffset(%d) error = %v", tt.lines, err)
+		}
+		if got := string(data[offset:]); got != tt.want {
+			t.Errorf("tailOffset(%d) = %q, want %q", tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestTail(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Tail(path, 2)
+	if err != nil {
+		t.Fatalf("Tail() error = %v", err)
+	}
+	if string(got) != "two\nthree\n" {
+		t.Errorf("Tail() = %q, want %q", got, "two\nthree\n")
+	}
+
+	long := filepath.Join(dir, "long.log")
+	if err := os.WriteFile(long, bytes.Repeat([]byte("x"), MaxTailBytes+10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Tail(long, 1); err != nil || len(got) != MaxTailBytes {
+		t.Errorf("Tail() of a long line = %d bytes, %v; want %d bytes", len(got), err, MaxTailBytes)
+	}
+
+	if _, err := Tail(dir, 1); err != ErrIsDirectory {
+		t.Errorf("Tail() of a directory error = %v, want %v", err, ErrIsDirectory)
+	}
+}
+
+// collector gathers the data passed to a Follow callback
+type collector struct {
+	mu      sync.Mutex
+	data    strings.Builder
+	rotated int
+}
+
+func (c *collector) add(data []byte, rotated bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data.Write(data)
+	if rotated {
+		c.rotated++
+	}
+	return nil
+}
+
+func (c *collector) waitFor(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		got := c.data.String()
+		c.mu.Unlock()
+		if got == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Follow() data = %q, want %q", c.data.String(), want)
+}
+
+func TestFollow_AppendAndRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("old\nlast\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &collector{}
+	done := make(chan error, 1)
+	go func() {
+		done <- Follow(ctx, path, TailOptions{Lines: 1, PollInterval: 10 * time.Millisecond}, c.add)
+	}()
+	c.waitFor(t, "last\n")
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("appended\n")
+	f.Close()
+	c.waitFor(t, "last\nappended\n")
+
+	// Rotate: move the file away and start a new one
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("fresh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.waitFor(t, "last\nappended\nfresh\n")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Follow() error = %v", err)
+	}
+	if c.rotated != 1 {
+		t.Errorf("rotated = %d, want 1", c.rotated)
+	}
+}
+
+func TestFollow_Directory(t *testing.T) {
+	err := Follow(context.Background(), t.TempDir(), DefaultTailOptions(), func([]byte, bool) error { return nil })
+	if err != ErrIsDirectory {
+		t.Errorf("Follow() error = %v, want ErrIsDirectory", err)
+	}
+}
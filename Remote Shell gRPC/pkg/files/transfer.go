@@ -0,0 +1,141 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Conflict is what committing a file does when its destination exists
+type Conflict int
+
+const (
+	// Overwrite replaces the existing file
+	Overwrite Conflict = iota
+	// Fail leaves the existing file alone and fails with ErrExists
+	Fail
+	// Rename keeps the existing file and places the new one at the first
+	// free name of path.1, path.2 and so on
+	Rename
+)
+
+// maxVersions is how many versioned names Rename tries
+const maxVersions = 1000
+
+// Writer writes a file under a temporary name and moves it into place on
+// Commit, so readers never see a partially written file
+type Writer struct {
+	*os.File
+	path string
+	done bool
+}
+
+// Create starts writing the file at path with the given permissions,
+// creating missing parent directories
+func Create(path string, mode os.FileMode) (*Writer, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(mode.Perm()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &Writer{File: f, path: path}, nil
+}
+
+// Path returns the path the file is moved to on Commit
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Offset returns how much has been written so far, where writing goes on
+func (w *Writer) Offset() (int64, error) {
+	return w.File.Seek(0, io.SeekCurrent)
+}
+
+// Checksum returns the hex-encoded SHA-256 checksum of what was written
+func (w *Writer) Checksum() (string, error) {
+	return Checksum(w.File.Name())
+}
+
+// Commit finishes the file and renames it to its final path, replacing
+// any file there
+func (w *Writer) Commit() error {
+	_, err := w.CommitWith(Overwrite)
+	return err
+}
+
+// CommitWith finishes the file and moves it into place, settling a
+// conflict with an existing file as conflict says, and returns the path
+// the file ended up at
+func (w *Writer) CommitWith(conflict Conflict) (string, error) {
+	if err := w.File.Close(); err != nil {
+		w.Abort()
+		return "", err
+	}
+	path, err := w.place(conflict)
+	if err != nil {
+		w.Abort()
+		return "", err
+	}
+	w.done = true
+	return path, nil
+}
+
+// place moves the closed temporary file to its destination
+func (w *Writer) place(conflict Conflict) (string, error) {
+	tmp := w.File.Name()
+	switch conflict {
+	case Fail:
+		return w.path, link(tmp, w.path)
+	case Rename:
+		for i := 0; i <= maxVersions; i++ {
+			path := w.path
+			if i > 0 {
+				path += "." + strconv.Itoa(i)
+			}
+			if err := link(tmp, path); err != ErrExists {
+				return path, err
+			}
+		}
+		return "", ErrExists
+	}
+	return w.path, os.Rename(tmp, w.path)
+}
+
+// link moves the file at tmp to path unless path exists, in which case it
+// fails with ErrExists. A hard link claims the name atomically; on file
+// systems without hard links the check and the rename are separate steps.
+func link(tmp, path string) error {
+	err := os.Link(tmp, path)
+	if err == nil {
+		return os.Remove(tmp)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return ErrExists
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return ErrExists
+	}
+	return os.Rename(tmp, path)
+}
+
+// Abort discards the file; it does nothing after a successful Commit
+func (w *Writer) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.File.Close()
+	os.Remove(w.File.Name())
+}
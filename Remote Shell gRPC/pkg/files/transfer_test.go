@@ -0,0 +1,103 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestCreate_Commit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "out.txt")
+
+	w, err := Create(path, 0600)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w.WriteString("data")
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file visible before Commit(), Stat() error = %v", err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	w.Abort()
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "data" {
+		t.Errorf("ReadFile() = %q, %v; want data", data, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want -rw-------", info.Mode().Perm())
+	}
+}
+
+func TestCreate_Abort(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := Create(filepath.Join(dir, "out.txt"), 0644)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	w.WriteString("partial")
+	w.Abort()
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("directory has %d entries after Abort(), want 0", len(entries))
+	}
+}
+
+func TestCreate_CommitWith(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	os.WriteFile(path, []byte("old"), 0644)
+
+	commit := func(data string, conflict Conflict) (string, error) {
+		w, err := Create(path, 0644)
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		w.WriteString(data)
+		return w.CommitWith(conflict)
+	}
+
+	if _, err := commit("new", Fail); err != ErrExists {
+		t.Errorf("CommitWith(Fail) error = %v, want %v", err, ErrExists)
+	}
+	for i, want := range []string{path + ".1", path + ".2"} {
+		got, err := commit("v"+strconv.Itoa(i+1), Rename)
+		if err != nil || got != want {
+			t.Errorf("CommitWith(Rename) = %s, %v; want %s", got, err, want)
+		}
+	}
+	if got, err := commit("new", Overwrite); err != nil || got != path {
+		t.Errorf("CommitWith(Overwrite) = %s, %v; want %s", got, err, path)
+	}
+
+	want := map[string]string{"out.txt": "new", "out.txt.1": "v1", "out.txt.2": "v2"}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(want) {
+		t.Errorf("directory has %d entries, want %d", len(entries), len(want))
+	}
+	for name, data := range want {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != data {
+			t.Errorf("%s = %q, want %q", name, got, data)
+		}
+	}
+}
+
+func TestWriter_Checksum(t *testing.T) {
+	w, err := Create(filepath.Join(t.TempDir(), "out.txt"), 0644)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	defer w.Abort()
+	w.WriteString("data")
+
+	// SHA-256 of "data"
+	want := "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7"
+	if got, err := w.Checksum(); err != nil || got != want {
+		t.Errorf("Checksum() = %s, %v; want %s", got, err, want)
+	}
+}
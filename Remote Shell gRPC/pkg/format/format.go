@@ -0,0 +1,66 @@
+// Package format renders durations and byte sizes for people to read, so
+// that every tool shows "1.5 MB" and "2m3s" rather than raw byte counts and
+// millisecond integers.
+package format
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// byteUnits are the units of Bytes, each 1024 times the one before
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+
+// Bytes formats a byte count with binary units: "512 B", "1.5 KB",
+// "32 KB". Values below 10 of a unit keep one decimal.
+func Bytes(n int64) string {
+	if n < 0 {
+		return "-" + Bytes(-n)
+	}
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	// Round first, so that 9.96 KB becomes "10 KB" rather than "10.0 KB"
+	if value = float64(int64(value*10+0.5)) / 10; value < 10 {
+		return fmt.Sprintf("%.1f %s", value, byteUnits[unit])
+	}
+	return fmt.Sprintf("%.0f %s", value, byteUnits[unit])
+}
+
+// Duration formats a duration with no more precision than is useful:
+// "350µs", "840ms", "1.2s", "2m3s", "1h5m", "3d4h"
+func Duration(d time.Duration) string {
+	if d < 0 {
+		return "-" + Duration(-d)
+	}
+	switch {
+	case d == 0:
+		return "0s"
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < 10*time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	case d < time.Hour:
+		return d.Round(time.Second).String()
+	case d < 24*time.Hour:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%dh%dm", d/time.Hour, d%time.Hour/time.Minute)
+	}
+	d = d.Round(time.Hour)
+	return fmt.Sprintf("%dd%dh", d/(24*time.Hour), d%(24*time.Hour)/time.Hour)
+}
+
+// Millis formats a duration given in milliseconds, as carried by the
+// *_ms fields of the API
+func Millis(ms int64) string {
+	return Duration(time.Duration(ms) * time.Millisecond)
+}
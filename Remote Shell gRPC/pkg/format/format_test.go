@@ -0,0 +1,56 @@
+package format
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{10188, "9.9 KB"},
+		{10199, "10 KB"},
+		{32 * 1024, "32 KB"},
+		{1 << 20, "1.0 MB"},
+		{1288490188, "1.2 GB"},
+		{-2048, "-2.0 KB"},
+	}
+	for _, tt := range tests {
+		if got := Bytes(tt.n); got != tt.want {
+			t.Errorf("Bytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{350 * time.Microsecond, "350µs"},
+		{840*time.Millisecond + 400*time.Microsecond, "840ms"},
+		{1234 * time.Millisecond, "1.2s"},
+		{59*time.Second + 600*time.Millisecond, "1m0s"},
+		{2*time.Minute + 3*time.Second, "2m3s"},
+		{time.Hour + 5*time.Minute + 20*time.Second, "1h5m"},
+		{3*24*time.Hour + 4*time.Hour + 10*time.Minute, "3d4h"},
+		{-1500 * time.Millisecond, "-1.5s"},
+	}
+	for _, tt := range tests {
+		if got := Duration(tt.d); got != tt.want {
+			t.Errorf("Duration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+
+	if got := Millis(90000); got != "1m30s" {
+		t.Errorf("Millis(90000) = %q, want %q", got, "1m30s")
+	}
+}
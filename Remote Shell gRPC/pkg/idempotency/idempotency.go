@@ -0,0 +1,153 @@
+// Package idempotency remembers the results of recent requests by a key the
+// client chooses, so that a request retried after a lost response returns
+// the original result instead of being carried out twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrKeyReused is returned when a key comes back with a different request
+var ErrKeyReused = errors.New("idempotency key reused for a different request")
+
+// Cache holds results by scope and key. Scopes keep the keys of different
+// owners, such as sessions, apart.
+type Cache struct {
+	ttl    time.Duration
+	max    int
+	mu     sync.Mutex
+	scopes map[string]map[string]*entry
+	now    func() time.Time
+}
+
+// entry is a request that is running or has finished
+type entry struct {
+	fingerprint string
+	// done is closed when the request has finished
+	done    chan struct{}
+	value   interface{}
+	err     error
+	kept    bool
+	expires time.Time
+}
+
+// New creates a cache that keeps results for ttl and at most maxPerScope
+// results per scope. Zero or negative maxPerScope disables the cap.
+func New(ttl time.Duration, maxPerScope int) *Cache {
+	return &Cache{
+		ttl:    ttl,
+		max:    maxPerScope,
+		scopes: make(map[string]map[string]*entry),
+		now:    time.Now,
+	}
+}
+
+// Do calls fn unless a request with the same scope and key ran recently,
+// in which case it returns that request's result and reports that it was
+// replayed. A request with the same key that is still running is waited
+// for. The fingerprint identifies the request; a key seen with a different
+// fingerprint fails with ErrKeyReused. keep decides whether a result is
+// remembered; results it rejects, such as errors that occurred before the
+// request had any effect, let the next retry run fn again. A nil keep
+// remembers every result.
+func (c *Cache) Do(ctx context.Context, scope, key, fingerprint string, fn func() (interface{}, error), keep func(value interface{}, err error) bool) (interface{}, bool, error) {
+	for {
+		c.mu.Lock()
+		keys := c.scopes[scope]
+		if keys == nil {
+			keys = make(map[string]*entry)
+			c.scopes[scope] = keys
+		}
+		c.purge(keys)
+		e, ok := keys[key]
+		if !ok {
+			e = &entry{fingerprint: fingerprint, done: make(chan struct{})}
+			keys[key] = e
+			c.evict(keys)
+			c.mu.Unlock()
+			value, err := c.run(scope, key, e, fn, keep)
+			return value, false, err
+		}
+		c.mu.Unlock()
+
+		if e.fingerprint != fingerprint {
+			return nil, false, ErrKeyReused
+		}
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if e.kept {
+			return e.value, true, e.err
+		}
+		// The earlier attempt left nothing to replay, so try again
+	}
+}
+
+// run calls fn for a new entry and records its result. If fn panics the
+// entry is dropped so that requests waiting for it do not hang.
+func (c *Cache) run(scope, key string, e *entry, fn func() (interface{}, error), keep func(interface{}, error) bool) (value interface{}, err error) {
+	kept := false
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		e.value, e.err, e.kept = value, err, kept
+		e.expires = c.now().Add(c.ttl)
+		if keys := c.scopes[scope]; !kept && keys[key] == e {
+			delete(keys, key)
+		}
+		close(e.done)
+	}()
+
+	value, err = fn()
+	kept = keep == nil || keep(value, err)
+	return value, err
+}
+
+// purge drops the expired results of a scope. The caller holds c.mu.
+func (c *Cache) purge(keys map[string]*entry) {
+	now := c.now()
+	for key, e := range keys {
+		if finished(e) && now.After(e.expires) {
+			delete(keys, key)
+		}
+	}
+}
+
+// evict drops the oldest results of a scope while it holds more than the
+// cap. Running requests are never dropped. The caller holds c.mu.
+func (c *Cache) evict(keys map[string]*entry) {
+	for c.max > 0 && len(keys) > c.max {
+		oldest := ""
+		for key, e := range keys {
+			if finished(e) && (oldest == "" || e.expires.Before(keys[oldest].expires)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(keys, oldest)
+	}
+}
+
+// finished reports whether an entry's request has finished
+func finished(e *entry) bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Forget drops every result of a scope, such as when its session ends
+func (c *Cache) Forget(scope string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.scopes, scope)
+}
@@ -0,0 +1,133 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache_Replay(t *testing.T) {
+	c := New(time.Minute, 10)
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	value, replayed, err := c.Do(context.Background(), "s1", "k", "echo", fn, nil)
+	if value != 1 || replayed || err != nil {
+		t.Fatalf("first Do = %v, %v, %v; want 1, false, nil", value, replayed, err)
+	}
+	value, replayed, err = c.Do(context.Background(), "s1", "k", "echo", fn, nil)
+	if value != 1 || !replayed || err != nil {
+		t.Errorf("retried Do = %v, %v, %v; want 1, true, nil", value, replayed, err)
+	}
+
+	// Keys are separate per scope
+	value, replayed, _ = c.Do(context.Background(), "s2", "k", "echo", fn, nil)
+	if value != 2 || replayed {
+		t.Errorf("Do in another scope = %v, %v; want 2, false", value, replayed)
+	}
+
+	if _, _, err := c.Do(context.Background(), "s1", "k", "rm", fn, nil); err != ErrKeyReused {
+		t.Errorf("Do with another fingerprint error = %v, want %v", err, ErrKeyReused)
+	}
+
+	c.Forget("s1")
+	if value, replayed, _ := c.Do(context.Background(), "s1", "k", "rm", fn, nil); value != 3 || replayed {
+		t.Errorf("Do after Forget = %v, %v; want 3, false", value, replayed)
+	}
+}
+
+func TestCache_NotKept(t *testing.T) {
+	c := New(time.Minute, 10)
+	refused := errors.New("refused")
+	keep := func(value interface{}, err error) bool { return err != refused }
+
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		if calls == 1 {
+			return nil, refused
+		}
+		return calls, nil
+	}
+
+	if _, _, err := c.Do(context.Background(), "s", "k", "f", fn, keep); err != refused {
+		t.Fatalf("first Do error = %v, want %v", err, refused)
+	}
+	value, replayed, err := c.Do(context.Background(), "s", "k", "f", fn, keep)
+	if value != 2 || replayed || err != nil {
+		t.Errorf("Do after a result that was not kept = %v, %v, %v; want 2, false, nil", value, replayed, err)
+	}
+}
+
+func TestCache_Expiry(t *testing.T) {
+	c := New(time.Minute, 2)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+
+	calls := 0
+	fn := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	c.Do(context.Background(), "s", "a", "f", fn, nil)
+	now = now.Add(2 * time.Minute)
+	if value, replayed, _ := c.Do(context.Background(), "s", "a", "f", fn, nil); value != 2 || replayed {
+		t.Errorf("Do after expiry = %v, %v; want 2, false", value, replayed)
+	}
+
+	// The oldest result makes room once the scope is full
+	now = now.Add(time.Second)
+	c.Do(context.Background(), "s", "b", "f", fn, nil)
+	now = now.Add(time.Second)
+	c.Do(context.Background(), "s", "c", "f", fn, nil)
+	if len(c.scopes["s"]) != 2 {
+		t.Fatalf("scope holds %d results, want 2", len(c.scopes["s"]))
+	}
+	if _, ok := c.scopes["s"]["a"]; ok {
+		t.Error("oldest result was not evicted")
+	}
+}
+
+func TestCache_WaitsForRunning(t *testing.T) {
+	c := New(time.Minute, 10)
+	started := make(chan struct{})
+	finish := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.Do(context.Background(), "s", "k", "f", func() (interface{}, error) {
+			close(started)
+			<-finish
+			return "done", nil
+		}, nil)
+	}()
+	<-started
+
+	// A retry that gives up while the original runs does not run it again
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.Do(ctx, "s", "k", "f", func() (interface{}, error) {
+		t.Error("retry ran while the original was running")
+		return nil, nil
+	}, nil); err != context.DeadlineExceeded {
+		t.Errorf("Do while running error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(finish)
+	value, replayed, err := c.Do(context.Background(), "s", "k", "f", func() (interface{}, error) {
+		t.Error("retry ran after the original finished")
+		return nil, nil
+	}, nil)
+	if value != "done" || !replayed || err != nil {
+		t.Errorf("Do after the original finished = %v, %v, %v; want done, true, nil", value, replayed, err)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,80 @@
+// Package limit caps how many operations run at once, both in total and
+// per key, so that a single caller cannot use up a shared budget.
+package limit
+
+import (
+	"errors"
+	"sync"
+)
+
+// Common errors
+var (
+	ErrLimitReached    = errors.New("concurrency limit reached")
+	ErrKeyLimitReached = errors.New("concurrency limit for key reached")
+)
+
+// Limiter counts active operations in total and per key
+type Limiter struct {
+	max    int
+	maxKey int
+	active int
+	perKey map[string]int
+	mu     sync.Mutex
+}
+
+// New creates a limiter allowing max operations in total and maxPerKey
+// per key. Zero or negative values disable the corresponding limit.
+func New(max, maxPerKey int) *Limiter {
+	return &Limiter{
+		max:    max,
+		maxKey: maxPerKey,
+		perKey: make(map[string]int),
+	}
+}
+
+// Acquire starts an operation for key. The returned function ends it and
+// must be called exactly once.
+func (l *Limiter) Acquire(key string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && l.active >= l.max {
+		return nil, ErrLimitReached
+	}
+	if l.maxKey > 0 && l.perKey[key] >= l.maxKey {
+		return nil, ErrKeyLimitReached
+	}
+
+	l.active++
+	l.perKey[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(key) })
+	}, nil
+}
+
+// release ends an operation for key
+func (l *Limiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if l.perKey[key] <= 1 {
+		delete(l.perKey, key)
+	} else {
+		l.perKey[key]--
+	}
+}
+
+// Active returns the number of running operations in total and for key
+func (l *Limiter) Active(key string) (total, forKey int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, l.perKey[key]
+}
+
+// Limits returns the configured total and per-key limits
+func (l *Limiter) Limits() (max, maxPerKey int) {
+	return l.max, l.maxKey
+}
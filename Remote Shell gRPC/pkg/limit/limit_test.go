@@ -0,0 +1,54 @@
+package limit
+
+import "testing"
+
+func TestLimiter_PerKey(t *testing.T) {
+	l := New(10, 2)
+
+	r1, err := l.Acquire("a")
+	if err != nil {
+		t.Fatalf("Acquire(a) error = %v", err)
+	}
+	if _, err := l.Acquire("a"); err != nil {
+		t.Fatalf("second Acquire(a) error = %v", err)
+	}
+	if _, err := l.Acquire("a"); err != ErrKeyLimitReached {
+		t.Errorf("third Acquire(a) error = %v, want %v", err, ErrKeyLimitReached)
+	}
+	if _, err := l.Acquire("b"); err != nil {
+		t.Errorf("Acquire(b) error = %v, want nil", err)
+	}
+
+	r1()
+	r1()
+	if total, forKey := l.Active("a"); total != 2 || forKey != 1 {
+		t.Errorf("Active(a) after release = %d, %d; want 2, 1", total, forKey)
+	}
+	if _, err := l.Acquire("a"); err != nil {
+		t.Errorf("Acquire(a) after release error = %v", err)
+	}
+}
+
+func TestLimiter_Global(t *testing.T) {
+	l := New(2, 0)
+
+	release, _ := l.Acquire("a")
+	l.Acquire("b")
+	if _, err := l.Acquire("c"); err != ErrLimitReached {
+		t.Errorf("Acquire(c) error = %v, want %v", err, ErrLimitReached)
+	}
+
+	release()
+	if _, err := l.Acquire("c"); err != nil {
+		t.Errorf("Acquire(c) after release error = %v", err)
+	}
+}
+
+func TestLimiter_Unlimited(t *testing.T) {
+	l := New(0, 0)
+	for i := 0; i < 100; i++ {
+		if _, err := l.Acquire("a"); err != nil {
+			t.Fatalf("Acquire() #%d error = %v", i, err)
+		}
+	}
+}
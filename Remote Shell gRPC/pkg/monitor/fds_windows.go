@@ -0,0 +1,6 @@
+package monitor
+
+// openFDs is not reported on Windows
+func openFDs() int {
+	return -1
+}
@@ -0,0 +1,59 @@
+// Package monitor samples the process's own resource usage so that a
+// server can warn about, and back off from, runaway growth before it
+// crashes.
+package monitor
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"remote-shell-rpc/pkg/format"
+)
+
+// Sample is a snapshot of the process's resource usage
+type Sample struct {
+	Goroutines int
+	HeapBytes  uint64
+	// OpenFDs is the number of open file descriptors, or -1 where the
+	// platform does not report it
+	OpenFDs int
+	Time    time.Time
+}
+
+// Read takes a sample of the current process
+func Read() Sample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	return Sample{
+		Goroutines: runtime.NumGoroutine(),
+		HeapBytes:  mem.HeapAlloc,
+		OpenFDs:    openFDs(),
+		Time:       time.Now(),
+	}
+}
+
+// Thresholds are the usage levels above which the process counts as
+// overloaded. Zero values disable the corresponding check.
+type Thresholds struct {
+	Goroutines int
+	HeapBytes  uint64
+	OpenFDs    int
+}
+
+// Exceeded returns a description of every threshold the sample is over,
+// or nil when it is within all of them
+func (t Thresholds) Exceeded(s Sample) []string {
+	var over []string
+	if t.Goroutines > 0 && s.Goroutines > t.Goroutines {
+		over = append(over, fmt.Sprintf("%d goroutines (limit %d)", s.Goroutines, t.Goroutines))
+	}
+	if t.HeapBytes > 0 && s.HeapBytes > t.HeapBytes {
+		over = append(over, fmt.Sprintf("%s heap (limit %s)", format.Bytes(int64(s.HeapBytes)), format.Bytes(int64(t.HeapBytes))))
+	}
+	if t.OpenFDs > 0 && s.OpenFDs > t.OpenFDs {
+		over = append(over, fmt.Sprintf("%d open files (limit %d)", s.OpenFDs, t.OpenFDs))
+	}
+	return over
+}
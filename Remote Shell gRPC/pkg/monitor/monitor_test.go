@@ -0,0 +1,53 @@
+package monitor
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	s := Read()
+	if s.Goroutines < 1 {
+		t.Errorf("Read() goroutines = %d, want at least 1", s.Goroutines)
+	}
+	if s.HeapBytes == 0 {
+		t.Error("Read() heap bytes = 0, want > 0")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	before := s.OpenFDs
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	if after := Read().OpenFDs; after != before+1 {
+		t.Errorf("Read() open files after Open() = %d, want %d", after, before+1)
+	}
+}
+
+func TestThresholds_Exceeded(t *testing.T) {
+	s := Sample{Goroutines: 500, HeapBytes: 300 << 20, OpenFDs: 40}
+
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		want       int
+	}{
+		{"disabled", Thresholds{}, 0},
+		{"within", Thresholds{Goroutines: 1000, HeapBytes: 512 << 20, OpenFDs: 100}, 0},
+		{"goroutines", Thresholds{Goroutines: 100}, 1},
+		{"all", Thresholds{Goroutines: 100, HeapBytes: 256 << 20, OpenFDs: 10}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.thresholds.Exceeded(s); len(got) != tt.want {
+				t.Errorf("Exceeded() = %v, want %d entries", got, tt.want)
+			}
+		})
+	}
+}
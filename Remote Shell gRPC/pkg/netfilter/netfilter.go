@@ -0,0 +1,132 @@
+// Package netfilter restricts which client addresses may connect, with
+// lists of allowed and denied networks in CIDR notation.
+package netfilter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// Filter decides whether an address may connect. An address in a denied
+// network is refused; otherwise it is accepted when there are no allowed
+// networks or it is in one of them. A nil Filter accepts everything.
+type Filter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// New creates a filter from lists of networks such as "10.0.0.0/8" or
+// "2001:db8::/32". A plain address stands for that single host.
+func New(allow, deny []string) (*Filter, error) {
+	allowed, err := parsePrefixes(allow)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{allow: allowed, deny: denied}, nil
+}
+
+// parsePrefixes parses networks and single addresses
+func parsePrefixes(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if !strings.Contains(network, "/") {
+			addr, err := netip.ParseAddr(network)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", network, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", network, err)
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Enabled reports whether the filter refuses any address
+func (f *Filter) Enabled() bool {
+	return f != nil && (len(f.allow) > 0 || len(f.deny) > 0)
+}
+
+// Allowed reports whether a host address may connect. Addresses that
+// cannot be parsed are only accepted when the filter is disabled.
+func (f *Filter) Allowed(host string) bool {
+	if !f.Enabled() {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	// IPv4 clients of a dual-stack listener show up as ::ffff:a.b.c.d
+	addr = addr.Unmap().WithZone("")
+
+	for _, prefix := range f.deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr reports whether a connection from addr may be accepted
+func (f *Filter) AllowedAddr(addr net.Addr) bool {
+	if !f.Enabled() {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return f.Allowed(host)
+}
+
+// listener closes connections from addresses the filter refuses
+type listener struct {
+	net.Listener
+	filter *Filter
+}
+
+// Listener wraps l so that connections the filter refuses are closed as
+// soon as they are accepted, before any request is read
+func Listener(l net.Listener, f *Filter) net.Listener {
+	if !f.Enabled() {
+		return l
+	}
+	return &listener{Listener: l, filter: f}
+}
+
+// Accept returns the next connection the filter allows
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.filter.AllowedAddr(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
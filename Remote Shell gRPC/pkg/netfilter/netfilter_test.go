@@ -0,0 +1,107 @@
+package netfilter
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFilter_Allowed(t *testing.T) {
+	f, err := New(
+		[]string{"10.0.0.0/8", "192.168.1.5", "2001:db8::/32"},
+		[]string{"10.9.0.0/16"},
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"10.9.1.1", false},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"::ffff:10.1.2.3", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"fe80::1%eth0", false},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		if got := f.Allowed(tt.host); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestFilter_DenyOnly(t *testing.T) {
+	f, err := New(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if f.Allowed("203.0.113.9") {
+		t.Error("Allowed() = true for a denied network")
+	}
+	if !f.Allowed("198.51.100.1") {
+		t.Error("Allowed() = false for an address outside the denied networks")
+	}
+}
+
+func TestFilter_Disabled(t *testing.T) {
+	var nilFilter *Filter
+	empty, _ := New(nil, nil)
+	for _, f := range []*Filter{nilFilter, empty} {
+		if f.Enabled() || !f.Allowed("unknown") || !f.Allowed("203.0.113.9") {
+			t.Errorf("filter %v refuses addresses while disabled", f)
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, network := range []string{"10.0.0.0/33", "example.com", "10.0.0/8", ""} {
+		if _, err := New([]string{network}, nil); err == nil {
+			t.Errorf("New(%q) error = nil, want an error", network)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	f, _ := New(nil, []string{"127.0.0.0/8"})
+	filtered := Listener(l, f)
+
+	accepted := make(chan struct{})
+	go func() {
+		if conn, err := filtered.Accept(); err == nil {
+			conn.Close()
+			close(accepted)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	// The listener closes the connection instead of returning it
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Error("Read() on a refused connection succeeded")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("refused connection was left open")
+	}
+	select {
+	case <-accepted:
+		t.Error("Accept() returned a refused connection")
+	default:
+	}
+}
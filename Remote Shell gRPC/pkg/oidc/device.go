@@ -0,0 +1,229 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// deviceGrantType is the grant type of the device authorization grant
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// ErrDeviceCodeExpired is returned when the user did not finish logging in
+// before the device code expired
+var ErrDeviceCodeExpired = errors.New("the login code expired")
+
+// App identifies the client application registered with the provider
+type App struct {
+	ClientID string
+	// ClientSecret is only needed by providers that treat the shell as a
+	// confidential client
+	ClientSecret string
+	Scopes       []string
+}
+
+// DeviceCode is a pending device login: the user opens VerificationURI in
+// a browser and enters UserCode
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+	// VerificationURL is the name some providers use for VerificationURI
+	VerificationURL string `json:"verification_url"`
+}
+
+// Token holds the tokens of a login
+type Token struct {
+	IDToken      string    `json:"id_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Valid reports whether the ID token is still valid for at least margin
+func (t *Token) Valid(margin time.Duration) bool {
+	return t != nil && t.IDToken != "" && time.Now().Add(margin).Before(t.Expiry)
+}
+
+// tokenResponse is the answer of the token endpoint
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// DeviceAuth starts a device login
+func (p *Provider) DeviceAuth(ctx context.Context, app App) (*DeviceCode, error) {
+	if p.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("provider %s does not support device login", p.Issuer)
+	}
+	form := url.Values{"client_id": {app.ClientID}, "scope": {strings.Join(app.Scopes, " ")}}
+	if app.ClientSecret != "" {
+		form.Set("client_secret", app.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.DeviceAuthorizationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var code DeviceCode
+	if err := p.do(req, &code); err != nil {
+		return nil, fmt.Errorf("failed to start device login: %w", err)
+	}
+	if code.VerificationURI == "" {
+		code.VerificationURI = code.VerificationURL
+	}
+	if code.DeviceCode == "" || code.UserCode == "" || code.VerificationURI == "" {
+		return nil, fmt.Errorf("failed to start device login: incomplete response")
+	}
+	if code.Interval <= 0 {
+		code.Interval = 5
+	}
+	return &code, nil
+}
+
+// WaitForToken polls the provider until the user approved or refused the
+// device login, the code expired or ctx is done
+func (p *Provider) WaitForToken(ctx context.Context, app App, code *DeviceCode) (*Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	var deadline <-chan time.Time
+	if code.ExpiresIn > 0 {
+		timer := time.NewTimer(time.Duration(code.ExpiresIn) * time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	form := url.Values{"grant_type": {deviceGrantType}, "device_code": {code.DeviceCode}}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, ErrDeviceCodeExpired
+		case <-time.After(interval):
+		}
+
+		resp, err := p.token(ctx, app, form)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.Error {
+		case "":
+			return p.newToken(resp, "")
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "expired_token":
+			return nil, ErrDeviceCodeExpired
+		case "access_denied":
+			return nil, fmt.Errorf("the login was refused")
+		default:
+			return nil, resp.err()
+		}
+	}
+}
+
+// Refresh gets a new ID token with the refresh token of a login
+func (p *Provider) Refresh(ctx context.Context, app App, refreshToken string) (*Token, error) {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	if len(app.Scopes) > 0 {
+		form.Set("scope", strings.Join(app.Scopes, " "))
+	}
+	resp, err := p.token(ctx, app, form)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, resp.err()
+	}
+	return p.newToken(resp, refreshToken)
+}
+
+// token posts a request to the token endpoint. Errors defined by OAuth2
+// are returned in the response rather than as an error.
+func (p *Provider) token(ctx context.Context, app App, form url.Values) (*tokenResponse, error) {
+	form.Set("client_id", app.ClientID)
+	if app.ClientSecret != "" {
+		form.Set("client_secret", app.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpResp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the token endpoint: %w", err)
+	}
+	defer httpResp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the token response: %w", err)
+	}
+
+	var resp tokenResponse
+	if err := json.Unmarshal(body, &resp); err != nil || (httpResp.StatusCode != http.StatusOK && resp.Error == "") {
+		return nil, fmt.Errorf("token endpoint: %s: %s", httpResp.Status, strings.TrimSpace(string(body)))
+	}
+	return &resp, nil
+}
+
+// newToken builds the token of a successful response, keeping
+// refreshToken when the provider did not issue a new one
+func (p *Provider) newToken(resp *tokenResponse, refreshToken string) (*Token, error) {
+	if resp.IDToken == "" {
+		return nil, fmt.Errorf("the provider returned no ID token; request the openid scope")
+	}
+	expiry, err := Expiry(resp.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if resp.RefreshToken != "" {
+		refreshToken = resp.RefreshToken
+	}
+	return &Token{IDToken: resp.IDToken, RefreshToken: refreshToken, Expiry: expiry}, nil
+}
+
+// err describes an OAuth2 error response
+func (r *tokenResponse) err() error {
+	if r.ErrorDescription != "" {
+		return fmt.Errorf("login failed: %s: %s", r.Error, r.ErrorDescription)
+	}
+	return fmt.Errorf("login failed: %s", r.Error)
+}
+
+// Expiry returns when a JWT expires, without verifying it. Clients use it
+// to know when to refresh a token; only the server's Verifier decides
+// whether a token is valid.
+func Expiry(token string) (time.Time, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Expiry == 0 {
+		return time.Time{}, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	return time.Unix(claims.Expiry, 0), nil
+}
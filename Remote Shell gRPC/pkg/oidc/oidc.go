@@ -0,0 +1,78 @@
+// Package oidc implements the parts of OpenID Connect the shell needs:
+// discovering an identity provider, logging in from a terminal with the
+// OAuth2 device authorization grant (RFC 8628), and verifying the ID
+// tokens the provider signs.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// discoveryPath is where a provider publishes its metadata, relative to
+// the issuer URL
+const discoveryPath = "/.well-known/openid-configuration"
+
+// maxResponseSize bounds the responses read from a provider
+const maxResponseSize = 1 << 20
+
+// Provider holds the metadata of an identity provider
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	JWKSURI                     string `json:"jwks_uri"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+
+	client *http.Client
+}
+
+// Discover fetches the metadata of the provider with the given issuer URL
+func Discover(ctx context.Context, issuer string) (*Provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	p := &Provider{client: &http.Client{Timeout: 10 * time.Second}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.do(req, p); err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", issuer, err)
+	}
+
+	// The metadata must come from the issuer it names, or tokens from
+	// another provider could be accepted
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider at %s claims to be issuer %q", issuer, p.Issuer)
+	}
+	if p.JWKSURI == "" || p.TokenEndpoint == "" {
+		return nil, fmt.Errorf("provider %s publishes no jwks_uri or token_endpoint", issuer)
+	}
+	return p, nil
+}
+
+// do sends a request and decodes its JSON response into v
+func (p *Provider) do(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
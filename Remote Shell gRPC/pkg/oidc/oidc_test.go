@@ -0,0 +1,204 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// provider is a fake identity provider that signs tokens with an RSA key
+type provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// polls counts the token requests of a device login, which is
+	// approved on the third
+	polls int
+}
+
+// newProvider starts a fake provider
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                        p.URL,
+			"jwks_uri":                      p.URL + "/keys",
+			"token_endpoint":                p.URL + "/token",
+			"device_authorization_endpoint": p.URL + "/device",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "dev-1",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": p.URL + "/activate",
+			"expires_in":       60,
+			"interval":         0,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		p.mu.Lock()
+		p.polls++
+		polls := p.polls
+		p.mu.Unlock()
+
+		switch {
+		case r.Form.Get("grant_type") == "refresh_token" && r.Form.Get("refresh_token") == "r1":
+		case r.Form.Get("device_code") != "dev-1":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		case polls < 3:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "opaque",
+			"id_token":      p.sign(t, p.claims(r.Form.Get("client_id"), time.Hour)),
+			"refresh_token": "r1",
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// claims returns valid claims for audience that expire after ttl
+func (p *provider) claims(aud string, ttl time.Duration) map[string]any {
+	return map[string]any{
+		"iss":   p.URL,
+		"sub":   "user-42",
+		"aud":   aud,
+		"exp":   time.Now().Add(ttl).Unix(),
+		"email": "alice@example.com",
+	}
+}
+
+// sign returns claims as an RS256 JWT
+func (p *provider) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	p := newProvider(t)
+	v := NewVerifier(p.URL, "shell")
+	ctx := context.Background()
+
+	claims, err := v.Verify(ctx, p.sign(t, p.claims("shell", time.Hour)))
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if claims.Subject != "user-42" || claims.Email != "alice@example.com" {
+		t.Errorf("Verify() = %+v, want the token's claims", claims)
+	}
+
+	multi := p.claims("other", time.Hour)
+	multi["aud"] = []string{"other", "shell"}
+	if _, err := v.Verify(ctx, p.sign(t, multi)); err != nil {
+		t.Errorf("Verify() with several audiences error = %v", err)
+	}
+
+	wrongIssuer := p.claims("shell", time.Hour)
+	wrongIssuer["iss"] = "https://evil.example.com"
+	tampered := p.sign(t, p.claims("shell", time.Hour))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	invalid := map[string]string{
+		"other audience": p.sign(t, p.claims("other", time.Hour)),
+		"expired":        p.sign(t, p.claims("shell", -2*time.Minute)),
+		"other issuer":   p.sign(t, wrongIssuer),
+		"bad signature":  tampered,
+		"not a JWT":      "opaque-token",
+	}
+	for name, token := range invalid {
+		if _, err := v.Verify(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Verify() of a token with %s error = %v, want %v", name, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestVerifier_ProviderDown(t *testing.T) {
+	p := newProvider(t)
+	token := p.sign(t, p.claims("shell", time.Hour))
+	v := NewVerifier(p.URL, "shell")
+	p.Close()
+
+	_, err := v.Verify(context.Background(), token)
+	if err == nil || errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() with the provider down error = %v, want an error other than %v", err, ErrInvalidToken)
+	}
+}
+
+func TestProvider_DeviceLogin(t *testing.T) {
+	p := newProvider(t)
+	ctx := context.Background()
+	app := App{ClientID: "shell", Scopes: []string{"openid"}}
+
+	provider, err := Discover(ctx, p.URL+"/")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	code, err := provider.DeviceAuth(ctx, app)
+	if err != nil {
+		t.Fatalf("DeviceAuth() error = %v", err)
+	}
+	if code.UserCode != "ABCD-EFGH" || code.Interval != 5 {
+		t.Errorf("DeviceAuth() = %+v, want the user code and the default interval", code)
+	}
+
+	code.Interval = 0
+	token, err := provider.WaitForToken(ctx, app, code)
+	if err != nil {
+		t.Fatalf("WaitForToken() error = %v", err)
+	}
+	if !token.Valid(time.Minute) || token.RefreshToken != "r1" {
+		t.Errorf("WaitForToken() = %+v, want a valid token with a refresh token", token)
+	}
+	if _, err := NewVerifier(p.URL, "shell").Verify(ctx, token.IDToken); err != nil {
+		t.Errorf("Verify() of the device login's token error = %v", err)
+	}
+
+	refreshed, err := provider.Refresh(ctx, app, token.RefreshToken)
+	if err != nil || !refreshed.Valid(time.Minute) {
+		t.Errorf("Refresh() = %+v, %v; want a valid token", refreshed, err)
+	}
+}
@@ -0,0 +1,363 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the clocks of the provider and the server may
+// disagree
+const clockSkew = time.Minute
+
+// keyRefreshInterval limits how often the provider's keys are fetched
+// again for a token signed with an unknown key
+const keyRefreshInterval = time.Minute
+
+// ErrInvalidToken is returned, wrapped, for tokens that are malformed,
+// badly signed, expired or meant for someone else
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the claims of an ID token the shell uses
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	Email     string   `json:"email"`
+	Name      string   `json:"name"`
+}
+
+// audience is the aud claim, which is a string or a list of strings
+type audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// contains reports whether aud is one of the audiences
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// jwk is a key of the provider's key set
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey is a parsed key of the provider
+type publicKey struct {
+	kid string
+	key crypto.PublicKey
+}
+
+// Verifier checks ID tokens issued by a provider for an audience. The
+// provider is discovered, and its keys fetched, when the first token is
+// verified.
+type Verifier struct {
+	issuer   string
+	audience string
+
+	mu       sync.Mutex
+	provider *Provider
+	keys     []publicKey
+	fetched  time.Time
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewVerifier creates a verifier for tokens issued by issuer to audience,
+// usually the client ID the shell is registered with
+func NewVerifier(issuer, audience string) *Verifier {
+	return &Verifier{issuer: issuer, audience: audience, now: time.Now}
+}
+
+// Prepare discovers the provider and fetches its keys ahead of the first
+// token, so that a misconfigured provider shows up early
+func (v *Verifier) Prepare(ctx context.Context) error {
+	_, err := v.keysFor(ctx, "")
+	return err
+}
+
+// Verify checks the signature and claims of a token and returns its
+// claims. Errors other than ErrInvalidToken mean the provider could not
+// be reached.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	keys, err := v.keysFor(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if header.Kid != "" && key.kid != header.Kid {
+			continue
+		}
+		if err := verifySignature(header.Alg, key.key, signed, sig); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// checkClaims verifies the issuer, audience and validity period
+func (v *Verifier) checkClaims(c *Claims) error {
+	v.mu.Lock()
+	issuer := v.provider.Issuer
+	v.mu.Unlock()
+
+	now := v.now()
+	switch {
+	case c.Issuer != issuer:
+		return fmt.Errorf("%w: issued by %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(v.audience):
+		return fmt.Errorf("%w: not issued for %q", ErrInvalidToken, v.audience)
+	case c.Subject == "":
+		return fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case c.Expiry == 0 || !now.Add(-clockSkew).Before(time.Unix(c.Expiry, 0)):
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)):
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return nil
+}
+
+// keysFor returns the provider's keys, fetching them again when none has
+// the key ID kid and they were not fetched within keyRefreshInterval, so
+// that keys the provider rotated in are picked up
+func (v *Verifier) keysFor(ctx context.Context, kid string) ([]publicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.provider == nil {
+		p, err := Discover(ctx, v.issuer)
+		if err != nil {
+			return nil, err
+		}
+		v.provider = p
+	}
+	if v.keys != nil && (hasKey(v.keys, kid) || v.now().Sub(v.fetched) < keyRefreshInterval) {
+		return v.keys, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.provider.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.provider.do(req, &set); err != nil {
+		if v.keys != nil {
+			return v.keys, nil
+		}
+		return nil, fmt.Errorf("failed to fetch the provider's keys: %w", err)
+	}
+
+	keys := []publicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of unsupported types are skipped
+		if key, err := k.parse(); err == nil {
+			keys = append(keys, publicKey{kid: k.Kid, key: key})
+		}
+	}
+	v.keys = keys
+	v.fetched = v.now()
+	return keys, nil
+}
+
+// hasKey reports whether a key has the key ID kid
+func hasKey(keys []publicKey, kid string) bool {
+	for _, k := range keys {
+		if kid == "" || k.kid == kid {
+			return true
+		}
+	}
+	return false
+}
+
+// parse returns the public key of a JWK
+func (k jwk) parse() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// algHashes are the hashes of the supported JWS algorithms besides EdDSA.
+// Unsigned tokens and shared-secret algorithms are refused.
+var algHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verifySignature checks a signature made with the JWS algorithm alg
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if alg == "EdDSA" {
+		if k, ok := key.(ed25519.PublicKey); ok && ed25519.Verify(k, signed, sig) {
+			return nil
+		}
+		return fmt.Errorf("signature does not verify with %s", alg)
+	}
+	hash, ok := algHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' {
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(k, hash, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature does not verify with %s", alg)
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeInt decodes a base64url big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
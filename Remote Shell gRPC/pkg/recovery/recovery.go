@@ -0,0 +1,73 @@
+// Package recovery turns panics in gRPC handlers into Internal errors. The
+// client gets a clean error naming a request ID, which the server logs
+// with the panic, instead of a hung or empty response.
+package recovery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"runtime/debug"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Panic describes a recovered panic
+type Panic struct {
+	// RequestID is also in the error returned to the client
+	RequestID string
+	Method    string
+	Value     interface{}
+	Stack     []byte
+}
+
+// Handler is called with every recovered panic
+type Handler func(p Panic)
+
+// UnaryServerInterceptor recovers from panics in unary handlers
+func UnaryServerInterceptor(h Handler) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				resp, err = nil, recovered(h, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor recovers from panics in stream handlers
+func StreamServerInterceptor(h Handler) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(h, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// recovered reports a panic to the handler and returns the error for the
+// client
+func recovered(h Handler, method string, value interface{}) error {
+	p := Panic{
+		RequestID: newRequestID(),
+		Method:    method,
+		Value:     value,
+		Stack:     debug.Stack(),
+	}
+	if h != nil {
+		h(p)
+	}
+	return status.Errorf(codes.Internal, "internal server error (request ID %s)", p.RequestID)
+}
+
+// newRequestID returns a random ID for finding a panic in the server log
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
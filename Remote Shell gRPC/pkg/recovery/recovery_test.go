@@ -0,0 +1,67 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor_Panic(t *testing.T) {
+	var got []Panic
+	intercept := UnaryServerInterceptor(func(p Panic) { got = append(got, p) })
+	info := &grpc.UnaryServerInfo{FullMethod: "/test/Panics"}
+
+	resp, err := intercept(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if resp != nil {
+		t.Errorf("response = %v, want nil", resp)
+	}
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("error = %v, want code %v", err, codes.Internal)
+	}
+	if len(got) != 1 {
+		t.Fatalf("handler called %d times, want 1", len(got))
+	}
+	p := got[0]
+	if p.Method != "/test/Panics" || p.Value != "boom" || len(p.Stack) == 0 {
+		t.Errorf("handler got %+v", p)
+	}
+	if p.RequestID == "" || !strings.Contains(status.Convert(err).Message(), p.RequestID) {
+		t.Errorf("error %q does not name request ID %q", status.Convert(err).Message(), p.RequestID)
+	}
+
+	// Requests that do not panic are left alone
+	want := errors.New("plain failure")
+	resp, err = intercept(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "partial", want
+	})
+	if resp != "partial" || err != want || len(got) != 1 {
+		t.Errorf("interceptor changed a normal result to %v, %v", resp, err)
+	}
+}
+
+func TestStreamServerInterceptor_Panic(t *testing.T) {
+	calls := 0
+	intercept := StreamServerInterceptor(func(p Panic) { calls++ })
+	info := &grpc.StreamServerInfo{FullMethod: "/test/Stream"}
+
+	err := intercept(nil, nil, info, func(srv interface{}, ss grpc.ServerStream) error {
+		var m map[string]int
+		m["nil map"]++
+		return nil
+	})
+	if status.Code(err) != codes.Internal || calls != 1 {
+		t.Errorf("error = %v after %d handler calls, want code %v after 1", err, calls, codes.Internal)
+	}
+
+	err = intercept(nil, nil, info, func(srv interface{}, ss grpc.ServerStream) error { return nil })
+	if err != nil || calls != 1 {
+		t.Errorf("error = %v after %d handler calls, want nil after 1", err, calls)
+	}
+}
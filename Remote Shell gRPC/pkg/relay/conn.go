@@ -0,0 +1,109 @@
+package relay
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// maxFrameSize is the largest chunk sent in a single tunnel frame
+const maxFrameSize = 32 * 1024
+
+// frameStream is the part of a gRPC stream a tunnel needs
+type frameStream interface {
+	Send(*pb.TunnelFrame) error
+	Recv() (*pb.TunnelFrame, error)
+}
+
+// addr is the address of one end of a tunnel
+type addr string
+
+// Network implements net.Addr
+func (a addr) Network() string { return "relay" }
+
+// String implements net.Addr
+func (a addr) String() string { return string(a) }
+
+// conn adapts a tunnel stream to a net.Conn
+type conn struct {
+	stream    frameStream
+	closeFn   func()
+	local     net.Addr
+	remote    net.Addr
+	buf       []byte
+	readMu    sync.Mutex
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+// newConn wraps a stream; closeFn tears the stream down
+func newConn(stream frameStream, closeFn func(), local, remote net.Addr) *conn {
+	return &conn{
+		stream:  stream,
+		closeFn: closeFn,
+		local:   local,
+		remote:  remote,
+	}
+}
+
+// Read reads data received through the tunnel
+func (c *conn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.buf) == 0 {
+		frame, err := c.stream.Recv()
+		if err != nil {
+			if err == io.EOF || status.Code(err) == codes.Canceled {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		c.buf = frame.Data
+	}
+
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write sends data through the tunnel in frames of at most maxFrameSize
+func (c *conn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxFrameSize)
+		if err := c.stream.Send(&pb.TunnelFrame{Data: p[:n]}); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close closes the tunnel
+func (c *conn) Close() error {
+	c.closeOnce.Do(c.closeFn)
+	return nil
+}
+
+// LocalAddr implements net.Conn
+func (c *conn) LocalAddr() net.Addr { return c.local }
+
+// RemoteAddr implements net.Conn
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+// Deadlines are not supported on tunnels; gRPC only uses them for
+// handshake timeouts, which keepalives cover as well
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
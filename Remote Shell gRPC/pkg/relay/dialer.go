@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// Dialer opens tunnels through a relay to servers registered by name
+type Dialer struct {
+	conn   *grpc.ClientConn
+	client pb.RelayServiceClient
+}
+
+// NewDialer creates a dialer for the relay at address. creds secure the
+// connection to the relay; nil connects in plaintext.
+func NewDialer(address string, creds credentials.TransportCredentials) (*Dialer, error) {
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to relay %s: %w", address, err)
+	}
+
+	return &Dialer{
+		conn:   conn,
+		client: pb.NewRelayServiceClient(conn),
+	}, nil
+}
+
+// DialContext opens a tunnel to the named server. It has the signature
+// expected by grpc.WithContextDialer.
+func (d *Dialer) DialContext(ctx context.Context, name string) (net.Conn, error) {
+	// The tunnel outlives the dial context, which only bounds the setup
+	streamCtx, cancel := context.WithCancel(context.Background())
+	streamCtx = metadata.AppendToOutgoingContext(streamCtx, targetKey, name)
+
+	stream, err := d.client.Dial(streamCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// The relay sends the header once the server has accepted the tunnel
+	ready := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-ready:
+		}
+	}()
+	err = waitReady(stream)
+	close(ready)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return newConn(stream, func() {
+		stream.CloseSend()
+		cancel()
+	}, addr("relay-client"), addr("relay/"+name)), nil
+}
+
+// ListServers returns the names of the servers registered with the relay
+func (d *Dialer) ListServers(ctx context.Context) ([]string, error) {
+	resp, err := d.client.ListServers(ctx, &pb.ListServersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(resp.Servers))
+	for _, srv := range resp.Servers {
+		names = append(names, srv.Name)
+	}
+	return names, nil
+}
+
+// Close closes the connection to the relay
+func (d *Dialer) Close() error {
+	return d.conn.Close()
+}
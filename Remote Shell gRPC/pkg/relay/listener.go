@@ -0,0 +1,172 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/logger"
+)
+
+// Registration retry backoff
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Listener registers a server with a relay and returns the tunnels opened
+// by clients as connections, so a gRPC server can serve them like any
+// other listener. The registration is retried until the listener is closed.
+type Listener struct {
+	conn   *grpc.ClientConn
+	client pb.RelayServiceClient
+	name   string
+	token  string
+	// instance tells the relay that a registration comes from this
+	// listener again after a reconnect
+	instance string
+	logger   *logger.Logger
+	conns    chan net.Conn
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// Listen registers with the relay at address under the given name. creds
+// secure the connection to the relay; nil connects in plaintext.
+func Listen(address, name, token string, creds credentials.TransportCredentials, log *logger.Logger) (*Listener, error) {
+	if log == nil {
+		log = logger.Default()
+	}
+
+	instance, err := generateID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create relay instance: %w", err)
+	}
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to relay %s: %w", address, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Listener{
+		conn:     conn,
+		client:   pb.NewRelayServiceClient(conn),
+		name:     name,
+		token:    token,
+		instance: instance,
+		logger:   log,
+		conns:    make(chan net.Conn),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	go l.run(address)
+
+	return l, nil
+}
+
+// Accept waits for the next tunnel
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+// Close unregisters from the relay
+func (l *Listener) Close() error {
+	l.cancel()
+	return l.conn.Close()
+}
+
+// Addr returns the name the server is registered under
+func (l *Listener) Addr() net.Addr {
+	return addr("relay/" + l.name)
+}
+
+// run keeps the registration alive, reconnecting with backoff
+func (l *Listener) run(address string) {
+	backoff := minBackoff
+	for {
+		registered, err := l.register()
+		if l.ctx.Err() != nil {
+			return
+		}
+		if registered {
+			backoff = minBackoff
+		}
+		l.logger.Warn("Relay registration lost, retrying",
+			"relay", address,
+			"error", err.Error(),
+			"retry_in", backoff.String(),
+		)
+
+		select {
+		case <-time.After(backoff):
+		case <-l.ctx.Done():
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// register holds one registration, answering tunnel requests until the
+// stream fails. It reports whether the relay accepted the registration.
+func (l *Listener) register() (bool, error) {
+	stream, err := l.client.Register(l.ctx, &pb.RegisterRequest{
+		Name:     l.name,
+		Token:    l.token,
+		Instance: l.instance,
+	})
+	if err != nil {
+		return false, err
+	}
+	if err := waitReady(stream); err != nil {
+		return false, err
+	}
+	l.logger.Info("Registered with relay", "name", l.name)
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return true, err
+		}
+		go l.accept(req.TunnelId)
+	}
+}
+
+// accept opens the server side of a tunnel and hands it to Accept
+func (l *Listener) accept(id string) {
+	ctx, cancel := context.WithCancel(l.ctx)
+	ctx = metadata.AppendToOutgoingContext(ctx, tunnelKey, id, tokenKey, l.token)
+
+	stream, err := l.client.Accept(ctx)
+	if err != nil {
+		cancel()
+		l.logger.Warn("Failed to accept tunnel", "tunnel_id", id, "error", err.Error())
+		return
+	}
+
+	c := newConn(stream, func() {
+		stream.CloseSend()
+		cancel()
+	}, l.Addr(), addr("relay/"+id))
+
+	select {
+	case l.conns <- c:
+	case <-l.ctx.Done():
+		c.Close()
+	}
+}
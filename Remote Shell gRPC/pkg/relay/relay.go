@@ -0,0 +1,387 @@
+// Package relay implements a relay that lets clients reach shell servers
+// behind NAT. Servers dial out to the relay and register under a name;
+// clients ask the relay for a tunnel to a name and the relay copies bytes
+// between the two streams. The shell gRPC traffic runs end to end inside
+// the tunnel, so the relay never sees individual RPCs, and when the server
+// serves TLS the relay cannot read or change them either.
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+
+	"remote-shell-rpc/pkg/logger"
+)
+
+// Metadata keys used by the relay streams
+const (
+	targetKey = "relay-target"
+	tunnelKey = "tunnel-id"
+	// tokenKey carries the token of a server accepting a tunnel
+	tokenKey = "relay-token"
+	// readyKey is sent in the response header once a registration or
+	// tunnel is up; a failed call has no header
+	readyKey = "relay-ready"
+)
+
+// Config holds relay configuration
+type Config struct {
+	// Token is required from servers registering with the relay
+	Token string
+	// Servers holds tokens of servers by name. A server listed here must
+	// register with its own token, so that the shared Token cannot be
+	// used to take its name.
+	Servers map[string]string
+	// Insecure lets servers register without any token
+	Insecure bool
+	// TunnelTimeout is how long a client waits for the server to answer
+	TunnelTimeout time.Duration
+}
+
+// DefaultConfig returns the default relay configuration
+func DefaultConfig() Config {
+	return Config{
+		TunnelTimeout: 10 * time.Second,
+	}
+}
+
+// node is a registered server
+type node struct {
+	instance     string
+	address      string
+	registeredAt time.Time
+	requests     chan string
+	done         chan struct{}
+}
+
+// tunnel is the server side of a tunnel handed to a waiting client
+type tunnel struct {
+	stream frameStream
+	done   chan struct{}
+}
+
+// pendingTunnel is a tunnel waiting for the named server to accept it
+type pendingTunnel struct {
+	name     string
+	accepted chan *tunnel
+}
+
+// Server implements the RelayService
+type Server struct {
+	pb.UnimplementedRelayServiceServer
+	config  Config
+	logger  *logger.Logger
+	nodes   map[string]*node
+	pending map[string]pendingTunnel
+	mu      sync.Mutex
+}
+
+// NewServer creates a relay server
+func NewServer(cfg Config, log *logger.Logger) *Server {
+	if log == nil {
+		log = logger.Default()
+	}
+	if cfg.TunnelTimeout <= 0 {
+		cfg.TunnelTimeout = DefaultConfig().TunnelTimeout
+	}
+
+	return &Server{
+		config:  cfg,
+		logger:  log.WithComponent("relay"),
+		nodes:   make(map[string]*node),
+		pending: make(map[string]pendingTunnel),
+	}
+}
+
+// Register keeps a server registered until its stream ends. A name that
+// is registered already is refused, unless the registration comes from the
+// same server instance: a server that reconnects replaces its stale stream
+// instead of waiting for it to time out.
+func (s *Server) Register(req *pb.RegisterRequest, stream pb.RelayService_RegisterServer) error {
+	if req.Name == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	if err := s.authorize(req.Name, req.Token); err != nil {
+		return err
+	}
+
+	n := &node{
+		instance:     req.Instance,
+		registeredAt: time.Now(),
+		requests:     make(chan string),
+		done:         make(chan struct{}),
+	}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		n.address = p.Addr.String()
+	}
+
+	s.mu.Lock()
+	if old, ok := s.nodes[req.Name]; ok {
+		if old.instance == "" || subtle.ConstantTimeCompare([]byte(old.instance), []byte(req.Instance)) != 1 {
+			s.mu.Unlock()
+			s.logger.Warn("Registration refused, name in use",
+				"name", req.Name,
+				"address", n.address,
+				"registered_address", old.address,
+			)
+			return status.Errorf(codes.AlreadyExists, "name %q is registered by another server", req.Name)
+		}
+		close(old.done)
+	}
+	s.nodes[req.Name] = n
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.nodes[req.Name] == n {
+			delete(s.nodes, req.Name)
+		}
+		s.mu.Unlock()
+		s.logger.Info("Server unregistered", "name", req.Name)
+	}()
+
+	// The header tells the server that it is registered
+	if err := stream.SendHeader(metadata.Pairs(readyKey, "1")); err != nil {
+		return err
+	}
+	s.logger.Info("Server registered", "name", req.Name, "address", n.address)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-n.done:
+			return status.Error(codes.Aborted, "replaced by a newer registration")
+		case id := <-n.requests:
+			if err := stream.Send(&pb.TunnelRequest{TunnelId: id}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authorize checks the token a server registers with: its own token when
+// it has one, else the shared token. Without either the relay only lets
+// servers in when it runs insecure.
+func (s *Server) authorize(name, token string) error {
+	want, ok := s.config.Servers[name]
+	if !ok {
+		want = s.config.Token
+	}
+	if want == "" {
+		if s.config.Insecure {
+			return nil
+		}
+		return status.Error(codes.Unauthenticated, "relay has no token for this server")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid relay token")
+	}
+	return nil
+}
+
+// Dial connects a client to a registered server
+func (s *Server) Dial(stream pb.RelayService_DialServer) error {
+	ctx := stream.Context()
+	name := metadataValue(ctx, targetKey)
+	if name == "" {
+		return status.Error(codes.InvalidArgument, "relay-target is required")
+	}
+
+	s.mu.Lock()
+	n, ok := s.nodes[name]
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.Unavailable, "server %q is not registered with the relay", name)
+	}
+
+	id, err := generateID()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create tunnel: %v", err)
+	}
+
+	accepted := make(chan *tunnel, 1)
+	s.mu.Lock()
+	s.pending[id] = pendingTunnel{name: name, accepted: accepted}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+
+		// Release a tunnel that arrived after we gave up on it
+		select {
+		case t := <-accepted:
+			close(t.done)
+		default:
+		}
+	}()
+
+	timer := time.NewTimer(s.config.TunnelTimeout)
+	defer timer.Stop()
+
+	select {
+	case n.requests <- id:
+	case <-n.done:
+		return status.Errorf(codes.Unavailable, "server %q went away", name)
+	case <-timer.C:
+		return status.Errorf(codes.Unavailable, "server %q did not respond", name)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var t *tunnel
+	select {
+	case t = <-accepted:
+	case <-timer.C:
+		return status.Errorf(codes.DeadlineExceeded, "server %q did not accept the tunnel", name)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer close(t.done)
+
+	// The header tells the client that the tunnel is up
+	if err := stream.SendHeader(metadata.Pairs(readyKey, "1")); err != nil {
+		return err
+	}
+	s.logger.Debug("Tunnel opened", "name", name, "tunnel_id", id)
+
+	pipe(stream, t.stream)
+
+	s.logger.Debug("Tunnel closed", "name", name, "tunnel_id", id)
+	return nil
+}
+
+// Accept hands a server's tunnel stream to the waiting client. The server
+// must send the token it registered with, so that knowing a tunnel ID is
+// not enough to answer in its place.
+func (s *Server) Accept(stream pb.RelayService_AcceptServer) error {
+	ctx := stream.Context()
+	id := metadataValue(ctx, tunnelKey)
+
+	s.mu.Lock()
+	p, ok := s.pending[id]
+	s.mu.Unlock()
+	if !ok {
+		return status.Error(codes.NotFound, "unknown tunnel")
+	}
+	if err := s.authorize(p.name, metadataValue(ctx, tokenKey)); err != nil {
+		s.logger.Warn("Tunnel accept refused", "name", p.name, "tunnel_id", id)
+		return err
+	}
+
+	s.mu.Lock()
+	_, ok = s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+	if !ok {
+		return status.Error(codes.NotFound, "unknown tunnel")
+	}
+	accepted := p.accepted
+
+	t := &tunnel{
+		stream: stream,
+		done:   make(chan struct{}),
+	}
+	accepted <- t
+
+	select {
+	case <-t.done:
+	case <-stream.Context().Done():
+	}
+	return nil
+}
+
+// waitReady waits for the relay's ready header. A call that failed before
+// sending it returns its error from Recv.
+func waitReady(stream interface {
+	Header() (metadata.MD, error)
+	RecvMsg(m any) error
+}) error {
+	md, err := stream.Header()
+	if err != nil {
+		return err
+	}
+	if len(md.Get(readyKey)) > 0 {
+		return nil
+	}
+	if err := stream.RecvMsg(new(pb.TunnelFrame)); err != nil {
+		return err
+	}
+	return status.Error(codes.Internal, "relay did not confirm the stream")
+}
+
+// ListServers returns the registered servers sorted by name
+func (s *Server) ListServers(ctx context.Context, req *pb.ListServersRequest) (*pb.ListServersResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &pb.ListServersResponse{
+		Servers: make([]*pb.RegisteredServer, 0, len(s.nodes)),
+	}
+	for name, n := range s.nodes {
+		resp.Servers = append(resp.Servers, &pb.RegisteredServer{
+			Name:               name,
+			Address:            n.address,
+			RegisteredAtUnixMs: n.registeredAt.UnixMilli(),
+		})
+	}
+	sort.Slice(resp.Servers, func(i, j int) bool {
+		return resp.Servers[i].Name < resp.Servers[j].Name
+	})
+	return resp, nil
+}
+
+// pipe copies frames in both directions until either side stops
+func pipe(a, b frameStream) {
+	errc := make(chan error, 2)
+	go func() { errc <- copyFrames(a, b) }()
+	go func() { errc <- copyFrames(b, a) }()
+	<-errc
+}
+
+// copyFrames copies frames from src to dst
+func copyFrames(dst, src frameStream) error {
+	for {
+		frame, err := src.Recv()
+		if err != nil {
+			return err
+		}
+		if err := dst.Send(frame); err != nil {
+			return err
+		}
+	}
+}
+
+// metadataValue returns the first value of an incoming metadata key
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// generateID generates a unique tunnel ID
+func generateID() (string, error) {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
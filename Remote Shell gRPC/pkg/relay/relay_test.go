@@ -0,0 +1,325 @@
+package relay
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "remote-shell-rpc/proto"
+)
+
+// startRelay runs a relay on a random local port
+func startRelay(t *testing.T, cfg Config, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := grpc.NewServer(opts...)
+	pb.RegisterRelayServiceServer(g, NewServer(cfg, nil))
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+
+	return lis.Addr().String()
+}
+
+// register opens a registration stream, returning its error once the
+// relay accepts or refuses it
+func register(t *testing.T, ctx context.Context, address string, req *pb.RegisterRequest) error {
+	t.Helper()
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := pb.NewRelayServiceClient(conn).Register(ctx, req)
+	if err != nil {
+		return err
+	}
+	return waitReady(stream)
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a
+// pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// serveEcho echoes everything received through the listener's tunnels
+func serveEcho(l *Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go io.Copy(c, c)
+	}
+}
+
+// dialEcho opens a tunnel to name and checks that it echoes
+func dialEcho(t *testing.T, ctx context.Context, d *Dialer, name string) {
+	t.Helper()
+
+	// The listener registers asynchronously
+	var c net.Conn
+	var err error
+	for {
+		c, err = d.DialContext(ctx, name)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("DialContext() error = %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("Read() = %q, want %q", buf, "hello")
+	}
+}
+
+func TestRelay_Tunnel(t *testing.T) {
+	address := startRelay(t, Config{Token: "secret"})
+
+	l, err := Listen(address, "node1", "secret", nil, nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	go serveEcho(l)
+
+	d, err := NewDialer(address, nil)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialEcho(t, ctx, d, "node1")
+
+	names, err := d.ListServers(ctx)
+	if err != nil {
+		t.Fatalf("ListServers() error = %v", err)
+	}
+	if len(names) != 1 || names[0] != "node1" {
+		t.Errorf("ListServers() = %v, want [node1]", names)
+	}
+}
+
+func TestRelay_TLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	address := startRelay(t, Config{Token: "secret"},
+		grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{cert}})))
+	creds := credentials.NewTLS(&tls.Config{RootCAs: pool})
+
+	l, err := Listen(address, "node1", "secret", creds, nil)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+	go serveEcho(l)
+
+	d, err := NewDialer(address, creds)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialEcho(t, ctx, d, "node1")
+
+	// A plaintext client gets nowhere
+	plain, err := NewDialer(address, nil)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	defer plain.Close()
+	if _, err := plain.ListServers(ctx); err == nil {
+		t.Error("ListServers() over plaintext to a TLS relay succeeded, want error")
+	}
+}
+
+func TestRelay_AcceptRequiresToken(t *testing.T) {
+	address := startRelay(t, Config{Token: "secret"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewRelayServiceClient(conn)
+
+	reg, err := client.Register(ctx, &pb.RegisterRequest{Name: "node1", Token: "secret"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := waitReady(reg); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	d, err := NewDialer(address, nil)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	defer d.Close()
+	dialed := make(chan error, 1)
+	go func() {
+		c, err := d.DialContext(ctx, "node1")
+		if err == nil {
+			c.Close()
+		}
+		dialed <- err
+	}()
+
+	req, err := reg.Recv()
+	if err != nil {
+		t.Fatalf("Recv() tunnel request error = %v", err)
+	}
+	accept := func(token string) error {
+		stream, err := client.Accept(metadata.AppendToOutgoingContext(ctx, tunnelKey, req.TunnelId, tokenKey, token))
+		if err != nil {
+			return err
+		}
+		_, err = stream.Recv()
+		return err
+	}
+
+	// Knowing the tunnel ID is not enough to answer for the server
+	if err := accept("guess"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Accept() with a wrong token error = %v, want Unauthenticated", err)
+	}
+
+	go accept("secret")
+	if err := <-dialed; err != nil {
+		t.Errorf("DialContext() after the server accepted error = %v", err)
+	}
+}
+
+func TestRelay_UnknownServer(t *testing.T) {
+	address := startRelay(t, DefaultConfig())
+
+	d, err := NewDialer(address, nil)
+	if err != nil {
+		t.Fatalf("NewDialer() error = %v", err)
+	}
+	defer d.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := d.DialContext(ctx, "missing"); err == nil {
+		t.Error("DialContext() to an unregistered server succeeded, want error")
+	}
+}
+
+func TestRelay_RequiresToken(t *testing.T) {
+	address := startRelay(t, DefaultConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := register(t, ctx, address, &pb.RegisterRequest{Name: "node1"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Register() without a relay token error = %v, want Unauthenticated", err)
+	}
+
+	address = startRelay(t, Config{Insecure: true})
+	if err := register(t, ctx, address, &pb.RegisterRequest{Name: "node1"}); err != nil {
+		t.Errorf("Register() on an insecure relay error = %v", err)
+	}
+}
+
+func TestRelay_NameTaken(t *testing.T) {
+	address := startRelay(t, Config{Token: "secret"})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, stop := context.WithCancel(ctx)
+	defer stop()
+	if err := register(t, first, address, &pb.RegisterRequest{Name: "node1", Token: "secret", Instance: "a"}); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	err := register(t, ctx, address, &pb.RegisterRequest{Name: "node1", Token: "secret", Instance: "b"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Register() by another server error = %v, want AlreadyExists", err)
+	}
+
+	// The same server reconnecting takes over its name
+	if err := register(t, ctx, address, &pb.RegisterRequest{Name: "node1", Token: "secret", Instance: "a"}); err != nil {
+		t.Errorf("Register() by the same server error = %v", err)
+	}
+}
+
+func TestRelay_ServerTokens(t *testing.T) {
+	address := startRelay(t, Config{
+		Token:   "shared",
+		Servers: map[string]string{"node1": "own"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := register(t, ctx, address, &pb.RegisterRequest{Name: "node1", Token: "shared", Instance: "a"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Register() with the shared token error = %v, want Unauthenticated", err)
+	}
+	if err := register(t, ctx, address, &pb.RegisterRequest{Name: "node1", Token: "own", Instance: "a"}); err != nil {
+		t.Errorf("Register() with the server's token error = %v", err)
+	}
+	if err := register(t, ctx, address, &pb.RegisterRequest{Name: "node2", Token: "shared", Instance: "b"}); err != nil {
+		t.Errorf("Register() of another server with the shared token error = %v", err)
+	}
+}
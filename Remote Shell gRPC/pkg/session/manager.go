@@ -11,7 +11,9 @@ type Manager struct {
 	sessions    map[string]*Session
 	clientIndex map[string]string // clientID -> sessionID
 	maxSessions int
-	mu          sync.RWMutex
+	// rejected counts the sessions refused because the limit was reached
+	rejected int64
+	mu       sync.RWMutex
 }
 
 // ManagerConfig holds configuration for the session manager
@@ -40,12 +42,22 @@ func NewManager(cfg ManagerConfig) *Manager {
 
 // Create creates a new session for a client
 func (m *Manager) Create(clientID string) (*Session, error) {
+	return m.CreateWithOptions(clientID, Options{})
+}
+
+// CreateWithOptions creates a new session for a client with the given
+// options. If the client already has a session it is returned unchanged,
+// unless it belongs to another owner.
+func (m *Manager) CreateWithOptions(clientID string, opts Options) (*Session, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	// Check if client already has a session
 	if existingID, exists := m.clientIndex[clientID]; exists {
 		if session, ok := m.sessions[existingID]; ok {
+			if err := session.CheckOwner(opts.Owner); err != nil {
+				return nil, err
+			}
 			session.UpdateActivity()
 			return session, nil
 		}
@@ -55,6 +67,7 @@ func (m *Manager) Create(clientID string) (*Session, error) {
 
 	// Check max sessions
 	if len(m.sessions) >= m.maxSessions {
+		m.rejected++
 		return nil, ErrMaxSessions
 	}
 
@@ -65,7 +78,7 @@ func (m *Manager) Create(clientID string) (*Session, error) {
 	}
 
 	// Create new session
-	session, err := NewSession(sessionID, clientID)
+	session, err := NewSessionWithOptions(sessionID, clientID, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -76,6 +89,48 @@ func (m *Manager) Create(clientID string) (*Session, error) {
 	return session, nil
 }
 
+// Import recreates a session from a snapshot taken on another server.
+// An existing session of the same client is replaced.
+func (m *Manager) Import(state State) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.sessions[state.ID]; exists {
+		return nil, ErrSessionExists
+	}
+	if len(m.sessions) >= m.maxSessions {
+		m.rejected++
+		return nil, ErrMaxSessions
+	}
+
+	session, err := NewSessionWithOptions(state.ID, state.ClientID, state.Options)
+	if err != nil {
+		return nil, err
+	}
+	if !state.CreatedAt.IsZero() {
+		session.CreatedAt = state.CreatedAt
+	}
+	if state.WorkingDir != "" {
+		session.SetWorkingDir(state.WorkingDir)
+	}
+	for k, v := range state.Environment {
+		session.Environment[k] = v
+	}
+	// Also hands the restored environment to the executor
+	session.SetTerminalSize(state.Rows, state.Cols)
+
+	if existingID, exists := m.clientIndex[state.ClientID]; exists {
+		if existing, ok := m.sessions[existingID]; ok {
+			existing.close()
+		}
+		delete(m.sessions, existingID)
+	}
+	m.sessions[state.ID] = session
+	m.clientIndex[state.ClientID] = state.ID
+
+	return session, nil
+}
+
 // Get retrieves a session by ID
 func (m *Manager) Get(sessionID string) (*Session, error) {
 	m.mu.RLock()
@@ -119,6 +174,7 @@ func (m *Manager) Delete(sessionID string) error {
 
 	delete(m.clientIndex, session.ClientID)
 	delete(m.sessions, sessionID)
+	session.close()
 
 	return nil
 }
@@ -142,6 +198,47 @@ func (m *Manager) Count() int {
 	return len(m.sessions)
 }
 
+// MaxSessions returns the number of sessions allowed at once
+func (m *Manager) MaxSessions() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.maxSessions
+}
+
+// SetMaxSessions changes the number of sessions allowed at once, returning
+// the previous limit. Lowering it below the current count closes nothing;
+// new sessions are refused until enough have ended.
+func (m *Manager) SetMaxSessions(n int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.maxSessions
+	if n > 0 {
+		m.maxSessions = n
+	}
+	return previous
+}
+
+// Rejected returns the number of sessions refused because the limit was
+// reached
+func (m *Manager) Rejected() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.rejected
+}
+
+// CountByOwner returns the number of active sessions of each owner;
+// sessions without one are counted under ""
+func (m *Manager) CountByOwner() map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, session := range m.sessions {
+		counts[session.Options.Owner]++
+	}
+	return counts
+}
+
 // generateSessionID generates a unique session ID
 func generateSessionID() (string, error) {
 	bytes := make([]byte, 16)
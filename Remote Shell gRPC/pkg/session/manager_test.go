@@ -1,8 +1,13 @@
 package session
-package session
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"remote-shell-rpc/pkg/executor"
 )
 
 func TestManager_Create(t *testing.T) {
@@ -98,6 +103,9 @@ func TestManager_Delete(t *testing.T) {
 	if err != ErrSessionNotFound {
 		t.Errorf("Get() after Delete() error = %v, want %v", err, ErrSessionNotFound)
 	}
+	if session.Context().Err() == nil {
+		t.Error("Context() not cancelled after Delete()")
+	}
 }
 
 func TestManager_DeleteNotFound(t *testing.T) {
@@ -144,6 +152,180 @@ func TestManager_MaxSessions(t *testing.T) {
 	if err != ErrMaxSessions {
 		t.Errorf("Create() error = %v, want %v", err, ErrMaxSessions)
 	}
+	if m.Rejected() != 1 {
+		t.Errorf("Rejected() = %d, want 1", m.Rejected())
+	}
+}
+
+func TestManager_SetMaxSessions(t *testing.T) {
+	m := NewManager(ManagerConfig{MaxSessions: 1})
+	m.Create("client1")
+
+	if previous := m.SetMaxSessions(2); previous != 1 {
+		t.Errorf("SetMaxSessions() = %d, want 1", previous)
+	}
+	if _, err := m.Create("client2"); err != nil {
+		t.Fatalf("Create() after raising the limit error = %v", err)
+	}
+
+	// Lowering the limit keeps the sessions already open
+	m.SetMaxSessions(1)
+	if m.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", m.Count())
+	}
+	if _, err := m.Create("client3"); err != ErrMaxSessions {
+		t.Errorf("Create() error = %v, want %v", err, ErrMaxSessions)
+	}
+
+	m.SetMaxSessions(0)
+	if m.MaxSessions() != 1 {
+		t.Errorf("MaxSessions() after SetMaxSessions(0) = %d, want 1", m.MaxSessions())
+	}
+}
+
+func TestManager_CountByOwner(t *testing.T) {
+	m := NewManager(DefaultManagerConfig())
+	m.CreateWithOptions("client1", Options{Owner: "alice"})
+	m.CreateWithOptions("client2", Options{Owner: "alice"})
+	m.CreateWithOptions("client3", Options{Owner: "bob"})
+	m.Create("client4")
+
+	counts := m.CountByOwner()
+	want := map[string]int{"alice": 2, "bob": 1, "": 1}
+	if len(counts) != len(want) {
+		t.Fatalf("CountByOwner() = %v, want %v", counts, want)
+	}
+	for owner, n := range want {
+		if counts[owner] != n {
+			t.Errorf("CountByOwner()[%q] = %d, want %d", owner, counts[owner], n)
+		}
+	}
+}
+
+func TestManager_CreateWithOptions(t *testing.T) {
+	m := NewManager(DefaultManagerConfig())
+
+	session, err := m.CreateWithOptions("client1", Options{Shell: "/bin/sh"})
+	if err != nil {
+		t.Fatalf("CreateWithOptions() error = %v", err)
+	}
+
+	if session.Shell != "/bin/sh" {
+		t.Errorf("CreateWithOptions() shell = %s, want /bin/sh", session.Shell)
+	}
+
+	// Reattaching keeps the shell the session was created with
+	again, _ := m.CreateWithOptions("client1", Options{Shell: "/bin/bash"})
+	if again.Shell != "/bin/sh" {
+		t.Errorf("CreateWithOptions() on existing session shell = %s, want /bin/sh", again.Shell)
+	}
+}
+
+func TestManager_CreateOtherOwner(t *testing.T) {
+	m := NewManager(DefaultManagerConfig())
+
+	owned, err := m.CreateWithOptions("client1", Options{Owner: "token:alice"})
+	if err != nil {
+		t.Fatalf("CreateWithOptions() error = %v", err)
+	}
+
+	// Another identity cannot take over the session by reusing the client ID
+	if _, err := m.CreateWithOptions("client1", Options{Owner: "token:mallory"}); err != ErrNotOwner {
+		t.Errorf("CreateWithOptions() by another owner error = %v, want %v", err, ErrNotOwner)
+	}
+
+	again, err := m.CreateWithOptions("client1", Options{Owner: "token:alice"})
+	if err != nil || again.ID != owned.ID {
+		t.Errorf("CreateWithOptions() by the owner = %v, %v; want the existing session", again, err)
+	}
+}
+
+func TestSession_CheckOwner(t *testing.T) {
+	session, _ := NewSessionWithOptions("test-id", "client1", Options{Owner: "addr:10.0.0.1"})
+
+	if err := session.CheckOwner("addr:10.0.0.1"); err != nil {
+		t.Errorf("CheckOwner() by the owner error = %v", err)
+	}
+	for _, other := range []string{"addr:10.0.0.2", "token:abc", ""} {
+		if err := session.CheckOwner(other); err != ErrNotOwner {
+			t.Errorf("CheckOwner(%q) error = %v, want %v", other, err, ErrNotOwner)
+		}
+	}
+
+	// Sessions without an owner, such as those moved from older servers,
+	// stay open to everyone
+	unowned, _ := NewSession("test-id-2", "client2")
+	if err := unowned.CheckOwner("addr:10.0.0.2"); err != nil {
+		t.Errorf("CheckOwner() on a session without owner error = %v", err)
+	}
+}
+
+func TestSession_Share(t *testing.T) {
+	session, _ := NewSessionWithOptions("test-id", "client1", Options{Owner: "addr:10.0.0.1"})
+
+	readOnly, _, err := session.Invite(false, time.Minute)
+	if err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	if _, err := session.Join(readOnly, "addr:10.0.0.2", "bob"); err != nil {
+		t.Fatalf("Join() error = %v", err)
+	}
+	if _, err := session.Join(readOnly, "addr:10.0.0.3", "eve"); err != ErrInvalidInvitation {
+		t.Errorf("Join() with a used invitation error = %v, want %v", err, ErrInvalidInvitation)
+	}
+
+	if err := session.CheckAccess("addr:10.0.0.2", false); err != nil {
+		t.Errorf("CheckAccess() to watch as a read-only guest error = %v", err)
+	}
+	if err := session.CheckAccess("addr:10.0.0.2", true); err != ErrNotOwner {
+		t.Errorf("CheckAccess() to write as a read-only guest error = %v, want %v", err, ErrNotOwner)
+	}
+	if err := session.CheckAccess("addr:10.0.0.3", false); err != ErrNotOwner {
+		t.Errorf("CheckAccess() by a stranger error = %v, want %v", err, ErrNotOwner)
+	}
+	if err := session.CheckAccess("addr:10.0.0.1", true); err != nil {
+		t.Errorf("CheckAccess() by the owner error = %v", err)
+	}
+
+	readWrite, _, _ := session.Invite(true, time.Minute)
+	guest, err := session.Join(readWrite, "addr:10.0.0.2", "bob")
+	if err != nil || !guest.ReadWrite {
+		t.Fatalf("Join() read-write = %+v, %v", guest, err)
+	}
+	if err := session.CheckAccess("addr:10.0.0.2", true); err != nil {
+		t.Errorf("CheckAccess() to write as a read-write guest error = %v", err)
+	}
+
+	expired, _, _ := session.Invite(true, -time.Second)
+	if _, err := session.Join(expired, "addr:10.0.0.3", "eve"); err != ErrInvalidInvitation {
+		t.Errorf("Join() with an expired invitation error = %v, want %v", err, ErrInvalidInvitation)
+	}
+
+	if n := session.Unshare(); n != 1 {
+		t.Errorf("Unshare() = %d, want 1", n)
+	}
+	if err := session.CheckAccess("addr:10.0.0.2", false); err != ErrNotOwner {
+		t.Errorf("CheckAccess() after Unshare() error = %v, want %v", err, ErrNotOwner)
+	}
+}
+
+func TestSession_Notices(t *testing.T) {
+	session, _ := NewSession("test-id", "client1")
+
+	if notices := session.TakeNotices(); len(notices) != 0 {
+		t.Fatalf("TakeNotices() = %v, want none", notices)
+	}
+	for i := 0; i < maxNotices+2; i++ {
+		session.Notify(fmt.Sprintf("notice %d", i))
+	}
+
+	notices := session.TakeNotices()
+	if len(notices) != maxNotices || notices[0] != "notice 2" {
+		t.Errorf("TakeNotices() = %v, want the last %d notices", notices, maxNotices)
+	}
+	if notices := session.TakeNotices(); len(notices) != 0 {
+		t.Errorf("second TakeNotices() = %v, want none", notices)
+	}
 }
 
 func TestSession_SetWorkingDir(t *testing.T) {
@@ -170,3 +352,147 @@ func TestSession_Environment(t *testing.T) {
 		t.Errorf("GetEnv() = %s, want my_value", val)
 	}
 }
+
+func TestSession_SetTerminalSize(t *testing.T) {
+	session, _ := NewSession("test-id", "client1")
+
+	session.SetTerminalSize(40, 120)
+
+	result, err := session.Executor.Execute(context.Background(), "echo $COLUMNS $LINES")
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if result.Output != "120 40\n" {
+		t.Errorf("Execute() output = %q, want %q", result.Output, "120 40\n")
+	}
+}
+
+func TestSession_InheritEnv(t *testing.T) {
+	t.Setenv("SESSION_TEST_TOKEN", "hunter2")
+	t.Setenv("SESSION_TEST_VAR", "visible")
+
+	tests := []struct {
+		name   string
+		policy executor.EnvPolicy
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "baseline",
+			policy: executor.EnvPolicy{Baseline: executor.DefaultBaselineEnv, Deny: executor.DefaultDenyEnv},
+			want:   []string{"PATH=", "MY_VAR=mine"},
+			absent: []string{"hunter2", "SESSION_TEST_VAR"},
+		},
+		{
+			name:   "all",
+			policy: executor.EnvPolicy{Baseline: executor.DefaultBaselineEnv, All: true, Deny: executor.DefaultDenyEnv},
+			want:   []string{"PATH=", "MY_VAR=mine", "SESSION_TEST_VAR=visible"},
+			absent: []string{"hunter2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := NewSessionWithOptions("test-id", "client1", Options{InheritEnv: tt.policy})
+			if err != nil {
+				t.Fatalf("NewSessionWithOptions() error = %v", err)
+			}
+			session.SetEnv("MY_VAR", "mine")
+
+			result, err := session.Executor.Execute(context.Background(), "env")
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(result.Output, want) {
+					t.Errorf("env output lacks %q:\n%s", want, result.Output)
+				}
+			}
+			for _, absent := range tt.absent {
+				if strings.Contains(result.Output, absent) {
+					t.Errorf("env output contains %q:\n%s", absent, result.Output)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_Import(t *testing.T) {
+	src := NewManager(DefaultManagerConfig())
+	orig, _ := src.CreateWithOptions("client1", Options{Shell: "/bin/sh"})
+	orig.SetWorkingDir("/tmp")
+	orig.SetEnv("STAGE", "prod")
+
+	dst := NewManager(DefaultManagerConfig())
+	imported, err := dst.Import(orig.Snapshot())
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if imported.ID != orig.ID || imported.Shell != "/bin/sh" || imported.GetWorkingDir() != "/tmp" {
+		t.Errorf("Import() = %s %s %s, want %s /bin/sh /tmp", imported.ID, imported.Shell, imported.GetWorkingDir(), orig.ID)
+	}
+	result, _ := imported.Executor.Execute(context.Background(), "echo $STAGE")
+	if result.Output != "prod\n" {
+		t.Errorf("Execute() after import output = %q, want %q", result.Output, "prod\n")
+	}
+
+	if got, _ := dst.GetByClientID("client1"); got != imported {
+		t.Error("GetByClientID() did not return the imported session")
+	}
+	if _, err := dst.Import(orig.Snapshot()); err != ErrSessionExists {
+		t.Errorf("second Import() error = %v, want %v", err, ErrSessionExists)
+	}
+}
+
+func TestSession_SaveLoadEnv(t *testing.T) {
+	session, _ := NewSession("test-id", "client1")
+	session.SetEnv("STAGE", "dev")
+	session.SetWorkingDir("/tmp")
+
+	if _, err := session.SaveEnv("base"); err != nil {
+		t.Fatalf("SaveEnv() error = %v", err)
+	}
+
+	session.SetEnv("STAGE", "prod")
+	session.SetEnv("EXTRA", "1")
+
+	snap, err := session.LoadEnv("base")
+	if err != nil {
+		t.Fatalf("LoadEnv() error = %v", err)
+	}
+	if snap.WorkingDir != "/tmp" {
+		t.Errorf("LoadEnv() working dir = %s, want /tmp", snap.WorkingDir)
+	}
+	if val, _ := session.GetEnv("STAGE"); val != "dev" {
+		t.Errorf("GetEnv(STAGE) after LoadEnv() = %s, want dev", val)
+	}
+	if _, ok := session.GetEnv("EXTRA"); ok {
+		t.Error("GetEnv(EXTRA) after LoadEnv() ok = true, want false")
+	}
+
+	if _, err := session.LoadEnv("missing"); err != ErrEnvNotFound {
+		t.Errorf("LoadEnv(missing) error = %v, want %v", err, ErrEnvNotFound)
+	}
+	if snaps := session.EnvSnapshots(); len(snaps) != 1 || snaps[0].Name != "base" {
+		t.Errorf("EnvSnapshots() = %v, want [base]", snaps)
+	}
+}
+
+func TestSession_Stats(t *testing.T) {
+	session, _ := NewSession("test-id", "client1")
+
+	session.RecordCommand(2*time.Second, 500*time.Millisecond)
+	session.RecordCommand(time.Second, 250*time.Millisecond)
+	session.AddBytesStreamed(100)
+	session.AddBytesStreamed(28)
+
+	want := Stats{
+		Commands:      2,
+		WallTime:      3 * time.Second,
+		CPUTime:       750 * time.Millisecond,
+		BytesStreamed: 128,
+	}
+	if got := session.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
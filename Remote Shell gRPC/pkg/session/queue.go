@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSessionBusy is returned when a session runs as many commands as it
+// may and its queue is full
+var ErrSessionBusy = errors.New("session busy")
+
+// commandQueue lets a session run a limited number of commands at once,
+// with later commands waiting their turn in order
+type commandQueue struct {
+	mu      sync.Mutex
+	running int
+	waiting []*queuedCommand
+}
+
+// queuedCommand is a command waiting for its turn
+type queuedCommand struct {
+	// ready is closed when the command may run
+	ready chan struct{}
+	// position receives the command's new place in the queue when the
+	// commands ahead of it leave
+	position chan int
+}
+
+// WaitTurn waits until the session runs fewer than maxRunning commands and
+// the commands queued before this one have started, then returns a
+// function to call when the command is done. While it waits, queued is
+// called with the command's place in the queue, starting at 1, each time
+// that changes; an error from queued gives up the turn. When maxQueued
+// commands are already waiting it fails with ErrSessionBusy at once. It
+// reports whether the command had to wait.
+func (s *Session) WaitTurn(ctx context.Context, maxRunning, maxQueued int, queued func(position int) error) (func(), bool, error) {
+	q := &s.queue
+	q.mu.Lock()
+	if q.running < maxRunning && len(q.waiting) == 0 {
+		q.running++
+		q.mu.Unlock()
+		return q.release, false, nil
+	}
+	if len(q.waiting) >= maxQueued {
+		q.mu.Unlock()
+		return nil, false, ErrSessionBusy
+	}
+	cmd := &queuedCommand{ready: make(chan struct{}), position: make(chan int, 1)}
+	q.waiting = append(q.waiting, cmd)
+	position := len(q.waiting)
+	q.mu.Unlock()
+
+	for {
+		err := queued(position)
+		if err == nil {
+			select {
+			case <-cmd.ready:
+				return q.release, true, nil
+			case position = <-cmd.position:
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		q.leave(cmd)
+		return nil, true, err
+	}
+}
+
+// release ends a running command and lets the next queued one run
+func (q *commandQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiting) == 0 {
+		q.running--
+		return
+	}
+	// The slot passes straight to the next command
+	next := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(next.ready)
+	q.reportPositions()
+}
+
+// leave removes a command that gave up waiting. If it was given a slot
+// meanwhile, the slot is passed on.
+func (q *commandQueue) leave(cmd *queuedCommand) {
+	q.mu.Lock()
+	for i, c := range q.waiting {
+		if c == cmd {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.reportPositions()
+			q.mu.Unlock()
+			return
+		}
+	}
+	q.mu.Unlock()
+	q.release()
+}
+
+// reportPositions tells the waiting commands their places in the queue.
+// The caller holds q.mu.
+func (q *commandQueue) reportPositions() {
+	for i, c := range q.waiting {
+		// Replace a place not yet read
+		select {
+		case <-c.position:
+		default:
+		}
+		c.position <- i + 1
+	}
+}
+
+// QueuedCommands returns the number of commands running and waiting in
+// the session
+func (s *Session) QueuedCommands() (running, waiting int) {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	return s.queue.running, len(s.queue.waiting)
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSession_WaitTurn(t *testing.T) {
+	sess, err := NewSession("s1", "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	noWait := func(int) error { t.Error("first command was queued"); return nil }
+
+	release, waited, err := sess.WaitTurn(ctx, 1, 1, noWait)
+	if err != nil || waited {
+		t.Fatalf("WaitTurn() = waited %v, error %v; want a slot at once", waited, err)
+	}
+
+	positions := make(chan int, 4)
+	done := make(chan error, 1)
+	go func() {
+		r, waited, err := sess.WaitTurn(ctx, 1, 1, func(p int) error {
+			positions <- p
+			return nil
+		})
+		if err == nil {
+			if !waited {
+				err = errors.New("second command did not wait")
+			}
+			r()
+		}
+		done <- err
+	}()
+	if p := <-positions; p != 1 {
+		t.Errorf("queued at position %d, want 1", p)
+	}
+
+	// The queue holds one command, so a third is refused
+	if _, _, err := sess.WaitTurn(ctx, 1, 1, noWait); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("WaitTurn() on a full queue error = %v, want %v", err, ErrSessionBusy)
+	}
+
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued command did not get its turn")
+	}
+	if running, waiting := sess.QueuedCommands(); running != 0 || waiting != 0 {
+		t.Errorf("QueuedCommands() = %d running, %d waiting after all finished", running, waiting)
+	}
+}
+
+func TestSession_WaitTurnCancelled(t *testing.T) {
+	sess, _ := NewSession("s1", "c1")
+	release, _, _ := sess.WaitTurn(context.Background(), 1, 5, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := sess.WaitTurn(ctx, 1, 5, func(int) error {
+			close(queued)
+			return nil
+		})
+		done <- err
+	}()
+	<-queued
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("WaitTurn() error = %v, want %v", err, context.Canceled)
+	}
+	if _, waiting := sess.QueuedCommands(); waiting != 0 {
+		t.Errorf("%d commands still waiting after cancellation", waiting)
+	}
+
+	// The slot is free again once the running command finishes
+	release()
+	r, waited, err := sess.WaitTurn(context.Background(), 1, 5, nil)
+	if err != nil || waited {
+		t.Fatalf("WaitTurn() = waited %v, error %v; want a slot at once", waited, err)
+	}
+	r()
+}
@@ -2,12 +2,16 @@
 package session
 
 import (
+	"context"
 	"errors"
 	"os"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"remote-shell-rpc/pkg/executor"
+	"remote-shell-rpc/pkg/share"
 )
 
 // Common errors
@@ -15,22 +19,145 @@ var (
 	ErrSessionNotFound = errors.New("session not found")
 	ErrSessionExists   = errors.New("session already exists")
 	ErrMaxSessions     = errors.New("maximum sessions reached")
+	ErrPendingNotFound = errors.New("pending command not found or expired")
+	ErrEnvNotFound     = errors.New("environment snapshot not found")
+	ErrTooManyEnvs     = errors.New("too many environment snapshots")
+	ErrNotOwner        = errors.New("session belongs to another client")
 )
 
+// maxEnvSnapshots limits the environment snapshots kept per session
+const maxEnvSnapshots = 32
+
+// EnvSnapshot is a saved copy of a session's environment and working
+// directory
+type EnvSnapshot struct {
+	Name        string
+	WorkingDir  string
+	Environment map[string]string
+	SavedAt     time.Time
+}
+
+// Stats holds the resources a session has used so far
+type Stats struct {
+	// Commands is the number of commands run
+	Commands int64
+	// WallTime is the total time spent running commands
+	WallTime time.Duration
+	// CPUTime is the total user and system time used by commands
+	CPUTime time.Duration
+	// BytesStreamed counts command output and file data sent to the client
+	BytesStreamed int64
+}
+
+// PendingCommand is a command held back until the user confirms it
+type PendingCommand struct {
+	Command        string
+	TimeoutSeconds int32
+	WorkingDir     string
+	Env            map[string]string
+	Stdin          []byte
+	OutputFile     string
+	TailLines      int32
+	// NoGlob runs the command with pathname expansion turned off
+	NoGlob bool
+	// Argv is run directly instead of Command when set
+	Argv      []string
+	Reason    string
+	ExpiresAt time.Time
+}
+
+// Options holds settings chosen when a session is created
+type Options struct {
+	// Shell is the path of the shell used to run commands
+	Shell string
+	// LoginShell runs commands through a login shell
+	LoginShell bool
+	// InitScript is sourced before every command when set
+	InitScript string
+	// IsolateNetwork runs commands without network access
+	IsolateNetwork bool
+	// Limits are resource limits set for every command
+	Limits executor.Limits
+	// Priority is the scheduling and IO priority commands start at
+	Priority executor.Priority
+	// Umask is the file mode creation mask of commands and uploaded
+	// files, in octal; empty keeps the server's
+	Umask string
+	// InheritEnv selects the variables of the server's environment that
+	// commands inherit
+	InheritEnv executor.EnvPolicy
+	// Owner identifies the client that created the session; requests
+	// from other identities are refused. Empty lets anyone use it.
+	Owner string
+	// Client describes the machine the session was created from
+	Client ClientInfo
+}
+
+// ClientInfo is what a client reports about itself when it logs in. It is
+// not verified.
+type ClientInfo struct {
+	OS       string
+	Hostname string
+	Version  string
+	Username string
+	// MaxRecvMsgSize is the largest message the client accepts, zero when
+	// it did not say
+	MaxRecvMsgSize int
+}
+
+// State is a portable snapshot of a session, used to move it to another
+// server
+type State struct {
+	ID          string
+	ClientID    string
+	Options     Options
+	WorkingDir  string
+	Environment map[string]string
+	Rows        uint32
+	Cols        uint32
+	CreatedAt   time.Time
+}
+
 // Session represents a client shell session
 type Session struct {
 	ID           string
 	ClientID     string
+	Shell        string
+	Options      Options
 	Executor     *executor.Executor
 	WorkingDir   string
 	Environment  map[string]string
 	CreatedAt    time.Time
 	LastActivity time.Time
+	pending      map[string]PendingCommand
+	envs         map[string]EnvSnapshot
+	stats        Stats
+	rows         uint32
+	cols         uint32
 	mu           sync.RWMutex
+
+	// ctx is cancelled when the session is closed, which stops its
+	// running commands
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Sharing: clients invited to the session and what it mirrors to them
+	mirror      *share.Hub
+	guests      map[string]Guest
+	invitations map[string]invitation
+	notices     []string
+
+	// queue limits the commands running at once
+	queue commandQueue
 }
 
 // NewSession creates a new session with the given ID and client ID
 func NewSession(id, clientID string) (*Session, error) {
+	return NewSessionWithOptions(id, clientID, Options{})
+}
+
+// NewSessionWithOptions creates a new session with the given options
+func NewSessionWithOptions(id, clientID string, opts Options) (*Session, error) {
 	// Get current working directory
 	wd, err := os.Getwd()
 	if err != nil {
@@ -40,19 +167,61 @@ func NewSession(id, clientID string) (*Session, error) {
 	// Create executor with default config
 	cfg := executor.DefaultConfig()
 	cfg.WorkingDir = wd
+	if opts.Shell != "" {
+		cfg.Shell = opts.Shell
+	}
+	cfg.LoginShell = opts.LoginShell
+	cfg.InitScript = opts.InitScript
+	cfg.IsolateNetwork = opts.IsolateNetwork
+	cfg.Limits = opts.Limits
+	cfg.Priority = opts.Priority
+	cfg.Umask = opts.Umask
 
 	exec := executor.New(cfg)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	now := time.Now()
-	return &Session{
+	s := &Session{
 		ID:           id,
 		ClientID:     clientID,
+		Shell:        cfg.Shell,
+		Options:      opts,
 		Executor:     exec,
 		WorkingDir:   wd,
 		Environment:  make(map[string]string),
 		CreatedAt:    now,
 		LastActivity: now,
-	}, nil
+		pending:      make(map[string]PendingCommand),
+		envs:         make(map[string]EnvSnapshot),
+		mirror:       share.NewHub(),
+		guests:       make(map[string]Guest),
+		invitations:  make(map[string]invitation),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+	s.updateExecutorEnv()
+	return s, nil
+}
+
+// Context returns a context that is cancelled when the session is closed
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// close stops the session's running commands and ends the attachments of
+// the clients watching it
+func (s *Session) close() {
+	s.cancel()
+	s.mirror.Close()
+}
+
+// CheckOwner returns ErrNotOwner unless the session may be used by the
+// given identity
+func (s *Session) CheckOwner(identity string) error {
+	if s.Options.Owner != "" && s.Options.Owner != identity {
+		return ErrNotOwner
+	}
+	return nil
 }
 
 // SetWorkingDir sets the working directory for the session
@@ -71,6 +240,23 @@ func (s *Session) GetWorkingDir() string {
 	return s.WorkingDir
 }
 
+// SetUmask sets the file mode creation mask of later commands and uploads
+func (s *Session) SetUmask(umask string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Options.Umask = umask
+	s.Executor.SetUmask(umask)
+	s.LastActivity = time.Now()
+}
+
+// GetUmask returns the session's file mode creation mask, empty when it
+// keeps the server's
+func (s *Session) GetUmask() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Options.Umask
+}
+
 // SetEnv sets an environment variable for the session
 func (s *Session) SetEnv(key, value string) {
 	s.mu.Lock()
@@ -80,6 +266,15 @@ func (s *Session) SetEnv(key, value string) {
 	s.LastActivity = time.Now()
 }
 
+// UnsetEnv removes an environment variable from the session
+func (s *Session) UnsetEnv(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Environment, key)
+	s.updateExecutorEnv()
+	s.LastActivity = time.Now()
+}
+
 // GetEnv gets an environment variable from the session
 func (s *Session) GetEnv(key string) (string, bool) {
 	s.mu.RLock()
@@ -88,6 +283,92 @@ func (s *Session) GetEnv(key string) (string, bool) {
 	return val, ok
 }
 
+// SetTerminalSize records the client terminal size, which commands see
+// through COLUMNS and LINES
+func (s *Session) SetTerminalSize(rows, cols uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows = rows
+	s.cols = cols
+	s.updateExecutorEnv()
+}
+
+// TerminalSize returns the last recorded client terminal size
+func (s *Session) TerminalSize() (rows, cols uint32) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rows, s.cols
+}
+
+// Snapshot returns the portable state of the session
+func (s *Session) Snapshot() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return State{
+		ID:          s.ID,
+		ClientID:    s.ClientID,
+		Options:     s.Options,
+		WorkingDir:  s.WorkingDir,
+		Environment: copyEnv(s.Environment),
+		Rows:        s.rows,
+		Cols:        s.cols,
+		CreatedAt:   s.CreatedAt,
+	}
+}
+
+// SaveEnv stores the environment and working directory under a name,
+// replacing an earlier snapshot with the same name
+func (s *Session) SaveEnv(name string) (EnvSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.envs[name]; !exists && len(s.envs) >= maxEnvSnapshots {
+		return EnvSnapshot{}, ErrTooManyEnvs
+	}
+
+	snap := EnvSnapshot{
+		Name:        name,
+		WorkingDir:  s.WorkingDir,
+		Environment: copyEnv(s.Environment),
+		SavedAt:     time.Now(),
+	}
+	s.envs[name] = snap
+	return snap, nil
+}
+
+// LoadEnv replaces the environment with a saved snapshot and returns it.
+// The working directory is restored by the caller, which can check that
+// it still exists.
+func (s *Session) LoadEnv(name string) (EnvSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, ok := s.envs[name]
+	if !ok {
+		return EnvSnapshot{}, ErrEnvNotFound
+	}
+	s.Environment = copyEnv(snap.Environment)
+	s.updateExecutorEnv()
+	s.LastActivity = time.Now()
+	return snap, nil
+}
+
+// EnvSnapshots returns the saved environment snapshots sorted by name
+func (s *Session) EnvSnapshots() []EnvSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snaps := make([]EnvSnapshot, 0, len(s.envs))
+	for _, snap := range s.envs {
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].Name < snaps[j].Name
+	})
+	return snaps
+}
+
 // UpdateActivity updates the last activity timestamp
 func (s *Session) UpdateActivity() {
 	s.mu.Lock()
@@ -102,11 +383,90 @@ func (s *Session) GetLastActivity() time.Time {
 	return s.LastActivity
 }
 
-// updateExecutorEnv updates the executor environment from the session environment
+// RecordCommand adds a finished command to the session statistics
+func (s *Session) RecordCommand(wall, cpu time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Commands++
+	s.stats.WallTime += wall
+	s.stats.CPUTime += cpu
+}
+
+// AddBytesStreamed adds data sent to the client to the session statistics
+func (s *Session) AddBytesStreamed(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.BytesStreamed += int64(n)
+}
+
+// Stats returns the resources the session has used so far
+func (s *Session) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stats
+}
+
+// AddPending stores a command awaiting confirmation and returns its token
+func (s *Session) AddPending(cmd PendingCommand) (string, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired entries so abandoned confirmations do not pile up
+	now := time.Now()
+	for t, p := range s.pending {
+		if now.After(p.ExpiresAt) {
+			delete(s.pending, t)
+		}
+	}
+
+	s.pending[token] = cmd
+	return token, nil
+}
+
+// TakePending removes and returns the pending command for a token
+func (s *Session) TakePending(token string) (PendingCommand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd, ok := s.pending[token]
+	if !ok {
+		return PendingCommand{}, ErrPendingNotFound
+	}
+	delete(s.pending, token)
+
+	if time.Now().After(cmd.ExpiresAt) {
+		return PendingCommand{}, ErrPendingNotFound
+	}
+	return cmd, nil
+}
+
+// copyEnv returns a copy of an environment map
+func copyEnv(env map[string]string) map[string]string {
+	c := make(map[string]string, len(env))
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
+
+// updateExecutorEnv updates the executor environment from the session
+// environment. It starts from the part of the server's environment the
+// InheritEnv option passes on, never all of it.
 func (s *Session) updateExecutorEnv() {
-	env := os.Environ()
+	env := s.Options.InheritEnv.Inherit(os.Environ())
 	for k, v := range s.Environment {
 		env = append(env, k+"="+v)
 	}
+	if s.rows > 0 && s.cols > 0 {
+		env = append(env,
+			"LINES="+strconv.FormatUint(uint64(s.rows), 10),
+			"COLUMNS="+strconv.FormatUint(uint64(s.cols), 10),
+		)
+	}
 	s.Executor.SetEnvironment(env)
 }
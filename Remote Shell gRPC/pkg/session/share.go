@@ -0,0 +1,140 @@
+package session
+
+import (
+	"errors"
+	"time"
+
+	"remote-shell-rpc/pkg/share"
+)
+
+// ErrInvalidInvitation is returned for unknown, used or expired invitations
+var ErrInvalidInvitation = errors.New("invalid or expired invitation")
+
+// maxNotices limits the notices queued for a session's user; the oldest
+// are dropped first
+const maxNotices = 16
+
+// Guest is a client that joined a session it does not own
+type Guest struct {
+	// Name is shown to the other participants
+	Name string
+	// ReadWrite guests may run commands; others only watch
+	ReadWrite bool
+	JoinedAt  time.Time
+}
+
+// invitation lets one client join a session
+type invitation struct {
+	readWrite bool
+	expiresAt time.Time
+}
+
+// Mirror returns the hub that delivers the session's events to the
+// clients watching it
+func (s *Session) Mirror() *share.Hub {
+	return s.mirror
+}
+
+// Invite creates a single-use invitation for another client to join the
+// session, read-only or read-write, until ttl has passed
+func (s *Session) Invite(readWrite bool, ttl time.Duration) (string, time.Time, error) {
+	token, err := generateSessionID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for t, inv := range s.invitations {
+		if now.After(inv.expiresAt) {
+			delete(s.invitations, t)
+		}
+	}
+
+	expiresAt := now.Add(ttl)
+	s.invitations[token] = invitation{readWrite: readWrite, expiresAt: expiresAt}
+	return token, expiresAt, nil
+}
+
+// Join redeems an invitation for the given identity. An identity that
+// already joined keeps the wider of its two access modes.
+func (s *Session) Join(token, identity, name string) (Guest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inv, ok := s.invitations[token]
+	if !ok {
+		return Guest{}, ErrInvalidInvitation
+	}
+	delete(s.invitations, token)
+	if time.Now().After(inv.expiresAt) {
+		return Guest{}, ErrInvalidInvitation
+	}
+
+	guest, joined := s.guests[identity]
+	if !joined {
+		guest = Guest{JoinedAt: time.Now()}
+	}
+	guest.Name = name
+	guest.ReadWrite = guest.ReadWrite || inv.readWrite
+	s.guests[identity] = guest
+	return guest, nil
+}
+
+// Guest returns the guest an identity joined the session as
+func (s *Session) Guest(identity string) (Guest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	guest, ok := s.guests[identity]
+	return guest, ok
+}
+
+// Unshare removes every guest and invitation; it returns the number of
+// guests removed
+func (s *Session) Unshare() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.guests)
+	s.guests = make(map[string]Guest)
+	s.invitations = make(map[string]invitation)
+	return n
+}
+
+// CheckAccess returns ErrNotOwner unless the identity may use the session:
+// the owner always may, guests may watch and read-write guests may also
+// run commands
+func (s *Session) CheckAccess(identity string, write bool) error {
+	if s.CheckOwner(identity) == nil {
+		return nil
+	}
+	guest, ok := s.Guest(identity)
+	if !ok || (write && !guest.ReadWrite) {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// Notify queues a message for the session's user, delivered with the
+// result of the next command
+func (s *Session) Notify(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notices = append(s.notices, msg)
+	if len(s.notices) > maxNotices {
+		s.notices = s.notices[len(s.notices)-maxNotices:]
+	}
+}
+
+// TakeNotices returns the queued messages and clears them
+func (s *Session) TakeNotices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notices := s.notices
+	s.notices = nil
+	return notices
+}
@@ -0,0 +1,81 @@
+// Package sessiontoken issues short-lived signed tokens for sessions. A
+// token names the session, the client it was issued to and when it
+// expires, so a leaked session ID is useless on its own and a leaked token
+// only for a short while.
+package sessiontoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors returned by Verify
+var (
+	ErrInvalid = errors.New("invalid session token")
+	ErrExpired = errors.New("session token expired")
+)
+
+// Issuer signs and verifies session tokens
+type Issuer struct {
+	key []byte
+	ttl time.Duration
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// New creates an issuer of tokens valid for ttl, signed with a random key.
+// Tokens therefore do not survive a restart, which sessions do not either.
+func New(ttl time.Duration) (*Issuer, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Issuer{key: key, ttl: ttl, now: time.Now}, nil
+}
+
+// TTL returns how long tokens are valid
+func (i *Issuer) TTL() time.Duration {
+	return i.ttl
+}
+
+// Issue returns a token for the session and the client holding it, and
+// when the token expires
+func (i *Issuer) Issue(sessionID, holder string) (string, time.Time) {
+	expires := i.now().Add(i.ttl).Truncate(time.Second)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return exp + "." + i.sign(sessionID, holder, exp), expires
+}
+
+// Verify checks that token was issued for the session and holder and has
+// not expired
+func (i *Issuer) Verify(token, sessionID, holder string) error {
+	exp, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(i.sign(sessionID, holder, exp))) {
+		return ErrInvalid
+	}
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if !i.now().Before(time.Unix(unix, 0)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// sign returns the signature of a token's fields
+func (i *Issuer) sign(sessionID, holder, exp string) string {
+	mac := hmac.New(sha256.New, i.key)
+	mac.Write([]byte("v1\x00" + sessionID + "\x00" + holder + "\x00" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,55 @@
+package sessiontoken
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestIssuer_Verify(t *testing.T) {
+	i, err := New(15 * time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	i.now = func() time.Time { return now }
+
+	token, expires := i.Issue("s1", "key:alice")
+	if want := now.Add(15 * time.Minute); !expires.Equal(want) {
+		t.Errorf("Issue() expires = %v, want %v", expires, want)
+	}
+	if err := i.Verify(token, "s1", "key:alice"); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		sessionID string
+		holder    string
+	}{
+		{"other session", token, "s2", "key:alice"},
+		{"other holder", token, "s1", "key:mallory"},
+		{"malformed", "garbage", "s1", "key:alice"},
+		{"extended expiry", "99999999999" + token[len("1900"):], "s1", "key:alice"},
+	}
+	for _, tt := range tests {
+		if err := i.Verify(tt.token, tt.sessionID, tt.holder); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Verify() with %s error = %v, want %v", tt.name, err, ErrInvalid)
+		}
+	}
+
+	now = now.Add(15 * time.Minute)
+	if err := i.Verify(token, "s1", "key:alice"); !errors.Is(err, ErrExpired) {
+		t.Errorf("Verify() of an expired token error = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestIssuer_OtherKey(t *testing.T) {
+	a, _ := New(time.Minute)
+	b, _ := New(time.Minute)
+	token, _ := a.Issue("s1", "addr:127.0.0.1")
+	if err := b.Verify(token, "s1", "addr:127.0.0.1"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify() of another issuer's token error = %v, want %v", err, ErrInvalid)
+	}
+}
@@ -0,0 +1,151 @@
+// Package share mirrors what happens in a session to everyone watching it:
+// the commands participants run, their output and who joins or leaves.
+package share
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType says what an Event reports
+type EventType int
+
+// Event types
+const (
+	// CommandStarted carries the command a participant ran
+	CommandStarted EventType = iota
+	// Output carries a chunk of a command's output
+	Output
+	// CommandFinished carries the command's exit code
+	CommandFinished
+	// Joined and Left report participants attaching and detaching
+	Joined
+	Left
+	// Notice carries a message for everyone watching, in Data
+	Notice
+)
+
+// Event is something that happened in a session
+type Event struct {
+	Type EventType
+	// Participant names who caused the event
+	Participant string
+	Command     string
+	Data        []byte
+	Stderr      bool
+	// Binary marks output that is not valid text
+	Binary     bool
+	ExitCode   int
+	WorkingDir string
+	Time       time.Time
+}
+
+// Hub delivers the events of one session to its subscribers
+type Hub struct {
+	subs   map[*Subscription]struct{}
+	closed bool
+	mu     sync.Mutex
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	hub     *Hub
+	events  chan Event
+	dropped int
+	closed  bool
+}
+
+// NewHub creates a hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe starts receiving events, keeping up to buffer of them for a
+// slow reader. Events that do not fit are dropped and counted.
+func (h *Hub) Subscribe(buffer int) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{hub: h, events: make(chan Event, buffer)}
+	if h.closed {
+		sub.closed = true
+		close(sub.events)
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// Publish sends an event to every subscriber without waiting for any of
+// them. The event's data is copied, so the caller may reuse it.
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Data != nil {
+		e.Data = append([]byte(nil), e.Data...)
+	}
+	for sub := range h.subs {
+		select {
+		case sub.events <- e:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Watchers returns the number of subscribers
+func (h *Hub) Watchers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Close ends every subscription, for when the session goes away
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		sub.closed = true
+		close(sub.events)
+		delete(h.subs, sub)
+	}
+}
+
+// Events returns the channel events arrive on. It is closed when the
+// subscription or the hub is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events were dropped since the last call
+// because the reader fell behind
+func (s *Subscription) Dropped() int {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.events)
+	delete(s.hub.subs, s)
+}
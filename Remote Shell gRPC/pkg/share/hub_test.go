@@ -0,0 +1,70 @@
+package share
+
+import (
+	"testing"
+)
+
+func TestHub_Publish(t *testing.T) {
+	h := NewHub()
+	a := h.Subscribe(4)
+	b := h.Subscribe(4)
+	if n := h.Watchers(); n != 2 {
+		t.Fatalf("Watchers() = %d, want 2", n)
+	}
+
+	data := []byte("hello")
+	h.Publish(Event{Type: Output, Participant: "alice", Data: data})
+	copy(data, "XXXXX")
+
+	for _, sub := range []*Subscription{a, b} {
+		e := <-sub.Events()
+		if e.Type != Output || e.Participant != "alice" || string(e.Data) != "hello" {
+			t.Errorf("event = %+v, want output %q from alice", e, "hello")
+		}
+		if e.Time.IsZero() {
+			t.Error("event time was not set")
+		}
+	}
+}
+
+func TestHub_SlowSubscriber(t *testing.T) {
+	h := NewHub()
+	slow := h.Subscribe(2)
+
+	for i := 0; i < 5; i++ {
+		h.Publish(Event{Type: Output, ExitCode: i})
+	}
+	if n := slow.Dropped(); n != 3 {
+		t.Errorf("Dropped() = %d, want 3", n)
+	}
+	if n := slow.Dropped(); n != 0 {
+		t.Errorf("Dropped() after reading the count = %d, want 0", n)
+	}
+	if e := <-slow.Events(); e.ExitCode != 0 {
+		t.Errorf("first buffered event = %d, want 0", e.ExitCode)
+	}
+}
+
+func TestHub_Close(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe(1)
+	left := h.Subscribe(1)
+
+	left.Close()
+	left.Close()
+	if n := h.Watchers(); n != 1 {
+		t.Errorf("Watchers() after Close() = %d, want 1", n)
+	}
+
+	h.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Error("subscription still open after the hub closed")
+	}
+	sub.Close()
+
+	late := h.Subscribe(1)
+	if _, ok := <-late.Events(); ok {
+		t.Error("subscription to a closed hub is open")
+	}
+	h.Publish(Event{Type: Notice})
+}
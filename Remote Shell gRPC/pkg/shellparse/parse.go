@@ -0,0 +1,773 @@
+// Package shellparse splits POSIX shell command lines into the simple
+// commands they run, so that policies can look at argument vectors rather
+// than raw text. It understands quoting, escapes, separators, pipelines,
+// redirections, here-documents, command substitution and function
+// definitions, but performs no expansion: variables and globs are left as
+// written. Globs reports where the pathname patterns are, for callers that
+// expand them themselves.
+package shellparse
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// ErrUnterminated is returned for a quote, substitution or here-document
+// that is never closed
+var ErrUnterminated = errors.New("unterminated quote or substitution")
+
+// Redirect is a redirection of a command, e.g. "> /dev/null"
+type Redirect struct {
+	// Op is the operator without a file descriptor number, e.g. ">>"
+	Op     string
+	Target string
+}
+
+// Command is a simple command with its arguments
+type Command struct {
+	Args      []string
+	Redirects []Redirect
+	// Function is the name of the function whose body holds the command
+	Function string
+	// Piped is set for commands in a pipeline with at least two commands
+	Piped bool
+	// FromPipe is set for commands that read the output of the command
+	// before them in a pipeline, including every command of a group,
+	// subshell or loop at the end of one
+	FromPipe bool
+	// Heredocs holds the bodies of the command's here-documents, one for
+	// every "<<" redirection in order
+	Heredocs []string
+	// Background is set for commands run asynchronously with "&"
+	Background bool
+}
+
+// Glob is an unquoted word of a command line that the shell expands as a
+// pathname pattern, e.g. *.log
+type Glob struct {
+	Pattern string
+	// Start and End are the byte offsets of the word in the line
+	Start, End int
+}
+
+// Parse returns the simple commands of a command line in the order they
+// appear, followed by the commands of any command substitutions
+func Parse(line string) ([]Command, error) {
+	l, p, err := parse(line)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := p.commands
+	for _, sub := range l.substitutions {
+		nested, err := Parse(sub)
+		if err != nil {
+			return nil, err
+		}
+		commands = append(commands, nested...)
+	}
+	return commands, nil
+}
+
+// Globs returns the pathname patterns among the arguments of the commands
+// of a line, in the order they appear. Only words written entirely without
+// quotes, escapes, expansions, braces or a leading tilde are reported;
+// words in command substitutions, redirections, assignments, for and case
+// headers and [[ ]] tests are not.
+func Globs(line string) ([]Glob, error) {
+	l, p, err := parse(line)
+	if err != nil {
+		return nil, err
+	}
+
+	globs := make([]Glob, 0, len(p.globs))
+	for _, tok := range p.globs {
+		globs = append(globs, Glob{
+			Pattern: tok.text,
+			Start:   len(string(l.input[:tok.start])),
+			End:     len(string(l.input[:tok.end])),
+		})
+	}
+	return globs, nil
+}
+
+// Syntax lists what a command line does beyond running a single simple
+// command
+type Syntax struct {
+	// Operators are the control and redirection operators in the order
+	// they appear, e.g. ";", "&&" or ">", followed by those inside command
+	// substitutions. Newlines count as ";" and the file descriptor numbers
+	// of redirections are left out.
+	Operators []string
+	// Substitution is set when the line holds a command substitution,
+	// $(...) or `...`, or a process substitution such as <(...)
+	Substitution bool
+}
+
+// Inspect returns the syntax a command line uses
+func Inspect(line string) (Syntax, error) {
+	l := &lexer{input: []rune(line)}
+	if err := l.run(); err != nil {
+		return Syntax{}, err
+	}
+
+	var syntax Syntax
+	previous := ""
+	for _, tok := range l.tokens {
+		if !tok.op {
+			previous = ""
+			continue
+		}
+		if tok.text == "(" && IsRedirect(previous) {
+			syntax.Substitution = true
+		}
+		syntax.Operators = append(syntax.Operators, tok.text)
+		previous = tok.text
+	}
+	for _, sub := range l.substitutions {
+		nested, err := Inspect(sub)
+		if err != nil {
+			return Syntax{}, err
+		}
+		syntax.Operators = append(syntax.Operators, nested.Operators...)
+		syntax.Substitution = true
+	}
+	return syntax, nil
+}
+
+// parse splits a line into tokens and groups them into commands
+func parse(line string) (*lexer, *parser, error) {
+	l := &lexer{input: []rune(line)}
+	if err := l.run(); err != nil {
+		return nil, nil, err
+	}
+
+	p := &parser{heredocs: l.bodies}
+	for _, tok := range l.tokens {
+		p.token(tok)
+	}
+	p.finish(false)
+	return l, p, nil
+}
+
+// token is a word or operator of a command line
+type token struct {
+	text   string
+	op     bool
+	quoted bool
+	// expanded is set for words holding a parameter expansion or command
+	// substitution
+	expanded bool
+	// start and end are the positions of a word in the input
+	start, end int
+}
+
+// operators recognised by the lexer, longest first
+var operators = []string{
+	"&>>", "<<<", "<<-",
+	"&&", "||", ";;", "|&", ">>", "<<", ">|", "&>", ">&", "<&", "<>",
+	";", "&", "|", "(", ")", "<", ">", "\n",
+}
+
+// lexer splits a command line into tokens
+type lexer struct {
+	input         []rune
+	pos           int
+	tokens        []token
+	substitutions []string
+	// heredocs are the delimiters of here-documents whose bodies start
+	// after the next newline
+	heredocs []heredoc
+	// bodies are the bodies of the here-documents read so far
+	bodies []string
+
+	word     strings.Builder
+	inWord   bool
+	quoted   bool
+	expanded bool
+	start    int  // position of the current word
+	pending  bool // the next word is a here-document delimiter
+	strip    bool // the pending here-document strips leading tabs
+}
+
+type heredoc struct {
+	delimiter string
+	strip     bool
+}
+
+// run tokenizes the whole input
+func (l *lexer) run() error {
+	for l.pos < len(l.input) {
+		if !l.inWord {
+			l.start = l.pos
+		}
+		c := l.input[l.pos]
+		switch {
+		case c == ' ' || c == '\t':
+			l.endWord()
+			l.pos++
+
+		case c == '#' && !l.inWord:
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+
+		case c == '\\':
+			l.pos++
+			if l.pos < len(l.input) {
+				if l.input[l.pos] != '\n' {
+					l.add(l.input[l.pos])
+					l.quoted = true
+				}
+				l.pos++
+			}
+
+		case c == '\'':
+			end := l.index('\'', l.pos+1)
+			if end < 0 {
+				return ErrUnterminated
+			}
+			l.addString(string(l.input[l.pos+1 : end]))
+			l.quoted = true
+			l.pos = end + 1
+
+		case c == '"':
+			if err := l.doubleQuoted(); err != nil {
+				return err
+			}
+
+		case c == '$' || c == '`':
+			if err := l.dollar(); err != nil {
+				return err
+			}
+
+		default:
+			if op := l.operator(); op != "" {
+				if err := l.addOperator(op); err != nil {
+					return err
+				}
+				continue
+			}
+			l.add(c)
+			l.pos++
+		}
+	}
+	l.endWord()
+	if len(l.heredocs) > 0 {
+		return ErrUnterminated
+	}
+	return nil
+}
+
+// doubleQuoted reads a double-quoted string, in which only $, `, " and \
+// are special
+func (l *lexer) doubleQuoted() error {
+	l.pos++
+	l.quoted = true
+	l.inWord = true
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return nil
+		case '\\':
+			if l.pos+1 < len(l.input) && strings.ContainsRune("$`\"\\\n", l.input[l.pos+1]) {
+				if l.input[l.pos+1] != '\n' {
+					l.add(l.input[l.pos+1])
+				}
+				l.pos += 2
+				continue
+			}
+			l.add(c)
+			l.pos++
+		case '$', '`':
+			if err := l.dollar(); err != nil {
+				return err
+			}
+		default:
+			l.add(c)
+			l.pos++
+		}
+	}
+	return ErrUnterminated
+}
+
+// dollar reads a parameter expansion or command substitution. The text is
+// kept in the word as written; the commands of a substitution are parsed
+// separately.
+func (l *lexer) dollar() error {
+	start := l.pos
+	switch {
+	case l.input[l.pos] == '`':
+		end := l.pos + 1
+		for end < len(l.input) && l.input[end] != '`' {
+			if l.input[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(l.input) {
+			return ErrUnterminated
+		}
+		l.substitutions = append(l.substitutions, string(l.input[l.pos+1:end]))
+		l.pos = end + 1
+
+	case l.hasPrefix("$(("):
+		end := l.closing(l.pos+3, '(', ')')
+		if end < 0 || end+1 >= len(l.input) || l.input[end+1] != ')' {
+			return ErrUnterminated
+		}
+		l.pos = end + 2
+
+	case l.hasPrefix("$("):
+		end := l.closing(l.pos+2, '(', ')')
+		if end < 0 {
+			return ErrUnterminated
+		}
+		l.substitutions = append(l.substitutions, string(l.input[l.pos+2:end]))
+		l.pos = end + 1
+
+	case l.hasPrefix("${"):
+		end := l.closing(l.pos+2, '{', '}')
+		if end < 0 {
+			return ErrUnterminated
+		}
+		l.pos = end + 1
+
+	default:
+		l.pos++
+	}
+	l.addString(string(l.input[start:l.pos]))
+	l.expanded = true
+	return nil
+}
+
+// closing returns the index of the bracket closing one opened before from,
+// skipping quoted text, or -1
+func (l *lexer) closing(from int, open, close rune) int {
+	depth := 1
+	for i := from; i < len(l.input); i++ {
+		switch l.input[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := l.index('\'', i+1)
+			if end < 0 {
+				return -1
+			}
+			i = end
+		case '"':
+			for i++; i < len(l.input) && l.input[i] != '"'; i++ {
+				if l.input[i] == '\\' {
+					i++
+				}
+			}
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// operator returns the operator at the current position, if any
+func (l *lexer) operator() string {
+	for _, op := range operators {
+		if l.hasPrefix(op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// addOperator ends the current word and emits an operator
+func (l *lexer) addOperator(op string) error {
+	// A number right before a redirection is a file descriptor
+	if IsRedirect(op) && l.inWord && !l.quoted && isDigits(l.word.String()) {
+		l.word.Reset()
+		l.inWord = false
+	}
+	l.endWord()
+	l.pos += len(op)
+
+	switch op {
+	case "<<", "<<-":
+		l.pending = true
+		l.strip = op == "<<-"
+		op = "<<"
+	case "\n":
+		l.tokens = append(l.tokens, token{text: ";", op: true})
+		return l.heredocBodies()
+	}
+	l.tokens = append(l.tokens, token{text: op, op: true})
+	return nil
+}
+
+// heredocBodies skips the bodies of the here-documents started on the line
+// that just ended
+func (l *lexer) heredocBodies() error {
+	for _, doc := range l.heredocs {
+		var body strings.Builder
+		for {
+			if l.pos >= len(l.input) {
+				return ErrUnterminated
+			}
+			end := l.index('\n', l.pos)
+			if end < 0 {
+				end = len(l.input)
+			}
+			line := string(l.input[l.pos:end])
+			l.pos = end + 1
+			if doc.strip {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == doc.delimiter {
+				break
+			}
+			body.WriteString(line)
+			body.WriteByte('\n')
+		}
+		l.bodies = append(l.bodies, body.String())
+	}
+	l.heredocs = nil
+	if l.pos > len(l.input) {
+		l.pos = len(l.input)
+	}
+	return nil
+}
+
+// add appends a character to the current word
+func (l *lexer) add(c rune) {
+	l.word.WriteRune(c)
+	l.inWord = true
+}
+
+// addString appends text to the current word
+func (l *lexer) addString(s string) {
+	l.word.WriteString(s)
+	l.inWord = true
+}
+
+// endWord emits the current word, if any
+func (l *lexer) endWord() {
+	if !l.inWord {
+		return
+	}
+	text := l.word.String()
+	if l.pending {
+		l.heredocs = append(l.heredocs, heredoc{delimiter: text, strip: l.strip})
+		l.pending = false
+	}
+	l.tokens = append(l.tokens, token{text: text, quoted: l.quoted, expanded: l.expanded, start: l.start, end: l.pos})
+	l.word.Reset()
+	l.inWord = false
+	l.quoted = false
+	l.expanded = false
+}
+
+// hasPrefix reports whether the input continues with s
+func (l *lexer) hasPrefix(s string) bool {
+	rs := []rune(s)
+	if l.pos+len(rs) > len(l.input) {
+		return false
+	}
+	for i, r := range rs {
+		if l.input[l.pos+i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// index returns the position of c at or after from, or -1
+func (l *lexer) index(c rune, from int) int {
+	for i := from; i < len(l.input); i++ {
+		if l.input[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsRedirect reports whether an operator redirects input or output
+func IsRedirect(op string) bool {
+	switch op {
+	case "<", ">", ">>", ">|", "&>", "&>>", ">&", "<&", "<>", "<<", "<<-", "<<<":
+		return true
+	}
+	return false
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedWords are skipped at the start of a command
+var reservedWords = map[string]bool{
+	"!": true, "if": true, "then": true, "elif": true, "else": true, "fi": true,
+	"while": true, "until": true, "do": true, "done": true, "esac": true,
+	"time": true,
+}
+
+// parser groups tokens into commands
+type parser struct {
+	commands []Command
+	cur      Command
+
+	redirect string   // operator waiting for its target
+	piped    bool     // the current command reads from a pipe
+	heredocs []string // bodies of the here-documents not yet reached
+
+	// groups are the compound commands open around the current command,
+	// set for those whose input is a pipe
+	groups    []bool
+	funcParen bool // the next ")" ends "name("
+
+	skipFor     bool // skipping "for name in words"
+	caseHeader  bool // skipping "case word in"
+	casePattern bool // skipping a case pattern up to ")"
+
+	funcName   string // function defined by the upcoming body
+	funcWord   bool   // the next word names a function
+	braceDepth int
+	functions  []frame
+
+	inTest bool    // inside a [[ ]] test, whose words are not globbed
+	globs  []token // words that are pathname patterns
+}
+
+// frame is a function body being parsed
+type frame struct {
+	name  string
+	depth int
+}
+
+// token handles the next token
+func (p *parser) token(tok token) {
+	if tok.op {
+		p.operator(tok.text)
+		return
+	}
+
+	if p.redirect != "" {
+		p.cur.Redirects = append(p.cur.Redirects, Redirect{Op: p.redirect, Target: tok.text})
+		if p.redirect == "<<" && len(p.heredocs) > 0 {
+			p.cur.Heredocs = append(p.cur.Heredocs, p.heredocs[0])
+			p.heredocs = p.heredocs[1:]
+		}
+		p.redirect = ""
+		return
+	}
+
+	atStart := len(p.cur.Args) == 0 && len(p.cur.Redirects) == 0
+	switch {
+	case p.skipFor:
+		if tok.text == "do" && !tok.quoted {
+			p.skipFor = false
+		}
+		return
+	case p.caseHeader:
+		if tok.text == "in" && !tok.quoted {
+			p.caseHeader = false
+			p.casePattern = true
+		}
+		return
+	case p.casePattern:
+		if tok.text == "esac" && !tok.quoted {
+			p.casePattern = false
+			p.close()
+		}
+		return
+	case atStart && !tok.quoted:
+		switch tok.text {
+		case "{":
+			p.open()
+			p.braceDepth++
+			if p.funcName != "" {
+				p.functions = append(p.functions, frame{name: p.funcName, depth: p.braceDepth})
+				p.funcName = ""
+			}
+			return
+		case "}":
+			if n := len(p.functions); n > 0 && p.functions[n-1].depth == p.braceDepth {
+				p.functions = p.functions[:n-1]
+			}
+			p.braceDepth--
+			p.close()
+			return
+		case "for", "select":
+			p.open()
+			p.skipFor = true
+			return
+		case "case":
+			p.open()
+			p.caseHeader = true
+			return
+		case "function":
+			p.funcWord = true
+			return
+		case "[[":
+			p.inTest = true
+		}
+		if p.funcWord {
+			p.funcName = tok.text
+			p.funcWord = false
+			return
+		}
+		if reservedWords[tok.text] {
+			switch tok.text {
+			case "if", "while", "until":
+				p.open()
+			case "fi", "done", "esac":
+				p.close()
+			}
+			return
+		}
+	}
+	switch {
+	case p.inTest:
+		if tok.text == "]]" && !tok.quoted {
+			p.inTest = false
+		}
+	case isGlob(tok) && !p.assignment(tok.text):
+		p.globs = append(p.globs, tok)
+	}
+	p.cur.Args = append(p.cur.Args, tok.text)
+}
+
+// isGlob reports whether a word is a pathname pattern the shell expands
+// without doing anything else to it first
+func isGlob(tok token) bool {
+	if tok.quoted || tok.expanded || strings.HasPrefix(tok.text, "~") || strings.Contains(tok.text, "{") {
+		return false
+	}
+	return strings.ContainsAny(tok.text, "*?[")
+}
+
+// assignment reports whether word is a variable assignment, which is only
+// the case among the words that start a command
+func (p *parser) assignment(word string) bool {
+	for _, arg := range p.cur.Args {
+		if !isAssignment(arg) {
+			return false
+		}
+	}
+	return isAssignment(word)
+}
+
+// isAssignment reports whether a word has the form name=value
+func isAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	if !ok || name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && !('a' <= c && c <= 'z') && !('A' <= c && c <= 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// operator handles an operator token
+func (p *parser) operator(op string) {
+	if IsRedirect(op) {
+		p.redirect = op
+		return
+	}
+	p.redirect = ""
+
+	// Alternatives of a case pattern are not a pipeline
+	if p.casePattern && op == "|" {
+		return
+	}
+
+	switch op {
+	case "|", "|&":
+		p.cur.Piped = true
+		p.finish(false)
+		p.piped = true
+		return
+	case "&":
+		p.finish(true)
+	case "(":
+		// "name()" starts a function definition
+		if len(p.cur.Args) == 1 && len(p.cur.Redirects) == 0 {
+			p.funcName = p.cur.Args[0]
+			p.funcParen = true
+			p.cur = Command{}
+			return
+		}
+		// A case pattern may start with "("
+		if p.casePattern {
+			return
+		}
+		p.open()
+		p.finish(false)
+	case ")":
+		if p.casePattern {
+			p.casePattern = false
+			return
+		}
+		p.finish(false)
+		if p.funcParen {
+			p.funcParen = false
+		} else {
+			p.close()
+		}
+	case ";;":
+		p.finish(false)
+		p.casePattern = true
+	default:
+		p.finish(false)
+		// The words of a for loop end with a separator as well as "do"
+		if op == ";" && p.skipFor {
+			p.skipFor = false
+		}
+	}
+	p.piped = false
+}
+
+// finish completes the current command
+func (p *parser) finish(background bool) {
+	if len(p.cur.Args) > 0 || len(p.cur.Redirects) > 0 {
+		if p.piped {
+			p.cur.Piped = true
+		}
+		p.cur.FromPipe = p.piped || slices.Contains(p.groups, true)
+		p.cur.Background = background
+		if n := len(p.functions); n > 0 {
+			p.cur.Function = p.functions[n-1].name
+		}
+		p.commands = append(p.commands, p.cur)
+	}
+	p.cur = Command{}
+	p.piped = false
+}
+
+// open starts a compound command, which reads what is piped into it
+func (p *parser) open() {
+	p.groups = append(p.groups, p.piped)
+}
+
+// close ends the innermost compound command
+func (p *parser) close() {
+	if n := len(p.groups); n > 0 {
+		p.groups = p.groups[:n-1]
+	}
+}
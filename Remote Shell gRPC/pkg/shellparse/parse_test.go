@@ -0,0 +1,260 @@
+package shellparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+// argv returns the argument vectors of parsed commands
+func argv(commands []Command) [][]string {
+	var out [][]string
+	for _, cmd := range commands {
+		out = append(out, cmd.Args)
+	}
+	return out
+}
+
+func TestParse_Words(t *testing.T) {
+	tests := []struct {
+		line string
+		want [][]string
+	}{
+		{`echo hello   world`, [][]string{{"echo", "hello", "world"}}},
+		{`rm  -rf "/"`, [][]string{{"rm", "-rf", "/"}}},
+		{`echo 'a  b' "c \"d\" \$e" f\ g`, [][]string{{"echo", "a  b", `c "d" $e`, "f g"}}},
+		{`echo "it's" 'say "hi"'`, [][]string{{"echo", "it's", `say "hi"`}}},
+		{`echo a""b ''`, [][]string{{"echo", "ab", ""}}},
+		{"echo one \\\ntwo", [][]string{{"echo", "one", "two"}}},
+		{`echo $HOME ${PATH:-/bin} $((1 + 2))`, [][]string{{"echo", "$HOME", "${PATH:-/bin}", "$((1 + 2))"}}},
+		{`echo hi # rm -rf /`, [][]string{{"echo", "hi"}}},
+		{`echo a#b`, [][]string{{"echo", "a#b"}}},
+	}
+
+	for _, tt := range tests {
+		commands, err := Parse(tt.line)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.line, err)
+			continue
+		}
+		if got := argv(commands); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParse_Separators(t *testing.T) {
+	commands, err := Parse("cd /tmp && ls -l | grep x; false || true & (echo sub)\necho last")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := [][]string{
+		{"cd", "/tmp"}, {"ls", "-l"}, {"grep", "x"}, {"false"}, {"true"}, {"echo", "sub"}, {"echo", "last"},
+	}
+	if got := argv(commands); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Parse() = %q, want %q", got, want)
+	}
+	if commands[0].Piped || !commands[1].Piped || !commands[2].Piped || commands[3].Piped {
+		t.Errorf("Piped = %v %v %v %v, want false true true false",
+			commands[0].Piped, commands[1].Piped, commands[2].Piped, commands[3].Piped)
+	}
+	if commands[3].Background || !commands[4].Background {
+		t.Errorf("Background = %v %v, want false true", commands[3].Background, commands[4].Background)
+	}
+}
+
+func TestParse_Redirects(t *testing.T) {
+	commands, err := Parse(`cat < in.txt 2>/dev/null >> "out file" &> all`)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(commands) != 1 {
+		t.Fatalf("Parse() = %d commands, want 1", len(commands))
+	}
+
+	want := []Redirect{{"<", "in.txt"}, {">", "/dev/null"}, {">>", "out file"}, {"&>", "all"}}
+	if !reflect.DeepEqual(commands[0].Redirects, want) {
+		t.Errorf("Redirects = %v, want %v", commands[0].Redirects, want)
+	}
+	if !reflect.DeepEqual(commands[0].Args, []string{"cat"}) {
+		t.Errorf("Args = %q, want %q", commands[0].Args, []string{"cat"})
+	}
+}
+
+func TestParse_Substitution(t *testing.T) {
+	commands, err := Parse("echo \"today is $(date +%A)\" `id -u`")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := [][]string{
+		{"echo", "today is $(date +%A)", "`id -u`"}, {"date", "+%A"}, {"id", "-u"},
+	}
+	if got := argv(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %q, want %q", got, want)
+	}
+}
+
+func TestParse_Compound(t *testing.T) {
+	line := "if test -d /tmp; then echo yes; else echo no; fi\n" +
+		"for f in a b; do rm $f; done\n" +
+		"case $x in a|b) echo ab;; *) echo other;; esac\n" +
+		"while read l; do echo $l; done"
+	commands, err := Parse(line)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := [][]string{
+		{"test", "-d", "/tmp"}, {"echo", "yes"}, {"echo", "no"},
+		{"rm", "$f"},
+		{"echo", "ab"}, {"echo", "other"},
+		{"read", "l"}, {"echo", "$l"},
+	}
+	if got := argv(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %q, want %q", got, want)
+	}
+	if commands[4].Piped {
+		t.Error("command after a case pattern with | is marked as piped")
+	}
+}
+
+func TestParse_Functions(t *testing.T) {
+	commands, err := Parse(":(){ :|:& };: ; function greet { echo hi; }; greet")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []Command{
+		{Args: []string{":"}, Function: ":", Piped: true},
+		{Args: []string{":"}, Function: ":", Piped: true, FromPipe: true, Background: true},
+		{Args: []string{":"}},
+		{Args: []string{"echo", "hi"}, Function: "greet"},
+		{Args: []string{"greet"}},
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("Parse() = %+v, want %+v", commands, want)
+	}
+}
+
+func TestParse_Heredoc(t *testing.T) {
+	commands, err := Parse("cat <<EOF > notes\nrm -rf /\nEOF\necho done")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := [][]string{{"cat"}, {"echo", "done"}}
+	if got := argv(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(commands[0].Heredocs, []string{"rm -rf /\n"}) {
+		t.Errorf("Heredocs = %q, want the body", commands[0].Heredocs)
+	}
+
+	commands, err = Parse("cat <<-A <<B | sh\n\techo a\n\tA\necho b\nB")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !reflect.DeepEqual(commands[0].Heredocs, []string{"echo a\n", "echo b\n"}) {
+		t.Errorf("Heredocs = %q, want both bodies", commands[0].Heredocs)
+	}
+}
+
+func TestParse_FromPipe(t *testing.T) {
+	tests := []struct {
+		line string
+		want []bool
+	}{
+		{"echo a | sh", []bool{false, true}},
+		{"echo a | sh | cat", []bool{false, true, true}},
+		{"echo a | (true; sh)", []bool{false, true, true}},
+		{"echo a | { true; sh; }; sh", []bool{false, true, true, false}},
+		{"echo a | while read l; do sh; done; sh", []bool{false, true, true, false}},
+		{"(echo a; echo b) | sh", []bool{false, false, true}},
+		{"f() { sh; }; echo a | f", []bool{false, false, true}},
+		{"case x in (a) echo a;; esac | sh", []bool{false, true}},
+	}
+
+	for _, tt := range tests {
+		commands, err := Parse(tt.line)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.line, err)
+			continue
+		}
+		var got []bool
+		for _, cmd := range commands {
+			got = append(got, cmd.FromPipe)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) FromPipe = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParse_Unterminated(t *testing.T) {
+	for _, line := range []string{`echo "abc`, `echo 'abc`, `echo $(date`, "echo `date", "cat <<EOF\nabc"} {
+		if _, err := Parse(line); err != ErrUnterminated {
+			t.Errorf("Parse(%q) error = %v, want %v", line, err, ErrUnterminated)
+		}
+	}
+}
+
+func TestGlobs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{`ls -l *.go src/[a-c]?.txt`, []string{"*.go", "src/[a-c]?.txt"}},
+		{`grep 'a*' "*.go" \*.md x\?`, nil},
+		{`echo $HOME/*.log $(ls *.tmp) {a,b}*.c ~/*.sh`, nil},
+		{`cat < *.in > *.out; FILES=*.go make *.o`, []string{"*.o"}},
+		{`[[ $f == *.go ]] && rm -- *.bak`, []string{"*.bak"}},
+		{`for f in *.c; do gcc $f; done; case $x in *.h) echo h*;; esac`, []string{"h*"}},
+		{`echo héllo *é`, []string{"*é"}},
+	}
+
+	for _, tt := range tests {
+		globs, err := Globs(tt.line)
+		if err != nil {
+			t.Errorf("Globs(%q) error = %v", tt.line, err)
+			continue
+		}
+		var got []string
+		for _, g := range globs {
+			if word := tt.line[g.Start:g.End]; word != g.Pattern {
+				t.Errorf("Globs(%q): word at %d:%d is %q, want %q", tt.line, g.Start, g.End, word, g.Pattern)
+			}
+			got = append(got, g.Pattern)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Globs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		line         string
+		operators    []string
+		substitution bool
+	}{
+		{`ls -l /tmp | grep x`, []string{"|"}, false},
+		{`echo "a;b" 'c && d' e\;f`, nil, false},
+		{"cd /tmp && make || echo failed; date\nid", []string{"&&", "||", ";", ";"}, false},
+		{`sort < in 2>/dev/null >> out`, []string{"<", ">", ">>"}, false},
+		{"echo $(id; date) `whoami` $((1 + 2))", []string{";"}, true},
+		{`echo "$(id)"`, nil, true},
+		{`diff <(ls a) <(ls b)`, []string{"<", "(", ")", "<", "(", ")"}, true},
+	}
+
+	for _, tt := range tests {
+		syntax, err := Inspect(tt.line)
+		if err != nil {
+			t.Errorf("Inspect(%q) error = %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(syntax.Operators, tt.operators) || syntax.Substitution != tt.substitution {
+			t.Errorf("Inspect(%q) = %q, substitution %v, want %q, %v", tt.line, syntax.Operators, syntax.Substitution, tt.operators, tt.substitution)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Package sshauth lets clients log in with an SSH key: the client signs a
+// random challenge with its private key, the signature is checked against
+// the keys in an authorized_keys file, and the client gets a login token
+// that it sends with its requests instead of a shared token.
+package sshauth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// signaturePrefix separates the signed challenges from anything else the
+// key might sign
+const signaturePrefix = "remote-shell-rpc login v1\x00"
+
+// maxChallenges bounds the challenges waiting for an answer, since anyone
+// may ask for one
+const maxChallenges = 10000
+
+// Errors returned by Login
+var (
+	ErrUnknownChallenge = errors.New("unknown or expired challenge")
+	ErrKeyNotAuthorized = errors.New("key is not authorized")
+	ErrBadSignature     = errors.New("signature does not match the key")
+	ErrTooManyLogins    = errors.New("too many logins in progress")
+)
+
+// SignedData returns what a client signs to answer a challenge
+func SignedData(challenge []byte) []byte {
+	return append([]byte(signaturePrefix), challenge...)
+}
+
+// Config controls the authenticator
+type Config struct {
+	// AuthorizedKeysFile lists the keys that may log in, in the format of
+	// OpenSSH's authorized_keys. It is read at every login, so keys can be
+	// added and removed without a restart; options are ignored.
+	AuthorizedKeysFile string
+	// ChallengeTTL is how long a challenge can be answered
+	ChallengeTTL time.Duration
+	// TokenTTL is how long a login token stays valid without being used
+	TokenTTL time.Duration
+}
+
+// Login describes a client that logged in
+type Login struct {
+	// Fingerprint is the SHA256 fingerprint of the key
+	Fingerprint string
+	// Comment is the key's comment in the authorized_keys file
+	Comment string
+	expires time.Time
+}
+
+// Authenticator issues challenges and login tokens
+type Authenticator struct {
+	cfg        Config
+	challenges map[string]time.Time
+	tokens     map[string]*Login
+	mu         sync.Mutex
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// New creates an authenticator with the given configuration
+func New(cfg Config) *Authenticator {
+	if cfg.ChallengeTTL <= 0 {
+		cfg.ChallengeTTL = time.Minute
+	}
+	if cfg.TokenTTL <= 0 {
+		cfg.TokenTTL = 12 * time.Hour
+	}
+	return &Authenticator{
+		cfg:        cfg,
+		challenges: make(map[string]time.Time),
+		tokens:     make(map[string]*Login),
+		now:        time.Now,
+	}
+}
+
+// Challenge returns a new challenge and when it expires
+func (a *Authenticator) Challenge() ([]byte, time.Time, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	a.prune(now)
+	if len(a.challenges) >= maxChallenges {
+		return nil, time.Time{}, ErrTooManyLogins
+	}
+	expires := now.Add(a.cfg.ChallengeTTL)
+	a.challenges[string(challenge)] = expires
+	return challenge, expires, nil
+}
+
+// Login checks the signature of a challenge with an authorized key and
+// returns a login token. Every challenge can be answered once.
+func (a *Authenticator) Login(publicKey, challenge []byte, sig *ssh.Signature) (string, Login, error) {
+	a.mu.Lock()
+	expires, ok := a.challenges[string(challenge)]
+	delete(a.challenges, string(challenge))
+	now := a.now()
+	a.mu.Unlock()
+	if !ok || !now.Before(expires) {
+		return "", Login{}, ErrUnknownChallenge
+	}
+
+	key, err := ssh.ParsePublicKey(publicKey)
+	if err != nil {
+		return "", Login{}, fmt.Errorf("invalid public key: %w", err)
+	}
+	comment, err := a.authorized(key)
+	if err != nil {
+		return "", Login{}, err
+	}
+	// SHA-1 RSA signatures are refused, as OpenSSH does
+	if sig == nil || sig.Format == ssh.KeyAlgoRSA {
+		return "", Login{}, ErrBadSignature
+	}
+	if err := key.Verify(SignedData(challenge), sig); err != nil {
+		return "", Login{}, ErrBadSignature
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Login{}, err
+	}
+	token := hex.EncodeToString(b)
+	login := &Login{
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Comment:     comment,
+		expires:     now.Add(a.cfg.TokenTTL),
+	}
+
+	a.mu.Lock()
+	a.tokens[token] = login
+	a.mu.Unlock()
+	return token, *login, nil
+}
+
+// Check returns the login a token belongs to and keeps it valid for
+// another TokenTTL
+func (a *Authenticator) Check(token string) (Login, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	login, ok := a.tokens[token]
+	if !ok {
+		return Login{}, false
+	}
+	if !now.Before(login.expires) {
+		delete(a.tokens, token)
+		return Login{}, false
+	}
+	login.expires = now.Add(a.cfg.TokenTTL)
+	return *login, true
+}
+
+// CheckKeys reads the authorized_keys file and returns how many keys it
+// lists
+func (a *Authenticator) CheckKeys() (int, error) {
+	keys, err := a.readKeys()
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// authorizedKey is a key from the authorized_keys file
+type authorizedKey struct {
+	key     ssh.PublicKey
+	comment string
+}
+
+// authorized returns the comment of key in the authorized_keys file
+func (a *Authenticator) authorized(key ssh.PublicKey) (string, error) {
+	keys, err := a.readKeys()
+	if err != nil {
+		return "", err
+	}
+	wire := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.key.Marshal(), wire) {
+			return k.comment, nil
+		}
+	}
+	return "", ErrKeyNotAuthorized
+}
+
+// readKeys parses the authorized_keys file
+func (a *Authenticator) readKeys() ([]authorizedKey, error) {
+	data, err := os.ReadFile(a.cfg.AuthorizedKeysFile)
+	if err != nil {
+		return nil, err
+	}
+	var keys []authorizedKey
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, comment, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// No further valid keys
+			break
+		}
+		keys = append(keys, authorizedKey{key: key, comment: comment})
+		data = rest
+	}
+	return keys, nil
+}
+
+// prune forgets expired challenges and tokens
+func (a *Authenticator) prune(now time.Time) {
+	for c, expires := range a.challenges {
+		if !now.Before(expires) {
+			delete(a.challenges, c)
+		}
+	}
+	for t, login := range a.tokens {
+		if !now.Before(login.expires) {
+			delete(a.tokens, t)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package sshauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// clock is a settable time source for the authenticator
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+// newSigner returns a new ed25519 key
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newAuthenticator returns an authenticator that accepts signer's key
+func newAuthenticator(t *testing.T, signer ssh.Signer) (*Authenticator, *clock) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	line := "# team keys\n" + string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	line = line[:len(line)-1] + " alice@laptop\n"
+	if err := os.WriteFile(path, []byte(line), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &clock{now: time.Unix(1000, 0)}
+	a := New(Config{AuthorizedKeysFile: path, ChallengeTTL: time.Minute, TokenTTL: time.Hour})
+	a.now = c.Now
+	return a, c
+}
+
+// answer signs a new challenge with signer
+func answer(t *testing.T, a *Authenticator, signer ssh.Signer) ([]byte, *ssh.Signature) {
+	t.Helper()
+	challenge, _, err := a.Challenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.Sign(rand.Reader, SignedData(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge, sig
+}
+
+func TestAuthenticator_Login(t *testing.T) {
+	signer := newSigner(t)
+	a, c := newAuthenticator(t, signer)
+
+	challenge, sig := answer(t, a, signer)
+	token, login, err := a.Login(signer.PublicKey().Marshal(), challenge, sig)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if login.Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) || login.Comment != "alice@laptop" {
+		t.Errorf("Login() = %+v, want the key's fingerprint and comment", login)
+	}
+
+	if got, ok := a.Check(token); !ok || got.Fingerprint != login.Fingerprint {
+		t.Fatalf("Check() = %+v, %v; want the login", got, ok)
+	}
+	if _, ok := a.Check("other"); ok {
+		t.Error("Check() accepted an unknown token")
+	}
+
+	// A challenge can only be answered once
+	if _, _, err := a.Login(signer.PublicKey().Marshal(), challenge, sig); !errors.Is(err, ErrUnknownChallenge) {
+		t.Errorf("replayed Login() error = %v, want %v", err, ErrUnknownChallenge)
+	}
+
+	// Using the token keeps it valid; leaving it unused does not
+	c.now = c.now.Add(50 * time.Minute)
+	if _, ok := a.Check(token); !ok {
+		t.Fatal("Check() refused a token in use")
+	}
+	c.now = c.now.Add(50 * time.Minute)
+	if _, ok := a.Check(token); !ok {
+		t.Fatal("Check() refused a token used 50 minutes ago")
+	}
+	c.now = c.now.Add(2 * time.Hour)
+	if _, ok := a.Check(token); ok {
+		t.Error("Check() accepted an idle token")
+	}
+}
+
+func TestAuthenticator_Refused(t *testing.T) {
+	signer := newSigner(t)
+	a, c := newAuthenticator(t, signer)
+
+	stranger := newSigner(t)
+	challenge, sig := answer(t, a, stranger)
+	if _, _, err := a.Login(stranger.PublicKey().Marshal(), challenge, sig); !errors.Is(err, ErrKeyNotAuthorized) {
+		t.Errorf("Login() with an unlisted key error = %v, want %v", err, ErrKeyNotAuthorized)
+	}
+
+	// Signed by another key than the one presented
+	challenge, sig = answer(t, a, stranger)
+	if _, _, err := a.Login(signer.PublicKey().Marshal(), challenge, sig); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Login() with a wrong signature error = %v, want %v", err, ErrBadSignature)
+	}
+
+	challenge, sig = answer(t, a, signer)
+	c.now = c.now.Add(2 * time.Minute)
+	if _, _, err := a.Login(signer.PublicKey().Marshal(), challenge, sig); !errors.Is(err, ErrUnknownChallenge) {
+		t.Errorf("Login() with an expired challenge error = %v, want %v", err, ErrUnknownChallenge)
+	}
+}
+
+func TestAuthenticator_CheckKeys(t *testing.T) {
+	a, _ := newAuthenticator(t, newSigner(t))
+	if n, err := a.CheckKeys(); n != 1 || err != nil {
+		t.Errorf("CheckKeys() = %d, %v; want 1, nil", n, err)
+	}
+
+	missing := New(Config{AuthorizedKeysFile: filepath.Join(t.TempDir(), "missing")})
+	if _, err := missing.CheckKeys(); err == nil {
+		t.Error("CheckKeys() of a missing file succeeded")
+	}
+}
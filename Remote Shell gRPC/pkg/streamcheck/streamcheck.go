@@ -0,0 +1,81 @@
+// Package streamcheck numbers the messages of an output stream and sums up
+// the data they carry, so that the receiving end can tell when messages
+// were lost, repeated or reordered on the way, for example by a relay.
+package streamcheck
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// Errors returned by Receiver
+var (
+	ErrOutOfOrder = errors.New("message out of order")
+	ErrMismatch   = errors.New("stream summary mismatch")
+)
+
+// table is the CRC-32C table checksums are computed with
+var table = crc32.MakeTable(crc32.Castagnoli)
+
+// Summary describes everything sent on a stream
+type Summary struct {
+	// Chunks is the number of messages, including the one carrying the
+	// summary
+	Chunks uint64
+	// Bytes is the amount of data and Checksum its CRC-32C in the order
+	// it was sent
+	Bytes    uint64
+	Checksum uint32
+}
+
+// Sender numbers outgoing messages and sums up their data
+type Sender struct {
+	sum Summary
+}
+
+// Next accounts for a message carrying data and returns its sequence
+// number. Numbers start at 1.
+func (s *Sender) Next(data []byte) uint64 {
+	s.sum.Chunks++
+	s.sum.Bytes += uint64(len(data))
+	s.sum.Checksum = crc32.Update(s.sum.Checksum, table, data)
+	return s.sum.Chunks
+}
+
+// Summary returns what was sent so far
+func (s *Sender) Summary() Summary {
+	return s.sum
+}
+
+// Receiver checks incoming messages against their sequence numbers and the
+// sender's summary
+type Receiver struct {
+	sum Summary
+}
+
+// Receive accounts for a message with the given sequence number and data.
+// It fails when the message is not the one expected next.
+func (r *Receiver) Receive(sequence uint64, data []byte) error {
+	if want := r.sum.Chunks + 1; sequence != want {
+		return fmt.Errorf("%w: got message %d, want %d", ErrOutOfOrder, sequence, want)
+	}
+	r.sum.Chunks++
+	r.sum.Bytes += uint64(len(data))
+	r.sum.Checksum = crc32.Update(r.sum.Checksum, table, data)
+	return nil
+}
+
+// Verify compares the sender's summary with what was received
+func (r *Receiver) Verify(sent Summary) error {
+	got := r.sum
+	switch {
+	case got.Chunks != sent.Chunks:
+		return fmt.Errorf("%w: received %d messages, %d were sent", ErrMismatch, got.Chunks, sent.Chunks)
+	case got.Bytes != sent.Bytes:
+		return fmt.Errorf("%w: received %d bytes, %d were sent", ErrMismatch, got.Bytes, sent.Bytes)
+	case got.Checksum != sent.Checksum:
+		return fmt.Errorf("%w: checksum %08x, want %08x", ErrMismatch, got.Checksum, sent.Checksum)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package streamcheck
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReceiver_Intact(t *testing.T) {
+	var s Sender
+	var r Receiver
+	for _, data := range []string{"hello ", "", "world\n"} {
+		seq := s.Next([]byte(data))
+		if err := r.Receive(seq, []byte(data)); err != nil {
+			t.Fatalf("Receive(%d) error = %v", seq, err)
+		}
+	}
+	sum := s.Summary()
+	if sum.Chunks != 3 || sum.Bytes != 12 {
+		t.Errorf("Summary() = %+v, want 3 chunks and 12 bytes", sum)
+	}
+	if err := r.Verify(sum); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+}
+
+func TestReceiver_Damaged(t *testing.T) {
+	var s Sender
+	first := s.Next([]byte("one"))
+	second := s.Next([]byte("two"))
+
+	// A lost message shows up as a gap in the numbers
+	var r Receiver
+	r.Receive(first, []byte("one"))
+	if err := r.Receive(second+1, nil); !errors.Is(err, ErrOutOfOrder) {
+		t.Errorf("Receive after a gap error = %v, want %v", err, ErrOutOfOrder)
+	}
+
+	// A reordered message does too
+	r = Receiver{}
+	if err := r.Receive(second, []byte("two")); !errors.Is(err, ErrOutOfOrder) {
+		t.Errorf("Receive out of order error = %v, want %v", err, ErrOutOfOrder)
+	}
+
+	// Changed data only shows up in the summary
+	r = Receiver{}
+	r.Receive(first, []byte("one"))
+	r.Receive(second, []byte("tw0"))
+	if err := r.Verify(s.Summary()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with changed data error = %v, want %v", err, ErrMismatch)
+	}
+
+	// And so does a stream cut short
+	r = Receiver{}
+	r.Receive(first, []byte("one"))
+	if err := r.Verify(s.Summary()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify of a short stream error = %v, want %v", err, ErrMismatch)
+	}
+}
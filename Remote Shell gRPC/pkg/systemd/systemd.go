@@ -0,0 +1,84 @@
+// Package systemd integrates a service with systemd without linking
+// libsystemd: it takes over the sockets passed by socket activation and
+// reports the service's state with sd_notify.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation
+const listenFdsStart = 3
+
+// Activated reports whether systemd passed sockets to this process
+func Activated() bool {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	return err == nil && pid == os.Getpid() && os.Getenv("LISTEN_FDS") != ""
+}
+
+// Listeners returns the sockets passed by socket activation, in the order
+// they are listed in the socket unit, or none when the process was not
+// socket activated. The variables describing them are unset so that child
+// processes do not take them for their own.
+func Listeners() ([]net.Listener, error) {
+	return listeners(listenFdsStart)
+}
+
+// listeners wraps the descriptors passed by socket activation, starting at
+// first
+func listeners(first int) ([]net.Listener, error) {
+	if !Activated() {
+		return nil, nil
+	}
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	var ls []net.Listener
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		// FileListener duplicates the descriptor, so the original is
+		// closed either way
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket %d is not a listening socket: %w", fd, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// Notify sends a state change such as "READY=1" or "STOPPING=1" to
+// systemd. It returns false when the process is not run by systemd as a
+// notify service.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// A leading @ names an abstract socket, which the net package
+	// understands as is
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
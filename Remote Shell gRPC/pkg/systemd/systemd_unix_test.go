@@ -0,0 +1,45 @@
+//go:build !windows
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestListeners_Activated(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// listeners closes the descriptor it is given, so it gets its own
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	ls, err := listeners(fd)
+	if err != nil || len(ls) != 1 {
+		t.Fatalf("listeners() = %v, %v; want one listener", ls, err)
+	}
+	defer ls[0].Close()
+
+	if got, want := ls[0].Addr().String(), l.Addr().String(); got != want {
+		t.Errorf("listener address = %s, want %s", got, want)
+	}
+	if Activated() {
+		t.Error("Activated() = true after the sockets were taken")
+	}
+}
@@ -0,0 +1,120 @@
+// Package tlsreload serves a TLS certificate loaded from files and loads
+// it again when the files change, so that a long-running server picks up
+// renewed certificates without a restart and without dropping the
+// connections it already has.
+package tlsreload
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader holds the certificate loaded from a certificate and key file
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// stamp identifies the version of the files that was loaded
+	stamp string
+}
+
+// New loads the certificate and key
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate; it is meant for
+// tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// CertFile returns the path of the certificate file
+func (r *Reloader) CertFile() string {
+	return r.certFile
+}
+
+// Expires returns when the current certificate expires
+func (r *Reloader) Expires() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert.Leaf.NotAfter
+}
+
+// Reload loads the files again if they changed since they were last
+// loaded and reports whether the certificate was replaced. When they
+// cannot be loaded, for example because only one of them was written yet,
+// the previous certificate stays in use.
+func (r *Reloader) Reload() (bool, error) {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := stamp == r.stamp
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.stamp = stamp
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the certificate every interval until stop is closed,
+// calling done after every attempt that replaced it or failed
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}, done func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if reloaded, err := r.Reload(); reloaded || err != nil {
+				done(err)
+			}
+		}
+	}
+}
+
+// fileStamp describes the files by size and modification time. Stat
+// follows symlinks, so certificates swapped in by replacing a link, as
+// Kubernetes does with secrets, are noticed as well.
+func (r *Reloader) fileStamp() (string, error) {
+	stamp := ""
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%d:%d;", info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
@@ -0,0 +1,125 @@
+package tlsreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for name and its key, with
+// the given modification time
+func writeCert(t *testing.T, dir, name string, mod time.Time) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	for _, f := range []struct {
+		path  string
+		block *pem.Block
+	}{
+		{certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}},
+		{keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}},
+	} {
+		if err := os.WriteFile(f.path, pem.EncodeToMemory(f.block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(f.path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+// subject returns the common name of the reloader's current certificate
+func subject(t *testing.T, r *Reloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	certFile, keyFile := writeCert(t, dir, "first", now.Add(-time.Minute))
+
+	r, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := subject(t, r); got != "first" {
+		t.Fatalf("certificate = %s, want first", got)
+	}
+	if r.Expires().Before(now) {
+		t.Errorf("Expires() = %v, want the certificate's expiry", r.Expires())
+	}
+
+	if reloaded, err := r.Reload(); reloaded || err != nil {
+		t.Fatalf("Reload() of unchanged files = %v, %v; want false, nil", reloaded, err)
+	}
+
+	writeCert(t, dir, "second", now)
+	if reloaded, err := r.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload() of changed files = %v, %v; want true, nil", reloaded, err)
+	}
+	if got := subject(t, r); got != "second" {
+		t.Errorf("certificate after reload = %s, want second", got)
+	}
+}
+
+func TestReloader_KeepsCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "good", time.Now().Add(-time.Minute))
+
+	r, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// A key that does not match, as while a renewal is half written
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reload(); err == nil {
+		t.Fatal("Reload() of a broken key succeeded")
+	}
+	if got := subject(t, r); got != "good" {
+		t.Errorf("certificate after a failed reload = %s, want good", got)
+	}
+}
+
+func TestNew_MissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Error("New() with missing files succeeded")
+	}
+}